@@ -0,0 +1,401 @@
+// Package dap implements a Debug Adapter Protocol server for golox, so that
+// editors can set breakpoints in a script, step through it and inspect its
+// variables. It speaks the protocol over a pair of streams, normally the
+// process's stdin and stdout, and debugs one script per session.
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"golox/errorhandling"
+	"golox/interpreter"
+	"golox/parser"
+	"golox/scanner"
+	"golox/statement"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// The interpreter runs the script on one goroutine, which the protocol
+// calls a thread.
+const threadID = 1
+
+// What the script should do when it resumes.
+type stepMode int
+
+const (
+	// Run until a breakpoint.
+	running stepMode = iota
+	// Stop at the next statement in the same call or a caller.
+	stepOver
+	// Stop at the next statement anywhere.
+	stepIn
+	// Stop at the next statement in a caller.
+	stepOut
+	// Stop at the next statement, because the user asked.
+	pausing
+)
+
+type Server struct {
+	in *bufio.Reader
+
+	// Guards everything below, which both the protocol loop and the
+	// goroutine running the script use.
+	mu  sync.Mutex
+	out io.Writer
+	seq int
+
+	program     string
+	source      string
+	stopOnEntry bool
+	// Breakpoint lines by the cleaned path of their file.
+	breakpoints map[string]map[int]bool
+	started     bool
+
+	mode stepMode
+	// The depth of the call stack when the script last stopped, for
+	// stepping over and out.
+	stepDepth int
+	// Where the last statement ran, so a breakpoint on a line with several
+	// statements is only hit once.
+	lastLine, lastDepth int
+	paused              bool
+	disconnected        bool
+	// The call stack while the script is stopped.
+	frames []*interpreter.Frame
+	// Variable references handed out since the script stopped. A reference
+	// is an index into handles plus one, as zero means "no children".
+	handles []func() []Variable
+	resume  chan struct{}
+}
+
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		in:          bufio.NewReader(in),
+		out:         out,
+		breakpoints: make(map[string]map[int]bool),
+		resume:      make(chan struct{}, 1),
+	}
+}
+
+// Handle requests until the client disconnects.
+func (s *Server) Serve() error {
+	for {
+		body, err := s.read()
+		if err != nil {
+			return fmt.Errorf("reading message: %w", err)
+		}
+		var req request
+		if err := json.Unmarshal(body, &req); err != nil {
+			return fmt.Errorf("decoding message: %w", err)
+		}
+		if req.Type != "request" {
+			continue
+		}
+
+		s.mu.Lock()
+		err = s.handle(req)
+		s.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		if req.Command == "disconnect" {
+			return nil
+		}
+	}
+}
+
+// Read the body of the next message, which follows a header giving its
+// length.
+func (s *Server) read() ([]byte, error) {
+	header, err := textproto.NewReader(s.in).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("bad Content-Length: %w", err)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.in, body); err != nil {
+		return nil, err
+	}
+
+	return body, nil
+}
+
+// Send a message. The caller must hold s.mu.
+func (s *Server) write(msg any) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+
+	return err
+}
+
+func (s *Server) respond(req request, body any) error {
+	s.seq++
+	return s.write(response{Seq: s.seq, Type: "response", RequestSeq: req.Seq, Success: true, Command: req.Command, Body: body})
+}
+
+func (s *Server) fail(req request, message string) error {
+	s.seq++
+	return s.write(response{Seq: s.seq, Type: "response", RequestSeq: req.Seq, Command: req.Command, Message: message})
+}
+
+func (s *Server) event(name string, body any) error {
+	s.seq++
+	return s.write(event{Seq: s.seq, Type: "event", Event: name, Body: body})
+}
+
+// Handle a request. The caller must hold s.mu.
+func (s *Server) handle(req request) error {
+	switch req.Command {
+	case "initialize":
+		if err := s.respond(req, Capabilities{SupportsConfigurationDoneRequest: true}); err != nil {
+			return err
+		}
+		return s.event("initialized", nil)
+	case "launch":
+		var args LaunchArguments
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return s.fail(req, err.Error())
+		}
+		src, err := os.ReadFile(args.Program)
+		if err != nil {
+			return s.fail(req, err.Error())
+		}
+		s.program, s.source, s.stopOnEntry = args.Program, string(src), args.StopOnEntry
+		return s.respond(req, nil)
+	case "setBreakpoints":
+		var args SetBreakpointsArguments
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return s.fail(req, err.Error())
+		}
+		lines := make(map[int]bool)
+		breakpoints := []Breakpoint{}
+		for _, b := range args.Breakpoints {
+			lines[b.Line] = true
+			breakpoints = append(breakpoints, Breakpoint{Verified: true, Line: b.Line})
+		}
+		s.breakpoints[filepath.Clean(args.Source.Path)] = lines
+		return s.respond(req, map[string]any{"breakpoints": breakpoints})
+	case "configurationDone":
+		if s.program == "" {
+			return s.fail(req, "no program has been launched")
+		}
+		if !s.started {
+			s.started = true
+			go s.run()
+		}
+		return s.respond(req, nil)
+	case "threads":
+		return s.respond(req, map[string]any{"threads": []Thread{{ID: threadID, Name: "main"}}})
+	case "stackTrace":
+		return s.respond(req, s.stackTrace())
+	case "scopes":
+		var args struct {
+			FrameID int `json:"frameId"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return s.fail(req, err.Error())
+		}
+		if !s.paused || args.FrameID < 1 || args.FrameID > len(s.frames) {
+			return s.fail(req, "no such stack frame")
+		}
+		frame := s.frames[args.FrameID-1]
+		return s.respond(req, map[string]any{"scopes": []Scope{
+			{Name: "Locals", VariablesReference: s.reference(func() []Variable { return s.variables(frame.Locals()) })},
+			{Name: "Globals", VariablesReference: s.reference(func() []Variable { return s.variables(frame.Globals()) })},
+		}})
+	case "variables":
+		var args struct {
+			VariablesReference int `json:"variablesReference"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return s.fail(req, err.Error())
+		}
+		if args.VariablesReference < 1 || args.VariablesReference > len(s.handles) {
+			return s.fail(req, "no such variables reference")
+		}
+		return s.respond(req, map[string]any{"variables": s.handles[args.VariablesReference-1]()})
+	case "continue":
+		s.continueWith(running)
+		return s.respond(req, map[string]any{"allThreadsContinued": true})
+	case "next":
+		s.continueWith(stepOver)
+		return s.respond(req, nil)
+	case "stepIn":
+		s.continueWith(stepIn)
+		return s.respond(req, nil)
+	case "stepOut":
+		s.continueWith(stepOut)
+		return s.respond(req, nil)
+	case "pause":
+		if !s.paused {
+			s.mode = pausing
+		}
+		return s.respond(req, nil)
+	case "disconnect":
+		// Let the script run to the end without stopping again.
+		s.disconnected = true
+		s.continueWith(running)
+		return s.respond(req, nil)
+	}
+
+	return s.fail(req, fmt.Sprintf("%s is not supported", req.Command))
+}
+
+// Resume the script if it is stopped. The caller must hold s.mu.
+func (s *Server) continueWith(mode stepMode) {
+	if !s.paused {
+		return
+	}
+	s.mode = mode
+	s.stepDepth = len(s.frames)
+	s.paused = false
+	s.frames = nil
+	s.handles = nil
+	s.resume <- struct{}{}
+}
+
+// Run the script, then tell the client how it finished.
+func (s *Server) run() {
+	reporter := errorhandling.NewTextReporter(outputWriter{s, "stderr"})
+	reporter.Source = s.source
+
+	tokens := scanner.NewScanner(s.source, reporter).ScanTokens()
+	p := parser.NewParser(tokens, reporter)
+	statements := p.Parse()
+	if reporter.ExitCode() == 0 {
+		interp := interpreter.NewInterpreter(reporter)
+		interp.SetOutput(outputWriter{s, "stdout"})
+		interp.SetScriptPath(s.program)
+		resolver := interpreter.Resolver{Interp: &interp, Reporter: reporter}
+		if err := resolver.Resolve(statements); err == nil {
+			interp.SetDebugger(s)
+			interp.Interpret(statements)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.event("exited", ExitedEvent{ExitCode: reporter.ExitCode()})
+	s.event("terminated", nil)
+}
+
+// Decide whether to stop before stmt runs, and if so wait until the client
+// resumes the script. Called on the goroutine running the script.
+func (s *Server) BeforeStatement(stmt statement.Statement, frames []*interpreter.Frame) {
+	s.mu.Lock()
+	if s.disconnected {
+		s.mu.Unlock()
+		return
+	}
+
+	line, depth := stmt.SourceSpan().Start.Line, len(frames)
+	reason := ""
+	switch {
+	case s.stopOnEntry:
+		s.stopOnEntry = false
+		reason = "entry"
+	case s.mode == stepIn,
+		s.mode == stepOver && depth <= s.stepDepth,
+		s.mode == stepOut && depth < s.stepDepth:
+		reason = "step"
+	case s.mode == pausing:
+		reason = "pause"
+	case s.breakpoints[filepath.Clean(s.program)][line] && (line != s.lastLine || depth != s.lastDepth):
+		reason = "breakpoint"
+	}
+	s.lastLine, s.lastDepth = line, depth
+	if reason == "" {
+		s.mu.Unlock()
+		return
+	}
+
+	s.paused = true
+	s.frames = frames
+	s.event("stopped", StoppedEvent{Reason: reason, ThreadID: threadID, AllThreadsStopped: true})
+	s.mu.Unlock()
+
+	<-s.resume
+}
+
+// The stack while the script is stopped, innermost call first. Frame IDs
+// count from the outermost frame, so they stay the same while the script is
+// stopped.
+func (s *Server) stackTrace() map[string]any {
+	frames := []StackFrame{}
+	for i := len(s.frames) - 1; i >= 0; i-- {
+		f := s.frames[i]
+		frames = append(frames, StackFrame{
+			ID:        i + 1,
+			Name:      f.Name,
+			Source:    Source{Name: filepath.Base(s.program), Path: s.program},
+			Line:      f.Span.Start.Line,
+			Column:    f.Span.Start.Column,
+			EndLine:   f.Span.End.Line,
+			EndColumn: f.Span.End.Column,
+		})
+	}
+
+	return map[string]any{"stackFrames": frames, "totalFrames": len(frames)}
+}
+
+// Hand out a variables reference that lists the variables returned by f.
+func (s *Server) reference(f func() []Variable) int {
+	s.handles = append(s.handles, f)
+	return len(s.handles)
+}
+
+// Convert variables for display. Instances get a reference to their fields
+// so that the client can expand them.
+func (s *Server) variables(vars []interpreter.Variable) []Variable {
+	result := []Variable{}
+	for _, v := range vars {
+		display := Variable{Name: v.Name, Value: format(v.Value)}
+		if inst, ok := v.Value.(interpreter.LoxInstance); ok {
+			display.VariablesReference = s.reference(func() []Variable { return s.variables(inst.Variables()) })
+		}
+		result = append(result, display)
+	}
+
+	return result
+}
+
+func format(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "nil"
+	case string:
+		return strconv.Quote(v)
+	case float64:
+		return interpreter.FormatFloat(v)
+	}
+
+	return fmt.Sprint(v)
+}
+
+// Sends whatever the script writes to the client as output events.
+type outputWriter struct {
+	s        *Server
+	category string
+}
+
+func (w outputWriter) Write(p []byte) (int, error) {
+	w.s.mu.Lock()
+	defer w.s.mu.Unlock()
+	if err := w.s.event("output", OutputEvent{Category: w.category, Output: string(p)}); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,294 @@
+package dap_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"golox/dap"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const script = `fun add(a, b) {
+  var sum = a + b;
+  return sum;
+}
+class Point {
+  init(x) { this.x = x; }
+}
+var p = Point(1);
+var total = add(1, 2);
+print total;
+`
+
+type message struct {
+	Seq        int
+	Type       string
+	Command    string
+	RequestSeq int `json:"request_seq"`
+	Success    bool
+	Message    string
+	Event      string
+	Body       json.RawMessage
+}
+
+// Drives a server with scripted requests over a pair of pipes.
+type client struct {
+	t        *testing.T
+	toServer *io.PipeWriter
+	messages chan message
+	seq      int
+	// Output events seen so far.
+	output string
+}
+
+func start(t *testing.T) *client {
+	serverIn, toServer := io.Pipe()
+	fromServer, serverOut := io.Pipe()
+	c := &client{t: t, toServer: toServer, messages: make(chan message, 100)}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- dap.NewServer(serverIn, serverOut).Serve()
+		serverOut.Close()
+	}()
+	go func() {
+		r := bufio.NewReader(fromServer)
+		for {
+			header, err := textproto.NewReader(r).ReadMIMEHeader()
+			if err != nil {
+				close(c.messages)
+				return
+			}
+			length, _ := strconv.Atoi(header.Get("Content-Length"))
+			body := make([]byte, length)
+			if _, err := io.ReadFull(r, body); err != nil {
+				close(c.messages)
+				return
+			}
+			var msg message
+			if err := json.Unmarshal(body, &msg); err != nil {
+				panic(err)
+			}
+			c.messages <- msg
+		}
+	}()
+	t.Cleanup(func() {
+		toServer.Close()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Logf("server stopped: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("the server didn't stop")
+		}
+	})
+
+	return c
+}
+
+func (c *client) send(command string, args any) int {
+	c.seq++
+	body, err := json.Marshal(map[string]any{"seq": c.seq, "type": "request", "command": command, "arguments": args})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	go fmt.Fprintf(c.toServer, "Content-Length: %d\r\n\r\n%s", len(body), body)
+
+	return c.seq
+}
+
+// Wait for a message that satisfies match, collecting output on the way.
+func (c *client) await(what string, match func(message) bool) message {
+	c.t.Helper()
+	for {
+		select {
+		case msg, ok := <-c.messages:
+			if !ok {
+				c.t.Fatalf("connection closed while waiting for %s", what)
+			}
+			if msg.Type == "event" && msg.Event == "output" {
+				var out dap.OutputEvent
+				json.Unmarshal(msg.Body, &out)
+				c.output += out.Output
+			}
+			if match(msg) {
+				return msg
+			}
+		case <-time.After(5 * time.Second):
+			c.t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+// Send a request and decode the body of its response, which must succeed,
+// into body.
+func (c *client) request(command string, args any, body any) {
+	c.t.Helper()
+	seq := c.send(command, args)
+	resp := c.await(command+" response", func(m message) bool { return m.Type == "response" && m.RequestSeq == seq })
+	if !resp.Success {
+		c.t.Fatalf("%s failed: %s", command, resp.Message)
+	}
+	if body != nil {
+		if err := json.Unmarshal(resp.Body, body); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+}
+
+func (c *client) event(name string, body any) {
+	c.t.Helper()
+	msg := c.await(name+" event", func(m message) bool { return m.Type == "event" && m.Event == name })
+	if body != nil {
+		if err := json.Unmarshal(msg.Body, body); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+}
+
+// Wait for the script to stop and return the reason and the stack,
+// innermost frame first.
+func (c *client) stopped() (string, []dap.StackFrame) {
+	c.t.Helper()
+	var ev dap.StoppedEvent
+	c.event("stopped", &ev)
+	var trace struct{ StackFrames []dap.StackFrame }
+	c.request("stackTrace", map[string]any{"threadId": ev.ThreadID}, &trace)
+
+	return ev.Reason, trace.StackFrames
+}
+
+// The variables in a frame's scope, by name.
+func (c *client) scope(frameID int, name string) map[string]dap.Variable {
+	c.t.Helper()
+	var scopes struct{ Scopes []dap.Scope }
+	c.request("scopes", map[string]any{"frameId": frameID}, &scopes)
+	for _, s := range scopes.Scopes {
+		if s.Name == name {
+			return c.variables(s.VariablesReference)
+		}
+	}
+	c.t.Fatalf("no %s scope in %v", name, scopes.Scopes)
+	return nil
+}
+
+func (c *client) variables(ref int) map[string]dap.Variable {
+	c.t.Helper()
+	var vars struct{ Variables []dap.Variable }
+	c.request("variables", map[string]any{"variablesReference": ref}, &vars)
+	result := make(map[string]dap.Variable)
+	for _, v := range vars.Variables {
+		result[v.Name] = v
+	}
+
+	return result
+}
+
+func expectStop(t *testing.T, c *client, reason string, name string, line int) []dap.StackFrame {
+	t.Helper()
+	r, frames := c.stopped()
+	if r != reason || len(frames) == 0 || frames[0].Name != name || frames[0].Line != line {
+		t.Fatalf("expected to stop for %s in %s on line %d, stopped for %s at %+v", reason, name, line, r, frames)
+	}
+
+	return frames
+}
+
+func TestSession(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "script.lox")
+	if err := os.WriteFile(path, []byte(script), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := start(t)
+	c.request("initialize", map[string]any{"adapterID": "golox"}, nil)
+	c.event("initialized", nil)
+	c.request("launch", dap.LaunchArguments{Program: path}, nil)
+	var bps struct{ Breakpoints []dap.Breakpoint }
+	c.request("setBreakpoints", dap.SetBreakpointsArguments{
+		Source:      dap.Source{Path: path},
+		Breakpoints: []dap.SourceBreakpoint{{Line: 6}},
+	}, &bps)
+	if len(bps.Breakpoints) != 1 || !bps.Breakpoints[0].Verified {
+		t.Fatalf("unexpected breakpoints %+v", bps.Breakpoints)
+	}
+	c.request("configurationDone", nil, nil)
+
+	// Inside the constructor, this is a local that can be expanded.
+	frames := expectStop(t, c, "breakpoint", "init", 6)
+	if len(frames) != 2 || frames[1].Name != "<script>" || frames[1].Line != 8 {
+		t.Fatalf("unexpected stack %+v", frames)
+	}
+	locals := c.scope(frames[0].ID, "Locals")
+	if locals["x"].Value != "1" || locals["this"].Value != "Point instance" || locals["this"].VariablesReference == 0 {
+		t.Fatalf("unexpected locals %+v", locals)
+	}
+	if fields := c.variables(locals["this"].VariablesReference); len(fields) != 0 {
+		t.Fatalf("expected no fields before the constructor sets them, got %+v", fields)
+	}
+
+	// Stepping over the last statement of the constructor returns to the
+	// script, where the instance's fields are set.
+	c.request("next", map[string]any{"threadId": 1}, nil)
+	frames = expectStop(t, c, "step", "<script>", 9)
+	globals := c.scope(frames[0].ID, "Globals")
+	if fields := c.variables(globals["p"].VariablesReference); fields["x"].Value != "1" {
+		t.Fatalf("unexpected fields %+v", fields)
+	}
+
+	c.request("stepIn", map[string]any{"threadId": 1}, nil)
+	frames = expectStop(t, c, "step", "add", 2)
+	if locals := c.scope(frames[0].ID, "Locals"); locals["a"].Value != "1" || locals["b"].Value != "2" {
+		t.Fatalf("unexpected locals %+v", locals)
+	}
+
+	c.request("next", map[string]any{"threadId": 1}, nil)
+	frames = expectStop(t, c, "step", "add", 3)
+	if locals := c.scope(frames[0].ID, "Locals"); locals["sum"].Value != "3" {
+		t.Fatalf("unexpected locals %+v", locals)
+	}
+
+	c.request("stepOut", map[string]any{"threadId": 1}, nil)
+	expectStop(t, c, "step", "<script>", 10)
+
+	c.request("continue", map[string]any{"threadId": 1}, nil)
+	var exited dap.ExitedEvent
+	c.event("exited", &exited)
+	c.event("terminated", nil)
+	if exited.ExitCode != 0 || c.output != "3\n" {
+		t.Fatalf("expected the script to print 3 and exit 0, got %q and %d", c.output, exited.ExitCode)
+	}
+	c.request("disconnect", nil, nil)
+}
+
+func TestRuntimeError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "script.lox")
+	if err := os.WriteFile(path, []byte("print 1;\nprint -\"a\";\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := start(t)
+	c.request("initialize", nil, nil)
+	c.request("launch", dap.LaunchArguments{Program: path, StopOnEntry: true}, nil)
+	c.request("configurationDone", nil, nil)
+	expectStop(t, c, "entry", "<script>", 1)
+	c.request("continue", nil, nil)
+
+	var exited dap.ExitedEvent
+	c.event("exited", &exited)
+	if exited.ExitCode != 70 {
+		t.Fatalf("expected exit code 70, got %d", exited.ExitCode)
+	}
+	expected := "1\n[line 2]: Operand must be a number.\n   2 | print -\"a\";\n     |       ^\n"
+	if c.output != expected {
+		t.Fatalf("expected output:\n%s\ngot:\n%s", expected, c.output)
+	}
+	c.request("disconnect", nil, nil)
+}
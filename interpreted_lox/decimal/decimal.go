@@ -0,0 +1,318 @@
+// Package decimal implements arbitrary-precision decimal numbers, for sums
+// of money and the like that can't put up with the rounding of floats.
+//
+// A decimal is an integer coefficient scaled by a power of ten, so 1.10 is
+// 110 with a scale of 2. Addition, subtraction and multiplication are exact
+// until the result has more digits than the precision of the Context they're
+// done in, and then they're rounded with its rounding mode.
+//
+// golox and lox-compiler have the same copy of this package. The two must
+// round decimals alike to print the same results, but each is a module of
+// its own that doesn't import the other, so neither can use the other's
+// package. Change both copies and both of their tests together.
+package decimal
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+)
+
+// How a result with too many digits is rounded.
+type Rounding int
+
+const (
+	// To the nearest, with ties to the even neighbour.
+	HalfEven Rounding = iota
+	// To the nearest, with ties away from zero.
+	HalfUp
+	// To the nearest, with ties towards zero.
+	HalfDown
+	// Away from zero.
+	Up
+	// Towards zero.
+	Down
+	// Towards positive infinity.
+	Ceiling
+	// Towards negative infinity.
+	Floor
+)
+
+var roundingNames = [...]string{
+	HalfEven: "half_even",
+	HalfUp:   "half_up",
+	HalfDown: "half_down",
+	Up:       "up",
+	Down:     "down",
+	Ceiling:  "ceiling",
+	Floor:    "floor",
+}
+
+func (r Rounding) String() string {
+	return roundingNames[r]
+}
+
+// The rounding mode called name, such as "half_even".
+func ParseRounding(name string) (Rounding, bool) {
+	for r, n := range roundingNames {
+		if n == name {
+			return Rounding(r), true
+		}
+	}
+	return 0, false
+}
+
+// The precision results are rounded to if a Context doesn't say.
+const DefaultPrecision = 28
+
+// The precision and rounding mode of arithmetic. The zero Context rounds
+// to DefaultPrecision digits, with ties to even.
+type Context struct {
+	// The most significant digits a result may have, or zero for
+	// DefaultPrecision.
+	Precision int
+	Rounding  Rounding
+}
+
+func (c Context) precision() int {
+	if c.Precision <= 0 {
+		return DefaultPrecision
+	}
+	return c.Precision
+}
+
+// A decimal number. The zero Decimal is 0. Decimals are values: nothing
+// changes one once it has been made.
+type Decimal struct {
+	// nil means zero.
+	coef  *big.Int
+	scale int
+}
+
+// The decimal coef / 10^scale. coef mustn't be changed afterwards.
+func New(coef *big.Int, scale int) Decimal {
+	return Decimal{coef: coef, scale: scale}
+}
+
+// The decimal equal to the integer i.
+func FromInt(i *big.Int) Decimal {
+	return Decimal{coef: i}
+}
+
+var ErrSyntax = errors.New("invalid decimal")
+
+// Parse a decimal written in base 10, with an optional sign and fractional
+// part, such as "-12.50". The scale is the number of digits after the point.
+func Parse(s string) (Decimal, error) {
+	whole, frac, _ := strings.Cut(s, ".")
+	digits := strings.TrimLeft(whole, "+-") + frac
+	if len(whole)-len(strings.TrimLeft(whole, "+-")) > 1 || digits == "" || strings.ContainsAny(digits, "+-") {
+		return Decimal{}, ErrSyntax
+	}
+	coef, ok := new(big.Int).SetString(digits, 10)
+	if !ok {
+		return Decimal{}, ErrSyntax
+	}
+	if strings.HasPrefix(whole, "-") {
+		coef.Neg(coef)
+	}
+
+	return Decimal{coef: coef, scale: len(frac)}, nil
+}
+
+func (d Decimal) c() *big.Int {
+	if d.coef == nil {
+		return new(big.Int)
+	}
+	return d.coef
+}
+
+// -1, 0 or 1 as d is negative, zero or positive.
+func (d Decimal) Sign() int {
+	return d.c().Sign()
+}
+
+func (d Decimal) Neg() Decimal {
+	return Decimal{coef: new(big.Int).Neg(d.c()), scale: d.scale}
+}
+
+// -1, 0 or 1 as d is less than, equal to or greater than e.
+func (d Decimal) Cmp(e Decimal) int {
+	x, y, _ := align(d, e)
+	return x.Cmp(y)
+}
+
+// The integer d is equal to, if it's a whole number.
+func (d Decimal) Int() (*big.Int, bool) {
+	if d.scale <= 0 {
+		return new(big.Int).Mul(d.c(), pow10(-d.scale)), true
+	}
+	q, r := new(big.Int).QuoRem(d.c(), pow10(d.scale), new(big.Int))
+	return q, r.Sign() == 0
+}
+
+// d as a fraction.
+func (d Decimal) Rat() *big.Rat {
+	if d.scale <= 0 {
+		return new(big.Rat).SetInt(new(big.Int).Mul(d.c(), pow10(-d.scale)))
+	}
+	return new(big.Rat).SetFrac(d.c(), pow10(d.scale))
+}
+
+// d with no zeros at the end of its fractional part, so that decimals that
+// are equal look the same.
+func (d Decimal) Reduce() Decimal {
+	return strip(d.c(), d.scale, 0)
+}
+
+// d in positional notation, with as many digits after the point as its scale.
+func (d Decimal) String() string {
+	if d.scale <= 0 {
+		return new(big.Int).Mul(d.c(), pow10(-d.scale)).String()
+	}
+	s := new(big.Int).Abs(d.c()).String()
+	if len(s) <= d.scale {
+		s = strings.Repeat("0", d.scale-len(s)+1) + s
+	}
+	s = s[:len(s)-d.scale] + "." + s[len(s)-d.scale:]
+	if d.Sign() < 0 {
+		s = "-" + s
+	}
+	return s
+}
+
+func (c Context) Add(x, y Decimal) Decimal {
+	a, b, scale := align(x, y)
+	return c.round(a.Add(a, b), scale, false)
+}
+
+func (c Context) Sub(x, y Decimal) Decimal {
+	a, b, scale := align(x, y)
+	return c.round(a.Sub(a, b), scale, false)
+}
+
+func (c Context) Mul(x, y Decimal) Decimal {
+	return c.round(new(big.Int).Mul(x.c(), y.c()), x.scale+y.scale, false)
+}
+
+// x / y, or false if y is zero. An exact quotient has no more digits after
+// the point than it needs, or than x has less y.
+func (c Context) Quo(x, y Decimal) (Decimal, bool) {
+	if y.Sign() == 0 {
+		return Decimal{}, false
+	}
+	ideal := x.scale - y.scale
+	// Shift x far enough left for the quotient to have a digit more than
+	// the precision, so that it can be rounded.
+	shift := max(c.precision()+1+digits(y.c())-digits(x.c()), 0)
+	num := new(big.Int).Mul(x.c(), pow10(shift))
+	q, r := num.QuoRem(num, y.c(), new(big.Int))
+	if r.Sign() == 0 {
+		d := strip(q, ideal+shift, ideal)
+		return c.round(d.coef, d.scale, false), true
+	}
+
+	return c.round(q, ideal+shift, true), true
+}
+
+// The remainder of x / y with the quotient truncated towards zero, which has
+// the sign of x, or false if y is zero.
+func (c Context) Rem(x, y Decimal) (Decimal, bool) {
+	if y.Sign() == 0 {
+		return Decimal{}, false
+	}
+	a, b, scale := align(x, y)
+	return c.round(a.Rem(a, b), scale, false), true
+}
+
+// d rounded to the precision of c.
+func (c Context) Round(d Decimal) Decimal {
+	return c.round(d.c(), d.scale, false)
+}
+
+// Round coef / 10^scale to the precision of c. inexact says that the number
+// being rounded is a little further from zero than that, which decides ties
+// and whether rounding up is needed at all; coef must then have more digits
+// than the precision.
+func (c Context) round(coef *big.Int, scale int, inexact bool) Decimal {
+	excess := digits(coef) - c.precision()
+	if excess <= 0 {
+		return Decimal{coef: coef, scale: scale}
+	}
+	unit := pow10(excess)
+	q, r := new(big.Int).QuoRem(coef, unit, new(big.Int))
+	// How the part being dropped compares with half a unit.
+	half := new(big.Int).Lsh(new(big.Int).Abs(r), 1).Cmp(unit)
+	if half == 0 && inexact {
+		half = 1
+	}
+	inexact = inexact || r.Sign() != 0
+	neg := coef.Sign() < 0
+
+	var up bool
+	switch c.Rounding {
+	case HalfEven:
+		up = half > 0 || half == 0 && q.Bit(0) == 1
+	case HalfUp:
+		up = half >= 0
+	case HalfDown:
+		up = half > 0
+	case Up:
+		up = inexact
+	case Down:
+		up = false
+	case Ceiling:
+		up = inexact && !neg
+	case Floor:
+		up = inexact && neg
+	}
+	if up {
+		if neg {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+		// Rounding 999 up gives 1000, a digit too many.
+		if digits(q) > c.precision() {
+			q.Quo(q, big.NewInt(10))
+			excess++
+		}
+	}
+
+	return Decimal{coef: q, scale: scale - excess}
+}
+
+// The coefficients of x and y at the larger of their scales.
+func align(x, y Decimal) (a, b *big.Int, scale int) {
+	scale = max(x.scale, y.scale)
+	a = new(big.Int).Mul(x.c(), pow10(scale-x.scale))
+	b = new(big.Int).Mul(y.c(), pow10(scale-y.scale))
+	return a, b, scale
+}
+
+// coef / 10^scale with the zeros at the end of coef dropped, for as long as
+// the scale stays at least minScale.
+func strip(coef *big.Int, scale, minScale int) Decimal {
+	coef = new(big.Int).Set(coef)
+	ten, r := big.NewInt(10), new(big.Int)
+	for scale > minScale && coef.Sign() != 0 {
+		q, _ := new(big.Int).QuoRem(coef, ten, r)
+		if r.Sign() != 0 {
+			break
+		}
+		coef, scale = q, scale-1
+	}
+	if coef.Sign() == 0 && scale > minScale {
+		scale = minScale
+	}
+	return Decimal{coef: coef, scale: scale}
+}
+
+// The number of decimal digits in x, not counting the sign.
+func digits(x *big.Int) int {
+	return len(new(big.Int).Abs(x).String())
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
@@ -1,13 +1,182 @@
+// Package errorhandling reports the problems found in a program while it is
+// scanned, parsed, resolved and run. Each stage reports through an
+// ErrorReporter, so the same front end can print errors for a person, emit
+// them for a tool, or collect them for a test.
 package errorhandling
 
 import (
+	"encoding/json"
 	"fmt"
+	"golox/source"
+	"io"
 )
 
-func Report(line int, where, message string) {
-	fmt.Printf("[line %d] Error %s: '%s'\n", line, where, message)
+// Exit statuses for scripts that fail, following sysexits.h.
+const (
+	ExitStaticError  = 65
+	ExitRuntimeError = 70
+)
+
+// The stage of running a program that found a problem.
+type Phase int
+
+const (
+	Scanning Phase = iota
+	Parsing
+	Resolving
+	Running
+)
+
+var phaseNames = map[Phase]string{
+	Scanning:  "scan",
+	Parsing:   "parse",
+	Resolving: "resolve",
+	Running:   "runtime",
+}
+
+func (p Phase) String() string {
+	return phaseNames[p]
+}
+
+// Errors found before the program starts running stop it from running at
+// all; runtime errors stop it part way through.
+func (p Phase) IsStatic() bool {
+	return p != Running
+}
+
+// A single problem found in a program.
+type Diagnostic struct {
+	Phase Phase
+	Line  int
+	// The source text the problem was found at, or a zero span if it can't
+	// be attributed to any.
+	Span source.Span
+	// Describes where on the line the problem is, such as " at 'x'" or
+	// " at end". Empty if there is nothing to add to the line number.
+	Where   string
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	if !d.Phase.IsStatic() {
+		if d.Line <= 0 {
+			return d.Message
+		}
+		return fmt.Sprintf("[line %d]: %s", d.Line, d.Message)
+	}
+
+	return fmt.Sprintf("[line %d] Error%s: %s", d.Line, d.Where, d.Message)
+}
+
+type ErrorReporter interface {
+	Report(d Diagnostic)
+	// The status a script should exit with given the diagnostics reported
+	// so far: 0 if there were none.
+	ExitCode() int
+	// Forget the diagnostics reported so far, as the REPL does between
+	// lines.
+	Reset()
+}
+
+// Keeps track of the worst diagnostic reported, for ErrorReporter.ExitCode.
+type tally struct {
+	hadError, hadRuntimeError bool
+}
+
+func (t *tally) record(d Diagnostic) {
+	if d.Phase.IsStatic() {
+		t.hadError = true
+	} else {
+		t.hadRuntimeError = true
+	}
+}
+
+func (t tally) ExitCode() int {
+	if t.hadError {
+		return ExitStaticError
+	}
+	if t.hadRuntimeError {
+		return ExitRuntimeError
+	}
+
+	return 0
+}
+
+func (t *tally) Reset() {
+	*t = tally{}
+}
+
+// Writes diagnostics for a person to read, each followed by an excerpt of
+// Source pointing at the problem if Source is set.
+type TextReporter struct {
+	tally
+	Out    io.Writer
+	Source string
+}
+
+func NewTextReporter(out io.Writer) *TextReporter {
+	return &TextReporter{Out: out}
+}
+
+func (r *TextReporter) Report(d Diagnostic) {
+	r.record(d)
+	fmt.Fprintln(r.Out, d)
+	if r.Source != "" {
+		fmt.Fprint(r.Out, source.Render(r.Source, d.Span))
+	}
+}
+
+// Writes each diagnostic as a JSON object on a line of its own, for editors
+// and other tools. Lines and columns count from 1; offsets count bytes from
+// the start of the source.
+type JSONReporter struct {
+	tally
+	Out io.Writer
+}
+
+type jsonPosition struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+	Offset int `json:"offset"`
+}
+
+type jsonDiagnostic struct {
+	Phase   string        `json:"phase"`
+	Line    int           `json:"line"`
+	Start   *jsonPosition `json:"start,omitempty"`
+	End     *jsonPosition `json:"end,omitempty"`
+	Message string        `json:"message"`
+	Text    string        `json:"text"`
+}
+
+func NewJSONReporter(out io.Writer) *JSONReporter {
+	return &JSONReporter{Out: out}
+}
+
+func (r *JSONReporter) Report(d Diagnostic) {
+	r.record(d)
+	out := jsonDiagnostic{Phase: d.Phase.String(), Line: d.Line, Message: d.Message, Text: d.String()}
+	if !d.Span.IsZero() {
+		out.Start = &jsonPosition{d.Span.Start.Line, d.Span.Start.Column, d.Span.Start.Offset}
+		out.End = &jsonPosition{d.Span.End.Line, d.Span.End.Column, d.Span.End.Offset}
+	}
+	// Encoding can't fail for this type, and a failed write to stderr has
+	// nowhere better to be reported.
+	json.NewEncoder(r.Out).Encode(out)
+}
+
+// Keeps every diagnostic reported, for tests to inspect.
+type CollectingReporter struct {
+	tally
+	Diagnostics []Diagnostic
+}
+
+func (r *CollectingReporter) Report(d Diagnostic) {
+	r.record(d)
+	r.Diagnostics = append(r.Diagnostics, d)
 }
 
-func RuntimeError(err error) {
-	fmt.Println(err.Error())
+func (r *CollectingReporter) Reset() {
+	r.tally.Reset()
+	r.Diagnostics = nil
 }
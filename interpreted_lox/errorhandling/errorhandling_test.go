@@ -0,0 +1,114 @@
+package errorhandling_test
+
+import (
+	"encoding/json"
+	"golox/errorhandling"
+	"golox/source"
+	"strings"
+	"testing"
+)
+
+var parseError = errorhandling.Diagnostic{
+	Phase: errorhandling.Parsing,
+	Line:  2,
+	Span: source.Span{
+		Start: source.Position{Line: 2, Column: 5, Offset: 13},
+		End:   source.Position{Line: 2, Column: 6, Offset: 14},
+	},
+	Where:   " at '1'",
+	Message: "Expect variable name.",
+}
+
+var runtimeError = errorhandling.Diagnostic{
+	Phase:   errorhandling.Running,
+	Line:    1,
+	Message: "Operands must be numbers.",
+}
+
+func TestString(t *testing.T) {
+	if s := parseError.String(); s != "[line 2] Error at '1': Expect variable name." {
+		t.Errorf("unexpected static error %q", s)
+	}
+	if s := runtimeError.String(); s != "[line 1]: Operands must be numbers." {
+		t.Errorf("unexpected runtime error %q", s)
+	}
+	noLine := errorhandling.Diagnostic{Phase: errorhandling.Running, Message: "Stack overflow."}
+	if s := noLine.String(); s != "Stack overflow." {
+		t.Errorf("unexpected runtime error without a line %q", s)
+	}
+}
+
+func TestTextReporter(t *testing.T) {
+	out := strings.Builder{}
+	r := errorhandling.NewTextReporter(&out)
+	r.Source = "print 1;\nvar 1 = 2;\n"
+	r.Report(parseError)
+
+	expected := "[line 2] Error at '1': Expect variable name.\n" +
+		"   2 | var 1 = 2;\n" +
+		"     |     ^\n"
+	if out.String() != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+func TestJSONReporter(t *testing.T) {
+	out := strings.Builder{}
+	r := errorhandling.NewJSONReporter(&out)
+	r.Report(parseError)
+	r.Report(runtimeError)
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected one line per diagnostic, got %q", out.String())
+	}
+
+	var d struct {
+		Phase         string
+		Line          int
+		Start         *struct{ Line, Column, Offset int }
+		End           *struct{ Line, Column, Offset int }
+		Message, Text string
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Phase != "parse" || d.Line != 2 || d.Message != parseError.Message || d.Text != parseError.String() {
+		t.Fatalf("unexpected diagnostic %+v", d)
+	}
+	if d.Start == nil || d.Start.Column != 5 || d.Start.Offset != 13 || d.End == nil || d.End.Offset != 14 {
+		t.Fatalf("unexpected span %+v to %+v", d.Start, d.End)
+	}
+
+	d.Start, d.End = nil, nil
+	if err := json.Unmarshal([]byte(lines[1]), &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Phase != "runtime" || d.Start != nil || d.End != nil {
+		t.Fatalf("unexpected diagnostic %+v", d)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	r := &errorhandling.CollectingReporter{}
+	if r.ExitCode() != 0 {
+		t.Fatalf("expected exit code 0 before any errors, got %d", r.ExitCode())
+	}
+
+	r.Report(runtimeError)
+	if r.ExitCode() != errorhandling.ExitRuntimeError {
+		t.Fatalf("expected exit code %d, got %d", errorhandling.ExitRuntimeError, r.ExitCode())
+	}
+	r.Report(parseError)
+	if r.ExitCode() != errorhandling.ExitStaticError {
+		t.Fatalf("expected static errors to take precedence, got %d", r.ExitCode())
+	}
+	if len(r.Diagnostics) != 2 || r.Diagnostics[1] != parseError {
+		t.Fatalf("unexpected diagnostics %v", r.Diagnostics)
+	}
+
+	r.Reset()
+	if r.ExitCode() != 0 || len(r.Diagnostics) != 0 {
+		t.Fatal("expected Reset to forget the diagnostics")
+	}
+}
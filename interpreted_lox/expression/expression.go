@@ -2,14 +2,19 @@ package expression
 
 import (
 	"fmt"
+	"golox/decimal"
 	"golox/scanner"
+	"golox/source"
+	"math/big"
 	"strconv"
 	"strings"
 )
 
+// Every expression embeds the source.Span of the text it was parsed from.
 type Expr interface {
 	Expand_to_string() string
 	Accept(v Visitor)
+	SourceSpan() source.Span
 }
 
 func parenthesize(name string, exprs ...Expr) string {
@@ -26,6 +31,7 @@ func parenthesize(name string, exprs ...Expr) string {
 }
 
 type Assign struct {
+	source.Span
 	Name  scanner.Token
 	Value Expr
 }
@@ -35,6 +41,7 @@ func (e Assign) Accept(v Visitor) {
 }
 
 type Binary struct {
+	source.Span
 	Left     Expr
 	Operator scanner.Token
 	Right    Expr
@@ -45,13 +52,23 @@ func (e Binary) Accept(v Visitor) {
 }
 
 type Call struct {
+	source.Span
 	Callee Expr
 	Paren  scanner.Token
 	Args   []Expr
+	// The arguments passed by name, such as "b: 2", which follow the
+	// positional ones.
+	Named []NamedArg
 }
 
-func NewCall(callee Expr, paren scanner.Token, args []Expr) Call {
-	return Call{Callee: callee, Paren: paren, Args: args}
+// An argument passed to a parameter by its name.
+type NamedArg struct {
+	Name  scanner.Token
+	Value Expr
+}
+
+func NewCall(span source.Span, callee Expr, paren scanner.Token, args []Expr) Call {
+	return Call{Span: span, Callee: callee, Paren: paren, Args: args}
 }
 
 func (e Call) Accept(v Visitor) {
@@ -59,6 +76,7 @@ func (e Call) Accept(v Visitor) {
 }
 
 type Get struct {
+	source.Span
 	Object Expr
 	Name   scanner.Token
 }
@@ -72,6 +90,7 @@ func (e Get) Expand_to_string() string {
 }
 
 type Grouping struct {
+	source.Span
 	Expr Expr
 }
 
@@ -80,6 +99,7 @@ func (e Grouping) Accept(v Visitor) {
 }
 
 type Literal struct {
+	source.Span
 	Value any
 }
 
@@ -88,13 +108,14 @@ func (e Literal) Accept(v Visitor) {
 }
 
 type Logical struct {
+	source.Span
 	Left     Expr
 	Operator scanner.Token
 	Right    Expr
 }
 
 func NewLogical(left Expr, operator scanner.Token, right Expr) Logical {
-	return Logical{Left: left, Operator: operator, Right: right}
+	return Logical{Span: left.SourceSpan().Join(right.SourceSpan()), Left: left, Operator: operator, Right: right}
 }
 
 func (e Logical) Accept(v Visitor) {
@@ -112,6 +133,7 @@ func (e Logical) Expand_to_string() string {
 }
 
 type Unary struct {
+	source.Span
 	Operator scanner.Token
 	Right    Expr
 }
@@ -121,6 +143,7 @@ func (e Unary) Accept(v Visitor) {
 }
 
 type Set struct {
+	source.Span
 	Object Expr
 	Name   scanner.Token
 	Value  Expr
@@ -138,7 +161,157 @@ func (e Set) Accept(v Visitor) {
 	v.VisitSet(e)
 }
 
+// A list literal, such as "[1, 2, 3]".
+type List struct {
+	source.Span
+	Bracket  scanner.Token
+	Elements []Expr
+}
+
+func (e List) Accept(v Visitor) {
+	v.VisitList(e)
+}
+
+func (e List) Expand_to_string() string {
+	elements := make([]string, len(e.Elements))
+	for i, element := range e.Elements {
+		elements[i] = element.Expand_to_string()
+	}
+
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+// A map literal, such as "{"a": 1, "b": 2}". Keys[i] maps to Values[i].
+type Map struct {
+	source.Span
+	Brace  scanner.Token
+	Keys   []Expr
+	Values []Expr
+}
+
+func (e Map) Accept(v Visitor) {
+	v.VisitMap(e)
+}
+
+func (e Map) Expand_to_string() string {
+	entries := make([]string, len(e.Keys))
+	for i := range e.Keys {
+		entries[i] = e.Keys[i].Expand_to_string() + ": " + e.Values[i].Expand_to_string()
+	}
+
+	return "{" + strings.Join(entries, ", ") + "}"
+}
+
+// A string literal with expressions in it, such as "Hello ${name}". Strings
+// are the tokens of the literal's text around the expressions, one more than
+// there are expressions.
+type Interpolation struct {
+	source.Span
+	Strings []scanner.Token
+	Exprs   []Expr
+}
+
+func (e Interpolation) Accept(v Visitor) {
+	v.VisitInterpolation(e)
+}
+
+func (e Interpolation) Expand_to_string() string {
+	parts := []string{"interpolate"}
+	for i, str := range e.Strings {
+		parts = append(parts, strconv.Quote(*str.Literal.(*string)))
+		if i < len(e.Exprs) {
+			parts = append(parts, e.Exprs[i].Expand_to_string())
+		}
+	}
+
+	return "(" + strings.Join(parts, " ") + ")"
+}
+
+// The numbers from Start up to but not including End, such as "0..n".
+type Range struct {
+	source.Span
+	Start    Expr
+	Operator scanner.Token
+	End      Expr
+}
+
+func (e Range) Accept(v Visitor) {
+	v.VisitRange(e)
+}
+
+func (e Range) Expand_to_string() string {
+	return e.Start.Expand_to_string() + ".." + e.End.Expand_to_string()
+}
+
+// A function expression, such as "fun (a, b) { return a + b; }", or the
+// arrow form "(a, b) => a + b", whose body is a single return statement.
+type Lambda struct {
+	source.Span
+	Keyword scanner.Token
+	Params  []scanner.Token
+	// The default value of each parameter, nil where there is none.
+	Defaults []Expr
+	// Whether the last parameter collects the rest of the arguments.
+	Variadic bool
+	// The []statement.Statement of the body. The statement package imports
+	// this one, so the type can't be named here.
+	Body  any
+	Arrow bool
+}
+
+func (e Lambda) Accept(v Visitor) {
+	v.VisitLambda(e)
+}
+
+func (e Lambda) Expand_to_string() string {
+	params := make([]string, len(e.Params))
+	for i, p := range e.Params {
+		params[i] = p.Lexeme
+	}
+
+	return "(fun (" + strings.Join(params, " ") + "))"
+}
+
+// An element of a list or map read by index or key, such as "xs[i]".
+type Subscript struct {
+	source.Span
+	Object  Expr
+	Bracket scanner.Token
+	Index   Expr
+}
+
+func (e Subscript) Accept(v Visitor) {
+	v.VisitSubscript(e)
+}
+
+func (e Subscript) Expand_to_string() string {
+	return e.Object.Expand_to_string() + "[" + e.Index.Expand_to_string() + "]"
+}
+
+// An element of a list or map assigned by index or key, such as
+// "xs[i] = v".
+type SubscriptSet struct {
+	source.Span
+	Object  Expr
+	Bracket scanner.Token
+	Index   Expr
+	Value   Expr
+}
+
+func (e SubscriptSet) Accept(v Visitor) {
+	v.VisitSubscriptSet(e)
+}
+
+func (e SubscriptSet) Expand_to_string() string {
+	return fmt.Sprintf("%s[%s] = %s",
+		e.Object.Expand_to_string(),
+		e.Index.Expand_to_string(),
+		e.Value.Expand_to_string(),
+	)
+}
+
 type Super struct {
+	source.Span
 	Keyword scanner.Token
 	Method  scanner.Token
 }
@@ -152,6 +325,7 @@ func (e Super) Expand_to_string() string {
 }
 
 type This struct {
+	source.Span
 	Keyword scanner.Token
 }
 
@@ -164,11 +338,12 @@ func (e This) Expand_to_string() string {
 }
 
 type Variable struct {
+	source.Span
 	Name scanner.Token
 }
 
 func NewVariableExpression(name scanner.Token) Variable {
-	return Variable{Name: name}
+	return Variable{Span: name.Span, Name: name}
 }
 
 func (e Variable) Accept(v Visitor) {
@@ -208,6 +383,9 @@ func (e Call) Expand_to_string() string {
 	for _, i := range e.Args {
 		sb.WriteString(i.Expand_to_string())
 	}
+	for _, i := range e.Named {
+		sb.WriteString(i.Name.Lexeme + ": " + i.Value.Expand_to_string())
+	}
 	sb.WriteString(")")
 
 	return sb.String()
@@ -229,10 +407,14 @@ func (e Literal) Expand_to_string() string {
 		return *v
 	case int:
 		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
 	case float64:
 		// return strconv.FormatFloat(v, 'f', 32, 64)
 		s := fmt.Sprintf("%f", v)
 		return s
+	case *big.Int, decimal.Decimal:
+		return fmt.Sprint(v)
 	default:
 		panic("Unexpected type")
 	}
@@ -252,9 +434,16 @@ type Visitor interface {
 	VisitCall(e Call)
 	VisitGet(e Get)
 	VisitGrouping(e Grouping)
+	VisitList(e List)
 	VisitLiteral(e Literal)
 	VisitLogical(e Logical)
+	VisitMap(e Map)
+	VisitRange(e Range)
+	VisitInterpolation(e Interpolation)
+	VisitLambda(e Lambda)
 	VisitSet(e Set)
+	VisitSubscript(e Subscript)
+	VisitSubscriptSet(e SubscriptSet)
 	VisitSuper(e Super)
 	VisitThis(e This)
 	VisitUnary(e Unary)
@@ -295,7 +484,16 @@ func (v *ExpressionStringVisitor) VisitBinary(e Binary) {
 
 func (v *ExpressionStringVisitor) VisitCall(e Call) {
 	e.Callee.Accept(v)
-	v.parenthesize("arguments", e.Args...)
+	v.expr_string_builder.WriteString("(arguments")
+	for _, arg := range e.Args {
+		v.expr_string_builder.WriteString(" ")
+		arg.Accept(v)
+	}
+	for _, arg := range e.Named {
+		v.expr_string_builder.WriteString(" " + arg.Name.Lexeme + ": ")
+		arg.Value.Accept(v)
+	}
+	v.expr_string_builder.WriteString(")")
 }
 
 func (v *ExpressionStringVisitor) VisitGet(e Get) {
@@ -320,10 +518,14 @@ func (v *ExpressionStringVisitor) VisitLiteral(e Literal) {
 		v.expr_string_builder.WriteString(*val)
 	case int:
 		v.expr_string_builder.WriteString(strconv.Itoa(val))
+	case int64:
+		v.expr_string_builder.WriteString(strconv.FormatInt(val, 10))
 	case float64:
 		// return strconv.FormatFloat(v, 'f', 32, 64)
 		s := fmt.Sprintf("%f", val)
 		v.expr_string_builder.WriteString(s)
+	case *big.Int, decimal.Decimal:
+		v.expr_string_builder.WriteString(fmt.Sprint(val))
 	default:
 		panic("Unexpected type")
 	}
@@ -349,6 +551,57 @@ func (v *ExpressionStringVisitor) VisitSet(e Set) {
 	e.Value.Accept(v)
 }
 
+func (v *ExpressionStringVisitor) VisitList(e List) {
+	v.expr_string_builder.WriteString("[")
+	for i, element := range e.Elements {
+		if i > 0 {
+			v.expr_string_builder.WriteString(", ")
+		}
+		element.Accept(v)
+	}
+	v.expr_string_builder.WriteString("]")
+}
+
+func (v *ExpressionStringVisitor) VisitMap(e Map) {
+	v.expr_string_builder.WriteString("{")
+	for i := range e.Keys {
+		if i > 0 {
+			v.expr_string_builder.WriteString(", ")
+		}
+		e.Keys[i].Accept(v)
+		v.expr_string_builder.WriteString(": ")
+		e.Values[i].Accept(v)
+	}
+	v.expr_string_builder.WriteString("}")
+}
+
+func (v *ExpressionStringVisitor) VisitRange(e Range) {
+	e.Start.Accept(v)
+	v.expr_string_builder.WriteString("..")
+	e.End.Accept(v)
+}
+
+func (v *ExpressionStringVisitor) VisitInterpolation(e Interpolation) {
+	v.expr_string_builder.WriteString(e.Expand_to_string())
+}
+
+func (v *ExpressionStringVisitor) VisitLambda(e Lambda) {
+	v.expr_string_builder.WriteString(e.Expand_to_string())
+}
+
+func (v *ExpressionStringVisitor) VisitSubscript(e Subscript) {
+	e.Object.Accept(v)
+	v.expr_string_builder.WriteString("[")
+	e.Index.Accept(v)
+	v.expr_string_builder.WriteString("]")
+}
+
+func (v *ExpressionStringVisitor) VisitSubscriptSet(e SubscriptSet) {
+	v.VisitSubscript(Subscript{Object: e.Object, Index: e.Index})
+	v.expr_string_builder.WriteString(" = ")
+	e.Value.Accept(v)
+}
+
 func (v *ExpressionStringVisitor) VisitUnary(e Unary) {
 	v.parenthesize(e.Operator.Lexeme, e.Right)
 }
@@ -2,50 +2,72 @@ package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
+	"golox/dap"
+	"golox/errorhandling"
 	"golox/interpreter"
 	"golox/parser"
 	"golox/scanner"
 	"os"
+	"path/filepath"
 )
 
-var hadError bool // Improvement idea: Implement an ErrorHandling interface so we can pass different strategies
+var jsonErrors = flag.Bool("json", false, "report errors as JSON objects, one per line")
+var debugAdapter = flag.Bool("dap", false, "serve the Debug Adapter Protocol on stdin and stdout")
+var searchPath = flag.String("path", os.Getenv("LOXPATH"), "directories to search for imported modules, separated by '"+string(filepath.ListSeparator)+"'")
+
+var reporter errorhandling.ErrorReporter
 var interp interpreter.Interpreter
 
 func main() {
-	interp = interpreter.NewInterpreter()
+	flag.Parse()
+	if *debugAdapter {
+		if err := dap.NewServer(os.Stdin, os.Stdout).Serve(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	text := errorhandling.NewTextReporter(os.Stderr)
+	reporter = text
+	if *jsonErrors {
+		reporter = errorhandling.NewJSONReporter(os.Stderr)
+	}
+	interp = interpreter.NewInterpreter(reporter)
+	interp.SetSearchPath(filepath.SplitList(*searchPath))
 
-	if len(os.Args) > 2 {
-		panic("Need two or more args")
-	} else if len(os.Args) == 2 {
-		fmt.Println(os.Args)
-		file, err := os.ReadFile(os.Args[1])
+	if flag.NArg() > 1 {
+		fmt.Fprintln(os.Stderr, "Usage: golox [-json] [-path dirs] [script]\n       golox -dap")
+		os.Exit(64)
+	} else if flag.NArg() == 1 {
+		err := runFile(flag.Arg(0), text)
 		if err != nil {
-			panic(err)
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(66)
 		}
-		fmt.Println(string(file))
-		run(string(file))
 	} else {
-		runPrompt()
+		runPrompt(text)
 	}
 }
 
-func runFile(path string) error {
+func runFile(path string, text *errorhandling.TextReporter) error {
 	file, err := os.ReadFile(path)
 	if err != nil {
 		return err
 	}
-	fmt.Println(file)
+	text.Source = string(file)
+	interp.SetScriptPath(path)
 	run(string(file))
 
-	if hadError {
-		os.Exit(65)
+	if code := reporter.ExitCode(); code != 0 {
+		os.Exit(code)
 	}
 
 	return nil
 }
 
-func runPrompt() error {
+func runPrompt(text *errorhandling.TextReporter) error {
 	interp.EnableInteractiveMode()
 	reader := bufio.NewReader(os.Stdin)
 	for {
@@ -57,26 +79,25 @@ func runPrompt() error {
 			}
 			panic(err)
 		}
+		text.Source = line
 		run(line)
-		hadError = false
+		reporter.Reset()
 	}
 }
 
-func run(source string) {
-	scanner := scanner.NewScanner(source)
+func run(src string) {
+	scanner := scanner.NewScanner(src, reporter)
 	tokens := scanner.ScanTokens()
-	parser := parser.NewParser(tokens)
+	parser := parser.NewParser(tokens, reporter)
 
 	statements := parser.Parse()
-	if statements == nil {
+	if reporter.ExitCode() != 0 {
+		return
+	}
+	resolver := interpreter.Resolver{Interp: &interp, Reporter: reporter}
+	if err := resolver.Resolve(statements); err != nil {
 		return
 	}
-    resolver := interpreter.Resolver{Interp: &interp}
-    err := resolver.Resolve(statements)
-    if err != nil {
-        fmt.Println(err)
-        return
-    }
 
 	interp.Interpret(statements)
 }
@@ -1,16 +1,18 @@
 package interpreter
 
 import (
+	"golox/expression"
+	"golox/scanner"
 	"golox/statement"
 )
 
 type BuiltinCallable struct {
-    params []string
+	arity Arity
 	foo   func(interp Interpreter, args []any) any
 }
 
-func (c BuiltinCallable) Arity() int {
-	return len(c.params)
+func (c BuiltinCallable) Arity() Arity {
+	return c.arity
 }
 
 func (c BuiltinCallable) Call(interp Interpreter, args []any) (any, *RuntimeError) {
@@ -21,34 +23,132 @@ func (c BuiltinCallable) String() string {
     return "<native fn>"
 }
 
+// A built-in function that can fail. It doesn't know where it was called
+// from, so its errors are reported at the call.
+type nativeFunction struct {
+	arity Arity
+	// Returns the message of the error instead if the call fails.
+	call func(interp Interpreter, args []any) (any, string)
+}
+
+func (f nativeFunction) Arity() Arity {
+	return f.arity
+}
+
+func (f nativeFunction) Call(interp Interpreter, args []any) (any, *RuntimeError) {
+	val, message := f.call(interp, args)
+	if message != "" {
+		return nil, &RuntimeError{error: message}
+	}
+	return val, nil
+}
+
+func (f nativeFunction) String() string {
+	return "<native fn>"
+}
+
 type UserCallable struct {
     declaration statement.Function
     closure *Environment
+    // The globals and resolved locals of the module the function was
+    // declared in, which it keeps using when another module calls it.
+    globals Environment
+    locals  map[expression.Expr]int
+}
+
+func (c UserCallable) Arity() Arity {
+    a := Arity{Max: len(c.declaration.Params)}
+    for i := range c.declaration.Params {
+        if c.declaration.Defaults[i] == nil && !c.isRest(i) {
+            a.Min = i + 1
+        }
+    }
+    if c.declaration.Variadic {
+        a.Max = -1
+    }
+
+    return a
+}
+
+func (c UserCallable) params() []scanner.Token {
+    return c.declaration.Params
 }
 
-func (c UserCallable) Arity() int {
-    return len(c.declaration.Params)
+// Whether the i'th parameter collects the rest of the arguments.
+func (c UserCallable) isRest(i int) bool {
+    return c.declaration.Variadic && i == len(c.declaration.Params)-1
 }
 
 func (c UserCallable) Call(interp Interpreter, args []any) (any, *RuntimeError) {
+   // interp is a copy, so this only lasts for the call.
+   interp.globals, interp.locals = c.globals, c.locals
    // Create env
    env := NewEnvironment()
    env.SetEnclosing(c.closure)
-   // Map param name to arg values
-   for i, v := range args {
-       env.Define(c.declaration.Params[i].Lexeme, v)
+   if interp.debugger != nil {
+       // interp is a copy, so the frame is popped when the call returns.
+       interp.frames = append(interp.frames, &Frame{Name: c.name()})
+   }
+   if err := c.bind(&interp, &env, args); err != nil {
+       return nil, err
    }
    // Evaluate block
    interp.executeBlock(c.declaration.Body, env)
-   if interp.err == nil && c.declaration.Name.Lexeme == constructor_name {
-       interp.val, _ = c.closure.GetAt(0, "this")
+   if interp.err != nil {
+       // A return statement unwinds with its value as an error.
+       return nil, interp.err
+   }
+   if c.declaration.Name.Lexeme == constructor_name {
+       this, _ := c.closure.GetAt(0, "this")
+       return this, nil
    }
 
-   return interp.val, interp.err
+   // Falling off the end of the body returns nil, not the value of the
+   // last expression statement.
+   return nil, nil
+}
+
+// Define the parameters in env. Those given no argument get their default
+// value, which is evaluated in env so that it can use the parameters before
+// it.
+func (c UserCallable) bind(interp *Interpreter, env *Environment, args []any) *RuntimeError {
+    interp.pushEnvironment(env)
+    defer interp.popEnvironment()
+    for i, param := range c.declaration.Params {
+        if c.isRest(i) {
+            rest := &LoxList{}
+            if i < len(args) {
+                rest.Elements = append(rest.Elements, args[i:]...)
+            }
+            env.Define(param.Lexeme, rest)
+            break
+        }
+        var value any = missingArg{}
+        if i < len(args) {
+            value = args[i]
+        }
+        if value == (missingArg{}) {
+            var err *RuntimeError
+            if value, err = interp.Evaluate(c.declaration.Defaults[i]); err != nil {
+                return err
+            }
+        }
+        env.Define(param.Lexeme, value)
+    }
+
+    return nil
 }
 
 func (c UserCallable) String() string {
-    return "<fn " + c.declaration.Name.Lexeme + ">"
+    return "<fn " + c.name() + ">"
+}
+
+// The function's name, or "lambda" for a function expression.
+func (c UserCallable) name() string {
+    if c.declaration.Name.Lexeme == "" {
+        return "lambda"
+    }
+    return c.declaration.Name.Lexeme
 }
 
 func (c *UserCallable) Bind(inst LoxInstance) UserCallable {
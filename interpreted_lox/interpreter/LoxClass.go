@@ -22,17 +22,23 @@ func (c LoxClass) Call(interp Interpreter, args []any) (any, *RuntimeError) {
     init, ok := c.Methods[constructor_name]
     if ok {
         init.Bind(instance)
-        init.Call(interp, args)
+        if _, err := init.Call(interp, args); err != nil {
+            return nil, err
+        }
     }
 	return instance, nil
 }
-func (c LoxClass) Arity() int {
+func (c LoxClass) Arity() Arity {
     val, ok := c.Methods[constructor_name]
     if ok {
         return val.Arity()
     }
 
-    return 0
+    return fixedArity(0)
+}
+
+func (c LoxClass) params() []scanner.Token {
+    return c.Methods[constructor_name].params()
 }
 
 func (c LoxClass) GetMethod(name string) (UserCallable, error) {
@@ -0,0 +1,97 @@
+package interpreter
+
+import (
+	"golox/source"
+	"golox/statement"
+	"sort"
+)
+
+// A Debugger is told about every statement before it runs, and can hold up
+// the interpreter by not returning until the user wants to carry on.
+type Debugger interface {
+	// frames holds the calls in progress, outermost first; the last frame
+	// is the one stmt runs in.
+	BeforeStatement(stmt statement.Statement, frames []*Frame)
+}
+
+// A call in progress, or the top level of the script.
+type Frame struct {
+	// The function being run, or "<script>" at the top level.
+	Name string
+	// The statement the frame is running: for all but the innermost frame,
+	// the one making the call.
+	Span source.Span
+	env  *Environment
+}
+
+// A variable and its value, for a debugger to display.
+type Variable struct {
+	Name  string
+	Value any
+}
+
+// Have d told about each statement as it runs. The call stack is only
+// tracked while a debugger is attached.
+func (v *Interpreter) SetDebugger(d Debugger) {
+	v.debugger = d
+	v.frames = []*Frame{{Name: "<script>", env: v.pEnvironment}}
+}
+
+// Tell the debugger that stmt is about to run in the innermost frame.
+func (v *Interpreter) debugStatement(stmt statement.Statement) {
+	frame := v.frames[len(v.frames)-1]
+	frame.Span = stmt.SourceSpan()
+	frame.env = v.pEnvironment
+	v.debugger.BeforeStatement(stmt, v.frames)
+}
+
+// The variables visible in the frame other than the globals, innermost
+// scope first. A variable hidden by one in an inner scope is left out.
+func (f *Frame) Locals() []Variable {
+	var vars []Variable
+	seen := make(map[string]bool)
+	for env := f.env; env != nil && env.enclosing != nil; env = env.enclosing {
+		for _, v := range sortedValues(env) {
+			if !seen[v.Name] {
+				seen[v.Name] = true
+				vars = append(vars, v)
+			}
+		}
+	}
+
+	return vars
+}
+
+// The global variables, which live in the outermost environment.
+func (f *Frame) Globals() []Variable {
+	env := f.env
+	for env != nil && env.enclosing != nil {
+		env = env.enclosing
+	}
+	if env == nil {
+		return nil
+	}
+
+	return sortedValues(env)
+}
+
+func sortedValues(env *Environment) []Variable {
+	vars := make([]Variable, 0, len(env.values))
+	for name, value := range env.values {
+		vars = append(vars, Variable{Name: name, Value: value})
+	}
+	sort.Slice(vars, func(i, j int) bool { return vars[i].Name < vars[j].Name })
+
+	return vars
+}
+
+// The fields of an instance, sorted by name.
+func (inst LoxInstance) Variables() []Variable {
+	vars := make([]Variable, 0, len(inst.Fields))
+	for name, value := range inst.Fields {
+		vars = append(vars, Variable{Name: name, Value: value})
+	}
+	sort.Slice(vars, func(i, j int) bool { return vars[i].Name < vars[j].Name })
+
+	return vars
+}
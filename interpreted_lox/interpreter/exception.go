@@ -0,0 +1,107 @@
+package interpreter
+
+import (
+	"fmt"
+	"golox/errorhandling"
+	"golox/parser"
+	"golox/scanner"
+	"golox/statement"
+)
+
+// The class that runtime errors are caught as. Scripts can construct,
+// subclass and throw it like any other class, which is why it is written in
+// Lox.
+const errorClassSource = `class Error {
+  init(message) {
+    this.message = message;
+    this.line = nil;
+  }
+}`
+
+// Define the Error class among the globals.
+func (v *Interpreter) defineErrorClass() {
+	reporter := &errorhandling.CollectingReporter{}
+	tokens := scanner.NewScanner(errorClassSource, reporter).ScanTokens()
+	p := parser.NewParser(tokens, reporter)
+	stmts := p.Parse()
+	resolver := Resolver{Interp: v, Reporter: reporter}
+	resolver.Resolve(stmts)
+	v.execute(stmts[0])
+
+	class, _ := v.globals.Get("Error")
+	v.errorClass = class.(LoxClass)
+}
+
+// The error that carries a thrown value up to the nearest catch clause. If
+// nothing catches an instance of Error, it is reported with its message.
+func (v *Interpreter) newThrowError(keyword scanner.Token, val any) *RuntimeError {
+	err := &RuntimeError{error: fmt.Sprintf("uncaught exception: %v", printable(val)), tok: keyword, thrown: true, thrown_value: val}
+	if inst, ok := val.(LoxInstance); ok && inst.isError() {
+		// Record where an error was first thrown from.
+		if inst.Fields["line"] == nil {
+			inst.Fields["line"] = int64(keyword.Line)
+		}
+		if message, ok := inst.Fields["message"].(string); ok {
+			err.error = message
+		}
+	}
+
+	return err
+}
+
+// The value a catch clause receives for err: the thrown value, or an
+// instance of Error for an error raised by the interpreter itself.
+func (v *Interpreter) exception(err *RuntimeError) any {
+	if err.thrown {
+		return err.thrown_value
+	}
+	inst := NewLoxInstance(v.errorClass)
+	inst.Fields["message"] = err.error
+	inst.Fields["line"] = int64(err.tok.Line)
+
+	return inst
+}
+
+// Whether the instance's class is Error or inherits from it.
+func (inst LoxInstance) isError() bool {
+	for c := &inst.Class; c != nil; c = c.Parent {
+		if c.Name == "Error" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (v *Interpreter) VisitThrowStmt(stmt statement.Throw) {
+	val, err := v.Evaluate(stmt.Value)
+	if err != nil {
+		v.err = err
+		return
+	}
+	v.err = v.newThrowError(stmt.Keyword, val)
+}
+
+// The catch clause handles anything but a return, break or continue
+// unwinding the body, and
+// the finally clause runs whatever happened, passing on any error still
+// pending unless it raises one of its own.
+func (v *Interpreter) VisitTryStmt(stmt statement.Try) {
+	v.execute(stmt.Body)
+	if err := v.err; err != nil && !err.isJump() && stmt.CatchName != nil {
+		v.err = nil
+		env := NewEnvironment()
+		env.Define(stmt.CatchName.Lexeme, v.exception(err))
+		v.pushEnvironment(&env)
+		v.execute(*stmt.Catch)
+		v.popEnvironment()
+	}
+	if stmt.Finally != nil {
+		pending := v.err
+		v.err = nil
+		v.execute(*stmt.Finally)
+		if v.err == nil {
+			v.err = pending
+		}
+	}
+}
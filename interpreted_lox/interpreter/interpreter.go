@@ -4,23 +4,68 @@ import (
 	"fmt"
     "bufio"
     "os"
+	"golox/decimal"
 	"golox/errorhandling"
 	"golox/expression"
 	"golox/scanner"
 	"golox/statement"
+	"io"
+	"math/big"
 	"reflect"
+	"strings"
 	"time"
 )
 
 type LoxCallable interface {
 	Call(interp Interpreter, args []any) (any, *RuntimeError)
-	Arity() int
+	Arity() Arity
 }
 
+// The number of arguments a callable takes. Max is -1 for a function with a
+// rest parameter, which takes any number of arguments from Min up.
+type Arity struct {
+	Min, Max int
+}
+
+func fixedArity(n int) Arity {
+	return Arity{Min: n, Max: n}
+}
+
+func (a Arity) accepts(n int) bool {
+	return n >= a.Min && (a.Max == -1 || n <= a.Max)
+}
+
+func (a Arity) String() string {
+	switch {
+	case a.Max == -1:
+		return fmt.Sprint("at least ", a.Min)
+	case a.Min != a.Max:
+		return fmt.Sprint(a.Min, " to ", a.Max)
+	}
+	return fmt.Sprint(a.Min)
+}
+
+// A callable whose parameters have names, so that arguments can be passed to
+// them by name.
+type parameterized interface {
+	params() []scanner.Token
+}
+
+// What a parameter is passed when a call names the parameters after it but
+// not it, so that the callee uses its default value.
+type missingArg struct{}
+
 type RuntimeError struct {
 	error        string
 	tok          scanner.Token
 	return_value any // This is used to return values up the call stack
+	is_return    bool
+	// Set for the errors that carry break and continue to their loop.
+	is_break     bool
+	is_continue  bool
+	// Set for the error of a throw statement, which carries the value thrown.
+	thrown       bool
+	thrown_value any
 }
 
 func (e RuntimeError) Error() string {
@@ -36,7 +81,23 @@ func (e RuntimeError) GetToken() scanner.Token {
 
 // This is used to return a value up the call stack to the 'call' function
 func newReturnError(val any) *RuntimeError {
-	return &RuntimeError{error: "'return' statement outside of function", return_value: val}
+	return &RuntimeError{error: "'return' statement outside of function", return_value: val, is_return: true}
+}
+
+// This is used to leave a loop body for the loop itself to handle
+func newLoopJumpError(keyword scanner.Token) *RuntimeError {
+	return &RuntimeError{
+		error:       "'" + keyword.Lexeme + "' statement outside of a loop",
+		tok:         keyword,
+		is_break:    keyword.Token_type == scanner.BREAK,
+		is_continue: keyword.Token_type == scanner.CONTINUE,
+	}
+}
+
+// Whether the error is a return, break or continue on its way to where it
+// is handled, rather than a failure.
+func (e RuntimeError) isJump() bool {
+	return e.is_return || e.is_break || e.is_continue
 }
 
 func newRuntimeError(operator scanner.Token, message string) *RuntimeError {
@@ -60,16 +121,28 @@ type Interpreter struct {
 	interactiveMode bool
 	locals          map[expression.Expr]int
 	globals         Environment
-}
-
-func NewInterpreter() Interpreter {
+	reporter        errorhandling.ErrorReporter
+	out             io.Writer
+	debugger        Debugger
+	frames          []*Frame
+	// The file being run, or empty if it wasn't read from a file.
+	path    string
+	modules *modules
+	// The class of the errors that catch clauses receive for runtime errors.
+	errorClass LoxClass
+	// The precision and rounding mode of arithmetic on decimals, which
+	// decimalContext changes for every module.
+	decimals *decimal.Context
+}
+
+func NewInterpreter(reporter errorhandling.ErrorReporter) Interpreter {
 	globals := NewEnvironment()
 	env := globals
 
-	globals.Define("clock", BuiltinCallable{params: make([]string, 0), foo: func(a Interpreter, b []any) any {
+	globals.Define("clock", BuiltinCallable{arity: fixedArity(0), foo: func(a Interpreter, b []any) any {
 		return float64(time.Now().UnixMilli()) / 1000
 	}})
-    globals.Define("input", BuiltinCallable{params: make([]string, 0), foo: func(a Interpreter, b []any) any {
+    globals.Define("input", BuiltinCallable{arity: fixedArity(0), foo: func(a Interpreter, b []any) any {
         reader := bufio.NewReader(os.Stdin)
         fmt.Print("> ")
         line, err := reader.ReadString('\n')
@@ -81,18 +154,33 @@ func NewInterpreter() Interpreter {
         }
         return line
     }})
-	return Interpreter{val: nil, err: nil, pEnvironment: &env, interactiveMode: false, locals: make(map[expression.Expr]int), globals: globals}
+	interp := Interpreter{val: nil, err: nil, pEnvironment: &env, interactiveMode: false, locals: make(map[expression.Expr]int), globals: globals, reporter: reporter, out: os.Stdout, modules: newModules(), decimals: &decimal.Context{}}
+	interp.defineErrorClass()
+	interp.defineNumberFunctions()
+	return interp
 }
 
-func (v *Interpreter) Interpret(statements []statement.Statement) {
+func (v *Interpreter) Interpret(statements []statement.Statement) *RuntimeError {
 	v.err = nil
 	for _, stmt := range statements {
 		err := v.execute(stmt)
 		if err != nil {
-			errorhandling.RuntimeError(err)
-			return
+			v.reporter.Report(errorhandling.Diagnostic{
+				Phase:   errorhandling.Running,
+				Line:    err.tok.Line,
+				Span:    err.tok.Span,
+				Message: err.error,
+			})
+			return err
 		}
 	}
+
+	return nil
+}
+
+// Write the output of print statements to w rather than stdout.
+func (v *Interpreter) SetOutput(w io.Writer) {
+	v.out = w
 }
 
 func (v *Interpreter) EnableInteractiveMode() {
@@ -104,10 +192,24 @@ func (v *Interpreter) DisableInteractiveMode() {
 }
 
 func (v *Interpreter) resolve(e expression.Expr, depth int) {
-	v.locals[e] = depth
+	v.locals[localKey(e)] = depth
+}
+
+// The key the depth of the variable e refers to is kept under. Only the name
+// and where it is written matter, and the value of an assignment, such as a
+// list or a function expression, may not be hashable.
+func localKey(e expression.Expr) expression.Expr {
+	if a, ok := e.(expression.Assign); ok {
+		a.Value = nil
+		return a
+	}
+	return e
 }
 
 func (v *Interpreter) execute(stmt statement.Statement) *RuntimeError {
+	if _, isBlock := stmt.(statement.Block); v.debugger != nil && !isBlock {
+		v.debugStatement(stmt)
+	}
 	stmt.Accept(v)
 	if v.err != nil {
 		return v.err
@@ -120,7 +222,9 @@ func (v *Interpreter) executeBlock(statements []statement.Statement, env Environ
 	v.pushEnvironment(&env)
 	defer v.popEnvironment()
 	for _, stmt := range statements {
-		v.execute(stmt)
+		if v.execute(stmt) != nil {
+			return
+		}
 	}
 }
 
@@ -164,6 +268,18 @@ func (v Interpreter) isTruthy(val any) bool {
 }
 
 func (v Interpreter) isEqual(left, right any) bool {
+	// A list or map is only equal to itself, whatever it holds.
+	switch l := left.(type) {
+	case *LoxList:
+		r, ok := right.(*LoxList)
+		return ok && l == r
+	case *LoxMap:
+		r, ok := right.(*LoxMap)
+		return ok && l == r
+	}
+	if equal, ok := numbersEqual(left, right); ok {
+		return equal
+	}
 	return reflect.DeepEqual(left, right)
 }
 
@@ -173,7 +289,7 @@ func (v *Interpreter) VisitAssign(e expression.Assign) {
 		v.err = err
 		return
 	}
-	val, ok := v.locals[e]
+	val, ok := v.locals[localKey(e)]
 	if !ok {
 		// v.err = newRuntimeError(e.Name, "undefined variable")
 		// return
@@ -198,18 +314,11 @@ func (v *Interpreter) VisitBinary(e expression.Binary) {
 		return
 	}
 	switch e.Operator.Token_type {
-	case scanner.MINUS:
-		l, l_ok := left.(float64)
-		r, r_ok := right.(float64)
-		if !(l_ok && r_ok) {
-			v.err = newOperandsError(e.Operator)
-		}
-		v.val = l - r
+	case scanner.MINUS, scanner.STAR, scanner.SLASH, scanner.PERCENT:
+		v.val, v.err = v.arithmetic(e.Operator, left, right)
 	case scanner.PLUS:
-		l_float, l_ok := left.(float64)
-		r_float, r_ok := right.(float64)
-		if l_ok && r_ok {
-			v.val = l_float + r_float
+		if kindOf(left) != notNumber && kindOf(right) != notNumber {
+			v.val, v.err = v.arithmetic(e.Operator, left, right)
 			return
 		}
 
@@ -222,53 +331,8 @@ func (v *Interpreter) VisitBinary(e expression.Binary) {
 		}
 		v.err = newRuntimeError(e.Operator, "Operands must be two numbers or two strings")
 
-	case scanner.SLASH:
-		l, l_ok := left.(float64)
-		r, r_ok := right.(float64)
-		if !(l_ok && r_ok) {
-			v.err = newOperandsError(e.Operator)
-		}
-		v.val = l / r
-
-	case scanner.STAR:
-		l, l_ok := left.(float64)
-		r, r_ok := right.(float64)
-		if !(l_ok && r_ok) {
-			v.err = newOperandsError(e.Operator)
-		}
-		v.val = l * r
-
-	case scanner.GREATER:
-		l, l_ok := left.(float64)
-		r, r_ok := right.(float64)
-		if !(l_ok && r_ok) {
-			v.err = newOperandsError(e.Operator)
-		}
-		v.val = l > r
-
-	case scanner.GREATER_EQUAL:
-		l, l_ok := left.(float64)
-		r, r_ok := right.(float64)
-		if !(l_ok && r_ok) {
-			v.err = newOperandsError(e.Operator)
-		}
-		v.val = l >= r
-
-	case scanner.LESS:
-		l, l_ok := left.(float64)
-		r, r_ok := right.(float64)
-		if !(l_ok && r_ok) {
-			v.err = newOperandsError(e.Operator)
-		}
-		v.val = l < r
-
-	case scanner.LESS_EQUAL:
-		l, l_ok := left.(float64)
-		r, r_ok := right.(float64)
-		if !(l_ok && r_ok) {
-			v.err = newOperandsError(e.Operator)
-		}
-		v.val = l <= r
+	case scanner.GREATER, scanner.GREATER_EQUAL, scanner.LESS, scanner.LESS_EQUAL:
+		v.val, v.err = compare(e.Operator, left, right)
 
 	case scanner.BANG_EQUAL:
 		v.val = !v.isEqual(left, right)
@@ -294,6 +358,15 @@ func (v *Interpreter) VisitCall(e expression.Call) {
 		}
 		args = append(args, val)
 	}
+	var named []any
+	for _, arg := range e.Named {
+		val, err := v.Evaluate(arg.Value)
+		if err != nil {
+			v.err = err
+			return
+		}
+		named = append(named, val)
+	}
 
 	// Call a loxcallable
 	lox_func, ok := callee.(LoxCallable)
@@ -301,20 +374,74 @@ func (v *Interpreter) VisitCall(e expression.Call) {
 		v.err = newRuntimeError(e.Paren, "Can only call functions and classes.")
 		return
 	}
+	if len(e.Named) > 0 {
+		args, err = bindNamed(lox_func, args, e.Named, named, e.Paren)
+		if err != nil {
+			v.err = err
+			return
+		}
+	}
 
-	if len(args) != lox_func.Arity() {
-		v.err = newRuntimeError(e.Paren, fmt.Sprint("Expected ", lox_func.Arity(), " arguments but got ", len(args)))
-		return
+	v.val, v.err = v.call(lox_func, args, e.Paren)
+}
+
+// Add the values of the named arguments to args, in the slots of the
+// parameters they name. The slots of parameters given no argument are filled
+// with missingArg.
+func bindNamed(callee LoxCallable, args []any, named []expression.NamedArg, values []any, tok scanner.Token) ([]any, *RuntimeError) {
+	p, ok := callee.(parameterized)
+	if !ok {
+		return nil, newRuntimeError(tok, "Native functions don't take named arguments.")
+	}
+	params, arity := p.params(), callee.Arity()
+	for i, arg := range named {
+		slot := -1
+		for j, param := range params {
+			if param.Lexeme == arg.Name.Lexeme {
+				slot = j
+			}
+		}
+		if slot == -1 {
+			return nil, newRuntimeError(arg.Name, fmt.Sprintf("No parameter named '%s'.", arg.Name.Lexeme))
+		}
+		if arity.Max == -1 && slot == len(params)-1 {
+			return nil, newRuntimeError(arg.Name, fmt.Sprintf("Can't pass the rest parameter '%s' by name.", arg.Name.Lexeme))
+		}
+		if slot < len(args) && args[slot] != (missingArg{}) {
+			return nil, newRuntimeError(arg.Name, fmt.Sprintf("Parameter '%s' was passed more than one argument.", arg.Name.Lexeme))
+		}
+		for len(args) <= slot {
+			args = append(args, missingArg{})
+		}
+		args[slot] = values[i]
 	}
+	for i := 0; i < arity.Min; i++ {
+		if i >= len(args) || args[i] == (missingArg{}) {
+			return nil, newRuntimeError(tok, fmt.Sprintf("Missing argument for parameter '%s'.", params[i].Lexeme))
+		}
+	}
+
+	return args, nil
+}
 
-	val, err := lox_func.Call(*v, args)
+// Call callee with args and return what it returns. Errors about the call
+// itself are reported at tok.
+func (v *Interpreter) call(callee LoxCallable, args []any, tok scanner.Token) (any, *RuntimeError) {
+	if !callee.Arity().accepts(len(args)) {
+		return nil, newRuntimeError(tok, fmt.Sprint("Expected ", callee.Arity(), " arguments but got ", len(args)))
+	}
+
+	val, err := callee.Call(*v, args)
+	if _, ok := callee.(nativeFunction); ok && err != nil {
+		err.tok = tok
+	}
 	if err != nil {
-		if err.return_value != nil {
+		if err.is_return {
 			val, err = err.return_value, nil
 		}
 	}
 
-	v.val, v.err = val, err
+	return val, err
 }
 
 func (v *Interpreter) VisitGet(e expression.Get) {
@@ -324,6 +451,25 @@ func (v *Interpreter) VisitGet(e expression.Get) {
 		return
 	}
 
+	if module, ok := val.(*LoxModule); ok {
+		export, ok := module.Exports[e.Name.Lexeme]
+		if !ok {
+			v.err = newRuntimeError(e.Name, fmt.Sprintf("\"%s\" is not exported by \"%s\"", e.Name.Lexeme, module.Name))
+			return
+		}
+		v.val = export
+		return
+	}
+
+	if list, ok := val.(*LoxList); ok {
+		v.val, v.err = list.Get(e.Name)
+		return
+	}
+	if m, ok := val.(*LoxMap); ok {
+		v.val, v.err = m.Get(e.Name)
+		return
+	}
+
 	obj, ok := val.(LoxInstance)
 	if !ok {
 		v.err = &RuntimeError{error: "only class instances have properties", tok: e.Name}
@@ -352,6 +498,25 @@ func (v *Interpreter) VisitLiteral(e expression.Literal) {
 	}
 }
 
+// Evaluate the expressions of an interpolated string and join them with its
+// text, each converted to a string the way print would.
+func (v *Interpreter) VisitInterpolation(e expression.Interpolation) {
+	var sb strings.Builder
+	for i, str := range e.Strings {
+		sb.WriteString(*str.Literal.(*string))
+		if i == len(e.Exprs) {
+			break
+		}
+		val, err := v.Evaluate(e.Exprs[i])
+		if err != nil {
+			v.err = err
+			return
+		}
+		fmt.Fprint(&sb, printable(val))
+	}
+	v.val = sb.String()
+}
+
 func (v *Interpreter) VisitLogical(e expression.Logical) {
 	left, err := v.Evaluate(e.Left)
 	if err != nil {
@@ -470,11 +635,18 @@ func (v *Interpreter) VisitUnary(e expression.Unary) {
 	t := e.Operator.Token_type
 	switch t {
 	case scanner.MINUS:
-		r, ok := right.(float64)
-		if !ok {
+		switch r := right.(type) {
+		case int64:
+			v.val = -r
+		case float64:
+			v.val = -r
+		case *big.Int:
+			v.val = new(big.Int).Neg(r)
+		case decimal.Decimal:
+			v.val = r.Neg()
+		default:
 			v.err = newNumberError(e.Operator)
 		}
-		v.val = -r
 	case scanner.BANG:
 		v.val = v.isTruthy(right)
 	}
@@ -505,6 +677,9 @@ func (v *Interpreter) VisitBlockStmt(stmt statement.Block) {
 	env := NewEnvironment()
 	v.executeBlock(stmt.GetStatements(), env)
 }
+func (v *Interpreter) VisitBreakStmt(stmt statement.Break) {
+	v.err = newLoopJumpError(stmt.Keyword)
+}
 func (v *Interpreter) VisitClassStmt(stmt statement.Class) {
     var parentClass LoxClass
     var ok bool
@@ -530,7 +705,7 @@ func (v *Interpreter) VisitClassStmt(stmt statement.Class) {
         v.pEnvironment.Define("super", parentClass)
     }
     for _, m := range stmt.Methods {
-        methods[m.Name.Lexeme] = UserCallable{declaration: m, closure: v.pEnvironment}
+        methods[m.Name.Lexeme] = UserCallable{declaration: m, closure: v.pEnvironment, globals: v.globals, locals: v.locals}
     }
     class := LoxClass{Name: stmt.Name.Lexeme, Methods: methods, Parent: &parentClass}
     if stmt.ParentClass != nil {
@@ -539,17 +714,40 @@ func (v *Interpreter) VisitClassStmt(stmt statement.Class) {
 	v.pEnvironment.Assign(stmt.Name.Lexeme, class)
     
 }
+func (v *Interpreter) VisitContinueStmt(stmt statement.Continue) {
+	v.err = newLoopJumpError(stmt.Keyword)
+}
+func (v *Interpreter) VisitExportStmt(stmt statement.Export) {
+	// Run the declaration without execute, so that a debugger doesn't stop
+	// at the same statement twice.
+	stmt.Declaration.Accept(v)
+}
 func (v *Interpreter) VisitExpressionStmt(stmt statement.Expression) {
 	val, err := v.Evaluate(stmt.Val)
 	if err == nil && v.interactiveMode {
-		fmt.Println(val)
+		fmt.Fprintln(v.out, printable(val))
 	}
 }
 func (v *Interpreter) VisitFunctionStmt(stmt statement.Function) {
-	var funcDef UserCallable = UserCallable{declaration: stmt, closure: v.pEnvironment}
+	var funcDef UserCallable = UserCallable{declaration: stmt, closure: v.pEnvironment, globals: v.globals, locals: v.locals}
 
 	v.pEnvironment.Define(stmt.Name.Lexeme, funcDef)
 }
+
+func (v *Interpreter) VisitLambda(e expression.Lambda) {
+	v.val = UserCallable{declaration: lambdaDeclaration(e), closure: v.pEnvironment, globals: v.globals, locals: v.locals}
+}
+
+// The function a function expression declares, which has no name.
+func lambdaDeclaration(e expression.Lambda) statement.Function {
+	return statement.Function{
+		Span:     e.Span,
+		Params:   e.Params,
+		Defaults: e.Defaults,
+		Variadic: e.Variadic,
+		Body:     e.Body.([]statement.Statement),
+	}
+}
 func (v *Interpreter) VisitIfStmt(stmt statement.If) {
 	val, err := v.Evaluate(stmt.Conditional)
 	if err != nil {
@@ -572,6 +770,20 @@ func (v *Interpreter) VisitIfStmt(stmt statement.If) {
 		}
 	}
 }
+func (v *Interpreter) VisitImportStmt(stmt statement.Import) {
+	module, err := v.importModule(*stmt.Path.Literal.(*string))
+	if err != nil {
+		v.err = newRuntimeError(stmt.Path, err.Error())
+		return
+	}
+	if stmt.Name != nil {
+		v.pEnvironment.Define(stmt.Name.Lexeme, module)
+		return
+	}
+	for name, val := range module.Exports {
+		v.pEnvironment.Define(name, val)
+	}
+}
 func (v *Interpreter) VisitPrintStmt(stmt statement.Print) {
 	val, err := v.Evaluate(stmt.Val)
 	if err != nil {
@@ -579,7 +791,7 @@ func (v *Interpreter) VisitPrintStmt(stmt statement.Print) {
 		return
 	}
 
-	fmt.Println(val)
+	fmt.Fprintln(v.out, printable(val))
 }
 func (v *Interpreter) VisitReturnStmt(stmt statement.Return) {
 	val, err := v.Evaluate(stmt.Return_expr)
@@ -608,7 +820,16 @@ func (v *Interpreter) VisitWhileStmt(stmt statement.While) {
 	var val any
 	for val, err = v.Evaluate(stmt.Conditional); err == nil && v.isTruthy(val); val, err = v.Evaluate(stmt.Conditional) {
 		err = v.execute(stmt.Stmt)
-		if err != nil {
+		if err != nil && err.is_break {
+			v.err = nil
+			return
+		}
+		if err != nil && !err.is_continue {
+			v.err = err
+			return
+		}
+		v.err = nil
+		if _, err = v.Evaluate(stmt.Increment); err != nil {
 			v.err = err
 			return
 		}
@@ -0,0 +1,166 @@
+package interpreter
+
+import (
+	"fmt"
+	"golox/expression"
+	"golox/scanner"
+	"golox/statement"
+)
+
+const notIterable = "Only lists, maps, strings, ranges and iterators can be iterated."
+
+// The integers from start up to but not including end, counting up by one.
+// Ranges are compared by their bounds.
+type LoxRange struct {
+	start, end int64
+}
+
+func (r LoxRange) String() string {
+	return fmt.Sprintf("%v..%v", r.start, r.end)
+}
+
+func (v *Interpreter) VisitRange(e expression.Range) {
+	start, err := v.Evaluate(e.Start)
+	if err != nil {
+		v.err = err
+		return
+	}
+	end, err := v.Evaluate(e.End)
+	if err != nil {
+		v.err = err
+		return
+	}
+	s, ok := start.(int64)
+	en, ok2 := end.(int64)
+	if !ok || !ok2 {
+		v.err = newRuntimeError(e.Operator, "Range bounds must be integers.")
+		return
+	}
+
+	v.val = LoxRange{start: s, end: en}
+}
+
+// A function that returns the next value a for-in loop visits in val, or
+// false once there are none left: the elements of a list, the keys of a map,
+// the characters of a string, the numbers of a range, or what the next
+// method returns of the object an instance's iterator method returns.
+func (v *Interpreter) iterator(tok scanner.Token, val any) (func() (any, bool, *RuntimeError), *RuntimeError) {
+	switch it := val.(type) {
+	case *LoxList:
+		// Elements pushed during the loop are visited too.
+		i := 0
+		return func() (any, bool, *RuntimeError) {
+			if i >= len(it.Elements) {
+				return nil, false, nil
+			}
+			i++
+			return it.Elements[i-1], true, nil
+		}, nil
+	case *LoxMap:
+		keys := make([]any, len(it.keys))
+		for i, k := range it.keys {
+			keys[i] = it.entries[k].key
+		}
+		return values(keys), nil
+	case string:
+		chars := []any{}
+		for _, c := range it {
+			chars = append(chars, string(c))
+		}
+		return values(chars), nil
+	case LoxRange:
+		n := it.start
+		return func() (any, bool, *RuntimeError) {
+			if n >= it.end {
+				return nil, false, nil
+			}
+			n++
+			return n - 1, true, nil
+		}, nil
+	case LoxInstance:
+		if _, err := it.Class.GetMethod("iterator"); err != nil {
+			break
+		}
+		iter, err := v.invoke(tok, it, "iterator")
+		if err != nil {
+			return nil, err
+		}
+		return func() (any, bool, *RuntimeError) {
+			hasNext, err := v.invoke(tok, iter, "hasNext")
+			if err != nil || !v.isTruthy(hasNext) {
+				return nil, false, err
+			}
+			next, err := v.invoke(tok, iter, "next")
+			return next, err == nil, err
+		}, nil
+	}
+
+	return nil, newRuntimeError(tok, notIterable)
+}
+
+// A function that returns each of vals in turn.
+func values(vals []any) func() (any, bool, *RuntimeError) {
+	i := 0
+	return func() (any, bool, *RuntimeError) {
+		if i >= len(vals) {
+			return nil, false, nil
+		}
+		i++
+		return vals[i-1], true, nil
+	}
+}
+
+// Call the method name of obj with no arguments.
+func (v *Interpreter) invoke(tok scanner.Token, obj any, name string) (any, *RuntimeError) {
+	inst, ok := obj.(LoxInstance)
+	if !ok {
+		return nil, newRuntimeError(tok, fmt.Sprintf("An iterator must be an instance with a %s method.", name))
+	}
+	method, err := inst.Get(scanner.Token{Token_type: scanner.IDENTIFIER, Lexeme: name, Line: tok.Line, Span: tok.Span})
+	if err != nil {
+		return nil, err
+	}
+	callable, ok := method.(LoxCallable)
+	if !ok {
+		return nil, newRuntimeError(tok, fmt.Sprintf("An iterator must be an instance with a %s method.", name))
+	}
+
+	return v.call(callable, nil, tok)
+}
+
+func (v *Interpreter) VisitForInStmt(stmt statement.ForIn) {
+	iterable, err := v.Evaluate(stmt.Iterable)
+	if err != nil {
+		v.err = err
+		return
+	}
+	next, err := v.iterator(stmt.Name, iterable)
+	if err != nil {
+		v.err = err
+		return
+	}
+
+	for {
+		val, ok, err := next()
+		if err != nil || !ok {
+			v.err = err
+			return
+		}
+		// Each pass gets a fresh variable, so closures capture the value
+		// of that pass.
+		env := NewEnvironment()
+		env.Define(stmt.Name.Lexeme, val)
+		v.pushEnvironment(&env)
+		err = v.execute(stmt.Stmt)
+		v.popEnvironment()
+		if err != nil && err.is_break {
+			v.err = nil
+			return
+		}
+		if err != nil && !err.is_continue {
+			v.err = err
+			return
+		}
+		v.err = nil
+	}
+}
@@ -0,0 +1,253 @@
+package interpreter
+
+import (
+	"fmt"
+	"golox/expression"
+	"golox/scanner"
+	"strings"
+)
+
+// A list of values. Lists are only ever handled by pointer, so that a change
+// made through one reference is seen through all of them.
+type LoxList struct {
+	Elements []any
+}
+
+func (l *LoxList) String() string {
+	elements := make([]string, len(l.Elements))
+	for i, e := range l.Elements {
+		elements[i] = stringify(e)
+	}
+
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+// How a value is shown inside a list or map.
+func stringify(val any) string {
+	if val == nil {
+		return "nil"
+	}
+
+	return fmt.Sprint(printable(val))
+}
+
+// The built-in method name of the list, bound to it.
+func (l *LoxList) Get(name scanner.Token) (any, *RuntimeError) {
+	method, ok := listMethods[name.Lexeme]
+	if !ok {
+		return nil, newRuntimeError(name, fmt.Sprintf("Lists have no method '%s'.", name.Lexeme))
+	}
+	method.list, method.name = l, name
+
+	return method, nil
+}
+
+// A built-in method of a list, bound to the list it was looked up on.
+type listMethod struct {
+	list  *LoxList
+	name  scanner.Token
+	arity int
+	call  func(interp Interpreter, m listMethod, args []any) (any, *RuntimeError)
+}
+
+func (m listMethod) Arity() Arity {
+	return fixedArity(m.arity)
+}
+
+func (m listMethod) Call(interp Interpreter, args []any) (any, *RuntimeError) {
+	return m.call(interp, m, args)
+}
+
+func (m listMethod) String() string {
+	return "<native fn>"
+}
+
+var listMethods = map[string]listMethod{
+	"push": {arity: 1, call: func(interp Interpreter, m listMethod, args []any) (any, *RuntimeError) {
+		m.list.Elements = append(m.list.Elements, args[0])
+		return nil, nil
+	}},
+	"pop": {arity: 0, call: func(interp Interpreter, m listMethod, args []any) (any, *RuntimeError) {
+		if len(m.list.Elements) == 0 {
+			return nil, newRuntimeError(m.name, "Can't pop from an empty list.")
+		}
+		last := m.list.Elements[len(m.list.Elements)-1]
+		m.list.Elements = m.list.Elements[:len(m.list.Elements)-1]
+		return last, nil
+	}},
+	"len": {arity: 0, call: func(interp Interpreter, m listMethod, args []any) (any, *RuntimeError) {
+		return int64(len(m.list.Elements)), nil
+	}},
+	"slice": {arity: 2, call: func(interp Interpreter, m listMethod, args []any) (any, *RuntimeError) {
+		start, err := sliceBound(m.name, args[0], len(m.list.Elements))
+		if err != nil {
+			return nil, err
+		}
+		end, err := sliceBound(m.name, args[1], len(m.list.Elements))
+		if err != nil {
+			return nil, err
+		}
+		if start > end {
+			return nil, newRuntimeError(m.name, "Slice bounds out of range.")
+		}
+		return &LoxList{Elements: append([]any{}, m.list.Elements[start:end]...)}, nil
+	}},
+	"map": {arity: 1, call: func(interp Interpreter, m listMethod, args []any) (any, *RuntimeError) {
+		result := &LoxList{}
+		err := m.each(interp, args[0], func(element, mapped any) {
+			result.Elements = append(result.Elements, mapped)
+		})
+		return result, err
+	}},
+	"filter": {arity: 1, call: func(interp Interpreter, m listMethod, args []any) (any, *RuntimeError) {
+		result := &LoxList{}
+		err := m.each(interp, args[0], func(element, keep any) {
+			if interp.isTruthy(keep) {
+				result.Elements = append(result.Elements, element)
+			}
+		})
+		return result, err
+	}},
+}
+
+// Call f with each element of the list in turn, and pass the element and
+// what f returned for it to yield.
+func (m listMethod) each(interp Interpreter, f any, yield func(element, result any)) *RuntimeError {
+	callable, ok := f.(LoxCallable)
+	if !ok {
+		return newRuntimeError(m.name, "Can only call functions and classes.")
+	}
+	// Go over a copy, in case f changes the list.
+	for _, element := range append([]any{}, m.list.Elements...) {
+		result, err := interp.call(callable, []any{element}, m.name)
+		if err != nil {
+			return err
+		}
+		yield(element, result)
+	}
+
+	return nil
+}
+
+func (v *Interpreter) VisitList(e expression.List) {
+	elements := make([]any, len(e.Elements))
+	for i, element := range e.Elements {
+		val, err := v.Evaluate(element)
+		if err != nil {
+			v.err = err
+			return
+		}
+		elements[i] = val
+	}
+
+	v.val = &LoxList{Elements: elements}
+}
+
+func (v *Interpreter) VisitSubscript(e expression.Subscript) {
+	obj, err := v.Evaluate(e.Object)
+	if err != nil {
+		v.err = err
+		return
+	}
+	idx, err := v.Evaluate(e.Index)
+	if err != nil {
+		v.err = err
+		return
+	}
+
+	switch o := obj.(type) {
+	case *LoxList:
+		i, err := o.index(e.Bracket, idx)
+		if err != nil {
+			v.err = err
+			return
+		}
+		v.val = o.Elements[i]
+	case *LoxMap:
+		v.val, v.err = o.get(e.Bracket, idx)
+	default:
+		v.err = newRuntimeError(e.Bracket, notIndexable)
+	}
+}
+
+func (v *Interpreter) VisitSubscriptSet(e expression.SubscriptSet) {
+	obj, err := v.Evaluate(e.Object)
+	if err != nil {
+		v.err = err
+		return
+	}
+	idx, err := v.Evaluate(e.Index)
+	if err != nil {
+		v.err = err
+		return
+	}
+	val, err := v.Evaluate(e.Value)
+	if err != nil {
+		v.err = err
+		return
+	}
+
+	switch o := obj.(type) {
+	case *LoxList:
+		i, err := o.index(e.Bracket, idx)
+		if err != nil {
+			v.err = err
+			return
+		}
+		o.Elements[i] = val
+	case *LoxMap:
+		if err := o.set(e.Bracket, idx, val); err != nil {
+			v.err = err
+			return
+		}
+	default:
+		v.err = newRuntimeError(e.Bracket, notIndexable)
+		return
+	}
+
+	v.val = val
+}
+
+const notIndexable = "Only lists and maps can be indexed."
+
+// The position of the element that idx picks out of the list. Negative
+// indexes count back from the end of the list.
+func (l *LoxList) index(tok scanner.Token, idx any) (int, *RuntimeError) {
+	i, ok := integer(idx)
+	if !ok {
+		return 0, newRuntimeError(tok, "List index must be an integer.")
+	}
+	if i < 0 {
+		i += len(l.Elements)
+	}
+	if i < 0 || i >= len(l.Elements) {
+		return 0, newRuntimeError(tok, "List index out of range.")
+	}
+
+	return i, nil
+}
+
+// Like an index, but a slice can end at the length of the list itself.
+func sliceBound(tok scanner.Token, bound any, n int) (int, *RuntimeError) {
+	i, ok := integer(bound)
+	if !ok {
+		return 0, newRuntimeError(tok, "Slice bounds must be integers.")
+	}
+	if i < 0 {
+		i += n
+	}
+	if i < 0 || i > n {
+		return 0, newRuntimeError(tok, "Slice bounds out of range.")
+	}
+
+	return i, nil
+}
+
+func integer(v any) (int, bool) {
+	i, ok := v.(int64)
+	if !ok || int64(int(i)) != i {
+		return 0, false
+	}
+
+	return int(i), true
+}
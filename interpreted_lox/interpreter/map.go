@@ -0,0 +1,209 @@
+package interpreter
+
+import (
+	"fmt"
+	"golox/decimal"
+	"golox/expression"
+	"golox/scanner"
+	"math/big"
+	"reflect"
+	"strings"
+)
+
+// A map from values to values, which keeps its keys in the order they were
+// first inserted. Like lists, maps are only ever handled by pointer.
+type LoxMap struct {
+	// The entries, by the identity of their keys.
+	entries map[any]mapEntry
+	// The identities of the keys, in order.
+	keys []any
+}
+
+type mapEntry struct {
+	key, val any
+}
+
+// What a map key is identified by. Instances are values, so one is
+// identified by its fields, which all copies of it share.
+type instanceKey uintptr
+
+// What a bigint or decimal that isn't equal to an integer or a float is
+// identified by: the fraction it's equal to.
+type fractionKey string
+
+func NewLoxMap() *LoxMap {
+	return &LoxMap{entries: make(map[any]mapEntry)}
+}
+
+func (m *LoxMap) String() string {
+	entries := make([]string, len(m.keys))
+	for i, k := range m.keys {
+		entry := m.entries[k]
+		entries[i] = fmt.Sprintf("%v: %v", stringify(entry.key), stringify(entry.val))
+	}
+
+	return "{" + strings.Join(entries, ", ") + "}"
+}
+
+// The built-in method name of the map, bound to it.
+func (m *LoxMap) Get(name scanner.Token) (any, *RuntimeError) {
+	method, ok := mapMethods[name.Lexeme]
+	if !ok {
+		return nil, newRuntimeError(name, fmt.Sprintf("Maps have no method '%s'.", name.Lexeme))
+	}
+	method.m, method.name = m, name
+
+	return method, nil
+}
+
+func (m *LoxMap) get(tok scanner.Token, key any) (any, *RuntimeError) {
+	k, err := mapKey(tok, key)
+	if err != nil {
+		return nil, err
+	}
+	entry, ok := m.entries[k]
+	if !ok {
+		return nil, newRuntimeError(tok, fmt.Sprintf("Map has no key '%s'.", stringify(key)))
+	}
+
+	return entry.val, nil
+}
+
+func (m *LoxMap) set(tok scanner.Token, key, val any) *RuntimeError {
+	k, err := mapKey(tok, key)
+	if err != nil {
+		return err
+	}
+	entry, ok := m.entries[k]
+	if !ok {
+		m.keys = append(m.keys, k)
+		entry.key = key
+	}
+	entry.val = val
+	m.entries[k] = entry
+
+	return nil
+}
+
+// Remove key from the map and return what it mapped to, or nil if it wasn't
+// there.
+func (m *LoxMap) remove(tok scanner.Token, key any) (any, *RuntimeError) {
+	k, err := mapKey(tok, key)
+	if err != nil {
+		return nil, err
+	}
+	entry, ok := m.entries[k]
+	if !ok {
+		return nil, nil
+	}
+	delete(m.entries, k)
+	for i, key := range m.keys {
+		if key == k {
+			m.keys = append(m.keys[:i], m.keys[i+1:]...)
+			break
+		}
+	}
+
+	return entry.val, nil
+}
+
+// The identity of key among the keys of a map. Numbers, strings, booleans and
+// nil are keys by value, and objects by identity. Numbers that are equal are
+// the same key, whatever their kinds.
+func mapKey(tok scanner.Token, key any) (any, *RuntimeError) {
+	switch k := key.(type) {
+	case float64:
+		if i, ok := floatToInt(k); ok {
+			return i, nil
+		}
+		return k, nil
+	case *big.Int, decimal.Decimal:
+		r, _ := toRat(k)
+		if r.IsInt() && r.Num().IsInt64() {
+			return r.Num().Int64(), nil
+		}
+		if f, exact := r.Float64(); exact {
+			return f, nil
+		}
+		return fractionKey(r.String()), nil
+	case nil, int64, string, bool, LoxRange, *LoxList, *LoxMap, *LoxModule:
+		return k, nil
+	case LoxInstance:
+		return instanceKey(reflect.ValueOf(k.Fields).Pointer()), nil
+	}
+
+	return nil, newRuntimeError(tok, "Functions and classes can't be map keys.")
+}
+
+// A built-in method of a map, bound to the map it was looked up on.
+type mapMethod struct {
+	m     *LoxMap
+	name  scanner.Token
+	arity int
+	call  func(m mapMethod, args []any) (any, *RuntimeError)
+}
+
+func (m mapMethod) Arity() Arity {
+	return fixedArity(m.arity)
+}
+
+func (m mapMethod) Call(interp Interpreter, args []any) (any, *RuntimeError) {
+	return m.call(m, args)
+}
+
+func (m mapMethod) String() string {
+	return "<native fn>"
+}
+
+var mapMethods = map[string]mapMethod{
+	"keys": {arity: 0, call: func(m mapMethod, args []any) (any, *RuntimeError) {
+		keys := &LoxList{}
+		for _, k := range m.m.keys {
+			keys.Elements = append(keys.Elements, m.m.entries[k].key)
+		}
+		return keys, nil
+	}},
+	"values": {arity: 0, call: func(m mapMethod, args []any) (any, *RuntimeError) {
+		values := &LoxList{}
+		for _, k := range m.m.keys {
+			values.Elements = append(values.Elements, m.m.entries[k].val)
+		}
+		return values, nil
+	}},
+	"has": {arity: 1, call: func(m mapMethod, args []any) (any, *RuntimeError) {
+		k, err := mapKey(m.name, args[0])
+		if err != nil {
+			return nil, err
+		}
+		_, ok := m.m.entries[k]
+		return ok, nil
+	}},
+	"remove": {arity: 1, call: func(m mapMethod, args []any) (any, *RuntimeError) {
+		return m.m.remove(m.name, args[0])
+	}},
+	"len": {arity: 0, call: func(m mapMethod, args []any) (any, *RuntimeError) {
+		return int64(len(m.m.keys)), nil
+	}},
+}
+
+func (v *Interpreter) VisitMap(e expression.Map) {
+	m := NewLoxMap()
+	for i := range e.Keys {
+		key, err := v.Evaluate(e.Keys[i])
+		if err != nil {
+			v.err = err
+			return
+		}
+		val, err := v.Evaluate(e.Values[i])
+		if err != nil {
+			v.err = err
+			return
+		}
+		if err := m.set(e.Brace, key, val); err != nil {
+			v.err = err
+			return
+		}
+	}
+
+	v.val = m
+}
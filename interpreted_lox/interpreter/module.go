@@ -0,0 +1,163 @@
+package interpreter
+
+import (
+	"fmt"
+	"golox/errorhandling"
+	"golox/parser"
+	"golox/scanner"
+	"golox/statement"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A module that has been imported, and the values of the globals it
+// exports. Modules are only ever handled by pointer, so that two imports of
+// the same module are equal.
+type LoxModule struct {
+	Name    string
+	Exports map[string]any
+}
+
+func (m *LoxModule) String() string {
+	return "<module " + m.Name + ">"
+}
+
+// The modules imported while running a script, shared by the interpreters
+// that run the script and each of its modules.
+type modules struct {
+	searchPath []string
+	// The directory that paths in error messages are relative to.
+	root   string
+	loaded map[string]*LoxModule
+	// The files being run, outermost first, for detecting import cycles.
+	running []string
+}
+
+func newModules() *modules {
+	return &modules{loaded: make(map[string]*LoxModule)}
+}
+
+// Resolve imports relative to the directory of path, the script being run.
+func (v *Interpreter) SetScriptPath(path string) {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	v.path = path
+	v.modules.root = filepath.Dir(path)
+	v.modules.running = []string{path}
+}
+
+// Look for imported modules in dirs when they aren't found relative to the
+// file that imports them.
+func (v *Interpreter) SetSearchPath(dirs []string) {
+	v.modules.searchPath = dirs
+}
+
+// Run the module that an import of name refers to, unless it has already
+// been run, and return its exports.
+func (v *Interpreter) importModule(name string) (*LoxModule, error) {
+	path, err := findModule(name, v.path, v.modules.searchPath)
+	if err != nil {
+		return nil, err
+	}
+	if m, ok := v.modules.loaded[path]; ok {
+		return m, nil
+	}
+	for i, running := range v.modules.running {
+		if running == path {
+			cycle := append(append([]string{}, v.modules.running[i:]...), path)
+			return nil, fmt.Errorf("import cycle: %s", v.modules.describe(cycle))
+		}
+	}
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't read module %q", name)
+	}
+
+	v.modules.running = append(v.modules.running, path)
+	defer func() { v.modules.running = v.modules.running[:len(v.modules.running)-1] }()
+
+	// The module's errors are reported at the import, so collect them rather
+	// than reporting them against the importing file's source.
+	reporter := &errorhandling.CollectingReporter{}
+	child := NewInterpreter(reporter)
+	child.path, child.modules, child.out, child.decimals = path, v.modules, v.out, v.decimals
+	tokens := scanner.NewScanner(string(src), reporter).ScanTokens()
+	p := parser.NewParser(tokens, reporter)
+	stmts := p.Parse()
+	if reporter.ExitCode() == 0 {
+		resolver := Resolver{Interp: &child, Reporter: reporter}
+		if err := resolver.Resolve(stmts); err == nil {
+			child.Interpret(stmts)
+		}
+	}
+	if len(reporter.Diagnostics) > 0 {
+		return nil, fmt.Errorf("in module %q: %s", name, reporter.Diagnostics[0])
+	}
+
+	m := &LoxModule{Name: name, Exports: make(map[string]any)}
+	for _, stmt := range stmts {
+		if export, ok := stmt.(statement.Export); ok {
+			exported := exportedName(export)
+			m.Exports[exported], _ = child.globals.Get(exported)
+		}
+	}
+	v.modules.loaded[path] = m
+
+	return m, nil
+}
+
+// The paths of files, relative to the directory of the script being run
+// where possible, joined with arrows.
+func (m *modules) describe(paths []string) string {
+	names := make([]string, len(paths))
+	for i, p := range paths {
+		names[i] = p
+		if rel, err := filepath.Rel(m.root, p); err == nil && m.root != "" {
+			names[i] = rel
+		}
+	}
+
+	return strings.Join(names, " -> ")
+}
+
+// The absolute path of the file that an import of name from the file at
+// importer refers to. A relative name is looked up in the importer's
+// directory first, and then in each directory of the search path in turn.
+// An empty importer stands for a script read from somewhere other than a
+// file, whose imports are relative to the working directory.
+func findModule(name, importer string, searchPath []string) (string, error) {
+	candidates := []string{name}
+	if !filepath.IsAbs(name) {
+		dir := "."
+		if importer != "" {
+			dir = filepath.Dir(importer)
+		}
+		candidates = []string{filepath.Join(dir, name)}
+		for _, d := range searchPath {
+			candidates = append(candidates, filepath.Join(d, name))
+		}
+	}
+
+	for _, c := range candidates {
+		if info, err := os.Stat(c); err == nil && !info.IsDir() {
+			return filepath.Abs(c)
+		}
+	}
+
+	return "", fmt.Errorf("can't find module %q", name)
+}
+
+func exportedName(s statement.Export) string {
+	switch d := s.Declaration.(type) {
+	case statement.Var:
+		return d.Name.Lexeme
+	case statement.Function:
+		return d.Name.Lexeme
+	case statement.Class:
+		return d.Name.Lexeme
+	}
+
+	return ""
+}
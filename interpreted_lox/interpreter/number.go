@@ -0,0 +1,402 @@
+package interpreter
+
+import (
+	"fmt"
+	"golox/decimal"
+	"golox/scanner"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Numbers are int64 for integers, *big.Int for bigints, decimal.Decimal for
+// decimals and float64 for floats. Arithmetic on two integers gives an
+// integer, wrapping around on overflow. Otherwise the operands are converted
+// to the later of their kinds, in the order of numberKind.
+
+const mixedDecimalFloat = "Can't mix decimals and floats."
+
+// The kinds of number, in the order that arithmetic on two kinds converts
+// to: an integer and a bigint give a bigint, a bigint and a decimal a
+// decimal, and anything but a decimal and a float a float. Decimals and
+// floats don't mix, since the point of a decimal is to avoid floats'
+// rounding.
+type numberKind int
+
+const (
+	notNumber numberKind = iota
+	intKind
+	bigIntKind
+	decimalKind
+	floatKind
+)
+
+func kindOf(v any) numberKind {
+	switch v.(type) {
+	case int64:
+		return intKind
+	case *big.Int:
+		return bigIntKind
+	case decimal.Decimal:
+		return decimalKind
+	case float64:
+		return floatKind
+	}
+	return notNumber
+}
+
+// Whether v is a bigint or a decimal.
+func isBig(v any) bool {
+	k := kindOf(v)
+	return k == bigIntKind || k == decimalKind
+}
+
+// Apply the arithmetic operator op, one of + - * / %, to two values, which
+// must be numbers. Integer and bigint division and remainder truncate
+// towards zero, and decimals are rounded to the interpreter's decimal
+// context.
+func (v *Interpreter) arithmetic(op scanner.Token, left, right any) (any, *RuntimeError) {
+	lk, rk := kindOf(left), kindOf(right)
+	if lk == notNumber || rk == notNumber {
+		return nil, newOperandsError(op)
+	}
+	switch max(lk, rk) {
+	case intKind:
+		return intArithmetic(op, left.(int64), right.(int64))
+	case bigIntKind:
+		return bigIntArithmetic(op, toBigInt(left), toBigInt(right))
+	case decimalKind:
+		return decimalArithmetic(op, *v.decimals, toDecimal(left), toDecimal(right))
+	}
+	if lk == decimalKind || rk == decimalKind {
+		return nil, newRuntimeError(op, mixedDecimalFloat)
+	}
+
+	lf, _ := toFloat(left)
+	rf, _ := toFloat(right)
+	switch op.Token_type {
+	case scanner.PLUS:
+		return lf + rf, nil
+	case scanner.MINUS:
+		return lf - rf, nil
+	case scanner.STAR:
+		return lf * rf, nil
+	case scanner.SLASH:
+		return lf / rf, nil
+	}
+	return math.Mod(lf, rf), nil
+}
+
+func intArithmetic(op scanner.Token, l, r int64) (any, *RuntimeError) {
+	switch op.Token_type {
+	case scanner.PLUS:
+		return l + r, nil
+	case scanner.MINUS:
+		return l - r, nil
+	case scanner.STAR:
+		return l * r, nil
+	}
+	if r == 0 {
+		return nil, newRuntimeError(op, "Division by zero.")
+	}
+	if op.Token_type == scanner.SLASH {
+		return l / r, nil
+	}
+	return l % r, nil
+}
+
+func bigIntArithmetic(op scanner.Token, l, r *big.Int) (any, *RuntimeError) {
+	z := new(big.Int)
+	switch op.Token_type {
+	case scanner.PLUS:
+		return z.Add(l, r), nil
+	case scanner.MINUS:
+		return z.Sub(l, r), nil
+	case scanner.STAR:
+		return z.Mul(l, r), nil
+	}
+	if r.Sign() == 0 {
+		return nil, newRuntimeError(op, "Division by zero.")
+	}
+	if op.Token_type == scanner.SLASH {
+		return z.Quo(l, r), nil
+	}
+	return z.Rem(l, r), nil
+}
+
+func decimalArithmetic(op scanner.Token, ctx decimal.Context, l, r decimal.Decimal) (any, *RuntimeError) {
+	switch op.Token_type {
+	case scanner.PLUS:
+		return ctx.Add(l, r), nil
+	case scanner.MINUS:
+		return ctx.Sub(l, r), nil
+	case scanner.STAR:
+		return ctx.Mul(l, r), nil
+	}
+	var d decimal.Decimal
+	var ok bool
+	if op.Token_type == scanner.SLASH {
+		d, ok = ctx.Quo(l, r)
+	} else {
+		d, ok = ctx.Rem(l, r)
+	}
+	if !ok {
+		return nil, newRuntimeError(op, "Division by zero.")
+	}
+	return d, nil
+}
+
+// Compare two numbers with the comparison operator op. Bigints and decimals
+// are compared exactly with other numbers.
+func compare(op scanner.Token, left, right any) (bool, *RuntimeError) {
+	cmp, ordered, ok := order(left, right)
+	if !ok {
+		return false, newOperandsError(op)
+	}
+	if !ordered {
+		return false, nil
+	}
+
+	switch op.Token_type {
+	case scanner.GREATER:
+		return cmp > 0, nil
+	case scanner.GREATER_EQUAL:
+		return cmp >= 0, nil
+	case scanner.LESS:
+		return cmp < 0, nil
+	}
+	return cmp <= 0, nil
+}
+
+// -1, 0 or 1 as the number left is less than, equal to or greater than
+// right. ordered is false if either is NaN, and ok if both are numbers.
+func order(left, right any) (cmp int, ordered, ok bool) {
+	l, lInt := left.(int64)
+	r, rInt := right.(int64)
+	if lInt && rInt {
+		return compareInts(l, r), true, true
+	}
+	if kindOf(left) == notNumber || kindOf(right) == notNumber {
+		return 0, false, false
+	}
+	if isBig(left) || isBig(right) {
+		cmp, ordered = compareExact(left, right)
+		return cmp, ordered, true
+	}
+	lf, _ := toFloat(left)
+	rf, _ := toFloat(right)
+	if math.IsNaN(lf) || math.IsNaN(rf) {
+		return 0, false, true
+	}
+	return compareFloats(lf, rf), true, true
+}
+
+// Compare two numbers as fractions. Only a float can't be one, if it's
+// infinite or NaN.
+func compareExact(left, right any) (cmp int, ordered bool) {
+	l, lok := toRat(left)
+	r, rok := toRat(right)
+	lf, _ := toFloat(left)
+	rf, _ := toFloat(right)
+	switch {
+	case lok && rok:
+		return l.Cmp(r), true
+	case !lok && !math.IsNaN(lf):
+		return int(math.Copysign(1, lf)), true
+	case !rok && !math.IsNaN(rf):
+		return -int(math.Copysign(1, rf)), true
+	}
+	return 0, false
+}
+
+func compareInts(l, r int64) int {
+	switch {
+	case l < r:
+		return -1
+	case l > r:
+		return 1
+	}
+	return 0
+}
+
+func compareFloats(l, r float64) int {
+	switch {
+	case l < r:
+		return -1
+	case l > r:
+		return 1
+	}
+	return 0
+}
+
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(n).Float64()
+		return f, true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func toBigInt(v any) *big.Int {
+	if n, ok := v.(int64); ok {
+		return big.NewInt(n)
+	}
+	return v.(*big.Int)
+}
+
+func toDecimal(v any) decimal.Decimal {
+	if d, ok := v.(decimal.Decimal); ok {
+		return d
+	}
+	return decimal.FromInt(toBigInt(v))
+}
+
+// The number v is, as an exact fraction. Infinities, NaN and values that
+// aren't numbers have none.
+func toRat(v any) (*big.Rat, bool) {
+	switch n := v.(type) {
+	case int64:
+		return new(big.Rat).SetInt64(n), true
+	case *big.Int:
+		return new(big.Rat).SetInt(n), true
+	case decimal.Decimal:
+		return n.Rat(), true
+	case float64:
+		if math.IsInf(n, 0) || math.IsNaN(n) {
+			return nil, false
+		}
+		return new(big.Rat).SetFloat64(n), true
+	}
+	return nil, false
+}
+
+// Define bigint and decimal, which convert numbers and strings to bigints
+// and decimals, and decimalContext, which sets the precision and rounding
+// mode of arithmetic on decimals, among the globals.
+func (v *Interpreter) defineNumberFunctions() {
+	v.globals.Define("bigint", nativeFunction{arity: fixedArity(1), call: func(interp Interpreter, args []any) (any, string) {
+		switch x := args[0].(type) {
+		case int64, *big.Int:
+			return toBigInt(x), ""
+		case float64:
+			if math.IsInf(x, 0) || x != math.Trunc(x) {
+				return nil, "Only whole numbers can be converted to bigints."
+			}
+			i, _ := big.NewFloat(x).Int(nil)
+			return i, ""
+		case decimal.Decimal:
+			i, ok := x.Int()
+			if !ok {
+				return nil, "Only whole numbers can be converted to bigints."
+			}
+			return i, ""
+		case string:
+			i, ok := new(big.Int).SetString(x, 10)
+			if !ok {
+				return nil, fmt.Sprintf("Can't convert %q to a bigint.", x)
+			}
+			return i, ""
+		}
+		return nil, "Only numbers and strings can be converted to bigints."
+	}})
+	// A float becomes the shortest decimal that reads back as the same
+	// float, so decimal(0.1) is 0.1 rather than the float's exact value.
+	v.globals.Define("decimal", nativeFunction{arity: fixedArity(1), call: func(interp Interpreter, args []any) (any, string) {
+		switch x := args[0].(type) {
+		case int64, *big.Int, decimal.Decimal:
+			return toDecimal(x), ""
+		case float64:
+			if math.IsInf(x, 0) || math.IsNaN(x) {
+				return nil, "Infinities and NaN can't be converted to decimals."
+			}
+			d, _ := decimal.Parse(strconv.FormatFloat(x, 'f', -1, 64))
+			return d, ""
+		case string:
+			d, err := decimal.Parse(x)
+			if err != nil {
+				return nil, fmt.Sprintf("Can't convert %q to a decimal.", x)
+			}
+			return d, ""
+		}
+		return nil, "Only numbers and strings can be converted to decimals."
+	}})
+	v.globals.Define("decimalContext", nativeFunction{arity: fixedArity(2), call: func(interp Interpreter, args []any) (any, string) {
+		precision, ok := integer(args[0])
+		if !ok || precision < 1 {
+			return nil, "Decimal precision must be a positive integer."
+		}
+		name, ok := args[1].(string)
+		if !ok {
+			return nil, "Rounding mode must be a string."
+		}
+		rounding, ok := decimal.ParseRounding(name)
+		if !ok {
+			return nil, fmt.Sprintf("Unknown rounding mode %q.", name)
+		}
+		*interp.decimals = decimal.Context{Precision: precision, Rounding: rounding}
+		return nil, ""
+	}})
+}
+
+// The integer a float is equal to, if there is one.
+func floatToInt(f float64) (int64, bool) {
+	if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+// Whether two numbers are equal. Numbers of different kinds are equal only
+// if they are exactly the same number.
+func numbersEqual(left, right any) (equal, ok bool) {
+	if isBig(left) || isBig(right) {
+		if kindOf(left) == notNumber || kindOf(right) == notNumber {
+			return false, false
+		}
+		cmp, ordered := compareExact(left, right)
+		return ordered && cmp == 0, true
+	}
+	switch l := left.(type) {
+	case int64:
+		switch r := right.(type) {
+		case int64:
+			return l == r, true
+		case float64:
+			i, ok := floatToInt(r)
+			return ok && i == l, true
+		}
+	case float64:
+		switch r := right.(type) {
+		case int64:
+			i, ok := floatToInt(l)
+			return ok && i == r, true
+		case float64:
+			return l == r, true
+		}
+	}
+	return false, false
+}
+
+// How print shows a value.
+func printable(val any) any {
+	if f, ok := val.(float64); ok {
+		return FormatFloat(f)
+	}
+	return val
+}
+
+// Show a float the way Go would, but with ".0" after a whole number so that
+// it can't be mistaken for an integer.
+func FormatFloat(f float64) string {
+	s := fmt.Sprint(f)
+	if math.IsInf(f, 0) || math.IsNaN(f) || strings.ContainsAny(s, ".e") {
+		return s
+	}
+	return s + ".0"
+}
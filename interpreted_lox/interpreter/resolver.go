@@ -1,9 +1,10 @@
 package interpreter
 
 import (
-	"fmt"
+	"golox/errorhandling"
 	"golox/expression"
 	"golox/scanner"
+	"golox/source"
 	"golox/statement"
 )
 
@@ -25,19 +26,37 @@ const (
 
 type Resolver struct {
 	Interp          *Interpreter
+	Reporter        errorhandling.ErrorReporter
 	scopes          []scope
 	err             error
 	currentFunction functionType
 	currentClass    classType
+	// The number of loops around the statement being resolved, within the
+	// innermost function or finally clause.
+	loops     int
+	inFinally bool
 }
 
 type resolver_error struct {
-	prefix string
-	msg    string
+	text string
 }
 
 func (e resolver_error) Error() string {
-	return fmt.Sprintf("error in '%s': %s", e.prefix, e.msg)
+	return e.text
+}
+
+// Report an error at the source text span, which starts with lexeme, and stop
+// resolving.
+func (r *Resolver) error(span source.Span, lexeme string, msg string) {
+	d := errorhandling.Diagnostic{
+		Phase:   errorhandling.Resolving,
+		Line:    span.Start.Line,
+		Span:    span,
+		Where:   " at '" + lexeme + "'",
+		Message: msg,
+	}
+	r.Reporter.Report(d)
+	r.err = resolver_error{text: d.String()}
 }
 
 func (r *Resolver) Resolve(stmts []statement.Statement) error {
@@ -105,7 +124,16 @@ func (r *Resolver) resolveFunction(stmt statement.Function, t functionType) erro
 	defer r.endScope()
 	defer r.setFunctionStatus(r.currentFunction)
 	r.setFunctionStatus(t)
-	for _, param := range stmt.Params {
+	defer func(loops int, inFinally bool) { r.loops, r.inFinally = loops, inFinally }(r.loops, r.inFinally)
+	r.loops, r.inFinally = 0, false
+	for i, param := range stmt.Params {
+		// A default value is evaluated when the function is called, and can
+		// use the parameters before its own.
+		if i < len(stmt.Defaults) && stmt.Defaults[i] != nil {
+			if err := r.resolve_expression(stmt.Defaults[i]); err != nil {
+				return err
+			}
+		}
 		r.declare(param)
 		r.define(param)
 	}
@@ -150,6 +178,12 @@ func (r *Resolver) VisitCall(e expression.Call) {
 			return
 		}
 	}
+	for _, arg := range e.Named {
+		r.err = r.resolve_expression(arg.Value)
+		if r.err != nil {
+			return
+		}
+	}
 }
 
 func (r *Resolver) VisitGet(e expression.Get) {
@@ -173,7 +207,7 @@ func (r *Resolver) VisitLogical(e expression.Logical) {
 
 func (r *Resolver) VisitThis(e expression.This) {
 	if r.currentClass != class {
-		r.err = resolver_error{prefix: fmt.Sprintf("[line %d]:", e.Keyword.Line), msg: "\"this\" can't be used outside of a class declaration"}
+		r.error(e.Keyword.Span, e.Keyword.Lexeme, "\"this\" can't be used outside of a class declaration")
 		return
 	}
 	r.resolveLocal(e, e.Keyword)
@@ -184,6 +218,68 @@ func (r *Resolver) VisitSet(e expression.Set) {
 	r.resolve_expression(e.Value)
 }
 
+func (r *Resolver) VisitList(e expression.List) {
+	for _, element := range e.Elements {
+		r.err = r.resolve_expression(element)
+		if r.err != nil {
+			return
+		}
+	}
+}
+
+func (r *Resolver) VisitMap(e expression.Map) {
+	for i := range e.Keys {
+		r.err = r.resolve_expression(e.Keys[i])
+		if r.err != nil {
+			return
+		}
+		r.err = r.resolve_expression(e.Values[i])
+		if r.err != nil {
+			return
+		}
+	}
+}
+
+func (r *Resolver) VisitLambda(e expression.Lambda) {
+	r.err = r.resolveFunction(lambdaDeclaration(e), function)
+}
+
+func (r *Resolver) VisitInterpolation(e expression.Interpolation) {
+	for _, expr := range e.Exprs {
+		r.err = r.resolve_expression(expr)
+		if r.err != nil {
+			return
+		}
+	}
+}
+
+func (r *Resolver) VisitRange(e expression.Range) {
+	r.err = r.resolve_expression(e.Start)
+	if r.err != nil {
+		return
+	}
+
+	r.err = r.resolve_expression(e.End)
+}
+
+func (r *Resolver) VisitSubscript(e expression.Subscript) {
+	r.err = r.resolve_expression(e.Object)
+	if r.err != nil {
+		return
+	}
+
+	r.err = r.resolve_expression(e.Index)
+}
+
+func (r *Resolver) VisitSubscriptSet(e expression.SubscriptSet) {
+	r.VisitSubscript(expression.Subscript{Object: e.Object, Index: e.Index})
+	if r.err != nil {
+		return
+	}
+
+	r.err = r.resolve_expression(e.Value)
+}
+
 func (r *Resolver) VisitSuper(e expression.Super) {
     r.resolveLocal(e, e.Keyword)
 
@@ -196,7 +292,7 @@ func (r *Resolver) VisitVariable(e expression.Variable) {
 	if len(r.scopes) > 0 {
 		res, ok := r.scopes[len(r.scopes)-1][e.GetName()]
 		if ok && res == false {
-			r.err = resolver_error{prefix: e.GetName(), msg: "can't read a local variable in its own initializer"}
+			r.error(e.Span, e.GetName(), "can't read a local variable in its own initializer")
 			return
 		}
 	}
@@ -214,13 +310,33 @@ func (r *Resolver) changeClassType(t classType) {
 	r.currentClass = t
 }
 
+func (r *Resolver) VisitBreakStmt(stmt statement.Break) {
+	r.resolveLoopJump(stmt.Keyword)
+}
+
+func (r *Resolver) VisitContinueStmt(stmt statement.Continue) {
+	r.resolveLoopJump(stmt.Keyword)
+}
+
+func (r *Resolver) resolveLoopJump(keyword scanner.Token) {
+	if r.loops > 0 {
+		return
+	}
+	if r.inFinally {
+		r.error(keyword.Span, keyword.Lexeme, "Can't use '"+keyword.Lexeme+"' to leave a finally clause.")
+		return
+	}
+	r.error(keyword.Span, keyword.Lexeme, "Can't use '"+keyword.Lexeme+"' outside of a loop.")
+}
+
 func (r *Resolver) VisitClassStmt(stmt statement.Class) {
 	r.declare(stmt.Name)
 	r.define(stmt.Name)
 
 	if stmt.ParentClass != nil {
         if stmt.ParentClass.GetToken().Lexeme == stmt.Name.Lexeme {
-            r.err = resolver_error{prefix: "class statement", msg: "a class cannot inherit from itself"}
+            parent := stmt.ParentClass.GetToken()
+            r.error(parent.Span, parent.Lexeme, "a class cannot inherit from itself")
             return
         }
 		r.resolve_expression(stmt.ParentClass)
@@ -243,9 +359,26 @@ func (r *Resolver) VisitClassStmt(stmt statement.Class) {
 		}
 	}
 }
+func (r *Resolver) VisitExportStmt(stmt statement.Export) {
+	r.err = r.resolve_statement(stmt.Declaration)
+}
 func (r *Resolver) VisitExpressionStmt(stmt statement.Expression) {
 	r.err = r.resolve_expression(stmt.Val)
 }
+func (r *Resolver) VisitForInStmt(stmt statement.ForIn) {
+	r.err = r.resolve_expression(stmt.Iterable)
+	if r.err != nil {
+		return
+	}
+
+	r.beginScope()
+	r.declare(stmt.Name)
+	r.define(stmt.Name)
+	r.loops++
+	r.err = r.resolve_statement(stmt.Stmt)
+	r.loops--
+	r.endScope()
+}
 func (r *Resolver) VisitFunctionStmt(stmt statement.Function) {
 	r.declare(stmt.Name)
 	r.define(stmt.Name)
@@ -265,15 +398,45 @@ func (r *Resolver) VisitIfStmt(stmt statement.If) {
 		r.err = r.resolve_statement(stmt.Else_stmt)
 	}
 }
+func (r *Resolver) VisitImportStmt(stmt statement.Import) {
+	// Imports only appear at the top level, where names are global and
+	// aren't resolved.
+}
 func (r *Resolver) VisitPrintStmt(stmt statement.Print) {
 	r.err = r.resolve_expression(stmt.Val)
 }
+func (r *Resolver) VisitThrowStmt(stmt statement.Throw) {
+	r.err = r.resolve_expression(stmt.Value)
+}
+func (r *Resolver) VisitTryStmt(stmt statement.Try) {
+	r.err = r.resolve_statement(stmt.Body)
+	if r.err != nil {
+		return
+	}
+	if stmt.CatchName != nil {
+		// The catch variable has a scope of its own around the clause.
+		r.beginScope()
+		r.declare(*stmt.CatchName)
+		r.define(*stmt.CatchName)
+		r.err = r.resolve_statement(*stmt.Catch)
+		r.endScope()
+		if r.err != nil {
+			return
+		}
+	}
+	if stmt.Finally != nil {
+		loops, inFinally := r.loops, r.inFinally
+		r.loops, r.inFinally = 0, true
+		r.err = r.resolve_statement(*stmt.Finally)
+		r.loops, r.inFinally = loops, inFinally
+	}
+}
 func (r *Resolver) VisitReturnStmt(stmt statement.Return) {
 	if r.currentFunction == notFunction {
-		r.err = resolver_error{prefix: "return statement", msg: "cannot call \"return\" outside of a function or method"}
+		r.error(stmt.Span, "return", "cannot call \"return\" outside of a function or method")
 		return
 	} else if r.currentFunction == initializer {
-		r.err = resolver_error{prefix: "return statement", msg: "cannot call \"return\" inside of an initializer"}
+		r.error(stmt.Span, "return", "cannot call \"return\" inside of an initializer")
 		return
 	}
 	r.err = r.resolve_expression(stmt.Return_expr)
@@ -292,5 +455,10 @@ func (r *Resolver) VisitWhileStmt(stmt statement.While) {
 		return
 	}
 
+	r.loops++
 	r.err = r.resolve_statement(stmt.Stmt)
+	r.loops--
+	if r.err == nil && stmt.Increment != nil {
+		r.err = r.resolve_expression(stmt.Increment)
+	}
 }
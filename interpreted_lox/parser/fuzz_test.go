@@ -0,0 +1,31 @@
+package parser_test
+
+import (
+	"golox/errorhandling"
+	"golox/parser"
+	"golox/scanner"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func FuzzParse(f *testing.F) {
+	files, err := filepath.Glob("../*.lox")
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, file := range files {
+		source, err := os.ReadFile(file)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(string(source))
+	}
+
+	f.Fuzz(func(t *testing.T, source string) {
+		reporter := &errorhandling.CollectingReporter{}
+		toks := scanner.NewScanner(source, reporter).ScanTokens()
+		p := parser.NewParser(toks, reporter)
+		p.Parse()
+	})
+}
@@ -4,40 +4,64 @@ import (
 	"golox/errorhandling"
 	"golox/expression"
 	"golox/scanner"
+	"golox/source"
 	"golox/statement"
 )
 
 type Parser struct {
-	tokens  []scanner.Token
-	current int
+	tokens   []scanner.Token
+	current  int
+	reporter errorhandling.ErrorReporter
 }
 
-func NewParser(tokens []scanner.Token) Parser {
-	return Parser{tokens: tokens, current: 0}
+func NewParser(tokens []scanner.Token, reporter errorhandling.ErrorReporter) Parser {
+	return Parser{tokens: tokens, current: 0, reporter: reporter}
 }
 
+// Parse the whole token stream, reporting every syntax error. After an error
+// the parser synchronizes at the next statement and carries on, so the
+// statements returned are incomplete if any errors were reported.
 func (p *Parser) Parse() []statement.Statement {
 	var statements []statement.Statement
 	at_end := p.IsAtEnd()
 	for !at_end {
-		stmt, err := p.declaration()
-		if err != nil {
-			errorhandling.RuntimeError(err)
-			return nil
+		stmt, err := p.topLevelDeclaration()
+		if err == nil {
+			statements = append(statements, stmt)
 		}
-		statements = append(statements, stmt)
 		at_end = p.IsAtEnd()
 	}
 
 	return statements
 }
 
+// A declaration that may only appear at the top level of a module.
+func (p *Parser) topLevelDeclaration() (statement.Statement, error) {
+	var stmt statement.Statement
+	var err error
+	if p.match(scanner.IMPORT) {
+		stmt, err = p.importDeclaration()
+	} else if p.match(scanner.EXPORT) {
+		stmt, err = p.exportDeclaration()
+	} else {
+		return p.declaration()
+	}
+	if err != nil {
+		p.syncronize()
+		return nil, err
+	}
+	return stmt, nil
+}
+
 func (p *Parser) declaration() (statement.Statement, error) {
 	var stmt statement.Statement
 	var err error
-	if p.match(scanner.VAR) {
+	if p.check(scanner.IMPORT) || p.check(scanner.EXPORT) {
+		err = p.error(p.peek(), "Can only use '"+p.peek().Lexeme+"' at the top level of a module.")
+	} else if p.match(scanner.VAR) {
 		stmt, err = p.varDeclaration()
-	} else if p.match(scanner.FUN) {
+	} else if !p.checkNext(scanner.LEFT_PAREN) && p.match(scanner.FUN) {
+		// "fun (" starts a function expression rather than a declaration.
 		stmt, err = p.funcDeclaration()
 	} else if p.match(scanner.CLASS) {
 		stmt, err = p.classDeclaration()
@@ -56,11 +80,7 @@ func (p *Parser) statement() (statement.Statement, error) {
 		return p.printStatement()
 	}
 	if p.peek().Token_type == scanner.LEFT_BRACE {
-		stmts, err := p.block()
-		if err != nil {
-			return nil, err
-		}
-		return statement.NewBlockStmt(stmts), nil
+		return p.blockStatement()
 	}
 
 	if p.match(scanner.IF) {
@@ -79,16 +99,28 @@ func (p *Parser) statement() (statement.Statement, error) {
 		return p.returnStatement()
 	}
 
+	if p.check(scanner.BREAK) || p.check(scanner.CONTINUE) {
+		return p.loopJumpStatement()
+	}
+
+	if p.check(scanner.THROW) {
+		return p.throwStatement()
+	}
+
+	if p.check(scanner.TRY) {
+		return p.tryStatement()
+	}
+
 	return p.expressionStatement()
 }
 
 func (p *Parser) returnStatement() (statement.Statement, error) {
-	_, err := p.consume(scanner.RETURN, "expected 'return'")
+	keyword, err := p.consume(scanner.RETURN, "expected 'return'")
 	if err != nil {
 		return nil, err
 	}
 	if p.match(scanner.SEMICOLON) {
-		return statement.Return{Return_expr: nil}, nil
+		return statement.Return{Span: p.spanFrom(keyword), Return_expr: nil}, nil
 	}
 	expr, err := p.expression()
 	if err != nil {
@@ -99,7 +131,78 @@ func (p *Parser) returnStatement() (statement.Statement, error) {
 		return nil, err
 	}
 
-	return statement.Return{Return_expr: expr}, nil
+	return statement.Return{Span: p.spanFrom(keyword), Return_expr: expr}, nil
+}
+
+func (p *Parser) loopJumpStatement() (statement.Statement, error) {
+	// breakStmt      → "break" ";" ;
+	// continueStmt   → "continue" ";" ;
+	// Whether the statement is inside a loop is checked by the resolver.
+	keyword := p.advance()
+	if _, err := p.consume(scanner.SEMICOLON, "Expect ';' after '"+keyword.Lexeme+"'."); err != nil {
+		return nil, err
+	}
+	if keyword.Token_type == scanner.BREAK {
+		return statement.Break{Span: p.spanFrom(keyword), Keyword: keyword}, nil
+	}
+
+	return statement.Continue{Span: p.spanFrom(keyword), Keyword: keyword}, nil
+}
+
+func (p *Parser) throwStatement() (statement.Statement, error) {
+	// throwStmt      → "throw" expression ";" ;
+	keyword := p.advance()
+	expr, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.consume(scanner.SEMICOLON, "Expect ';' after thrown value."); err != nil {
+		return nil, err
+	}
+
+	return statement.Throw{Span: p.spanFrom(keyword), Keyword: keyword, Value: expr}, nil
+}
+
+func (p *Parser) tryStatement() (statement.Statement, error) {
+	// tryStmt        → "try" block ( "catch" "(" IDENTIFIER ")" block )? ( "finally" block )? ;
+	// with at least one of the two clauses.
+	keyword := p.advance()
+	body, err := p.blockStatement()
+	if err != nil {
+		return nil, err
+	}
+	stmt := statement.Try{Body: body}
+
+	if p.match(scanner.CATCH) {
+		if _, err := p.consume(scanner.LEFT_PAREN, "Expect '(' after 'catch'."); err != nil {
+			return nil, err
+		}
+		name, err := p.consume(scanner.IDENTIFIER, "Expect exception variable name.")
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.consume(scanner.RIGHT_PAREN, "Expect ')' after exception variable."); err != nil {
+			return nil, err
+		}
+		catch, err := p.blockStatement()
+		if err != nil {
+			return nil, err
+		}
+		stmt.CatchName, stmt.Catch = &name, &catch
+	}
+	if p.match(scanner.FINALLY) {
+		finally, err := p.blockStatement()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Finally = &finally
+	}
+	if stmt.CatchName == nil && stmt.Finally == nil {
+		return nil, p.error(p.peek(), "Expect 'catch' or 'finally' after try block.")
+	}
+	stmt.Span = p.spanFrom(keyword)
+
+	return stmt, nil
 }
 
 func (p *Parser) forStatement() (statement.Statement, error) {
@@ -107,12 +210,16 @@ func (p *Parser) forStatement() (statement.Statement, error) {
 	var conditional_expr expression.Expr
 	var increment_expression expression.Expr
 	var loop_stmt statement.Statement
+	keyword := p.previous()
 
 	_, err := p.consume(scanner.LEFT_PAREN, "Expected '(' after 'for'.")
 	if err != nil {
 		return nil, err
 	}
 
+	if p.check(scanner.VAR) && p.checkNext(scanner.IDENTIFIER) && p.tokens[p.current+2].Token_type == scanner.IN {
+		return p.forInStatement(keyword)
+	}
 	if p.match(scanner.VAR) {
 		initializer_stmt, err = p.varDeclaration()
 		if err != nil {
@@ -156,25 +263,46 @@ func (p *Parser) forStatement() (statement.Statement, error) {
 	if err != nil {
 		return nil, err
 	}
-	if increment_expression != nil {
-		body := []statement.Statement{loop_stmt, statement.NewExpressionStmt(increment_expression)}
-		loop_stmt = statement.NewBlockStmt(body)
-	}
+	// The nodes the loop desugars into all share the span of the whole loop.
+	span := p.spanFrom(keyword)
 	if conditional_expr == nil {
-		conditional_expr = expression.Literal{Value: true}
+		conditional_expr = expression.Literal{Span: span, Value: true}
 	}
-	var body statement.Statement = statement.NewWhileStmt(conditional_expr, loop_stmt)
+	loop := statement.NewWhileStmt(span, conditional_expr, loop_stmt)
+	loop.Increment = increment_expression
+	var body statement.Statement = loop
 	if initializer_stmt != nil {
 		tmp := []statement.Statement{initializer_stmt, body}
-		body = statement.NewBlockStmt(tmp)
+		body = statement.NewBlockStmt(span, tmp)
 	}
 
 	return body, nil // for stmt
 
 }
 
+func (p *Parser) forInStatement(keyword scanner.Token) (statement.Statement, error) {
+	// forInStmt      → "for" "(" "var" IDENTIFIER "in" expression ")" statement ;
+	p.advance()
+	name := p.advance()
+	p.advance()
+	iterable, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.consume(scanner.RIGHT_PAREN, "Expect ')' after for-in iterable."); err != nil {
+		return nil, err
+	}
+	body, err := p.statement()
+	if err != nil {
+		return nil, err
+	}
+
+	return statement.ForIn{Span: p.spanFrom(keyword), Name: name, Iterable: iterable, Stmt: body}, nil
+}
+
 func (p *Parser) whileStatement() (statement.Statement, error) {
 	var err error
+	keyword := p.previous()
 	_, err = p.consume(scanner.LEFT_PAREN, "Expected '(' after 'while'.")
 	if err != nil {
 		return nil, err
@@ -195,11 +323,12 @@ func (p *Parser) whileStatement() (statement.Statement, error) {
 		return nil, err
 	}
 
-	return statement.NewWhileStmt(conditional_stmt, while_body), nil
+	return statement.NewWhileStmt(p.spanFrom(keyword), conditional_stmt, while_body), nil
 }
 
 func (p *Parser) ifStatement() (statement.Statement, error) {
 	var else_stmt statement.Statement
+	keyword := p.previous()
 
 	_, err := p.consume(scanner.LEFT_PAREN, "Expected '(' after 'if'.")
 	if err != nil {
@@ -228,11 +357,12 @@ func (p *Parser) ifStatement() (statement.Statement, error) {
 		return nil, err
 	}
 
-	return statement.NewIfStatement(expr, if_stmt, else_stmt), nil
+	return statement.NewIfStatement(p.spanFrom(keyword), expr, if_stmt, else_stmt), nil
 }
 
 func (p *Parser) printStatement() (statement.Statement, error) {
 	var stmt statement.Statement
+	keyword := p.previous()
 	expr, err := p.expression()
 	if err != nil {
 		return stmt, err
@@ -243,7 +373,7 @@ func (p *Parser) printStatement() (statement.Statement, error) {
 		return stmt, err
 	}
 
-	return statement.NewPrintStmt(expr), nil
+	return statement.NewPrintStmt(p.spanFrom(keyword), expr), nil
 }
 
 func (p *Parser) expressionStatement() (statement.Statement, error) {
@@ -257,7 +387,17 @@ func (p *Parser) expressionStatement() (statement.Statement, error) {
 		return stmt, err
 	}
 
-	return statement.NewExpressionStmt(expr), nil
+	return statement.NewExpressionStmt(expr.SourceSpan().Join(p.previous().Span), expr), nil
+}
+
+func (p *Parser) blockStatement() (statement.Block, error) {
+	start := p.peek()
+	stmts, err := p.block()
+	if err != nil {
+		return statement.Block{}, err
+	}
+
+	return statement.NewBlockStmt(p.spanFrom(start), stmts), nil
 }
 
 func (p *Parser) block() ([]statement.Statement, error) {
@@ -269,11 +409,12 @@ func (p *Parser) block() ([]statement.Statement, error) {
 	}
 
 	for !p.check(scanner.RIGHT_BRACE) && !p.IsAtEnd() {
+		// declaration has already reported any error and synchronized, so
+		// keep going to find the errors in the rest of the block.
 		stmt, err := p.declaration()
-		if err != nil {
-			return nil, err
+		if err == nil {
+			statements = append(statements, stmt)
 		}
-		statements = append(statements, stmt)
 	}
 	_, err = p.consume(scanner.RIGHT_BRACE, "Expect '}' after block.")
 	if err != nil {
@@ -288,6 +429,7 @@ func (p *Parser) classDeclaration() (statement.Statement, error) {
 	var parentClass *expression.Variable
 	var err error
 	var funcs []statement.Function
+	keyword := p.previous()
 
 	classId, err = p.consume(scanner.IDENTIFIER, "expected an identifier")
 	if err != nil {
@@ -299,7 +441,7 @@ func (p *Parser) classDeclaration() (statement.Statement, error) {
 		if err != nil {
 			return nil, err
 		}
-        parentClass = &expression.Variable{Name: p.previous()}
+		parentClass = &expression.Variable{Span: p.previous().Span, Name: p.previous()}
 	}
 
 	_, err = p.consume(scanner.LEFT_BRACE, "expected '{'")
@@ -308,23 +450,39 @@ func (p *Parser) classDeclaration() (statement.Statement, error) {
 	}
 
 	if p.match(scanner.RIGHT_BRACE) {
-		return statement.Class{Name: classId}, nil // return class here
+		return statement.Class{Span: p.spanFrom(keyword), Name: classId, ParentClass: parentClass}, nil // return class here
 	}
 
+	var fields []statement.Field
 	for !p.match(scanner.RIGHT_BRACE) && !p.IsAtEnd() {
+		// A name followed by a colon declares a field rather than a method.
+		if p.check(scanner.IDENTIFIER) && p.checkNext(scanner.COLON) {
+			name := p.advance()
+			annotation, err := p.typeAnnotation()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.consume(scanner.SEMICOLON, "Expect ';' after field declaration."); err != nil {
+				return nil, err
+			}
+			fields = append(fields, statement.Field{Span: p.spanFrom(name), Name: name, Type: annotation})
+			continue
+		}
 		val, err := p.funcDeclaration()
 		if err != nil {
 			return nil, err
 		}
 		fun, ok := val.(statement.Function)
 		if !ok {
-			return nil, NewParseError("expected a function definition")
+			return nil, p.error(p.previous(), "expected a function definition")
 		}
 		funcs = append(funcs, fun)
 	}
 
 	return statement.Class{
+		Span:        p.spanFrom(keyword),
 		Name:        classId,
+		Fields:      fields,
 		Methods:     funcs,
 		ParentClass: parentClass,
 	}, nil // return class here
@@ -336,71 +494,169 @@ func (p *Parser) funcDeclaration() (statement.Statement, error) {
 }
 
 func (p *Parser) function() (statement.Statement, error) {
-	var funcId scanner.Token
-	var err ParseError
-	var identifers []scanner.Token
+	// Methods are declared without the 'fun' keyword.
+	start := p.peek()
+	if p.previous().Token_type == scanner.FUN {
+		start = p.previous()
+	}
 
 	if !p.match(scanner.IDENTIFIER) {
-		err = NewParseError("expected an identifer")
-		return nil, &err
+		return nil, p.error(p.peek(), "expected an identifer")
+	}
+	fn, pErr := p.functionBody(start, p.previous())
+	if pErr != nil {
+		return nil, pErr
 	}
-	funcId = p.previous()
+
+	return fn, nil
+}
+
+// The parameters, return type and body of a function that starts at start,
+// which is either a declaration or a function expression with no name.
+func (p *Parser) functionBody(start scanner.Token, funcId scanner.Token) (statement.Function, error) {
+	fn := statement.Function{Name: funcId}
 
 	_, pErr := p.consume(scanner.LEFT_PAREN, "expected '('.")
 	if pErr != nil {
-		return nil, pErr
+		return statement.Function{}, pErr
 	}
 
 	if p.peek().Token_type != scanner.RIGHT_PAREN {
-		identifers, pErr = p.identifiers()
-		if pErr != nil {
-			return nil, pErr
+		if pErr = p.parameters(&fn); pErr != nil {
+			return statement.Function{}, pErr
 		}
 	}
 
 	_, pErr = p.consume(scanner.RIGHT_PAREN, "expected ')'.")
 	if pErr != nil {
-		return nil, pErr
+		return statement.Function{}, pErr
+	}
+	fn.ReturnType, pErr = p.typeAnnotation()
+	if pErr != nil {
+		return statement.Function{}, pErr
 	}
 
-	block, pErr := p.block()
+	fn.Body, pErr = p.block()
 	if pErr != nil {
-		return nil, pErr
+		return statement.Function{}, pErr
 	}
+	fn.Span = p.spanFrom(start)
 
-	return statement.Function{Name: funcId, Params: identifers, Body: block}, nil
+	return fn, nil
 }
 
-func (p *Parser) identifiers() ([]scanner.Token, error) {
-	// parameters     → IDENTIFIER ( "," IDENTIFIER )* ;
-	var tokens []scanner.Token
-	var err ParseError
+// Parse the parameters of fn.
+func (p *Parser) parameters(fn *statement.Function) error {
+	// parameters     → parameter ( "," parameter )* ;
+	// parameter      → "..."? IDENTIFIER annotation? ( "=" expression )? ;
+	for {
+		if fn.Variadic {
+			return p.error(p.previous(), "A rest parameter must be the last parameter.")
+		}
+		fn.Variadic = p.match(scanner.ELLIPSIS)
+		if !p.match(scanner.IDENTIFIER) {
+			return p.error(p.peek(), "expected an idenifier.")
+		}
+		name := p.previous()
+		annotation, pErr := p.typeAnnotation()
+		if pErr != nil {
+			return pErr
+		}
 
-	if !p.match(scanner.IDENTIFIER) {
-		err = NewParseError("expected an idenifier.")
-		return nil, &err
+		var value expression.Expr
+		if p.match(scanner.EQUAL) {
+			if fn.Variadic {
+				return p.error(p.previous(), "A rest parameter can't have a default value.")
+			}
+			value, pErr = p.expression()
+			if pErr != nil {
+				return pErr
+			}
+		} else if !fn.Variadic && len(fn.Defaults) > 0 && fn.Defaults[len(fn.Defaults)-1] != nil {
+			return p.error(name, "A parameter without a default value can't follow one with a default.")
+		}
+		fn.Params = append(fn.Params, name)
+		fn.ParamTypes = append(fn.ParamTypes, annotation)
+		fn.Defaults = append(fn.Defaults, value)
+
+		if !p.match(scanner.COMMA) {
+			return nil
+		}
 	}
-	tokens = append(tokens, p.previous())
+}
 
-	if p.match(scanner.COMMA) {
-		val, pErr := p.identifiers()
-		if pErr != nil {
-			return nil, pErr
+// An optional ": type" after a name. The type is a class name, one of the
+// built-in type names, or nil.
+func (p *Parser) typeAnnotation() (*statement.TypeAnnotation, error) {
+	// annotation     → ":" ( IDENTIFIER | "nil" ) ;
+	if !p.match(scanner.COLON) {
+		return nil, nil
+	}
+	if !p.match(scanner.IDENTIFIER, scanner.NIL) {
+		return nil, p.error(p.peek(), "Expect type after ':'.")
+	}
+
+	return &statement.TypeAnnotation{Span: p.previous().Span, Name: p.previous()}, nil
+}
+
+func (p *Parser) importDeclaration() (statement.Statement, error) {
+	// importDecl     → "import" ( IDENTIFIER "from" )? STRING ";" ;
+	keyword := p.previous()
+	var name *scanner.Token
+	if p.match(scanner.IDENTIFIER) {
+		n := p.previous()
+		name = &n
+		// "from" is only a keyword here, so that it can still name variables.
+		if !p.check(scanner.IDENTIFIER) || p.peek().Lexeme != "from" {
+			return nil, p.error(p.peek(), "Expect 'from' after module name.")
 		}
-		return append(tokens, val...), nil
+		p.advance()
+	}
+	path, err := p.consume(scanner.STRING, "Expect module path.")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.consume(scanner.SEMICOLON, "Expect ';' after import."); err != nil {
+		return nil, err
 	}
 
-	return tokens, nil
+	return statement.Import{Span: p.spanFrom(keyword), Name: name, Path: path}, nil
+}
+
+func (p *Parser) exportDeclaration() (statement.Statement, error) {
+	// exportDecl     → "export" ( varDecl | funDecl | classDecl ) ;
+	keyword := p.previous()
+	var decl statement.Statement
+	var err error
+	if p.match(scanner.VAR) {
+		decl, err = p.varDeclaration()
+	} else if p.match(scanner.FUN) {
+		decl, err = p.funcDeclaration()
+	} else if p.match(scanner.CLASS) {
+		decl, err = p.classDeclaration()
+	} else {
+		return nil, p.error(p.peek(), "Expect declaration after 'export'.")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return statement.Export{Span: p.spanFrom(keyword), Declaration: decl}, nil
 }
 
 func (p *Parser) varDeclaration() (statement.Statement, error) {
 	var initializer expression.Expr
 	var err error
+	keyword := p.previous()
 
 	name, err := p.consume(scanner.IDENTIFIER, "Expect variable name.")
 	if err != nil {
 		return nil, err
 	}
+	annotation, err := p.typeAnnotation()
+	if err != nil {
+		return nil, err
+	}
 	if p.match(scanner.EQUAL) {
 		initializer, err = p.expression()
 	} else {
@@ -414,7 +670,7 @@ func (p *Parser) varDeclaration() (statement.Statement, error) {
 	if err != nil {
 		return nil, err
 	}
-	return statement.NewVarStmt(name, initializer), nil
+	return statement.NewVarStmt(p.spanFrom(keyword), name, initializer, annotation), nil
 }
 
 func (p *Parser) expression() (expression.Expr, error) {
@@ -428,27 +684,23 @@ func (p *Parser) assignment() (expression.Expr, error) {
 	}
 
 	if p.match(scanner.EQUAL) {
+		equals := p.previous()
 		right, err := p.assignment()
 		if err != nil {
 			return nil, err
 		}
 
+		span := left.SourceSpan().Join(right.SourceSpan())
 		switch t := left.(type) {
 		case expression.Variable:
-			return expression.Assign{Name: t.GetToken(), Value: right}, nil
+			return expression.Assign{Span: span, Name: t.GetToken(), Value: right}, nil
 		case expression.Get:
-			return expression.Set{Object: t.Object, Name: t.Name, Value: right}, nil // Set expression
+			return expression.Set{Span: span, Object: t.Object, Name: t.Name, Value: right}, nil // Set expression
+		case expression.Subscript:
+			return expression.SubscriptSet{Span: span, Object: t.Object, Bracket: t.Bracket, Index: t.Index, Value: right}, nil
 		default:
-			err := NewParseError("Left side of assignment must be a variable.")
-			return nil, &err
+			return nil, p.error(equals, "Left side of assignment must be a variable.")
 		}
-
-		val, ok := left.(expression.Variable)
-		if !ok {
-			err := NewParseError("Left side of assignment must be a variable.")
-			return nil, &err
-		}
-		return expression.Assign{Name: val.GetToken(), Value: right}, nil
 	}
 
 	return left, nil
@@ -506,9 +758,9 @@ func (p *Parser) equality() (expression.Expr, error) {
 		op := p.previous()
 		right, err := p.equality()
 		if err != nil {
-			return expression.Unary{}, nil
+			return expression.Unary{}, err
 		}
-		return expression.Binary{Left: prefix, Operator: op, Right: right}, nil
+		return expression.Binary{Span: prefix.SourceSpan().Join(right.SourceSpan()), Left: prefix, Operator: op, Right: right}, nil
 	}
 
 	// Base case:
@@ -518,7 +770,7 @@ func (p *Parser) equality() (expression.Expr, error) {
 }
 
 func (p *Parser) comparison() (expression.Expr, error) {
-	prefix, err := p.term()
+	prefix, err := p.rangeExpr()
 	if err != nil {
 		return expression.Unary{}, err
 	}
@@ -527,14 +779,32 @@ func (p *Parser) comparison() (expression.Expr, error) {
 		op := p.previous()
 		right, err := p.comparison()
 		if err != nil {
-			return expression.Unary{}, nil
+			return expression.Unary{}, err
 		}
-		return expression.Binary{Left: prefix, Operator: op, Right: right}, nil
+		return expression.Binary{Span: prefix.SourceSpan().Join(right.SourceSpan()), Left: prefix, Operator: op, Right: right}, nil
 	}
 
 	return prefix, nil
 }
 
+func (p *Parser) rangeExpr() (expression.Expr, error) {
+	// range          → term ( ".." term )? ;
+	start, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	if !p.match(scanner.DOT_DOT) {
+		return start, nil
+	}
+	op := p.previous()
+	end, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+
+	return expression.Range{Span: start.SourceSpan().Join(end.SourceSpan()), Start: start, Operator: op, End: end}, nil
+}
+
 // term           → factor ( ( "-" | "+" ) factor )* ;
 func (p *Parser) term() (expression.Expr, error) {
 	prefix, err := p.factor()
@@ -548,26 +818,26 @@ func (p *Parser) term() (expression.Expr, error) {
 		if err != nil {
 			return expression.Unary{}, err
 		}
-		return expression.Binary{Left: prefix, Operator: op, Right: right}, nil
+		return expression.Binary{Span: prefix.SourceSpan().Join(right.SourceSpan()), Left: prefix, Operator: op, Right: right}, nil
 	}
 
 	return prefix, nil
 }
 
-// factor         → unary ( ( "/" | "*" ) unary )* ;
+// factor         → unary ( ( "/" | "*" | "%" ) unary )* ;
 func (p *Parser) factor() (expression.Expr, error) {
 	prefix, err := p.unary()
 	if err != nil {
 		return expression.Unary{}, err
 	}
 
-	if p.match(scanner.STAR, scanner.SLASH) {
+	if p.match(scanner.STAR, scanner.SLASH, scanner.PERCENT) {
 		op := p.previous()
 		right, err := p.factor()
 		if err != nil {
 			return expression.Unary{}, err
 		}
-		return expression.Binary{Left: prefix, Operator: op, Right: right}, nil
+		return expression.Binary{Span: prefix.SourceSpan().Join(right.SourceSpan()), Left: prefix, Operator: op, Right: right}, nil
 	}
 
 	return prefix, nil
@@ -587,7 +857,7 @@ func (p *Parser) unary() (expression.Expr, error) {
 		if err != nil {
 			return expression.Unary{}, err
 		}
-		return expression.Unary{Operator: operator, Right: right}, nil
+		return expression.Unary{Span: operator.Span.Join(right.SourceSpan()), Operator: operator, Right: right}, nil
 	}
 
 	primary, err := p.call()
@@ -619,7 +889,12 @@ func (p *Parser) call() (expression.Expr, error) {
 			if err != nil {
 				return nil, err
 			}
-			expr = expression.Get{Object: expr, Name: name}
+			expr = expression.Get{Span: expr.SourceSpan().Join(name.Span), Object: expr, Name: name}
+		} else if p.match(scanner.LEFT_BRACKET) {
+			expr, err = p.subscript(expr)
+			if err != nil {
+				return nil, err
+			}
 		} else {
 			break
 		}
@@ -631,10 +906,10 @@ func (p *Parser) call() (expression.Expr, error) {
 func (p *Parser) add_args(expr expression.Expr) (expression.Expr, error) {
 	paren := p.previous()
 	if p.match(scanner.RIGHT_PAREN) {
-		return expression.NewCall(expr, paren, nil), nil
+		return expression.NewCall(expr.SourceSpan().Join(p.previous().Span), expr, paren, nil), nil
 	}
 
-	args, err := p.arguments()
+	args, named, err := p.arguments()
 	if err != nil {
 		return nil, err
 	}
@@ -644,56 +919,176 @@ func (p *Parser) add_args(expr expression.Expr) (expression.Expr, error) {
 		return nil, err
 	}
 
-	return expression.NewCall(expr, paren, args), nil // call expr with args, nil
+	call := expression.NewCall(expr.SourceSpan().Join(p.previous().Span), expr, paren, args) // call expr with args, nil
+	call.Named = named
+
+	return call, nil
 }
 
-func (p *Parser) arguments() ([]expression.Expr, error) {
+func (p *Parser) subscript(object expression.Expr) (expression.Expr, error) {
+	// subscript      → call "[" expression "]" ;
+	bracket := p.previous()
+	index, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.consume(scanner.RIGHT_BRACKET, "Expect ']' after index."); err != nil {
+		return nil, err
+	}
+
+	return expression.Subscript{Span: object.SourceSpan().Join(p.previous().Span), Object: object, Bracket: bracket, Index: index}, nil
+}
+
+func (p *Parser) list() (expression.Expr, error) {
+	// list           → "[" ( expression ( "," expression )* )? "]" ;
+	bracket := p.previous()
+	var elements []expression.Expr
+	if !p.check(scanner.RIGHT_BRACKET) {
+		for {
+			element, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			elements = append(elements, element)
+			if !p.match(scanner.COMMA) {
+				break
+			}
+		}
+	}
+	if _, err := p.consume(scanner.RIGHT_BRACKET, "Expect ']' after list elements."); err != nil {
+		return nil, err
+	}
+
+	return expression.List{Span: p.spanFrom(bracket), Bracket: bracket, Elements: elements}, nil
+}
+
+func (p *Parser) mapLiteral() (expression.Expr, error) {
+	// map            → "{" ( entry ( "," entry )* )? "}" ;
+	// entry          → expression ":" expression ;
+	brace := p.previous()
+	var keys, values []expression.Expr
+	if !p.check(scanner.RIGHT_BRACE) {
+		for {
+			key, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.consume(scanner.COLON, "Expect ':' after map key."); err != nil {
+				return nil, err
+			}
+			value, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			keys, values = append(keys, key), append(values, value)
+			if !p.match(scanner.COMMA) {
+				break
+			}
+		}
+	}
+	if _, err := p.consume(scanner.RIGHT_BRACE, "Expect '}' after map entries."); err != nil {
+		return nil, err
+	}
+
+	return expression.Map{Span: p.spanFrom(brace), Brace: brace, Keys: keys, Values: values}, nil
+}
+
+func (p *Parser) arguments() ([]expression.Expr, []expression.NamedArg, error) {
+	// arguments      → argument ( "," argument )* ;
+	// argument       → ( IDENTIFIER ":" )? expression ;
 	var args []expression.Expr
-	var err error
-	for cur_arg, err := p.expression(); err == nil; cur_arg, err = p.expression() {
-		if len(args) >= 255 {
+	var named []expression.NamedArg
+	for {
+		var name *scanner.Token
+		if p.check(scanner.IDENTIFIER) && p.checkNext(scanner.COLON) {
+			tok := p.advance()
+			p.advance()
+			name = &tok
+		} else if len(named) > 0 {
+			return nil, nil, p.error(p.peek(), "Positional arguments can't follow named arguments.")
+		}
+		cur_arg, err := p.expression()
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(args)+len(named) >= 255 {
 			p.error(p.peek(), "Can't have more than 255 argumens.")
 		}
-		args = append(args, cur_arg)
+		if name != nil {
+			named = append(named, expression.NamedArg{Name: *name, Value: cur_arg})
+		} else {
+			args = append(args, cur_arg)
+		}
 		if !p.match(scanner.COMMA) {
-			return args, nil
+			return args, named, nil
 		}
 	}
-	return nil, err
 }
 
 // primary        → NUMBER | STRING | "true" | "false" | "nil" | IDENTIFIER | (expression)
 //
-//	| "(" expression ")"
+//	| "(" expression ")" | list | map | lambda | arrow | interpolation
 func (p *Parser) primary() (expression.Expr, error) {
 	var err error
 	var expr expression.Expr
 	if p.match(scanner.FALSE) {
-		return expression.Literal{Value: false}, nil
+		return expression.Literal{Span: p.previous().Span, Value: false}, nil
 	}
 	if p.match(scanner.TRUE) {
-		return expression.Literal{Value: true}, nil
+		return expression.Literal{Span: p.previous().Span, Value: true}, nil
 	}
 	if p.match(scanner.NIL) {
-		return expression.Literal{Value: nil}, nil
+		return expression.Literal{Span: p.previous().Span, Value: nil}, nil
+	}
+	if p.match(scanner.INTERPOLATION) {
+		return p.interpolation()
 	}
 	if p.match(scanner.STRING, scanner.NUMBER) {
-		return expression.Literal{Value: p.previous().Literal}, nil
+		return expression.Literal{Span: p.previous().Span, Value: p.previous().Literal}, nil
+	}
+	if p.check(scanner.FUN) && p.checkNext(scanner.LEFT_PAREN) {
+		keyword := p.advance()
+		decl, err := p.functionBody(keyword, scanner.Token{})
+		if err != nil {
+			return nil, err
+		}
+
+		return expression.Lambda{
+			Span:     decl.Span,
+			Keyword:  keyword,
+			Params:   decl.Params,
+			Defaults: decl.Defaults,
+			Variadic: decl.Variadic,
+			Body:     decl.Body,
+		}, nil
+	}
+	if p.arrowAhead() {
+		return p.arrowFunction()
 	}
 	if p.match(scanner.LEFT_PAREN) {
+		paren := p.previous()
 		expr, err = p.expression()
+		if err != nil {
+			return expression.Unary{}, err
+		}
 		_, err = p.consume(scanner.RIGHT_PAREN, "Expected right paren!")
 		if err != nil {
 			return expression.Unary{}, err
 		}
 
-		return expression.Grouping{Expr: expr}, nil
+		return expression.Grouping{Span: p.spanFrom(paren), Expr: expr}, nil
+	}
+	if p.match(scanner.LEFT_BRACKET) {
+		return p.list()
+	}
+	if p.match(scanner.LEFT_BRACE) {
+		return p.mapLiteral()
 	}
 	if p.match(scanner.IDENTIFIER) {
 		return expression.NewVariableExpression(p.previous()), nil
 	}
 	if p.match(scanner.THIS) {
-		return expression.This{Keyword: p.previous()}, nil
+		return expression.This{Span: p.previous().Span, Keyword: p.previous()}, nil
 	}
     if p.match(scanner.SUPER) {
         keyword := p.previous()
@@ -706,11 +1101,124 @@ func (p *Parser) primary() (expression.Expr, error) {
             return nil, err
         }
 
-        return expression.Super{Keyword: keyword, Method: id}, nil
+        return expression.Super{Span: p.spanFrom(keyword), Keyword: keyword, Method: id}, nil
     }
-	parse_error := p.error(p.peek(), "Expect expression.")
 
-	return expression.Unary{}, &parse_error
+	return expression.Unary{}, p.error(p.peek(), "Expect expression.")
+}
+
+func (p *Parser) interpolation() (expression.Expr, error) {
+	// interpolation  → ( INTERPOLATION expression )+ STRING ;
+	start := p.previous()
+	parts := []scanner.Token{start}
+	var exprs []expression.Expr
+	for {
+		// The text after an interpolated expression starts with the "}"
+		// that ends it, which is all there is if the expression is missing.
+		if p.check(scanner.STRING) || p.check(scanner.INTERPOLATION) {
+			if p.peek().Lexeme[0] == '}' {
+				return nil, p.error(p.peek(), "Expect expression.")
+			}
+		}
+		expr, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+		if p.match(scanner.STRING) {
+			break
+		}
+		if !p.match(scanner.INTERPOLATION) {
+			return nil, p.error(p.peek(), "Expect '}' after interpolated expression.")
+		}
+		parts = append(parts, p.previous())
+	}
+	parts = append(parts, p.previous())
+
+	return expression.Interpolation{Span: p.spanFrom(start), Strings: parts, Exprs: exprs}, nil
+}
+
+// Whether the tokens ahead are the parameters of an arrow function: a name,
+// or a parenthesized list of parameters, followed by "=>".
+func (p Parser) arrowAhead() bool {
+	i := p.current
+	if p.typeAt(i) == scanner.IDENTIFIER {
+		return p.typeAt(i+1) == scanner.ARROW
+	}
+	if p.typeAt(i) != scanner.LEFT_PAREN {
+		return false
+	}
+	// Once a default value starts, any expression can follow, so only the
+	// brackets are tracked to find the closing paren.
+	depth, inDefault := 1, false
+	for i++; ; i++ {
+		t := p.typeAt(i)
+		switch {
+		case t == scanner.EOF:
+			return false
+		case t == scanner.RIGHT_PAREN && depth == 1:
+			return p.typeAt(i+1) == scanner.ARROW
+		case inDefault:
+			switch t {
+			case scanner.LEFT_PAREN, scanner.LEFT_BRACKET, scanner.LEFT_BRACE:
+				depth++
+			case scanner.RIGHT_PAREN, scanner.RIGHT_BRACKET, scanner.RIGHT_BRACE:
+				depth--
+			}
+		case t == scanner.EQUAL:
+			inDefault = true
+		case t == scanner.IDENTIFIER, t == scanner.COMMA, t == scanner.COLON, t == scanner.NIL, t == scanner.ELLIPSIS:
+			continue
+		default:
+			return false
+		}
+	}
+}
+
+// The type of the i'th token, or EOF past the end.
+func (p Parser) typeAt(i int) scanner.TokenType {
+	if i >= len(p.tokens) {
+		return scanner.EOF
+	}
+	return p.tokens[i].Token_type
+}
+
+func (p *Parser) arrowFunction() (expression.Expr, error) {
+	// lambda         → "fun" "(" parameters? ")" annotation? block ;
+	// arrow          → ( IDENTIFIER | "(" parameters? ")" ) "=>" expression ;
+	start := p.peek()
+	var fn statement.Function
+	if p.match(scanner.IDENTIFIER) {
+		fn.Params, fn.Defaults = []scanner.Token{p.previous()}, []expression.Expr{nil}
+	} else {
+		p.advance()
+		if !p.check(scanner.RIGHT_PAREN) {
+			if err := p.parameters(&fn); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := p.consume(scanner.RIGHT_PAREN, "expected ')'."); err != nil {
+			return nil, err
+		}
+	}
+	arrow, err := p.consume(scanner.ARROW, "Expect '=>' after parameters.")
+	if err != nil {
+		return nil, err
+	}
+	body, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+
+	return expression.Lambda{
+		Span:    p.spanFrom(start),
+		Keyword:  arrow,
+		Params:   fn.Params,
+		Defaults: fn.Defaults,
+		Variadic: fn.Variadic,
+		Body:     []statement.Statement{statement.Return{Span: body.SourceSpan(), Return_expr: body}},
+		Arrow:    true,
+	}, nil
 }
 
 // func (p *Parser) identifier() (expression.Expr, error)
@@ -724,7 +1232,7 @@ func (p *Parser) syncronize() {
 		}
 		t := p.peek().Token_type
 		switch t {
-		case scanner.CLASS, scanner.FUN, scanner.VAR, scanner.FOR, scanner.IF, scanner.WHILE, scanner.PRINT, scanner.RETURN:
+		case scanner.CLASS, scanner.FUN, scanner.VAR, scanner.FOR, scanner.IF, scanner.WHILE, scanner.PRINT, scanner.RETURN, scanner.IMPORT, scanner.EXPORT, scanner.THROW, scanner.TRY, scanner.BREAK, scanner.CONTINUE:
 			return
 		}
 
@@ -732,6 +1240,12 @@ func (p *Parser) syncronize() {
 	}
 }
 
+// The span from the start of token start to the end of the last token
+// consumed.
+func (p Parser) spanFrom(start scanner.Token) source.Span {
+	return start.Span.Join(p.previous().Span)
+}
+
 func (p *Parser) match(token_type ...scanner.TokenType) bool {
 	for _, v := range token_type {
 		if p.check(v) {
@@ -764,18 +1278,34 @@ func (p *Parser) consume(tokenType scanner.TokenType, message string) (scanner.T
 	if p.check(tokenType) {
 		return p.advance(), nil
 	}
-	error := p.error(p.peek(), message)
 
-	return scanner.Token{}, &error
+	return scanner.Token{}, p.error(p.peek(), message)
 }
 
-func (p Parser) error(token scanner.Token, message string) ParseError {
+// Report a syntax error at token. The caller decides whether to unwind to
+// the enclosing declaration by returning the error.
+func (p Parser) error(token scanner.Token, message string) *ParseError {
+	d := errorhandling.Diagnostic{
+		Phase:   errorhandling.Parsing,
+		Line:    token.Line,
+		Span:    token.Span,
+		Where:   " at '" + token.Lexeme + "'",
+		Message: message,
+	}
 	if token.Token_type == scanner.EOF {
-		errorhandling.Report(token.Line, " at end ", message)
-	} else {
-		errorhandling.Report(token.Line, " at "+token.Lexeme, message)
+		d.Where = " at end"
+	}
+	p.reporter.Report(d)
+
+	return &ParseError{error: d.String()}
+}
+
+// Whether the token after the current one has the given type.
+func (p Parser) checkNext(token_type scanner.TokenType) bool {
+	if p.current+1 >= len(p.tokens) {
+		return false
 	}
-	return NewParseError(message)
+	return p.tokens[p.current+1].Token_type == token_type
 }
 
 func (p Parser) peek() scanner.Token {
@@ -797,7 +1327,3 @@ type ParseError struct {
 func (e ParseError) Error() string {
 	return e.error
 }
-
-func NewParseError(message string) ParseError {
-	return ParseError{error: "Parser Error: " + message}
-}
@@ -0,0 +1,30 @@
+package scanner_test
+
+import (
+	"golox/errorhandling"
+	"golox/scanner"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func FuzzScanTokens(f *testing.F) {
+	files, err := filepath.Glob("../*.lox")
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, file := range files {
+		source, err := os.ReadFile(file)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(string(source))
+	}
+
+	f.Fuzz(func(t *testing.T, source string) {
+		toks := scanner.NewScanner(source, &errorhandling.CollectingReporter{}).ScanTokens()
+		if len(toks) == 0 || toks[len(toks)-1].Token_type != scanner.EOF {
+			t.Fatalf("expected the tokens to end with EOF, got %v", toks)
+		}
+	})
+}
@@ -1,19 +1,51 @@
 package scanner
 
 import (
+	"golox/decimal"
 	"golox/errorhandling"
+	"golox/source"
+	"math/big"
 	"strconv"
+	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 type Scanner struct {
 	source               string
 	tokens               []Token
 	start, current, line int
+	// The offset at which the current line begins, and the line and column
+	// at which the token being scanned starts.
+	lineStart, startLine, column int
+	reporter                     errorhandling.ErrorReporter
+	// The interpolated expressions being scanned, innermost last.
+	interpolations []interpolation
+	// Errors in the text of the string literal being scanned, which are
+	// reported once its token is added.
+	stringErrors []errorhandling.Diagnostic
 }
 
-func NewScanner(source string) *Scanner {
-	ret := Scanner{source: source, tokens: []Token{}, start: 0, current: 0, line: 1}
+// How the text of a string literal is scanned.
+type stringLiteral struct {
+	// Whether escape sequences and interpolations are left as they are.
+	raw bool
+	// Whether the string is triple-quoted, and the indentation stripped from
+	// each of its lines.
+	multiline bool
+	indent    string
+}
+
+// An interpolated expression being scanned.
+type interpolation struct {
+	// The string the expression is in.
+	str stringLiteral
+	// The number of braces opened in the expression and not yet closed.
+	braces int
+}
+
+func NewScanner(source string, reporter errorhandling.ErrorReporter) *Scanner {
+	ret := Scanner{source: source, tokens: []Token{}, start: 0, current: 0, line: 1, reporter: reporter}
 
 	return &ret
 }
@@ -21,6 +53,8 @@ func NewScanner(source string) *Scanner {
 func (s *Scanner) ScanTokens() []Token {
 	for !s.isAtEnd() {
 		s.start = s.current
+		s.startLine = s.line
+		s.column = s.current - s.lineStart + 1
 		s.scanToken()
 	}
 	s.tokens = append(
@@ -30,6 +64,7 @@ func (s *Scanner) ScanTokens() []Token {
 			Lexeme:     "",
 			Literal:    nil,
 			Line:       s.line,
+			Span:       source.Span{Start: s.position(), End: s.position()},
 		},
 	)
 
@@ -47,16 +82,48 @@ func (s *Scanner) scanToken() {
 		s.addToken(RIGHT_PAREN)
 
 	case '{':
+		if n := len(s.interpolations); n > 0 {
+			s.interpolations[n-1].braces++
+		}
 		s.addToken(LEFT_BRACE)
 
 	case '}':
+		n := len(s.interpolations)
+		if n > 0 && s.interpolations[n-1].braces == 0 {
+			// The end of an interpolated expression, and the rest of the
+			// string it is in.
+			str := s.interpolations[n-1].str
+			s.interpolations = s.interpolations[:n-1]
+			s.tokenize_string(str)
+			break
+		}
+		if n > 0 {
+			s.interpolations[n-1].braces--
+		}
 		s.addToken(RIGHT_BRACE)
 
+	case '[':
+		s.addToken(LEFT_BRACKET)
+
+	case ']':
+		s.addToken(RIGHT_BRACKET)
+
 	case ',':
 		s.addToken(COMMA)
 
+	case ':':
+		s.addToken(COLON)
+
 	case '.':
-		s.addToken(DOT)
+		if s.match('.') {
+			t = DOT_DOT
+			if s.match('.') {
+				t = ELLIPSIS
+			}
+		} else {
+			t = DOT
+		}
+		s.addToken(t)
 
 	case '-':
 		s.addToken(MINUS)
@@ -70,6 +137,9 @@ func (s *Scanner) scanToken() {
 	case '*':
 		s.addToken(STAR)
 
+	case '%':
+		s.addToken(PERCENT)
+
 	case '!':
 		if s.match('=') {
 			t = BANG_EQUAL
@@ -81,6 +151,8 @@ func (s *Scanner) scanToken() {
 	case '=':
 		if s.match('=') {
 			t = EQUAL_EQUAL
+		} else if s.match('>') {
+			t = ARROW
 		} else {
 			t = EQUAL
 		}
@@ -115,28 +187,64 @@ func (s *Scanner) scanToken() {
 	case '\r':
 	case '\t':
 	case '\n':
-		s.line += 1
+		s.newLine()
 	case '"':
-		s.tokenize_string()
+		s.tokenize_string(s.openString(false))
 
 	default:
-		if unicode.IsDigit(c) {
+		if c == 'r' && s.peek() == '"' {
+			s.advance()
+			s.tokenize_string(s.openString(true))
+		} else if unicode.IsDigit(c) {
 			s.tokenize_number()
 		} else if unicode.IsLetter(c) || c == '_' {
 			s.tokenize_identifier()
 		} else {
-			errorhandling.Report(s.line, s.source[s.start:s.current], "Unexpected character.")
+			s.error("Unexpected character.")
 		}
 	}
 }
 
+// Report an error in the token being scanned. Errors are reported on the
+// line the token starts on, so an unterminated string is reported where its
+// opening quote is rather than at the end of the file.
+func (s *Scanner) error(message string) {
+	s.reporter.Report(errorhandling.Diagnostic{
+		Phase:   errorhandling.Scanning,
+		Line:    s.startLine,
+		Span:    s.span(),
+		Message: message,
+	})
+}
+
+// Record an error in the text of a string literal, from offset start on the
+// current line to the next character.
+func (s *Scanner) stringError(start int, message string) {
+	s.stringErrors = append(s.stringErrors, errorhandling.Diagnostic{
+		Phase: errorhandling.Scanning,
+		Line:  s.line,
+		Span: source.Span{
+			Start: source.Position{Line: s.line, Column: start - s.lineStart + 1, Offset: start},
+			End:   s.position(),
+		},
+		Message: message,
+	})
+}
+
+func (s *Scanner) reportStringErrors() {
+	for _, d := range s.stringErrors {
+		s.reporter.Report(d)
+	}
+	s.stringErrors = nil
+}
+
 func (s *Scanner) addToken(t TokenType) {
 	s.addTokenLiteral(t, nil)
 }
 
 func (s *Scanner) addTokenLiteral(t TokenType, literal any) {
 	text := s.source[s.start:s.current]
-	s.tokens = append(s.tokens, Token{Token_type: t, Lexeme: text, Literal: literal, Line: s.line})
+	s.tokens = append(s.tokens, Token{Token_type: t, Lexeme: text, Literal: literal, Line: s.line, Span: s.span()})
 }
 
 func (s *Scanner) tokenize_identifier() {
@@ -161,43 +269,245 @@ func (s *Scanner) tokenize_number() {
 	for unicode.IsDigit(s.peek()) {
 		s.advance()
 	}
-
-	if s.peek() == '.' && unicode.IsDigit(s.peekNext()) {
+	fractional := s.peek() == '.' && unicode.IsDigit(s.peekNext())
+	if fractional {
 		s.advance()
 		for unicode.IsDigit(s.peek()) {
 			s.advance()
 		}
 	}
-
 	new_string = s.source[s.start:s.current]
+
+	// An n after the digits makes a bigint and a d a decimal, both of which
+	// are exact however many digits there are.
+	switch s.numberSuffix() {
+	case 'n':
+		if fractional {
+			s.error("A bigint literal can't have a fractional part.")
+			return
+		}
+		num, _ := new(big.Int).SetString(new_string, 10)
+		s.addTokenLiteral(NUMBER, num)
+		return
+	case 'd':
+		num, _ := decimal.Parse(new_string)
+		s.addTokenLiteral(NUMBER, num)
+		return
+	}
+
+	// Otherwise a number with a fractional part is a float, and one without
+	// is an integer.
+	if !fractional {
+		num, err := strconv.ParseInt(new_string, 10, 64)
+		if err != nil {
+			s.error("Number literal is out of range.")
+			return
+		}
+		s.addTokenLiteral(NUMBER, num)
+		return
+	}
 	num, err := strconv.ParseFloat(new_string, 64)
 	if err != nil {
-		panic("Not a number!")
+		s.error("Number literal is out of range.")
+		return
 	}
 
 	s.addTokenLiteral(NUMBER, num)
 }
 
-func (s *Scanner) tokenize_string() {
-	var new_string string
+// Scan the suffix of a number literal, n or d, and report which it was, or
+// 0 if there isn't one. A letter that starts a longer identifier isn't one.
+func (s *Scanner) numberSuffix() rune {
+	c := s.peek()
+	if c != 'n' && c != 'd' {
+		return 0
+	}
+	if next := s.peekNext(); unicode.IsDigit(next) || unicode.IsLetter(next) || next == '_' {
+		return 0
+	}
+	s.advance()
+
+	return c
+}
+
+// Scan the rest of the quotes that open a string literal, and work out how
+// its text is scanned. A raw string's "r" and first quote have been scanned.
+func (s *Scanner) openString(raw bool) stringLiteral {
+	str := stringLiteral{raw: raw}
+	if s.peek() != '"' || s.peekNext() != '"' {
+		return str
+	}
+	s.advance()
+	s.advance()
+	str.multiline = true
+	str.indent = s.closingIndent(raw)
+	// A line break straight after the opening quotes isn't part of the
+	// string.
+	if s.match('\n') {
+		s.newLine()
+		s.skipIndent(str)
+	}
+
+	return str
+}
 
-	for s.peek() != '"' && !s.isAtEnd() {
-		if s.peek() == '\n' {
-			s.line += 1
+// The indentation of the closing quotes of the multi-line string being
+// scanned, or "" unless they are on a line of their own. They are taken to
+// be the first unescaped """, so a multi-line string nested in an
+// interpolated expression ends the search early.
+func (s Scanner) closingIndent(raw bool) string {
+	end := -1
+	for i := s.current; i < len(s.source); i++ {
+		if s.source[i] == '\\' && !raw {
+			i++
+		} else if strings.HasPrefix(s.source[i:], `"""`) {
+			end = i
+			break
 		}
+	}
+	if end < 0 {
+		return ""
+	}
+	lineBreak := strings.LastIndexByte(s.source[s.current:end], '\n')
+	if lineBreak < 0 {
+		return ""
+	}
+	indent := s.source[s.current+lineBreak+1 : end]
+	if strings.Trim(indent, " \t") != "" {
+		return ""
+	}
+
+	return indent
+}
+
+// Skip the indentation at the start of a line of a multi-line string. Lines
+// with nothing but whitespace on them needn't be indented.
+func (s *Scanner) skipIndent(str stringLiteral) {
+	rest := s.source[s.current:]
+	if strings.HasPrefix(rest, str.indent) {
+		s.current += len(str.indent)
+		return
+	}
+	line := rest
+	if i := strings.IndexByte(rest, '\n'); i >= 0 {
+		line = rest[:i]
+	}
+	if strings.TrimLeft(line, " \t\r") == "" {
+		s.current += len(line)
+		return
+	}
+
+	start := s.current
+	for s.peek() == ' ' || s.peek() == '\t' {
 		s.advance()
 	}
+	s.stringError(start, "Line is indented less than the closing quotes.")
+}
+
+// Scan the text of a string literal, or the rest of it after an interpolated
+// expression. The part before a "${" is an INTERPOLATION token, which the
+// tokens of the expression follow.
+func (s *Scanner) tokenize_string(str stringLiteral) {
+	var value strings.Builder
+	closing := `"`
+	if str.multiline {
+		closing = `"""`
+	}
+
+	for !strings.HasPrefix(s.source[s.current:], closing) && !s.isAtEnd() {
+		c := s.advance()
+		switch {
+		case c == '\n':
+			s.newLine()
+			if !str.multiline {
+				break
+			}
+			// Neither is the line break before closing quotes on a line of
+			// their own.
+			if strings.HasPrefix(s.source[s.current:], str.indent+closing) {
+				s.current += len(str.indent)
+				continue
+			}
+			value.WriteByte('\n')
+			s.skipIndent(str)
+			continue
+		case c == '\\' && !str.raw:
+			s.escape(&value)
+			continue
+		case c == '$' && !str.raw && s.match('{'):
+			s.interpolations = append(s.interpolations, interpolation{str: str})
+			new_string := value.String()
+			s.addTokenLiteral(INTERPOLATION, &new_string)
+			s.reportStringErrors()
+			return
+		}
+		value.WriteByte(byte(c))
+	}
 
 	if s.isAtEnd() {
-		errorhandling.Report(s.line, s.source[s.start:s.current], "Unterminated string.")
+		// Errors in the text of a string with no end would only add noise.
+		s.stringErrors = nil
+		s.error("Unterminated string.")
 		return
 	}
 
-	s.advance()
-	new_string = s.source[s.start+1 : s.current-1]
+	s.current += len(closing)
+	new_string := value.String()
 
 	s.addTokenLiteral(STRING, &new_string)
+	s.reportStringErrors()
+}
 
+// Scan the escape sequence after a backslash into value.
+func (s *Scanner) escape(value *strings.Builder) {
+	start := s.current - 1
+	switch c := s.peek(); c {
+	case 'n':
+		value.WriteByte('\n')
+	case 't':
+		value.WriteByte('\t')
+	case 'r':
+		value.WriteByte('\r')
+	case '\\', '"', '$':
+		value.WriteByte(byte(c))
+	case 'u':
+		s.advance()
+		s.unicodeEscape(value, start)
+		return
+	default:
+		// A line break is left for the string to scan.
+		if c != '\n' && !s.isAtEnd() {
+			_, size := utf8.DecodeRuneInString(s.source[s.current:])
+			s.current += size
+		}
+		s.stringError(start, "Invalid escape sequence.")
+		return
+	}
+	s.advance()
+}
+
+// Scan the rest of a "\u{...}" escape sequence, which names a Unicode code
+// point with one to six hex digits.
+func (s *Scanner) unicodeEscape(value *strings.Builder, start int) {
+	if !s.match('{') {
+		s.stringError(start, "Invalid Unicode escape sequence.")
+		return
+	}
+	digits := s.current
+	for unicode.Is(unicode.ASCII_Hex_Digit, s.peek()) {
+		s.advance()
+	}
+	hex := s.source[digits:s.current]
+	if !s.match('}') || len(hex) == 0 || len(hex) > 6 {
+		s.stringError(start, "Invalid Unicode escape sequence.")
+		return
+	}
+	r, _ := strconv.ParseUint(hex, 16, 32)
+	if !utf8.ValidRune(rune(r)) {
+		s.stringError(start, "Invalid Unicode escape sequence.")
+		return
+	}
+	value.WriteRune(rune(r))
 }
 
 func (s *Scanner) match(expected rune) bool {
@@ -220,6 +530,29 @@ func (s *Scanner) advance() rune {
 	return rune(r)
 }
 
+// The position of the next character to be scanned.
+func (s Scanner) position() source.Position {
+	return source.Position{Line: s.line, Column: s.current - s.lineStart + 1, Offset: s.current}
+}
+
+// The span of the token being scanned.
+func (s Scanner) span() source.Span {
+	return source.Span{
+		Start: source.Position{Line: s.startLine, Column: s.column, Offset: s.start},
+		End:   s.position(),
+	}
+}
+
+func (s Scanner) previous() rune {
+	return rune(s.source[s.current-1])
+}
+
+// Called after consuming a newline.
+func (s *Scanner) newLine() {
+	s.line += 1
+	s.lineStart = s.current
+}
+
 func (s Scanner) isAtEnd() bool {
 	return s.current >= len(s.source)
 }
@@ -1,5 +1,7 @@
 package scanner
 
+import "golox/source"
+
 type TokenType int
 
 //go:generate stringer -type=TokenType
@@ -10,13 +12,17 @@ const (
 	RIGHT_PAREN
 	LEFT_BRACE
 	RIGHT_BRACE
+	LEFT_BRACKET
+	RIGHT_BRACKET
 	COMMA
+	COLON
 	DOT
 	MINUS
 	PLUS
 	SEMICOLON
 	SLASH
 	STAR
+	PERCENT
 
 	// One or two character tokens
 	BANG
@@ -27,27 +33,41 @@ const (
 	GREATER_EQUAL
 	LESS
 	LESS_EQUAL
+	DOT_DOT
+	ARROW
+	ELLIPSIS
 
 	// Literals
 	IDENTIFIER
 	STRING
+	// The part of a string literal before an interpolated expression.
+	INTERPOLATION
 	NUMBER
 
 	// Keywords
 	AND
+	BREAK
+	CATCH
 	CLASS
+	CONTINUE
 	ELSE
+	EXPORT
 	FALSE
+	FINALLY
 	FOR
 	FUN
 	IF
+	IMPORT
+	IN
 	NIL
 	OR
 	PRINT
 	RETURN
 	SUPER
 	THIS
+	THROW
 	TRUE
+	TRY
 	VAR
 	WHILE
 
@@ -63,23 +83,33 @@ type Token struct {
 	Lexeme     string
 	Literal    any
 	Line       int
+	source.Span
 }
 
 var KeywordMap = map[string]TokenType{
-	"and":    AND,
-	"class":  CLASS,
-	"else":   ELSE,
-	"false":  FALSE,
-	"fun":    FUN,
-	"for":    FOR,
-	"if":     IF,
-	"nil":    NIL,
-	"or":     OR,
-	"print":  PRINT,
-	"return": RETURN,
-	"super":  SUPER,
-	"this":   THIS,
-	"true":   TRUE,
-	"var":    VAR,
-	"while":  WHILE,
+	"and":      AND,
+	"break":    BREAK,
+	"catch":    CATCH,
+	"class":    CLASS,
+	"continue": CONTINUE,
+	"else":     ELSE,
+	"export":   EXPORT,
+	"false":    FALSE,
+	"finally":  FINALLY,
+	"fun":      FUN,
+	"for":      FOR,
+	"if":       IF,
+	"import":   IMPORT,
+	"in":       IN,
+	"nil":      NIL,
+	"or":       OR,
+	"print":    PRINT,
+	"return":   RETURN,
+	"super":    SUPER,
+	"this":     THIS,
+	"throw":    THROW,
+	"true":     TRUE,
+	"try":      TRY,
+	"var":      VAR,
+	"while":    WHILE,
 }
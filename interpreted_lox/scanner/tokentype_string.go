@@ -12,46 +12,63 @@ func _() {
 	_ = x[RIGHT_PAREN-1]
 	_ = x[LEFT_BRACE-2]
 	_ = x[RIGHT_BRACE-3]
-	_ = x[COMMA-4]
-	_ = x[DOT-5]
-	_ = x[MINUS-6]
-	_ = x[PLUS-7]
-	_ = x[SEMICOLON-8]
-	_ = x[SLASH-9]
-	_ = x[STAR-10]
-	_ = x[BANG-11]
-	_ = x[BANG_EQUAL-12]
-	_ = x[EQUAL-13]
-	_ = x[EQUAL_EQUAL-14]
-	_ = x[GREATER-15]
-	_ = x[GREATER_EQUAL-16]
-	_ = x[LESS-17]
-	_ = x[LESS_EQUAL-18]
-	_ = x[IDENTIFIER-19]
-	_ = x[STRING-20]
-	_ = x[NUMBER-21]
-	_ = x[AND-22]
-	_ = x[CLASS-23]
-	_ = x[ELSE-24]
-	_ = x[FALSE-25]
-	_ = x[FOR-26]
-	_ = x[FUN-27]
-	_ = x[IF-28]
-	_ = x[NIL-29]
-	_ = x[OR-30]
-	_ = x[PRINT-31]
-	_ = x[RETURN-32]
-	_ = x[SUPER-33]
-	_ = x[THIS-34]
-	_ = x[TRUE-35]
-	_ = x[VAR-36]
-	_ = x[WHILE-37]
-	_ = x[EOF-38]
+	_ = x[LEFT_BRACKET-4]
+	_ = x[RIGHT_BRACKET-5]
+	_ = x[COMMA-6]
+	_ = x[COLON-7]
+	_ = x[DOT-8]
+	_ = x[MINUS-9]
+	_ = x[PLUS-10]
+	_ = x[SEMICOLON-11]
+	_ = x[SLASH-12]
+	_ = x[STAR-13]
+	_ = x[PERCENT-14]
+	_ = x[BANG-15]
+	_ = x[BANG_EQUAL-16]
+	_ = x[EQUAL-17]
+	_ = x[EQUAL_EQUAL-18]
+	_ = x[GREATER-19]
+	_ = x[GREATER_EQUAL-20]
+	_ = x[LESS-21]
+	_ = x[LESS_EQUAL-22]
+	_ = x[DOT_DOT-23]
+	_ = x[ARROW-24]
+	_ = x[ELLIPSIS-25]
+	_ = x[IDENTIFIER-26]
+	_ = x[STRING-27]
+	_ = x[INTERPOLATION-28]
+	_ = x[NUMBER-29]
+	_ = x[AND-30]
+	_ = x[BREAK-31]
+	_ = x[CATCH-32]
+	_ = x[CLASS-33]
+	_ = x[CONTINUE-34]
+	_ = x[ELSE-35]
+	_ = x[EXPORT-36]
+	_ = x[FALSE-37]
+	_ = x[FINALLY-38]
+	_ = x[FOR-39]
+	_ = x[FUN-40]
+	_ = x[IF-41]
+	_ = x[IMPORT-42]
+	_ = x[IN-43]
+	_ = x[NIL-44]
+	_ = x[OR-45]
+	_ = x[PRINT-46]
+	_ = x[RETURN-47]
+	_ = x[SUPER-48]
+	_ = x[THIS-49]
+	_ = x[THROW-50]
+	_ = x[TRUE-51]
+	_ = x[TRY-52]
+	_ = x[VAR-53]
+	_ = x[WHILE-54]
+	_ = x[EOF-55]
 }
 
-const _TokenType_name = "LEFT_PARENRIGHT_PARENLEFT_BRACERIGHT_BRACECOMMADOTMINUSPLUSSEMICOLONSLASHSTARBANGBANG_EQUALEQUALEQUAL_EQUALGREATERGREATER_EQUALLESSLESS_EQUALIDENTIFIERSTRINGNUMBERANDCLASSELSEFALSEFORFUNIFNILORPRINTRETURNSUPERTHISTRUEVARWHILEEOF"
+const _TokenType_name = "LEFT_PARENRIGHT_PARENLEFT_BRACERIGHT_BRACELEFT_BRACKETRIGHT_BRACKETCOMMACOLONDOTMINUSPLUSSEMICOLONSLASHSTARPERCENTBANGBANG_EQUALEQUALEQUAL_EQUALGREATERGREATER_EQUALLESSLESS_EQUALDOT_DOTARROWELLIPSISIDENTIFIERSTRINGINTERPOLATIONNUMBERANDBREAKCATCHCLASSCONTINUEELSEEXPORTFALSEFINALLYFORFUNIFIMPORTINNILORPRINTRETURNSUPERTHISTHROWTRUETRYVARWHILEEOF"
 
-var _TokenType_index = [...]uint8{0, 10, 21, 31, 42, 47, 50, 55, 59, 68, 73, 77, 81, 91, 96, 107, 114, 127, 131, 141, 151, 157, 163, 166, 171, 175, 180, 183, 186, 188, 191, 193, 198, 204, 209, 213, 217, 220, 225, 228}
+var _TokenType_index = [...]uint16{0, 10, 21, 31, 42, 54, 67, 72, 77, 80, 85, 89, 98, 103, 107, 114, 118, 128, 133, 144, 151, 164, 168, 178, 185, 190, 198, 208, 214, 227, 233, 236, 241, 246, 251, 259, 263, 269, 274, 281, 284, 287, 289, 295, 297, 300, 302, 307, 313, 318, 322, 327, 331, 334, 337, 342, 345}
 
 func (i TokenType) String() string {
 	if i < 0 || i >= TokenType(len(_TokenType_index)-1) {
@@ -0,0 +1,103 @@
+// Package source locates tokens and syntax tree nodes in the program text
+// and renders diagnostics that point at them.
+//
+// golox and lox-compiler each have a copy of this package. They are separate
+// modules, each built and run on its own, and neither depends on the other,
+// so there is nowhere shared for it to live. The copies are kept identical:
+// a change to one must be made to the other, along with its tests.
+package source
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A location in the program text. Lines and columns count from 1 and columns
+// count bytes; Offset is the byte offset from the start of the text.
+type Position struct {
+	Line, Column, Offset int
+}
+
+// The half-open range [Start, End) of program text covered by a token or a
+// syntax tree node.
+type Span struct {
+	Start, End Position
+}
+
+// Tokens and syntax tree nodes embed a Span, which makes this part of their
+// method set.
+func (s Span) SourceSpan() Span {
+	return s
+}
+
+func (s Span) IsZero() bool {
+	return s == Span{}
+}
+
+// The smallest span covering both s and other. A zero span is ignored.
+func (s Span) Join(other Span) Span {
+	if s.IsZero() {
+		return other
+	}
+	if other.IsZero() {
+		return s
+	}
+	if other.Start.Offset < s.Start.Offset {
+		s.Start = other.Start
+	}
+	if other.End.Offset > s.End.Offset {
+		s.End = other.End
+	}
+
+	return s
+}
+
+// Render the line of src on which span starts, with a caret underline
+// beneath the span:
+//
+//	   2 | var 1 = 2;
+//	     |     ^
+//
+// A span reaching past the end of its first line is underlined to the end
+// of that line, and an empty span gets a single caret. Render returns an
+// empty string if the span doesn't fall inside src.
+func Render(src string, span Span) string {
+	if span.IsZero() || span.Start.Offset > len(src) {
+		return ""
+	}
+	lineStart := span.Start.Offset - (span.Start.Column - 1)
+	if lineStart < 0 || lineStart > span.Start.Offset {
+		return ""
+	}
+	lineEnd := strings.IndexByte(src[lineStart:], '\n')
+	if lineEnd < 0 {
+		lineEnd = len(src)
+	} else {
+		lineEnd += lineStart
+	}
+	line := strings.TrimSuffix(src[lineStart:lineEnd], "\r")
+
+	// Copy tabs from the source line into the padding so the carets line up
+	// however the terminal expands them.
+	pad := strings.Builder{}
+	for _, c := range []byte(line[:min(span.Start.Offset-lineStart, len(line))]) {
+		if c == '\t' {
+			pad.WriteByte('\t')
+		} else {
+			pad.WriteByte(' ')
+		}
+	}
+	width := min(span.End.Offset, lineStart+len(line)) - span.Start.Offset
+	if width < 1 {
+		width = 1
+	}
+
+	gutter := fmt.Sprintf("%4d | ", span.Start.Line)
+	return fmt.Sprintf("%s%s\n%s| %s%s\n",
+		gutter,
+		line,
+		strings.Repeat(" ", len(gutter)-2),
+		pad.String(),
+		strings.Repeat("^", width),
+	)
+}
@@ -3,25 +3,36 @@ package statement
 import (
 	"golox/expression"
 	"golox/scanner"
+	"golox/source"
 )
 
 type StatementVisitor interface {
 	VisitBlockStmt(stmt Block)
+	VisitBreakStmt(stmt Break)
 	VisitClassStmt(stmt Class)
+	VisitContinueStmt(stmt Continue)
+	VisitExportStmt(stmt Export)
 	VisitExpressionStmt(stmt Expression)
+	VisitForInStmt(stmt ForIn)
 	VisitFunctionStmt(stmt Function)
 	VisitIfStmt(stmt If)
+	VisitImportStmt(stmt Import)
 	VisitPrintStmt(stmt Print)
 	VisitReturnStmt(stmt Return)
+	VisitThrowStmt(stmt Throw)
+	VisitTryStmt(stmt Try)
 	VisitVarStmt(stmt Var)
 	VisitWhileStmt(stmt While)
 }
 
+// Every statement embeds the source.Span of the text it was parsed from.
 type Statement interface {
 	Accept(StatementVisitor)
+	SourceSpan() source.Span
 }
 
 type Block struct {
+	source.Span
 	statements []Statement
 }
 
@@ -29,8 +40,8 @@ func (s Block) Accept(v StatementVisitor) {
 	v.VisitBlockStmt(s)
 }
 
-func NewBlockStmt(statments []Statement) Block {
-	return Block{statements: statments}
+func NewBlockStmt(span source.Span, statments []Statement) Block {
+	return Block{Span: span, statements: statments}
 }
 
 func (s Block) GetStatements() []Statement {
@@ -38,21 +49,68 @@ func (s Block) GetStatements() []Statement {
 }
 
 type Class struct {
+	source.Span
     Name scanner.Token
+    Fields []Field
     Methods []Function
     ParentClass *expression.Variable
 }
 
+// A field declared with its type in a class body, such as "x: number;".
+type Field struct {
+	source.Span
+	Name scanner.Token
+	Type *TypeAnnotation
+}
+
+// An optional type annotation, such as the ": number" in "var x: number".
+// The interpreter ignores annotations.
+type TypeAnnotation struct {
+	source.Span
+	Name scanner.Token
+}
+
 func (s Class) Accept(v StatementVisitor) {
     v.VisitClassStmt(s)
 }
 
+// A top-level declaration that the module makes available to the modules
+// that import it.
+type Break struct {
+	source.Span
+	Keyword scanner.Token
+}
+
+func (s Break) Accept(v StatementVisitor) {
+	v.VisitBreakStmt(s)
+}
+
+type Continue struct {
+	source.Span
+	Keyword scanner.Token
+}
+
+func (s Continue) Accept(v StatementVisitor) {
+	v.VisitContinueStmt(s)
+}
+
+type Export struct {
+	source.Span
+	// A Var, Function or Class.
+	Declaration Statement
+}
+
+func (s Export) Accept(v StatementVisitor) {
+	v.VisitExportStmt(s)
+}
+
 type Expression struct {
+	source.Span
 	Val expression.Expr
 }
 
-func NewExpressionStmt(val expression.Expr) Expression {
-	return Expression{Val: val}
+func NewExpressionStmt(span source.Span, val expression.Expr) Expression {
+	return Expression{Span: span, Val: val}
 }
 
 func (s Expression) Accept(v StatementVisitor) {
@@ -60,8 +118,17 @@ func (s Expression) Accept(v StatementVisitor) {
 }
 
 type Function struct {
+	source.Span
     Name scanner.Token
     Params []scanner.Token
+    // The annotations on the parameters, one for each, nil where there is
+    // none.
+    ParamTypes []*TypeAnnotation
+    // The default value of each parameter, nil where there is none.
+    Defaults []expression.Expr
+    // Whether the last parameter collects the rest of the arguments.
+    Variadic bool
+    ReturnType *TypeAnnotation
     Body []Statement
 }
 
@@ -70,25 +137,41 @@ func (s Function) Accept(v StatementVisitor) {
 }
 
 type If struct {
+	source.Span
 	Conditional expression.Expr
 	If_stmt     Statement
 	Else_stmt   Statement
 }
 
-func NewIfStatement(conitional expression.Expr, if_stmt, else_stmt Statement) If {
-	return If{Conditional: conitional, If_stmt: if_stmt, Else_stmt: else_stmt}
+func NewIfStatement(span source.Span, conitional expression.Expr, if_stmt, else_stmt Statement) If {
+	return If{Span: span, Conditional: conitional, If_stmt: if_stmt, Else_stmt: else_stmt}
 }
 
 func (s If) Accept(v StatementVisitor) {
 	v.VisitIfStmt(s)
 }
 
+// Runs the module at Path, unless it has already been run, and either
+// defines each of its exports as a global or, when Name isn't nil, defines
+// a single global holding the module.
+type Import struct {
+	source.Span
+	Name *scanner.Token
+	// The STRING token naming the module.
+	Path scanner.Token
+}
+
+func (s Import) Accept(v StatementVisitor) {
+	v.VisitImportStmt(s)
+}
+
 type Print struct {
+	source.Span
 	Val expression.Expr
 }
 
-func NewPrintStmt(val expression.Expr) Print {
-	return Print{Val: val}
+func NewPrintStmt(span source.Span, val expression.Expr) Print {
+	return Print{Span: span, Val: val}
 }
 
 func (s Print) Accept(v StatementVisitor) {
@@ -96,6 +179,7 @@ func (s Print) Accept(v StatementVisitor) {
 }
 
 type Return struct {
+	source.Span
     Return_expr expression.Expr
 }
 
@@ -103,26 +187,72 @@ func (s Return) Accept(v StatementVisitor) {
     v.VisitReturnStmt(s)
 }
 
+type Throw struct {
+	source.Span
+	Keyword scanner.Token
+	Value   expression.Expr
+}
+
+func (s Throw) Accept(v StatementVisitor) {
+	v.VisitThrowStmt(s)
+}
+
+// A try statement has a catch clause, a finally clause, or both. CatchName
+// is nil when there is no catch clause, and Finally is nil when there is no
+// finally clause.
+type Try struct {
+	source.Span
+	Body      Block
+	CatchName *scanner.Token
+	Catch     *Block
+	Finally   *Block
+}
+
+func (s Try) Accept(v StatementVisitor) {
+	v.VisitTryStmt(s)
+}
+
 type Var struct {
+	source.Span
 	Initializer expression.Expr
 	Name        scanner.Token
+	Type        *TypeAnnotation
 }
 
-func NewVarStmt(name scanner.Token, initializer expression.Expr) Var {
-	return Var{Initializer: initializer, Name: name}
+func NewVarStmt(span source.Span, name scanner.Token, initializer expression.Expr, annotation *TypeAnnotation) Var {
+	return Var{Span: span, Initializer: initializer, Name: name, Type: annotation}
 }
 
 func (s Var) Accept(v StatementVisitor) {
 	v.VisitVarStmt(s)
 }
 
+// A loop over the elements of a list, the keys of a map, the characters of a
+// string, the numbers of a range or the values of an iterator, such as
+// "for (var x in xs) print x;".
+type ForIn struct {
+	source.Span
+	Name     scanner.Token
+	Iterable expression.Expr
+	Stmt     Statement
+}
+
+func (s ForIn) Accept(v StatementVisitor) {
+	v.VisitForInStmt(s)
+}
+
 type While struct {
+	source.Span
 	Conditional expression.Expr
 	Stmt        Statement
+	// The increment of the for loop the while loop was desugared from,
+	// which runs after each iteration, even one that continues. Nil for a
+	// while loop.
+	Increment expression.Expr
 }
 
-func NewWhileStmt(conditional expression.Expr, stmt Statement) While {
-	return While{Conditional: conditional, Stmt: stmt}
+func NewWhileStmt(span source.Span, conditional expression.Expr, stmt Statement) While {
+	return While{Span: span, Conditional: conditional, Stmt: stmt}
 }
 
 func (s While) Accept(v StatementVisitor) {
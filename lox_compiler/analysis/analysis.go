@@ -0,0 +1,407 @@
+// Package analysis works out what a program declares and where each
+// declaration is used, for editor tooling. It runs the same scanner, parser
+// and compiler as the VM, so the errors it reports are the ones running the
+// program would report.
+package analysis
+
+import (
+	"fmt"
+	"lox-compiler/compiler"
+	"lox-compiler/parser"
+	"lox-compiler/source"
+	"strings"
+)
+
+type Kind int
+
+const (
+	Variable Kind = iota
+	Parameter
+	Function
+	Class
+	Method
+)
+
+// A name declared by the program.
+type Symbol struct {
+	Name string
+	Kind Kind
+	// The span of the name where it is declared.
+	NameSpan source.Span
+	// The span of the whole declaration.
+	Span source.Span
+	// How the declaration reads in the source, such as "fun add(a, b)".
+	Detail string
+	// The functions, classes, methods and variables declared directly
+	// inside this one.
+	Children []*Symbol
+	// The spans of every use of the name that refers to this declaration.
+	References []source.Span
+}
+
+// A problem that stops the program from compiling.
+type Diagnostic struct {
+	Span    source.Span
+	Message string
+}
+
+// The result of analysing one program.
+type File struct {
+	Source      string
+	Statements  []parser.Statement
+	Diagnostics []Diagnostic
+	// The top-level declarations, in source order.
+	Symbols []*Symbol
+	// Every declaration and use of a symbol, in the order they were found.
+	occurrences []occurrence
+}
+
+type occurrence struct {
+	span   source.Span
+	symbol *Symbol
+}
+
+// Analyse src. Analysis always succeeds; the problems found in src are
+// collected in the returned File's Diagnostics.
+func Analyze(src string) *File {
+	f := &File{Source: src}
+
+	tokens, scanErr := parser.NewScanner(src).ScanTokens()
+	if scanErr != nil {
+		f.Diagnostics = append(f.Diagnostics, Diagnostic{Message: scanErr.Error()})
+		return f
+	}
+	p := parser.NewParser(tokens)
+	stmts, err := p.Parse()
+	f.Statements = stmts
+	if err != nil {
+		for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+			d := Diagnostic{Message: e.Error()}
+			if pErr, ok := e.(*parser.ParseError); ok {
+				d = Diagnostic{Span: pErr.Span(), Message: pErr.Message()}
+			}
+			f.Diagnostics = append(f.Diagnostics, d)
+		}
+	} else {
+		f.compile()
+	}
+
+	r := resolver{file: f, globals: make(map[string]*Symbol)}
+	r.statements(stmts, nil)
+	r.resolveGlobals()
+
+	return f
+}
+
+func (f *File) compile() {
+	defer func() {
+		// The VM turns a compiler panic into an internal error; do the same
+		// so that one bad program can't take down the editor's server.
+		if r := recover(); r != nil {
+			f.Diagnostics = append(f.Diagnostics, Diagnostic{Message: fmt.Sprintf("internal error: %v", r)})
+		}
+	}()
+
+	c := compiler.Compiler{}
+	if _, err := c.CompileAST(f.Statements); err != nil {
+		f.Diagnostics = append(f.Diagnostics, Diagnostic{Span: err.Span(), Message: err.Message()})
+	}
+}
+
+// The symbol declared or used at the byte offset, or nil. An offset just
+// past the end of a name counts as being on it, as editors place the cursor
+// there after typing it.
+func (f *File) SymbolAt(offset int) *Symbol {
+	for _, o := range f.occurrences {
+		if o.span.Start.Offset <= offset && offset <= o.span.End.Offset {
+			return o.symbol
+		}
+	}
+
+	return nil
+}
+
+// Resolves names the way the compiler does: locals lexically, innermost
+// scope first, and anything else as a global. Globals are looked up once the
+// whole program has been seen, since a function may use a global declared
+// after it.
+type resolver struct {
+	file    *File
+	scopes  []map[string]*Symbol
+	globals map[string]*Symbol
+	// Uses of names that weren't found in any enclosing scope.
+	unresolved []parser.Token
+}
+
+func (r *resolver) beginScope() {
+	r.scopes = append(r.scopes, make(map[string]*Symbol))
+}
+
+func (r *resolver) endScope() {
+	r.scopes = r.scopes[:len(r.scopes)-1]
+}
+
+// Record a declaration of name, as a child of parent if it isn't nil.
+func (r *resolver) declare(name parser.Token, kind Kind, span source.Span, detail string, parent *Symbol) *Symbol {
+	sym := &Symbol{Name: name.Lexeme, Kind: kind, NameSpan: name.Span, Span: span, Detail: detail}
+	r.file.occurrences = append(r.file.occurrences, occurrence{name.Span, sym})
+	if parent != nil {
+		parent.Children = append(parent.Children, sym)
+	} else {
+		r.file.Symbols = append(r.file.Symbols, sym)
+	}
+
+	if len(r.scopes) > 0 {
+		r.scopes[len(r.scopes)-1][name.Lexeme] = sym
+	} else if _, ok := r.globals[name.Lexeme]; !ok {
+		// Redeclaring a global assigns to it, so uses refer to the first
+		// declaration.
+		r.globals[name.Lexeme] = sym
+	}
+
+	return sym
+}
+
+func (r *resolver) use(name parser.Token) {
+	for i := len(r.scopes) - 1; i >= 0; i-- {
+		if sym, ok := r.scopes[i][name.Lexeme]; ok {
+			r.reference(sym, name.Span)
+			return
+		}
+	}
+	r.unresolved = append(r.unresolved, name)
+}
+
+func (r *resolver) reference(sym *Symbol, span source.Span) {
+	sym.References = append(sym.References, span)
+	r.file.occurrences = append(r.file.occurrences, occurrence{span, sym})
+}
+
+func (r *resolver) resolveGlobals() {
+	for _, name := range r.unresolved {
+		if sym, ok := r.globals[name.Lexeme]; ok {
+			r.reference(sym, name.Span)
+		}
+	}
+}
+
+func (r *resolver) statements(stmts []parser.Statement, parent *Symbol) {
+	for _, stmt := range stmts {
+		r.statement(stmt, parent)
+	}
+}
+
+func (r *resolver) statement(stmt parser.Statement, parent *Symbol) {
+	switch s := stmt.(type) {
+	case parser.Block:
+		r.beginScope()
+		r.statements(s.Statements, parent)
+		r.endScope()
+	case parser.Class:
+		r.class(s, parent)
+	case parser.Export:
+		r.statement(s.Declaration, parent)
+	case parser.ExpressionStmt:
+		r.expression(s.Val)
+	case parser.For:
+		r.statement(s.Desugar(), parent)
+	case parser.ForIn:
+		r.expression(s.Iterable)
+		r.beginScope()
+		r.declare(s.Name, Variable, s.Name.Span, "var "+s.Name.Lexeme, parent)
+		r.statement(s.Stmt, parent)
+		r.endScope()
+	case parser.Function:
+		sym := r.declare(s.Name, Function, s.Span, "fun "+r.signature(s), parent)
+		r.function(s, sym)
+	case parser.If:
+		r.expression(s.Conditional)
+		r.statement(s.If_stmt, parent)
+		if s.Else_stmt != nil {
+			r.statement(s.Else_stmt, parent)
+		}
+	case parser.Import:
+		// The names a module exports aren't known until it runs, so only a
+		// module imported by name declares anything.
+		if s.Name != nil {
+			r.declare(*s.Name, Variable, s.Span, "import "+s.Name.Lexeme+" from "+s.Path.Lexeme, parent)
+		}
+	case parser.Print:
+		r.expression(s.Val)
+	case parser.Return:
+		if s.Return_expr != nil {
+			r.expression(s.Return_expr)
+		}
+	case parser.Throw:
+		r.expression(s.Value)
+	case parser.Try:
+		r.statement(s.Body, parent)
+		if s.CatchName != nil {
+			// Like a parameter, the catch variable is scoped to the clause
+			// and isn't listed among the document's symbols.
+			name := *s.CatchName
+			r.beginScope()
+			v := &Symbol{Name: name.Lexeme, Kind: Variable, NameSpan: name.Span, Span: name.Span, Detail: "catch (" + name.Lexeme + ")"}
+			r.file.occurrences = append(r.file.occurrences, occurrence{name.Span, v})
+			r.scopes[len(r.scopes)-1][name.Lexeme] = v
+			r.statement(*s.Catch, parent)
+			r.endScope()
+		}
+		if s.Finally != nil {
+			r.statement(*s.Finally, parent)
+		}
+	case parser.Var:
+		// The initializer can't see the variable it initializes, so resolve
+		// it first.
+		if s.Initializer != nil {
+			r.expression(s.Initializer)
+		}
+		r.declare(s.Name, Variable, s.Span, "var "+s.Name.Lexeme+annotation(s.Type), parent)
+	case parser.While:
+		r.expression(s.Conditional)
+		r.statement(s.Stmt, parent)
+		if s.Increment != nil {
+			r.expression(s.Increment)
+		}
+	}
+}
+
+func (r *resolver) class(s parser.Class, parent *Symbol) {
+	detail := "class " + s.Name.Lexeme
+	if s.ParentClass != nil {
+		detail += " < " + s.ParentClass.Name.Lexeme
+	}
+	sym := r.declare(s.Name, Class, s.Span, detail, parent)
+	if s.ParentClass != nil {
+		r.use(s.ParentClass.Name)
+	}
+
+	for _, m := range s.Methods {
+		method := &Symbol{
+			Name:     m.Name.Lexeme,
+			Kind:     Method,
+			NameSpan: m.Name.Span,
+			Span:     m.Span,
+			Detail:   s.Name.Lexeme + "." + r.signature(m),
+		}
+		// Methods are looked up on the instance at runtime, so they aren't
+		// in scope anywhere.
+		r.file.occurrences = append(r.file.occurrences, occurrence{m.Name.Span, method})
+		sym.Children = append(sym.Children, method)
+		r.function(m, method)
+	}
+}
+
+// Resolve a function's parameters and body, which share one scope.
+func (r *resolver) function(s parser.Function, sym *Symbol) {
+	r.beginScope()
+	for i, param := range s.Params {
+		// A default value can use the parameters before its own.
+		if i < len(s.Defaults) && s.Defaults[i] != nil {
+			r.expression(s.Defaults[i])
+		}
+		detail := "parameter " + r.param(s, i)
+		p := &Symbol{Name: param.Lexeme, Kind: Parameter, NameSpan: param.Span, Span: param.Span, Detail: detail}
+		r.file.occurrences = append(r.file.occurrences, occurrence{param.Span, p})
+		r.scopes[len(r.scopes)-1][param.Lexeme] = p
+	}
+	r.statements(s.Body, sym)
+	r.endScope()
+}
+
+func (r *resolver) expression(e parser.Expr) {
+	switch e := e.(type) {
+	case parser.Assign:
+		r.expression(e.Value)
+		r.use(e.Name)
+	case parser.Binary:
+		r.expression(e.Left)
+		r.expression(e.Right)
+	case parser.Call:
+		r.expression(e.Callee)
+		for _, arg := range e.Args {
+			r.expression(arg)
+		}
+		for _, arg := range e.Named {
+			r.expression(arg.Value)
+		}
+	case parser.Get:
+		r.expression(e.Object)
+	case parser.Grouping:
+		r.expression(e.Expr)
+	case parser.Logical:
+		r.expression(e.Left)
+		r.expression(e.Right)
+	case parser.Unary:
+		r.expression(e.Right)
+	case parser.List:
+		for _, element := range e.Elements {
+			r.expression(element)
+		}
+	case parser.Map:
+		for i := range e.Keys {
+			r.expression(e.Keys[i])
+			r.expression(e.Values[i])
+		}
+	case parser.Set:
+		r.expression(e.Object)
+		r.expression(e.Value)
+	case parser.Subscript:
+		r.expression(e.Object)
+		r.expression(e.Index)
+	case parser.SubscriptSet:
+		r.expression(e.Object)
+		r.expression(e.Index)
+		r.expression(e.Value)
+	case parser.Range:
+		r.expression(e.Start)
+		r.expression(e.End)
+	case parser.Interpolation:
+		for _, expr := range e.Exprs {
+			r.expression(expr)
+		}
+	case parser.Lambda:
+		// A function expression has no name to list it under, so what it
+		// declares is left out of the outline.
+		r.function(e.Decl, &Symbol{Name: "lambda", Kind: Function, Span: e.Span})
+	case parser.Variable:
+		r.use(e.Name)
+	}
+}
+
+// A function's name and parameter list, such as "add(a, b = 1)".
+func (r *resolver) signature(f parser.Function) string {
+	params := make([]string, len(f.Params))
+	for i := range f.Params {
+		params[i] = r.param(f, i)
+	}
+
+	return fmt.Sprintf("%s(%s)%s", f.Name.Lexeme, strings.Join(params, ", "), annotation(f.ReturnType))
+}
+
+// The i'th parameter of f as it reads in the source, such as "...rest" or
+// "b: number = 1".
+func (r *resolver) param(f parser.Function, i int) string {
+	param := f.Params[i].Lexeme
+	if f.IsRest(i) {
+		param = "..." + param
+	}
+	if i < len(f.ParamTypes) {
+		param += annotation(f.ParamTypes[i])
+	}
+	if i < len(f.Defaults) && f.Defaults[i] != nil {
+		span := f.Defaults[i].SourceSpan()
+		param += " = " + r.file.Source[span.Start.Offset:span.End.Offset]
+	}
+
+	return param
+}
+
+// A type annotation as it reads after a name, or "" if there is none.
+func annotation(t *parser.TypeAnnotation) string {
+	if t == nil {
+		return ""
+	}
+
+	return ": " + t.Name.Lexeme
+}
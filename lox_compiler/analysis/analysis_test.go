@@ -0,0 +1,186 @@
+package analysis_test
+
+import (
+	"lox-compiler/analysis"
+	"strings"
+	"testing"
+)
+
+const program = `var greeting = "hi";
+fun greet(name) {
+  var greeting = name;
+  print greeting;
+}
+class Robot < Base {
+  speak(words) { print words; }
+}
+greet(greeting);
+fun later() { return total; }
+var total = 1;
+`
+
+// The byte offset of the nth occurrence (counting from 0) of sub in s.
+func offset(t *testing.T, s, sub string, n int) int {
+	off := 0
+	for i := 0; ; i++ {
+		j := strings.Index(s[off:], sub)
+		if j < 0 {
+			t.Fatalf("%q doesn't occur %d times", sub, n+1)
+		}
+		if i == n {
+			return off + j
+		}
+		off += j + len(sub)
+	}
+}
+
+func TestSymbols(t *testing.T) {
+	f := analysis.Analyze(program)
+
+	var names []string
+	for _, sym := range f.Symbols {
+		names = append(names, sym.Detail)
+	}
+	expected := "var greeting|fun greet(name)|class Robot < Base|fun later()|var total"
+	if got := strings.Join(names, "|"); got != expected {
+		t.Fatalf("expected top-level symbols %q, got %q", expected, got)
+	}
+
+	greet := f.Symbols[1]
+	if len(greet.Children) != 1 || greet.Children[0].Detail != "var greeting" {
+		t.Fatalf("expected greet to declare a local, got %v", greet.Children)
+	}
+	robot := f.Symbols[2]
+	if len(robot.Children) != 1 || robot.Children[0].Kind != analysis.Method || robot.Children[0].Detail != "Robot.speak(words)" {
+		t.Fatalf("expected Robot to have a speak method, got %v", robot.Children)
+	}
+}
+
+func TestReferences(t *testing.T) {
+	f := analysis.Analyze(program)
+
+	tests := []struct {
+		name string
+		// Which occurrence of name to look up, and the span of the
+		// declaration it should resolve to.
+		use, decl int
+	}{
+		// The local shadows the global inside greet.
+		{"greeting", 2, 1},
+		// The global is used outside it.
+		{"greeting", 3, 0},
+		{"name", 1, 0},
+		{"words", 1, 0},
+		{"greet(", 1, 0},
+		// Functions can use globals declared after them.
+		{"total", 0, 1},
+	}
+	for _, test := range tests {
+		sym := f.SymbolAt(offset(t, program, test.name, test.use))
+		if sym == nil {
+			t.Errorf("%s #%d: expected a symbol", test.name, test.use)
+			continue
+		}
+		if got, want := sym.NameSpan.Start.Offset, offset(t, program, test.name, test.decl); got != want {
+			t.Errorf("%s #%d: expected the declaration at %d, got %d", test.name, test.use, want, got)
+		}
+	}
+
+	global := f.SymbolAt(offset(t, program, "greeting", 0))
+	if len(global.References) != 1 || global.References[0].Start.Offset != offset(t, program, "greeting", 3) {
+		t.Fatalf("unexpected references to the global %v", global.References)
+	}
+	if sym := f.SymbolAt(offset(t, program, "Base", 0)); sym != nil {
+		t.Fatalf("expected an undeclared name to have no symbol, got %v", sym)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	f := analysis.Analyze("var 1 = 2;\nprint 1 @;\n")
+	if len(f.Diagnostics) != 2 {
+		t.Fatalf("expected two diagnostics, got %v", f.Diagnostics)
+	}
+	if d := f.Diagnostics[0]; d.Message != "Expect variable name." || d.Span.Start.Offset != 4 {
+		t.Fatalf("unexpected diagnostic %v", d)
+	}
+	if d := f.Diagnostics[1]; d.Message != "Unexpected character." || d.Span.Start.Line != 2 {
+		t.Fatalf("unexpected diagnostic %v", d)
+	}
+
+	f = analysis.Analyze("{\n  var a;\n  var a;\n}\n")
+	if len(f.Diagnostics) != 1 || f.Diagnostics[0].Span.Start.Line != 3 {
+		t.Fatalf("expected the compiler's error on line 3, got %v", f.Diagnostics)
+	}
+}
+
+func TestModules(t *testing.T) {
+	f := analysis.Analyze(`import "all.lox";
+import m from "m.lox";
+export fun f() { print m; }
+`)
+	if len(f.Diagnostics) != 0 {
+		t.Fatalf("unexpected diagnostics %v", f.Diagnostics)
+	}
+	var names []string
+	for _, sym := range f.Symbols {
+		names = append(names, sym.Detail)
+	}
+	expected := `import m from "m.lox"|fun f()`
+	if got := strings.Join(names, "|"); got != expected {
+		t.Fatalf("expected top-level symbols %q, got %q", expected, got)
+	}
+	if refs := f.Symbols[0].References; len(refs) != 1 {
+		t.Fatalf("expected one reference to m, got %v", refs)
+	}
+}
+
+func TestLambda(t *testing.T) {
+	src := `var n = 1;
+var f = (a) => a + n;
+var g = fun (b) { var c = b; return c; };
+`
+	f := analysis.Analyze(src)
+	if len(f.Diagnostics) != 0 {
+		t.Fatalf("unexpected diagnostics %v", f.Diagnostics)
+	}
+	var names []string
+	for _, sym := range f.Symbols {
+		names = append(names, sym.Detail)
+	}
+	// What a lambda declares is left out of the outline.
+	expected := "var n|var f|var g"
+	if got := strings.Join(names, "|"); got != expected {
+		t.Fatalf("expected top-level symbols %q, got %q", expected, got)
+	}
+
+	// But its parameters and locals still resolve.
+	if sym := f.SymbolAt(offset(t, src, "a + n", 0)); sym == nil || sym.Detail != "parameter a" {
+		t.Fatalf("expected the parameter a, got %v", sym)
+	}
+	if sym := f.SymbolAt(offset(t, src, "c;", 0)); sym == nil || sym.Detail != "var c" || len(sym.References) != 1 {
+		t.Fatalf("expected the local c with one reference, got %v", sym)
+	}
+	if refs := f.Symbols[0].References; len(refs) != 1 {
+		t.Fatalf("expected the lambda to refer to n, got %v", refs)
+	}
+}
+
+func TestParameters(t *testing.T) {
+	src := `var n = 1;
+fun f(a, b: number = a + n, ...rest) { return rest; }
+`
+	f := analysis.Analyze(src)
+	if len(f.Diagnostics) != 0 {
+		t.Fatalf("unexpected diagnostics %v", f.Diagnostics)
+	}
+	if d := f.Symbols[1].Detail; d != "fun f(a, b: number = a + n, ...rest)" {
+		t.Fatalf("unexpected detail %q", d)
+	}
+	// A default value refers to the parameters before it.
+	if sym := f.SymbolAt(offset(t, src, "a + n", 0)); sym == nil || sym.Detail != "parameter a" {
+		t.Fatalf("expected the parameter a, got %v", sym)
+	}
+	if refs := f.Symbols[0].References; len(refs) != 1 {
+		t.Fatalf("expected the default value to refer to n, got %v", refs)
+	}
+}
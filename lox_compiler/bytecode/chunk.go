@@ -10,6 +10,8 @@ type Chunk struct {
 	InstructionSlice
 	Constants ValueSlice
 	Values    ValueStack
+	// The globals that the module compiled into the chunk exports.
+	Exports []LoxString
 }
 
 func NewChunk() Chunk {
@@ -1,66 +1,164 @@
 package bytecode
 
 import (
+	"encoding/binary"
 	"fmt"
+	"math"
+	"reflect"
+	"sort"
 	"strings"
 )
 
+// The fraction of the buckets that can hold a key or a tombstone before the
+// map is rehashed.
 var loadFactor float64 = 0.5
 
 // Hash function interface
 type Hasher interface {
-	Hash(LoxString) int
+	Hash(Value) int
 }
 
-type HashFunction func(LoxString) int
+type HashFunction func(Value) int
 
-func (f HashFunction) Hash(s LoxString) int {
-	return f(s)
+func (f HashFunction) Hash(v Value) int {
+	return f(v)
 }
 
-func fvnHash(s LoxString) int {
-    var hash uint32 = 2166136261
-    var prime uint32 = 16777619
+func fvnHash(v Value) int {
+	var hash uint32 = 2166136261
+	var prime uint32 = 16777619
 
-    for _, v := range s {
-        hash =  hash * prime
-        hash = uint32(uint8(v)) ^ hash
-    }
+	for _, b := range hashBytes(v) {
+		hash = hash * prime
+		hash = uint32(b) ^ hash
+	}
 
-    return int(hash)
+	return int(hash)
 }
 
 var FVNHashFunction = HashFunction(fvnHash)
 
+// The bytes a key is hashed by. Keys that are equal have the same bytes, so
+// objects, which are compared by identity, are hashed by their address.
+func hashBytes(v Value) []byte {
+	var b []byte
+	switch k := v.(type) {
+	case LoxString:
+		return append([]byte{'s'}, k...)
+	case LoxInt:
+		b = appendInt([]byte{'i'}, k)
+	case LoxFloat:
+		// A float equal to an integer is the same key as the integer.
+		if i, ok := k.Int(); ok {
+			return appendInt([]byte{'i'}, i)
+		}
+		b = binary.LittleEndian.AppendUint64([]byte{'n'}, math.Float64bits(float64(k)))
+	case LoxBigInt, LoxDecimal:
+		// A bigint or decimal is the same key as the integer or float it's
+		// equal to, if there is one.
+		r, _ := Rat(k)
+		if r.IsInt() && r.Num().IsInt64() {
+			return appendInt([]byte{'i'}, LoxInt(r.Num().Int64()))
+		}
+		if f, exact := r.Float64(); exact {
+			return hashBytes(LoxFloat(f))
+		}
+		return append([]byte{'q'}, r.String()...)
+	case LoxRange:
+		b = appendInt(appendInt([]byte{'r'}, k.Start), k.End)
+	case LoxBool:
+		if k {
+			return []byte{'t'}
+		}
+		return []byte{'f'}
+	case LoxNil:
+		return []byte{'0'}
+	default:
+		r := reflect.ValueOf(v)
+		if r.Kind() != reflect.Pointer {
+			return nil
+		}
+		b = binary.LittleEndian.AppendUint64([]byte{'p'}, uint64(r.Pointer()))
+	}
+
+	return b
+}
+
+func appendInt(b []byte, n LoxInt) []byte {
+	return binary.LittleEndian.AppendUint64(b, uint64(n))
+}
+
+// Whether v can be a key of a map. Every value can, apart from functions.
+// Function declarations are copied around rather than handled by pointer, so
+// have no identity to hash, and closures and built-in methods are left out
+// to match them.
+func Hashable(v Value) bool {
+	switch v.(type) {
+	case *LoxClosure, *LoxBuiltinMethod:
+		return false
+	}
+	return v == nil || reflect.TypeOf(v).Comparable()
+}
+
+// Whether a and b are the same key. Values that can't be compared are never
+// the same key, not even as themselves.
+func sameKey(a, b Value) bool {
+	if !Hashable(a) || !Hashable(b) {
+		return false
+	}
+
+	return Equal(a, b)
+}
+
+type bucketState uint8
+
+const (
+	empty bucketState = iota
+	occupied
+	// A bucket whose key was deleted. Lookups carry on probing past it, since
+	// the key they're after might have been inserted after the deleted one.
+	tombstone
+)
+
 type keyValPair struct {
-	key LoxString
-	val Value
+	key   Value
+	val   Value
+	state bucketState
+	// When the key was first inserted, relative to the others.
+	order int
 }
 
 type LinearProbingHashMap struct {
 	buckets      []keyValPair
-	loadFactor   float64
 	hashFunction Hasher
+	// The number of occupied buckets and of tombstones.
+	count      int
+	tombstones int
+	// The order the next new key gets.
+	inserted int
 }
 
 func NewLinearProbingHashMap() LinearProbingHashMap {
+	return NewLinearProbingHashMapWithHasher(FVNHashFunction)
+}
+
+func NewLinearProbingHashMapWithHasher(h Hasher) LinearProbingHashMap {
 	return LinearProbingHashMap{
 		buckets:      make([]keyValPair, 1000),
-		loadFactor:   0,
-		hashFunction: FVNHashFunction,
+		hashFunction: h,
 	}
 }
 
-func (hashMap LinearProbingHashMap) getIndex(s LoxString) int {
-    return FVNHashFunction.Hash(s) % cap(hashMap.buckets)
+func (hashMap LinearProbingHashMap) getIndex(key Value) int {
+	return int(uint(hashMap.hashFunction.Hash(key)) % uint(len(hashMap.buckets)))
 }
 
 func (hashMap LinearProbingHashMap) String() string {
 	str := strings.Builder{}
 	str.WriteString("{\n")
 	for _, v := range hashMap.buckets {
-		if v.key != "" {
-			str.WriteString(fmt.Sprintf("\t%s: %v,\n", v.key, v.val))
+		if v.state == occupied {
+			str.WriteString(fmt.Sprintf("\t%v: %v,\n", v.key, v.val))
 		}
 	}
 	str.WriteString("}\n")
@@ -68,70 +166,109 @@ func (hashMap LinearProbingHashMap) String() string {
 	return str.String()
 }
 
-func (hashMap *LinearProbingHashMap) Insert(s LoxString, v Value) {
-    i := hashMap.getIndex(s)
-	for true {
-		if hashMap.buckets[i].key == "" || hashMap.buckets[i].key == s {
-			hashMap.buckets[i] = keyValPair{s, v}
-			break
-		} else {
-			i++
-			if i >= cap(hashMap.buckets) {
-				i = 0
+// The bucket that holds key, or -1 if it isn't in the map.
+func (hashMap LinearProbingHashMap) find(key Value) int {
+	i := hashMap.getIndex(key)
+	for range hashMap.buckets {
+		switch pair := hashMap.buckets[i]; pair.state {
+		case empty:
+			return -1
+		case occupied:
+			if sameKey(pair.key, key) {
+				return i
 			}
 		}
+		i = (i + 1) % len(hashMap.buckets)
 	}
 
-	hashMap.loadFactor += 1 / float64(cap(hashMap.buckets))
-	// rehash
-	if hashMap.loadFactor >= loadFactor {
-		oldBuckets := hashMap.buckets
-		hashMap.buckets = make([]keyValPair, cap(hashMap.buckets)*2)
-		hashMap.loadFactor = 0
-		for _, v := range oldBuckets {
-			if v.key != "" {
-				hashMap.Insert(v.key, v.val)
-			}
-		}
+	return -1
+}
+
+func (hashMap *LinearProbingHashMap) Insert(key Value, v Value) {
+	if i := hashMap.find(key); i >= 0 {
+		// A key that is already there keeps its place in the order.
+		hashMap.buckets[i].val = v
+		return
+	}
+
+	hashMap.insert(keyValPair{key: key, val: v, state: occupied, order: hashMap.inserted})
+	hashMap.inserted++
+	if float64(hashMap.count+hashMap.tombstones) >= loadFactor*float64(len(hashMap.buckets)) {
+		hashMap.rehash()
 	}
 }
 
-func (hashMap *LinearProbingHashMap) Get(s LoxString) (Value, error) {
-    initial_i := hashMap.getIndex(s)
-	i := initial_i
-	for true {
-		pair := hashMap.buckets[i]
-		if pair.key == s {
-			return pair.val, nil
-		}
-		i++
-		if i >= cap(hashMap.buckets) {
-			i = 0
-		}
-		if i == initial_i {
-			return nil, fmt.Errorf("%s is not in the map", s)
+// Put a key that isn't in the map yet into the first free bucket along its
+// probe sequence, reusing a tombstone if there is one.
+func (hashMap *LinearProbingHashMap) insert(pair keyValPair) {
+	i := hashMap.getIndex(pair.key)
+	for hashMap.buckets[i].state == occupied {
+		i = (i + 1) % len(hashMap.buckets)
+	}
+	if hashMap.buckets[i].state == tombstone {
+		hashMap.tombstones--
+	}
+	hashMap.buckets[i] = pair
+	hashMap.count++
+}
+
+// Move the keys to a new set of buckets, leaving the tombstones behind. The
+// map only grows if the keys themselves fill enough of it; otherwise getting
+// rid of the tombstones makes enough room.
+func (hashMap *LinearProbingHashMap) rehash() {
+	size := len(hashMap.buckets)
+	if float64(hashMap.count) >= loadFactor*float64(size)/2 {
+		size *= 2
+	}
+
+	oldBuckets := hashMap.buckets
+	hashMap.buckets = make([]keyValPair, size)
+	hashMap.count, hashMap.tombstones = 0, 0
+	for _, v := range oldBuckets {
+		if v.state == occupied {
+			hashMap.insert(v)
 		}
 	}
+}
+
+func (hashMap *LinearProbingHashMap) Get(key Value) (Value, error) {
+	i := hashMap.find(key)
+	if i < 0 {
+		return nil, fmt.Errorf("%v is not in the map", key)
+	}
 
-	return nil, nil // unreachable
+	return hashMap.buckets[i].val, nil
 }
 
-func (hashMap *LinearProbingHashMap) Delete(s LoxString) {
-    initial_i := hashMap.getIndex(s)
-	i := initial_i
-	for true {
-		pair := hashMap.buckets[i]
-		if pair.key == s {
-            hashMap.buckets[i].key = ""
-            hashMap.loadFactor -= 1 / float64(cap(hashMap.buckets))
-            return
-		}
-		i++
-		if i >= cap(hashMap.buckets) {
-			i = 0
-		}
-		if i == initial_i {
-            return
+func (hashMap *LinearProbingHashMap) Delete(key Value) {
+	i := hashMap.find(key)
+	if i < 0 {
+		return
+	}
+
+	hashMap.buckets[i] = keyValPair{state: tombstone}
+	hashMap.count--
+	hashMap.tombstones++
+}
+
+// The number of keys in the map.
+func (hashMap LinearProbingHashMap) Len() int {
+	return hashMap.count
+}
+
+// The keys in the map, in the order they were first inserted.
+func (hashMap LinearProbingHashMap) Keys() []Value {
+	var pairs []keyValPair
+	for _, v := range hashMap.buckets {
+		if v.state == occupied {
+			pairs = append(pairs, v)
 		}
 	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].order < pairs[j].order })
+
+	keys := make([]Value, len(pairs))
+	for i, pair := range pairs {
+		keys[i] = pair.key
+	}
+	return keys
 }
@@ -3,21 +3,25 @@ package bytecode_test
 import (
 	"fmt"
     "lox-compiler/bytecode"
+	"lox-compiler/decimal"
+	"math"
+	"math/big"
+	"math/rand"
 	"testing"
 )
 
 func TestInsertElem(t *testing.T) {
     m := bytecode.NewLinearProbingHashMap()
     fmt.Println(m)
-    m.Insert("a", bytecode.LoxInt(1))
+    m.Insert(bytecode.LoxString("a"), bytecode.LoxInt(1))
     fmt.Println(m)
-    m.Insert("b", bytecode.LoxInt(2))
+    m.Insert(bytecode.LoxString("b"), bytecode.LoxInt(2))
     fmt.Println(m)
 }
 
 func TestGetInvalid(t *testing.T) {
     m := bytecode.NewLinearProbingHashMap()
-    _, err := m.Get("a")
+    _, err := m.Get(bytecode.LoxString("a"))
     if err == nil {
         t.FailNow()
     }
@@ -27,15 +31,15 @@ func TestGetInvalid(t *testing.T) {
 
 func TestGetValid(t *testing.T) {
     m := bytecode.NewLinearProbingHashMap()
-    m.Insert("a", bytecode.LoxString("asdf"))
-    m.Insert("b", bytecode.LoxString("1234"))
-    val, err := m.Get("a")
+    m.Insert(bytecode.LoxString("a"), bytecode.LoxString("asdf"))
+    m.Insert(bytecode.LoxString("b"), bytecode.LoxString("1234"))
+    val, err := m.Get(bytecode.LoxString("a"))
     if err != nil {
         t.Fatalf(err.Error())
     }
 
     fmt.Println(val)
-    val, err = m.Get("b")
+    val, err = m.Get(bytecode.LoxString("b"))
     if err != nil {
         fmt.Println(m)
         fmt.Println(val)
@@ -61,14 +65,201 @@ func TestRehash(t *testing.T) {
 
 func TestDelElem(t *testing.T) {
     m := bytecode.NewLinearProbingHashMap()
-    m.Insert("a", bytecode.LoxInt(1))
+    m.Insert(bytecode.LoxString("a"), bytecode.LoxInt(1))
     fmt.Println(m)
-    // m.Insert("b", bytecode.LoxInt(2))
-    m.Delete("a")
+    // m.Insert(bytecode.LoxString("b"), bytecode.LoxInt(2))
+    m.Delete(bytecode.LoxString("a"))
     fmt.Println(m)
-    _, err := m.Get("a")
+    _, err := m.Get(bytecode.LoxString("a"))
     if err == nil {
         t.Fatalf("%s", m)
     }
 }
 
+
+func TestKeysInInsertionOrder(t *testing.T) {
+    m := bytecode.NewLinearProbingHashMap()
+    var expected []bytecode.LoxString
+    // Enough keys to make the map grow.
+    for i := 0; i < 2000; i++ {
+        key := bytecode.LoxString(fmt.Sprint(2000 - i))
+        m.Insert(key, bytecode.LoxInt(i))
+        expected = append(expected, key)
+    }
+    // Updating a key doesn't move it.
+    m.Insert(bytecode.LoxString("2000"), bytecode.LoxNil(0))
+
+    keys := m.Keys()
+    if len(keys) != len(expected) {
+        t.Fatalf("expected %d keys, got %d", len(expected), len(keys))
+    }
+    for i, key := range keys {
+        if key != expected[i] {
+            t.Fatalf("expected key %d to be %s, got %s", i, expected[i], key)
+        }
+    }
+}
+
+// Every key hashes to the same bucket, so every key is on one probe chain.
+var collide = bytecode.HashFunction(func(bytecode.Value) int { return 7 })
+
+func TestDeleteKeepsProbeChain(t *testing.T) {
+    m := bytecode.NewLinearProbingHashMapWithHasher(collide)
+    m.Insert(bytecode.LoxString("a"), bytecode.LoxInt(1))
+    m.Insert(bytecode.LoxString("b"), bytecode.LoxInt(2))
+    m.Insert(bytecode.LoxString("c"), bytecode.LoxInt(3))
+    m.Delete(bytecode.LoxString("a"))
+
+    for _, key := range []bytecode.LoxString{"b", "c"} {
+        if _, err := m.Get(key); err != nil {
+            t.Fatalf("%s: %s", key, err)
+        }
+    }
+    // Inserting after the delete doesn't duplicate a key further along.
+    m.Insert(bytecode.LoxString("c"), bytecode.LoxInt(4))
+    if m.Len() != 2 {
+        t.Fatalf("expected 2 keys, got %d", m.Len())
+    }
+    if v, _ := m.Get(bytecode.LoxString("c")); v != bytecode.LoxInt(4) {
+        t.Fatalf("expected 4, got %v", v)
+    }
+}
+
+func TestKeyKinds(t *testing.T) {
+    list, otherList := &bytecode.LoxList{}, &bytecode.LoxList{}
+    m := bytecode.NewLinearProbingHashMap()
+    keys := []bytecode.Value{
+        bytecode.LoxString("1"),
+        bytecode.LoxInt(1),
+        bytecode.LoxBool(true),
+        bytecode.LoxNil(0),
+        list,
+    }
+    for i, key := range keys {
+        m.Insert(key, bytecode.LoxInt(i))
+    }
+
+    for i, key := range keys {
+        v, err := m.Get(key)
+        if err != nil {
+            t.Fatalf("%v: %s", key, err)
+        }
+        if v != bytecode.LoxInt(i) {
+            t.Fatalf("%v: expected %d, got %v", key, i, v)
+        }
+    }
+    // Objects are keys by identity, not by contents.
+    if _, err := m.Get(otherList); err == nil {
+        t.Fatalf("an equal list shouldn't be the same key")
+    }
+    // -0 and 0 are the same number.
+    m.Insert(bytecode.LoxInt(0), bytecode.LoxInt(1))
+    if _, err := m.Get(bytecode.LoxFloat(math.Copysign(0, -1))); err != nil {
+        t.Fatalf("-0: %s", err)
+    }
+    // So are a float and the integer it is equal to.
+    if v, err := m.Get(bytecode.LoxFloat(1)); err != nil || v != bytecode.LoxInt(1) {
+        t.Fatalf("1.0: got %v, %v", v, err)
+    }
+    m.Insert(bytecode.LoxFloat(1.5), bytecode.LoxInt(2))
+    if _, err := m.Get(bytecode.LoxInt(1)); err != nil || m.Len() != len(keys)+2 {
+        t.Fatalf("1.5 should be a key of its own")
+    }
+    // Bigints and decimals are the same keys as the integers and floats
+    // they're equal to.
+    if v, err := m.Get(bytecode.LoxBigInt{Int: big.NewInt(1)}); err != nil || v != bytecode.LoxInt(1) {
+        t.Fatalf("1n: got %v, %v", v, err)
+    }
+    if v, err := m.Get(bytecode.LoxDecimal{Decimal: decimal.New(big.NewInt(150), 2)}); err != nil || v != bytecode.LoxInt(2) {
+        t.Fatalf("1.50d: got %v, %v", v, err)
+    }
+    tenth := bytecode.LoxDecimal{Decimal: decimal.New(big.NewInt(1), 1)}
+    m.Insert(tenth, bytecode.LoxInt(3))
+    if _, err := m.Get(bytecode.LoxFloat(0.1)); err == nil {
+        t.Fatalf("0.1d shouldn't be the same key as the float nearest 0.1")
+    }
+    if v, err := m.Get(bytecode.LoxDecimal{Decimal: decimal.New(big.NewInt(10), 2)}); err != nil || v != bytecode.LoxInt(3) {
+        t.Fatalf("0.10d: got %v, %v", v, err)
+    }
+}
+
+// Apply random inserts, deletes and lookups to the map and to a Go map, and
+// check they always agree.
+func checkAgainstGoMap(t *testing.T, m bytecode.LinearProbingHashMap, seed int64) {
+    r := rand.New(rand.NewSource(seed))
+    objects := []bytecode.Value{&bytecode.LoxList{}, &bytecode.LoxList{}, bytecode.NewLoxMap()}
+    // A small pool of keys, so that keys get deleted and reinserted often.
+    randomKey := func() bytecode.Value {
+        switch r.Intn(5) {
+        case 0:
+            return bytecode.LoxString(fmt.Sprint(r.Intn(300)))
+        case 1:
+            return bytecode.LoxInt(r.Intn(300))
+        case 2:
+            return bytecode.LoxBool(r.Intn(2) == 0)
+        case 3:
+            return bytecode.LoxNil(0)
+        }
+        return objects[r.Intn(len(objects))]
+    }
+
+    expected := map[bytecode.Value]bytecode.Value{}
+    var order []bytecode.Value
+    for i := 0; i < 20000; i++ {
+        key := randomKey()
+        switch r.Intn(3) {
+        case 0:
+            if _, ok := expected[key]; !ok {
+                order = append(order, key)
+            }
+            expected[key] = bytecode.LoxInt(i)
+            m.Insert(key, bytecode.LoxInt(i))
+        case 1:
+            if _, ok := expected[key]; ok {
+                for j, k := range order {
+                    if k == key {
+                        order = append(order[:j], order[j+1:]...)
+                        break
+                    }
+                }
+            }
+            delete(expected, key)
+            m.Delete(key)
+        case 2:
+            want, ok := expected[key]
+            got, err := m.Get(key)
+            if ok != (err == nil) || got != want {
+                t.Fatalf("seed %d, step %d: Get(%v) = %v, %v; expected %v, %v", seed, i, key, got, err, want, ok)
+            }
+        }
+        if m.Len() != len(expected) {
+            t.Fatalf("seed %d, step %d: expected %d keys, got %d", seed, i, len(expected), m.Len())
+        }
+    }
+
+    keys := m.Keys()
+    if len(keys) != len(order) {
+        t.Fatalf("seed %d: expected %d keys, got %d", seed, len(order), len(keys))
+    }
+    for i, key := range keys {
+        if key != order[i] {
+            t.Fatalf("seed %d: expected key %d to be %v, got %v", seed, i, order[i], key)
+        }
+    }
+}
+
+func TestAgainstGoMap(t *testing.T) {
+    for seed := int64(0); seed < 10; seed++ {
+        checkAgainstGoMap(t, bytecode.NewLinearProbingHashMap(), seed)
+    }
+}
+
+func TestAgainstGoMapWithCollisions(t *testing.T) {
+    // Colliding hashes make every operation probe, so keep it short.
+    hashes := bytecode.HashFunction(func(v bytecode.Value) int {
+        return bytecode.FVNHashFunction.Hash(v) % 4
+    })
+    for seed := int64(0); seed < 3; seed++ {
+        checkAgainstGoMap(t, bytecode.NewLinearProbingHashMapWithHasher(hashes), seed)
+    }
+}
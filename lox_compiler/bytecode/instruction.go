@@ -2,6 +2,7 @@ package bytecode
 
 import (
 	"fmt"
+	"lox-compiler/source"
 	"strings"
 )
 
@@ -13,34 +14,60 @@ type OperandArray [2]Operand
 const (
     OpAdd OpCode = iota
     OpAnd
+    OpArgMissing
     OpAssign
+    OpBuildList
+    OpBuildMap
+    OpBuildRange
+    OpBuildString
+    OpCall
+    OpCallNamed
+    OpCloseUpvalue
+    OpClosure
     OpConditionalJump
     OpConstant
     OpDeclareGlobal
     OpDivide
+    OpEndTry
     OpEqualEqual
+    OpGetProperty
+    OpGetUpvalue
     OpGlobalLookup
     OpGreater
     OpGreaterEqual
+    OpImport
+    OpImportAll
+    OpIndex
+    OpInvoke
+    OpIterate
+    OpIterNext
     OpJump
     OpLess
     OpLessEqual
     OpLocalAssign
     OpLocalLookup
+    OpModulo
     OpMultiply
     OpNegate
     OpNotEqual
     OpOr
     OpPop
     OpPrint
+    OpRethrow
     OpReturn
+    OpSetUpvalue
+    OpStoreIndex
     OpSubtract
+    OpThrow
+    OpTry
 )
 
 type Instruction struct {
 	Code            OpCode
 	Operands        OperandArray
 	SourceLineNumer int
+	// The source text the instruction was compiled from.
+	Span source.Span
 }
 
 func NewInst(code OpCode, line int) Instruction {
@@ -10,33 +10,57 @@ func _() {
 	var x [1]struct{}
 	_ = x[OpAdd-0]
 	_ = x[OpAnd-1]
-	_ = x[OpAssign-2]
-	_ = x[OpConditionalJump-3]
-	_ = x[OpConstant-4]
-	_ = x[OpDeclareGlobal-5]
-	_ = x[OpDivide-6]
-	_ = x[OpEqualEqual-7]
-	_ = x[OpGlobalLookup-8]
-	_ = x[OpGreater-9]
-	_ = x[OpGreaterEqual-10]
-	_ = x[OpJump-11]
-	_ = x[OpLess-12]
-	_ = x[OpLessEqual-13]
-	_ = x[OpLocalAssign-14]
-	_ = x[OpLocalLookup-15]
-	_ = x[OpMultiply-16]
-	_ = x[OpNegate-17]
-	_ = x[OpNotEqual-18]
-	_ = x[OpOr-19]
-	_ = x[OpPop-20]
-	_ = x[OpPrint-21]
-	_ = x[OpReturn-22]
-	_ = x[OpSubtract-23]
+	_ = x[OpArgMissing-2]
+	_ = x[OpAssign-3]
+	_ = x[OpBuildList-4]
+	_ = x[OpBuildMap-5]
+	_ = x[OpBuildRange-6]
+	_ = x[OpBuildString-7]
+	_ = x[OpCall-8]
+	_ = x[OpCallNamed-9]
+	_ = x[OpCloseUpvalue-10]
+	_ = x[OpClosure-11]
+	_ = x[OpConditionalJump-12]
+	_ = x[OpConstant-13]
+	_ = x[OpDeclareGlobal-14]
+	_ = x[OpDivide-15]
+	_ = x[OpEndTry-16]
+	_ = x[OpEqualEqual-17]
+	_ = x[OpGetProperty-18]
+	_ = x[OpGetUpvalue-19]
+	_ = x[OpGlobalLookup-20]
+	_ = x[OpGreater-21]
+	_ = x[OpGreaterEqual-22]
+	_ = x[OpImport-23]
+	_ = x[OpImportAll-24]
+	_ = x[OpIndex-25]
+	_ = x[OpInvoke-26]
+	_ = x[OpIterate-27]
+	_ = x[OpIterNext-28]
+	_ = x[OpJump-29]
+	_ = x[OpLess-30]
+	_ = x[OpLessEqual-31]
+	_ = x[OpLocalAssign-32]
+	_ = x[OpLocalLookup-33]
+	_ = x[OpModulo-34]
+	_ = x[OpMultiply-35]
+	_ = x[OpNegate-36]
+	_ = x[OpNotEqual-37]
+	_ = x[OpOr-38]
+	_ = x[OpPop-39]
+	_ = x[OpPrint-40]
+	_ = x[OpRethrow-41]
+	_ = x[OpReturn-42]
+	_ = x[OpSetUpvalue-43]
+	_ = x[OpStoreIndex-44]
+	_ = x[OpSubtract-45]
+	_ = x[OpThrow-46]
+	_ = x[OpTry-47]
 }
 
-const _OpCode_name = "OpAddOpAndOpAssignOpConditionalJumpOpConstantOpDeclareGlobalOpDivideOpEqualEqualOpGlobalLookupOpGreaterOpGreaterEqualOpJumpOpLessOpLessEqualOpLocalAssignOpLocalLookupOpMultiplyOpNegateOpNotEqualOpOrOpPopOpPrintOpReturnOpSubtract"
+const _OpCode_name = "OpAddOpAndOpArgMissingOpAssignOpBuildListOpBuildMapOpBuildRangeOpBuildStringOpCallOpCallNamedOpCloseUpvalueOpClosureOpConditionalJumpOpConstantOpDeclareGlobalOpDivideOpEndTryOpEqualEqualOpGetPropertyOpGetUpvalueOpGlobalLookupOpGreaterOpGreaterEqualOpImportOpImportAllOpIndexOpInvokeOpIterateOpIterNextOpJumpOpLessOpLessEqualOpLocalAssignOpLocalLookupOpModuloOpMultiplyOpNegateOpNotEqualOpOrOpPopOpPrintOpRethrowOpReturnOpSetUpvalueOpStoreIndexOpSubtractOpThrowOpTry"
 
-var _OpCode_index = [...]uint8{0, 5, 10, 18, 35, 45, 60, 68, 80, 94, 103, 117, 123, 129, 140, 153, 166, 176, 184, 194, 198, 203, 210, 218, 228}
+var _OpCode_index = [...]uint16{0, 5, 10, 22, 30, 41, 51, 63, 76, 82, 93, 107, 116, 133, 143, 158, 166, 174, 186, 199, 211, 225, 234, 248, 256, 267, 274, 282, 291, 301, 307, 313, 324, 337, 350, 358, 368, 376, 386, 390, 395, 402, 411, 419, 431, 443, 453, 460, 465}
 
 func (i OpCode) String() string {
 	if i >= OpCode(len(_OpCode_index)-1) {
@@ -1,6 +1,12 @@
 package bytecode
 
-import "fmt"
+import (
+	"fmt"
+	"lox-compiler/decimal"
+	"math"
+	"math/big"
+	"strings"
+)
 
 type Value interface {
 	Truthy() bool
@@ -11,8 +17,14 @@ type ValueStack []Value
 
 func NewValue(v any) (Value, error) {
 	switch val := v.(type) {
-	case float64:
+	case int64:
 		return LoxInt(val), nil
+	case float64:
+		return LoxFloat(val), nil
+	case *big.Int:
+		return LoxBigInt{val}, nil
+	case decimal.Decimal:
+		return LoxDecimal{val}, nil
 	case string:
 		return LoxString(val), nil
 	case bool:
@@ -20,13 +32,15 @@ func NewValue(v any) (Value, error) {
 	case nil:
 		return LoxNil(0), nil
 	case LinearProbingHashMap:
-		return LoxMap(val), nil
+		m := LoxMap(val)
+		return &m, nil
 	}
 
 	return nil, fmt.Errorf("%T is not a valid LoxValue type", v)
 }
 
-type LoxInt float64
+// A 64-bit integer. Arithmetic on two integers wraps around on overflow.
+type LoxInt int64
 
 func (v LoxInt) private() {}
 func (v LoxInt) Truthy() bool {
@@ -37,6 +51,106 @@ func (v LoxInt) Val() any {
 	return v
 }
 
+// A 64-bit float. Mixing an integer with a float in arithmetic gives a float.
+type LoxFloat float64
+
+func (v LoxFloat) private() {}
+func (v LoxFloat) Truthy() bool {
+	return v != 0
+}
+
+// Floats show the way Go shows them, but with ".0" after a whole number so
+// that they can't be mistaken for integers.
+func (v LoxFloat) String() string {
+	f := float64(v)
+	s := fmt.Sprint(f)
+	if math.IsInf(f, 0) || math.IsNaN(f) || strings.ContainsAny(s, ".e") {
+		return s
+	}
+	return s + ".0"
+}
+
+// The integer a float is equal to, if there is one.
+func (v LoxFloat) Int() (LoxInt, bool) {
+	f := float64(v)
+	if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return 0, false
+	}
+	return LoxInt(f), true
+}
+
+// An integer of any size, written with an n after it: 123n. Bigints are
+// never changed once made, so they can be shared. Arithmetic on a bigint and
+// an integer gives a bigint.
+type LoxBigInt struct{ *big.Int }
+
+func (v LoxBigInt) private() {}
+func (v LoxBigInt) Truthy() bool {
+	return v.Sign() != 0
+}
+
+// A decimal number, written with a d after it: 1.10d. Arithmetic on a
+// decimal and an integer or bigint gives a decimal, rounded to the precision
+// of the VM's decimal context.
+type LoxDecimal struct{ decimal.Decimal }
+
+func (v LoxDecimal) private() {}
+func (v LoxDecimal) Truthy() bool {
+	return v.Sign() != 0
+}
+
+// The number v is, as an exact fraction. Infinities, NaN and values that
+// aren't numbers have none.
+func Rat(v Value) (*big.Rat, bool) {
+	switch n := v.(type) {
+	case LoxInt:
+		return new(big.Rat).SetInt64(int64(n)), true
+	case LoxFloat:
+		if math.IsInf(float64(n), 0) || math.IsNaN(float64(n)) {
+			return nil, false
+		}
+		return new(big.Rat).SetFloat64(float64(n)), true
+	case LoxBigInt:
+		return new(big.Rat).SetInt(n.Int), true
+	case LoxDecimal:
+		return n.Rat(), true
+	}
+	return nil, false
+}
+
+// Whether a and b are equal. Numbers are equal only if they are exactly the
+// same number, whatever their types; other values are compared with ==.
+func Equal(a, b Value) bool {
+	if isBig(a) || isBig(b) {
+		x, ok := Rat(a)
+		y, ok2 := Rat(b)
+		return ok && ok2 && x.Cmp(y) == 0
+	}
+	switch x := a.(type) {
+	case LoxInt:
+		if y, ok := b.(LoxFloat); ok {
+			i, ok := y.Int()
+			return ok && i == x
+		}
+	case LoxFloat:
+		if y, ok := b.(LoxInt); ok {
+			i, ok := x.Int()
+			return ok && i == y
+		}
+	}
+
+	return a == b
+}
+
+// Whether v is a bigint or a decimal.
+func isBig(v Value) bool {
+	switch v.(type) {
+	case LoxBigInt, LoxDecimal:
+		return true
+	}
+	return false
+}
+
 type LoxString string
 
 func (v LoxString) private() {}
@@ -62,22 +176,129 @@ func (v LoxNil) String() string {
 	return "nil"
 }
 
+// A map from values to values. Like lists, maps are only ever handled by
+// pointer.
 type LoxMap LinearProbingHashMap
 
+func NewLoxMap() *LoxMap {
+	m := LoxMap(NewLinearProbingHashMap())
+	return &m
+}
+
 func (v LoxMap) private() {}
 func (v LoxMap) Truthy() bool {
 	return true
 }
 
+// A module that has been imported, and the values of the globals it
+// exports. Modules are only ever handled by pointer, so that two imports of
+// the same module are equal.
+type LoxModule struct {
+	Name    LoxString
+	Exports map[LoxString]Value
+}
+
+func (*LoxModule) private() {}
+func (*LoxModule) Truthy() bool {
+	return true
+}
+
+func (m *LoxModule) String() string {
+	return fmt.Sprintf("<module %s>", m.Name)
+}
+
+// A list of values. Lists are only ever handled by pointer, so that a change
+// made through one reference is seen through all of them.
+type LoxList struct {
+	Elements []Value
+}
+
+func (*LoxList) private() {}
+func (*LoxList) Truthy() bool {
+	return true
+}
+
+func (l *LoxList) String() string {
+	elements := make([]string, len(l.Elements))
+	for i, e := range l.Elements {
+		elements[i] = fmt.Sprint(e)
+	}
+
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+// The integers from Start up to but not including End, counting up by one.
+// Ranges are compared by their bounds.
+type LoxRange struct {
+	Start, End LoxInt
+}
+
+func (LoxRange) private() {}
+func (LoxRange) Truthy() bool {
+	return true
+}
+
+func (r LoxRange) String() string {
+	return fmt.Sprintf("%v..%v", r.Start, r.End)
+}
+
+// How far a for-in loop has got through what it iterates over. Iterators
+// only ever live in a loop's hidden local, so scripts never see one.
+type LoxIterator struct {
+	// The next value, or false once there are none left.
+	Next func() (Value, bool)
+}
+
+func (*LoxIterator) private() {}
+func (*LoxIterator) Truthy() bool {
+	return true
+}
+
+func (*LoxIterator) String() string {
+	return "<iterator>"
+}
+
+// The value a catch clause receives for a runtime error raised by the
+// virtual machine itself rather than by a throw statement.
+type LoxError struct {
+	Message LoxString
+	Line    LoxInt
+}
+
+func (*LoxError) private() {}
+func (*LoxError) Truthy() bool {
+	return true
+}
+
+func (e *LoxError) String() string {
+	return fmt.Sprintf("<error %s>", e.Message)
+}
+
 type LoxFunc struct {
 	Args  []LoxString
+	// The number of arguments a call must pass; the parameters after them
+	// have default values.
+	MinArity int
+	// Whether the last of Args collects the rest of the arguments.
+	Variadic bool
 	Body  Chunk
 	Name  LoxString
+	// The variables of the functions around this one that its body uses,
+	// in the order of the upvalues of the closures made from it.
+	Captures []Capture
+}
+
+// Where a closure gets a variable it captures from when it is created: a
+// local of the function that creates it, or one of that function's own
+// upvalues.
+type Capture struct {
+	Local bool
+	Index int
 }
 
 func NewLoxFunc(name string) LoxFunc {
 	return LoxFunc{
-		Args: make([]LoxString, 5),
+		Args: make([]LoxString, 0, 5),
 		Body: NewChunk(),
         Name: LoxString(name),
 	}
@@ -96,16 +317,96 @@ func (f LoxFunc) Arity() int {
     return len(f.Args)
 }
 
-func (v *LoxMap) Insert(s LoxString, val Value) {
-	(*LinearProbingHashMap)(v).Insert(s, val)
+// A function together with the variables it captured when it was created.
+// Closures made by the same scope share the variables they capture.
+type LoxClosure struct {
+	Func     LoxFunc
+	Upvalues []*Upvalue
+}
+
+func (*LoxClosure) private() {}
+func (*LoxClosure) Truthy() bool {
+	return true
+}
+
+// A built-in method of a list or map that was looked up without being
+// called, bound to the list or map it was looked up on.
+type LoxBuiltinMethod struct {
+	Receiver Value
+	Name     LoxString
+}
+
+func (*LoxBuiltinMethod) private() {}
+func (*LoxBuiltinMethod) Truthy() bool {
+	return true
+}
+
+func (*LoxBuiltinMethod) String() string {
+	return "<native fn>"
+}
+
+// A function built into the VM, such as bigint. Fn returns an error instead
+// if the call fails, which is reported at the call.
+type LoxNative struct {
+	Name  LoxString
+	Arity int
+	Fn    func(args []Value) (Value, error)
+}
+
+func (*LoxNative) private() {}
+func (*LoxNative) Truthy() bool {
+	return true
+}
+
+func (*LoxNative) String() string {
+	return "<native fn>"
+}
+
+func (c *LoxClosure) String() string {
+	if c.Func.Name == "" {
+		return "<fn lambda>"
+	}
+	return fmt.Sprintf("<fn %s>", c.Func.Name)
+}
+
+// A variable captured by a closure. Until the scope that declared it ends,
+// the variable lives on the stack at Slot; then it is closed, and its value
+// moves into the upvalue.
+type Upvalue struct {
+	Slot   int
+	Closed bool
+	Value  Value
+}
+
+func (v *LoxMap) Insert(key Value, val Value) {
+	(*LinearProbingHashMap)(v).Insert(key, val)
+}
+
+func (v *LoxMap) Get(key Value) (Value, error) {
+	return (*LinearProbingHashMap)(v).Get(key)
 }
 
-func (v *LoxMap) Get(s LoxString) (Value, error) {
-	return (*LinearProbingHashMap)(v).Get(s)
+func (v *LoxMap) Delete(key Value) {
+	(*LinearProbingHashMap)(v).Delete(key)
 }
 
-func (v *LoxMap) Delete(s LoxString) {
-	(*LinearProbingHashMap)(v).Delete(s)
+func (v *LoxMap) Len() int {
+	return (*LinearProbingHashMap)(v).Len()
+}
+
+// The keys of the map, in the order they were first inserted.
+func (v *LoxMap) Keys() []Value {
+	return (*LinearProbingHashMap)(v).Keys()
+}
+
+func (v *LoxMap) String() string {
+	entries := []string{}
+	for _, key := range v.Keys() {
+		val, _ := v.Get(key)
+		entries = append(entries, fmt.Sprintf("%v: %v", key, val))
+	}
+
+	return "{" + strings.Join(entries, ", ") + "}"
 }
 
 func (vs ValueSlice) String() string {
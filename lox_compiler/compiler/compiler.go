@@ -5,19 +5,40 @@ import (
 	"lox-compiler/bytecode"
 	"lox-compiler/debug"
 	"lox-compiler/parser"
+	"lox-compiler/source"
 	"math"
+	"os"
 )
 
 const maxLocals int = math.MaxUint8
 
 type CompilationError struct {
-	err string
+	err  string
+	span source.Span
 }
 
 func (e CompilationError) Error() string {
 	return fmt.Sprintf("compilation error: %s", e.err)
 }
 
+func (e CompilationError) Message() string {
+	return e.err
+}
+
+// The source text of the node that couldn't be compiled, or a zero span if
+// the error isn't about any one node.
+func (e CompilationError) Span() source.Span {
+	return e.span
+}
+
+// Attribute the error to span unless a nested node has already claimed it.
+// Safe to call on a nil error.
+func (e *CompilationError) at(span source.Span) {
+	if e != nil && e.span.IsZero() {
+		e.span = span
+	}
+}
+
 type Compiler struct {
 	rootChunk       *bytecode.Chunk
 	curChunk        *bytecode.Chunk
@@ -25,22 +46,72 @@ type Compiler struct {
 	localCount      int
 	scopeDepth      int
 	locals          [maxLocals]local
+	// The loops being compiled, innermost last.
+	loops []loop
+	// The try statements being compiled, innermost last.
+	tries []tryContext
+	// The compiler of the function around the one being compiled, or nil
+	// for the top level.
+	enclosing *Compiler
+	// The variables of the functions around it that the function being
+	// compiled uses.
+	captures []bytecode.Capture
 }
 
 type local struct {
 	name  parser.Token
 	depth int
+	// Whether a closure captures the variable, so that it has to be moved
+	// off the stack when its scope ends.
+	captured bool
 }
 
-func (c *Compiler) Compile(source string) (*bytecode.Chunk, *CompilationError) {
-	s := parser.NewScanner(source)
-	tokens, err := s.ScanTokens()
-	if err != nil {
-		return nil, &CompilationError{err: err.Error()}
+type loop struct {
+	// The number of locals when the loop started, which break and continue
+	// pop back down to.
+	localCount int
+	// The number of try statements being compiled when the loop started.
+	tries     int
+	breaks    []forwardJump
+	continues []forwardJump
+}
+
+// What leaving the part of a try statement being compiled with break or
+// continue has to undo.
+type tryContext struct {
+	// The number of handlers the VM has for the statement at this point.
+	handlers int
+	// The finally clause to run on the way out, or nil.
+	finally *parser.Block
+	// Set inside the finally clause itself, which can't be left that way.
+	inFinally bool
+}
+
+func (c *Compiler) Compile(src string) (*bytecode.Chunk, *CompilationError) {
+	s := parser.NewScanner(src)
+	tokens, scanErr := s.ScanTokens()
+	if scanErr != nil {
+		return nil, &CompilationError{err: scanErr.Error()}
 	}
 	p := parser.NewParser(tokens)
-	ast := p.Parse()
+	ast, err := p.Parse()
+	if err != nil {
+		// Print every syntax error so they can all be fixed in one go.
+		for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+			fmt.Fprintln(os.Stderr, e)
+			if pErr, ok := e.(*parser.ParseError); ok {
+				fmt.Fprint(os.Stderr, source.Render(src, pErr.Span()))
+			}
+		}
+		return nil, &CompilationError{err: "the source has syntax errors"}
+	}
 	debug.Printf("%v", tokens)
+
+	return c.CompileAST(ast)
+}
+
+// Compile a program that has already been parsed without errors.
+func (c *Compiler) CompileAST(ast []parser.Statement) (*bytecode.Chunk, *CompilationError) {
 	debug.Printf("%s", ast)
 	compilationErr := c.compileFromAST(ast)
 	debug.Printf("%s", *c.rootChunk)
@@ -62,22 +133,40 @@ func (c *Compiler) compileFromAST(nodes []parser.ASTNode) *CompilationError {
 	return nil
 }
 
-func (c *Compiler) compileStmt(stmt parser.Statement) *CompilationError {
+func (c *Compiler) compileStmt(stmt parser.Statement) (err *CompilationError) {
+	defer c.locate(len(c.curChunk.InstructionSlice), stmt.SourceSpan())
+	defer func() { err.at(stmt.SourceSpan()) }()
 	switch v := stmt.(type) {
 	case parser.Block:
 		return c.compileBlock(v)
+	case parser.Break:
+		return c.compileLoopJump(v.Keyword)
 	case parser.Class:
 		return c.compileClass(v)
+	case parser.Continue:
+		return c.compileLoopJump(v.Keyword)
+	case parser.Export:
+		return c.compileExport(v)
 	case parser.ExpressionStmt:
 		return c.compileExpressionStmt(v)
+	case parser.For:
+		return c.compileStmt(v.Desugar())
+	case parser.ForIn:
+		return c.compileForIn(v)
 	case parser.Function:
 		return c.compileFunction(v)
 	case parser.If:
 		return c.compileIf(v)
+	case parser.Import:
+		return c.compileImport(v)
 	case parser.Print:
 		return c.compilePrint(v)
 	case parser.Return:
 		return c.compileReturn(v)
+	case parser.Throw:
+		return c.compileThrow(v)
+	case parser.Try:
+		return c.compileTry(v)
 	case parser.Var:
 		return c.compileVar(v)
 	case parser.While:
@@ -87,7 +176,9 @@ func (c *Compiler) compileStmt(stmt parser.Statement) *CompilationError {
 	return &CompilationError{err: "expected a statement"}
 }
 
-func (c *Compiler) compileExpr(e parser.Expr) *CompilationError {
+func (c *Compiler) compileExpr(e parser.Expr) (err *CompilationError) {
+	defer c.locate(len(c.curChunk.InstructionSlice), e.SourceSpan())
+	defer func() { err.at(e.SourceSpan()) }()
 	switch v := e.(type) {
 	case parser.Assign:
 		return c.compileAssign(v)
@@ -105,8 +196,22 @@ func (c *Compiler) compileExpr(e parser.Expr) *CompilationError {
 		return c.compileLogical(v)
 	case parser.Unary:
 		return c.compileUnary(v)
+	case parser.List:
+		return c.compileList(v)
+	case parser.Map:
+		return c.compileMap(v)
+	case parser.Range:
+		return c.compileRange(v)
+	case parser.Interpolation:
+		return c.compileInterpolation(v)
+	case parser.Lambda:
+		return c.compileLambda(v)
 	case parser.Set:
 		return c.compileSet(v)
+	case parser.Subscript:
+		return c.compileSubscript(v)
+	case parser.SubscriptSet:
+		return c.compileSubscriptSet(v)
 	case parser.Super:
 		return c.compileSuper(v)
 	case parser.This:
@@ -118,6 +223,21 @@ func (c *Compiler) compileExpr(e parser.Expr) *CompilationError {
 	return &CompilationError{err: "expected an expression"}
 }
 
+// Attribute the instructions emitted since start that no nested node has
+// claimed to span, so that runtime errors can point at the source.
+func (c *Compiler) locate(start int, span source.Span) {
+	insts := c.curChunk.InstructionSlice
+	for i := start; i < len(insts); i++ {
+		if !insts[i].Span.IsZero() {
+			continue
+		}
+		insts[i].Span = span
+		if insts[i].SourceLineNumer == 0 {
+			insts[i].SourceLineNumer = span.Start.Line
+		}
+	}
+}
+
 func (c *Compiler) compileBlock(stmt parser.Block) *CompilationError {
 	c.beginScope()
 	defer c.endScope()
@@ -137,16 +257,46 @@ func (c *Compiler) beginScope() {
 func (c *Compiler) endScope() {
 	c.scopeDepth--
 	for c.localCount > 0 && (c.locals[c.localCount-1].depth > c.scopeDepth) {
-		// pop
-		c.curChunk.AddInst(bytecode.NewInst(bytecode.OpPop, -1))
+		c.popLocal(c.localCount-1, -1)
 		c.localCount--
 	}
 }
 
+// Pop the local in slot i off the top of the stack, closing it over first if
+// a closure captured it.
+func (c *Compiler) popLocal(i int, line int) {
+	code := bytecode.OpPop
+	if c.locals[i].captured {
+		code = bytecode.OpCloseUpvalue
+	}
+	c.curChunk.AddInst(bytecode.NewInst(code, line))
+}
+
 func (c *Compiler) compileClass(stmt parser.Class) *CompilationError {
 	return &CompilationError{err: "compiling `Class` statements is not implemented"}
 }
 
+func (c *Compiler) compileExport(stmt parser.Export) *CompilationError {
+	if err := c.compileStmt(stmt.Declaration); err != nil {
+		return err
+	}
+
+	var name parser.Token
+	switch d := stmt.Declaration.(type) {
+	case parser.Var:
+		name = d.Name
+	case parser.Function:
+		name = d.Name
+	case parser.Class:
+		name = d.Name
+	default:
+		return &CompilationError{err: "can only export variables, functions and classes"}
+	}
+	c.rootChunk.Exports = append(c.rootChunk.Exports, bytecode.LoxString(name.Lexeme))
+
+	return nil
+}
+
 func (c *Compiler) compileExpressionStmt(stmt parser.ExpressionStmt) *CompilationError {
 	err := c.compileExpr(stmt.Val)
 	if err != nil {
@@ -164,79 +314,108 @@ func (c *Compiler) compileExpressionStmt(stmt parser.ExpressionStmt) *Compilatio
 }
 
 func (c *Compiler) compileFunction(stmt parser.Function) *CompilationError {
-	curChunk := c.curChunk
-	newFunc := bytecode.NewLoxFunc(stmt.Name.Lexeme)
+	if c.scopeDepth > 0 {
+		if err := c.checkForNameRedefinition(stmt.Name); err != nil {
+			return err
+		}
+		// Declared before the body is compiled, so that the function can
+		// call itself.
+		if err := c.addLocal(stmt.Name); err != nil {
+			return err
+		}
+		return c.compileClosure(stmt, stmt.Name.Line)
+	}
 
-	for _, param := range stmt.Params {
-		newFunc.Args = append(newFunc.Args, bytecode.LoxString(param.Lexeme))
+	// Define a global holding the function, the way a var declaration
+	// would.
+	nameIndex := c.curChunk.AddConstant(bytecode.LoxString(stmt.Name.Lexeme))
+	c.curChunk.AddInst(bytecode.NewConstantInst(bytecode.Operand(nameIndex), stmt.Name.Line))
+	c.curChunk.AddInst(bytecode.NewInst(bytecode.OpDeclareGlobal, stmt.Name.Line))
+	if err := c.compileClosure(stmt, stmt.Name.Line); err != nil {
+		return err
 	}
+	c.curChunk.AddInst(bytecode.NewConstantInst(bytecode.Operand(nameIndex), stmt.Name.Line))
+	c.curChunk.AddInst(bytecode.NewInst(bytecode.OpAssign, stmt.Name.Line))
+	c.curChunk.AddInst(bytecode.NewInst(bytecode.OpPop, stmt.Name.Line))
 
-	c.curChunk = &newFunc.Body
+	return nil
+}
 
-	for _, param := range stmt.Params {
-		if err := c.checkForNameRedefinition(param); err != nil {
+// Compile a function's body into a constant of its own, and the instruction
+// that makes a closure of it.
+func (c *Compiler) compileClosure(stmt parser.Function, line int) *CompilationError {
+	newFunc := bytecode.NewLoxFunc(stmt.Name.Lexeme)
+	// The body has its own locals, counted from the bottom of the call's
+	// part of the stack. Loops and try statements around the declaration
+	// don't reach into it.
+	fc := &Compiler{rootChunk: c.rootChunk, curChunk: &newFunc.Body, scopeDepth: 1, enclosing: c}
+
+	newFunc.MinArity, _ = stmt.Arity()
+	newFunc.Variadic = stmt.Variadic
+	for i, param := range stmt.Params {
+		newFunc.Args = append(newFunc.Args, bytecode.LoxString(param.Lexeme))
+		if err := fc.checkForNameRedefinition(param); err != nil {
 			return err
 		}
+		// A default value can use the parameters before its own, so it is
+		// compiled before the parameter is declared.
+		if i < len(stmt.Defaults) && stmt.Defaults[i] != nil {
+			if err := fc.compileDefault(i, stmt.Defaults[i], param.Line); err != nil {
+				return err
+			}
+		}
 		// By C-calling-convention, the caller will push the args to the
 		// stack in reverse order.
-		if err := c.addLocal(param); err != nil {
+		if err := fc.addLocal(param); err != nil {
 			return err
 		}
 	}
 
 	for _, s := range stmt.Body {
-		if err := c.compileStmt(s); err != nil {
+		if err := fc.compileStmt(s); err != nil {
 			return err
 		}
 	}
+	// Falling off the end of the body returns nil.
+	fc.compileReturnValue(nil, line)
+	newFunc.Captures = fc.captures
 
-	c.curChunk = curChunk
 	funcIndex := c.curChunk.AddConstant(newFunc)
-	c.curChunk.AddInst(bytecode.NewConstantInst(bytecode.Operand(funcIndex), stmt.Name.Line))
-	if c.scopeDepth > 0 {
-		if err := c.checkForNameRedefinition(stmt.Name); err != nil {
-			return err
-		}
-		return c.addLocal(stmt.Name)
-	} else {
-		// compile global
-		// declare a global Variable
-		nameIndex := c.curChunk.AddConstant(
-			bytecode.LoxString(stmt.Name.Lexeme),
-		)
-		c.curChunk.AddInst(
-			bytecode.NewConstantInst(
-				bytecode.Operand(nameIndex),
-				stmt.Name.Line,
-			),
-		)
-		c.curChunk.AddInst(
-			bytecode.Instruction{Code: bytecode.OpDeclareGlobal, SourceLineNumer: stmt.Name.Line},
-		)
+	c.curChunk.AddInst(
+		bytecode.Instruction{
+			Code:            bytecode.OpClosure,
+			Operands:        bytecode.OperandArray{bytecode.Operand(funcIndex)},
+			SourceLineNumer: line,
+		},
+	)
 
-		// if there's an Initializer
-		// evaluate Initializer
-		c.curChunk.AddInst(
-			bytecode.NewConstantInst(
-				bytecode.Operand(funcIndex),
-				stmt.Name.Line,
-			),
-		)
-		c.curChunk.AddInst(
-			bytecode.NewConstantInst(
-				bytecode.Operand(nameIndex),
-				stmt.Name.Line,
-			),
-		)
-		c.curChunk.AddInst(
-			bytecode.Instruction{Code: bytecode.OpAssign, SourceLineNumer: stmt.Name.Line},
-		)
+	return nil
+}
 
-		return nil
+// Compile the start of a function body that gives the parameter in slot its
+// default value when the call passes no argument for it.
+func (c *Compiler) compileDefault(slot int, value parser.Expr, line int) *CompilationError {
+	c.curChunk.AddInst(
+		bytecode.Instruction{
+			Code:            bytecode.OpArgMissing,
+			Operands:        bytecode.OperandArray{bytecode.Operand(slot)},
+			SourceLineNumer: line,
+		},
+	)
+	_, skipIndex := c.addConditionalJmp()
+	start := len(c.curChunk.InstructionSlice)
+	if err := c.compileExpr(value); err != nil {
+		return err
 	}
-	// if err := c.addLocal(stmt.Name); err != nil {
-	// 	return err
-	// }
+	c.curChunk.AddInst(
+		bytecode.Instruction{
+			Code:            bytecode.OpLocalAssign,
+			Operands:        bytecode.OperandArray{bytecode.Operand(slot)},
+			SourceLineNumer: line,
+		},
+	)
+	c.curChunk.AddInst(bytecode.Instruction{Code: bytecode.OpPop, SourceLineNumer: line})
+	c.backpatchIndex(skipIndex, len(c.curChunk.InstructionSlice)-start)
 
 	return nil
 }
@@ -272,15 +451,205 @@ func (c *Compiler) compileIf(stmt parser.If) *CompilationError {
 	return nil
 }
 
+func (c *Compiler) compileImport(stmt parser.Import) *CompilationError {
+	line := stmt.Path.Line
+	pathIndex := c.curChunk.AddConstant(bytecode.LoxString(stmt.Path.Literal.(string)))
+	if stmt.Name == nil {
+		// Define each of the module's exports as a global.
+		c.curChunk.AddInst(bytecode.NewConstantInst(bytecode.Operand(pathIndex), line))
+		c.curChunk.AddInst(bytecode.NewInst(bytecode.OpImport, line))
+		c.curChunk.AddInst(bytecode.NewInst(bytecode.OpImportAll, line))
+		return nil
+	}
+
+	// Define a global holding the module, the way a var declaration would.
+	nameIndex := c.curChunk.AddConstant(bytecode.LoxString(stmt.Name.Lexeme))
+	c.curChunk.AddInst(bytecode.NewConstantInst(bytecode.Operand(nameIndex), line))
+	c.curChunk.AddInst(bytecode.NewInst(bytecode.OpDeclareGlobal, line))
+	c.curChunk.AddInst(bytecode.NewConstantInst(bytecode.Operand(pathIndex), line))
+	c.curChunk.AddInst(bytecode.NewInst(bytecode.OpImport, line))
+	c.curChunk.AddInst(bytecode.NewConstantInst(bytecode.Operand(nameIndex), line))
+	c.curChunk.AddInst(bytecode.NewInst(bytecode.OpAssign, line))
+	c.curChunk.AddInst(bytecode.NewInst(bytecode.OpPop, line))
+
+	return nil
+}
+
 func (c *Compiler) compilePrint(stmt parser.Print) *CompilationError {
-	c.compileExpr(stmt.Val)
+	if err := c.compileExpr(stmt.Val); err != nil {
+		return err
+	}
 	c.curChunk.AddInst(bytecode.NewPrintInst(0))
 
 	return nil
 }
 
 func (c *Compiler) compileReturn(stmt parser.Return) *CompilationError {
-	return &CompilationError{err: "compiling `Return` statements is not implemented"}
+	if c.enclosing == nil {
+		return &CompilationError{err: "Can't return from top-level code."}
+	}
+
+	if len(c.tries) == 0 {
+		return c.compileReturnValue(stmt.Return_expr, stmt.Start.Line)
+	}
+
+	// Keep the value on the stack, as a local the finally clauses can't
+	// name, while the try statements around the return are ended.
+	if stmt.Return_expr == nil {
+		c.curChunk.AddInst(bytecode.NewConstantInst(bytecode.Operand(c.curChunk.AddConstant(bytecode.LoxNil(0))), stmt.Start.Line))
+	} else if err := c.compileExpr(stmt.Return_expr); err != nil {
+		return err
+	}
+	if err := c.addLocal(parser.Token{Lexeme: "return value"}); err != nil {
+		return err
+	}
+	err := c.leaveTries(parser.Token{Token_type: parser.RETURN, Lexeme: "return", Line: stmt.Start.Line}, 0)
+	c.localCount--
+	if err != nil {
+		return err
+	}
+	c.curChunk.AddInst(bytecode.NewReturnInst(stmt.Start.Line))
+
+	return nil
+}
+
+// Return the value of e, or nil if e is nil, from the function being
+// compiled.
+func (c *Compiler) compileReturnValue(e parser.Expr, line int) *CompilationError {
+	if e == nil {
+		c.curChunk.AddInst(bytecode.NewConstantInst(bytecode.Operand(c.curChunk.AddConstant(bytecode.LoxNil(0))), line))
+	} else if err := c.compileExpr(e); err != nil {
+		return err
+	}
+	c.curChunk.AddInst(bytecode.NewReturnInst(line))
+
+	return nil
+}
+
+func (c *Compiler) compileThrow(stmt parser.Throw) *CompilationError {
+	if err := c.compileExpr(stmt.Value); err != nil {
+		return err
+	}
+	c.curChunk.AddInst(bytecode.NewInst(bytecode.OpThrow, stmt.Keyword.Line))
+
+	return nil
+}
+
+// The VM starts a catch clause with the exception on top of the stack,
+// where the clause finds it as its variable. The finally clause is compiled
+// twice: once for when the statement completes, and once for when an
+// exception escapes it, which is thrown again afterwards.
+func (c *Compiler) compileTry(stmt parser.Try) *CompilationError {
+	line := stmt.Span.Start.Line
+	handler := c.addTry(line, stmt.CatchName == nil)
+	if err := c.inTry(tryContext{handlers: 1, finally: stmt.Finally}, stmt.Body); err != nil {
+		return err
+	}
+	c.curChunk.AddInst(bytecode.NewInst(bytecode.OpEndTry, line))
+	done := []forwardJump{c.addForwardJmp()}
+	c.land(handler)
+
+	if stmt.CatchName != nil {
+		c.beginScope()
+		if err := c.addLocal(*stmt.CatchName); err != nil {
+			return err
+		}
+		var finallyHandler forwardJump
+		catch := tryContext{finally: stmt.Finally}
+		if stmt.Finally != nil {
+			// The finally clause runs even if the catch clause throws.
+			finallyHandler = c.addTry(line, true)
+			catch.handlers = 1
+		}
+		if err := c.inTry(catch, *stmt.Catch); err != nil {
+			return err
+		}
+		if stmt.Finally == nil {
+			c.endScope()
+			c.land(done[0])
+			return nil
+		}
+		c.curChunk.AddInst(bytecode.NewInst(bytecode.OpEndTry, line))
+		// Leave the catch variable's scope on this path only; below it
+		// stays open because the variable is still on the stack.
+		c.popLocal(c.localCount-1, line)
+		done = append(done, c.addForwardJmp())
+		c.land(finallyHandler)
+	}
+
+	if err := c.inTry(tryContext{inFinally: true}, *stmt.Finally); err != nil {
+		return err
+	}
+	c.curChunk.AddInst(bytecode.NewInst(bytecode.OpRethrow, line))
+	if stmt.CatchName != nil {
+		c.endScope()
+	}
+
+	for _, j := range done {
+		c.land(j)
+	}
+
+	return c.inTry(tryContext{inFinally: true}, *stmt.Finally)
+}
+
+// Compile a part of a try statement.
+func (c *Compiler) inTry(t tryContext, block parser.Block) *CompilationError {
+	c.tries = append(c.tries, t)
+	defer func() { c.tries = c.tries[:len(c.tries)-1] }()
+
+	return c.compileBlock(block)
+}
+
+// Jump out of the innermost loop, or to where it continues. On the way, end
+// the try statements inside the loop, running their finally clauses, and pop
+// the locals declared since the loop started.
+func (c *Compiler) compileLoopJump(keyword parser.Token) *CompilationError {
+	if len(c.loops) == 0 {
+		return &CompilationError{err: fmt.Sprintf("Can't use '%s' outside of a loop.", keyword.Lexeme)}
+	}
+	l := &c.loops[len(c.loops)-1]
+	if err := c.leaveTries(keyword, l.tries); err != nil {
+		return err
+	}
+	for i := c.localCount; i > l.localCount; i-- {
+		c.popLocal(i-1, keyword.Line)
+	}
+
+	// The loop may have been moved by a loop compiled in a finally clause.
+	l = &c.loops[len(c.loops)-1]
+	if keyword.Token_type == parser.BREAK {
+		l.breaks = append(l.breaks, c.addForwardJmp())
+	} else {
+		l.continues = append(l.continues, c.addForwardJmp())
+	}
+
+	return nil
+}
+
+// End the try statements from depth in, innermost first, running their
+// finally clauses, for keyword to jump out of them.
+func (c *Compiler) leaveTries(keyword parser.Token, depth int) *CompilationError {
+	for i := len(c.tries) - 1; i >= depth; i-- {
+		t := c.tries[i]
+		if t.inFinally {
+			return &CompilationError{err: fmt.Sprintf("Can't use '%s' to leave a finally clause.", keyword.Lexeme)}
+		}
+		for j := 0; j < t.handlers; j++ {
+			c.curChunk.AddInst(bytecode.NewInst(bytecode.OpEndTry, keyword.Line))
+		}
+		if t.finally != nil {
+			// The try statements outside this one are still running.
+			tries := c.tries
+			c.tries = append(c.tries[:i:i], tryContext{inFinally: true})
+			err := c.compileBlock(*t.finally)
+			c.tries = tries
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
 }
 
 func (c *Compiler) compileVar(stmt parser.Var) *CompilationError {
@@ -362,15 +731,84 @@ func (c *Compiler) addLocal(name parser.Token) *CompilationError {
 func (c *Compiler) compileWhile(stmt parser.While) *CompilationError {
 	curLen := len(c.curChunk.InstructionSlice)
 	if err := c.compileExpr(stmt.Conditional); err != nil {
-		return nil
+		return err
 	}
 	_, falseJmpOffsetIndex := c.addConditionalJmp()
-	if err := c.compileStmt(stmt.Stmt); err != nil {
-		return nil
+	exit := forwardJump{offsetIndex: falseJmpOffsetIndex, from: len(c.curChunk.InstructionSlice)}
+
+	c.loops = append(c.loops, loop{localCount: c.localCount, tries: len(c.tries)})
+	err := c.compileStmt(stmt.Stmt)
+	l := c.loops[len(c.loops)-1]
+	c.loops = c.loops[:len(c.loops)-1]
+	if err != nil {
+		return err
+	}
+
+	for _, j := range l.continues {
+		c.land(j)
+	}
+	if stmt.Increment != nil {
+		if err := c.compileExpr(stmt.Increment); err != nil {
+			return err
+		}
+		c.curChunk.AddInst(bytecode.NewInst(bytecode.OpPop, 0))
 	}
 	whileLoopTopOffsetIndex := c.addJmp()
 	c.backpatchIndex(whileLoopTopOffsetIndex, curLen-len(c.curChunk.InstructionSlice))
-	c.backpatchIndex(falseJmpOffsetIndex, len(c.curChunk.InstructionSlice)-curLen+1)
+	c.land(exit)
+	for _, j := range l.breaks {
+		c.land(j)
+	}
+
+	return nil
+}
+
+// The iterator the loop goes through lives on the stack as a local that no
+// script can name, under the loop variable, which is a fresh local on every
+// pass.
+func (c *Compiler) compileForIn(stmt parser.ForIn) *CompilationError {
+	line := stmt.Span.Start.Line
+	if err := c.compileExpr(stmt.Iterable); err != nil {
+		return err
+	}
+	iterate := bytecode.NewInst(bytecode.OpIterate, line)
+	iterate.Span = stmt.Iterable.SourceSpan()
+	c.curChunk.AddInst(iterate)
+	c.beginScope()
+	if err := c.addLocal(parser.Token{Lexeme: "for iterator"}); err != nil {
+		return err
+	}
+
+	top := len(c.curChunk.InstructionSlice)
+	offsetIndex := c.curChunk.AddConstant(bytecode.LoxInt(0))
+	next := bytecode.NewInst(bytecode.OpIterNext, line)
+	next.Operands[0] = bytecode.Operand(offsetIndex)
+	c.curChunk.AddInst(next)
+	exit := forwardJump{offsetIndex: offsetIndex, from: len(c.curChunk.InstructionSlice)}
+
+	c.loops = append(c.loops, loop{localCount: c.localCount, tries: len(c.tries)})
+	c.beginScope()
+	err := c.addLocal(stmt.Name)
+	if err == nil {
+		err = c.compileStmt(stmt.Stmt)
+	}
+	c.endScope()
+	l := c.loops[len(c.loops)-1]
+	c.loops = c.loops[:len(c.loops)-1]
+	if err != nil {
+		return err
+	}
+
+	for _, j := range l.continues {
+		c.land(j)
+	}
+	loopTopOffsetIndex := c.addJmp()
+	c.backpatchIndex(loopTopOffsetIndex, top-len(c.curChunk.InstructionSlice))
+	c.land(exit)
+	for _, j := range l.breaks {
+		c.land(j)
+	}
+	c.endScope()
 
 	return nil
 }
@@ -390,6 +828,16 @@ func (c *Compiler) compileAssign(e parser.Assign) *CompilationError {
 		)
 		return nil
 	}
+	if i := c.resolveUpvalue(e.Name); i >= 0 {
+		c.curChunk.AddInst(
+			bytecode.Instruction{
+				Code:            bytecode.OpSetUpvalue,
+				Operands:        bytecode.OperandArray{bytecode.Operand(i)},
+				SourceLineNumer: e.Name.Line,
+			},
+		)
+		return nil
+	}
 	// store var Name
 	c.curChunk.AddInst(
 		bytecode.NewConstantInst(
@@ -419,6 +867,7 @@ func (c *Compiler) compileBinary(e parser.Binary) *CompilationError {
 		parser.MINUS:         bytecode.OpSubtract,
 		parser.STAR:          bytecode.OpMultiply,
 		parser.SLASH:         bytecode.OpDivide,
+		parser.PERCENT:       bytecode.OpModulo,
 	}
 	err := c.compileExpr(e.Left)
 	if err != nil {
@@ -440,12 +889,88 @@ func (c *Compiler) compileBinary(e parser.Binary) *CompilationError {
 	return nil
 }
 
+// A method call compiles to OpInvoke, and only reaches the built-in methods
+// of values such as lists. Any other call pushes the callee and then its
+// arguments, which OpCall makes the first locals of the callee's frame. A
+// call with named arguments pushes their values after the positional ones,
+// then their names, for OpCallNamed to put in the slots of the parameters
+// they name.
 func (c *Compiler) compileCall(e parser.Call) *CompilationError {
-	return &CompilationError{err: "compiling Call expression is not implemented"}
+	if len(e.Args)+len(e.Named) > math.MaxUint8 {
+		return &CompilationError{err: "Can't have more than 255 arguments."}
+	}
+	get, ok := e.Callee.(parser.Get)
+	if !ok {
+		if err := c.compileExpr(e.Callee); err != nil {
+			return err
+		}
+		for _, arg := range e.Args {
+			if err := c.compileExpr(arg); err != nil {
+				return err
+			}
+		}
+		if len(e.Named) == 0 {
+			inst := bytecode.NewInst(bytecode.OpCall, e.Paren.Line)
+			inst.Operands[0] = bytecode.Operand(len(e.Args))
+			c.curChunk.AddInst(inst)
+			return nil
+		}
+
+		for _, arg := range e.Named {
+			if err := c.compileExpr(arg.Value); err != nil {
+				return err
+			}
+		}
+		for _, arg := range e.Named {
+			c.curChunk.AddInst(bytecode.NewConstantInst(bytecode.Operand(c.curChunk.AddConstant(bytecode.LoxString(arg.Name.Lexeme))), arg.Name.Line))
+		}
+		inst := bytecode.NewInst(bytecode.OpCallNamed, e.Paren.Line)
+		inst.Operands = bytecode.OperandArray{bytecode.Operand(len(e.Args)), bytecode.Operand(len(e.Named))}
+		c.curChunk.AddInst(inst)
+		return nil
+	}
+	if len(e.Named) > 0 {
+		return &CompilationError{err: "Built-in methods don't take named arguments."}
+	}
+
+	if err := c.compileExpr(get.Object); err != nil {
+		return err
+	}
+	for _, arg := range e.Args {
+		if err := c.compileExpr(arg); err != nil {
+			return err
+		}
+	}
+	c.curChunk.AddInst(
+		bytecode.NewConstantInst(
+			bytecode.Operand(c.curChunk.AddConstant(
+				bytecode.LoxString(get.Name.Lexeme),
+			)),
+			get.Name.Line,
+		),
+	)
+	inst := bytecode.NewInst(bytecode.OpInvoke, e.Paren.Line)
+	inst.Operands[0] = bytecode.Operand(len(e.Args))
+	c.curChunk.AddInst(inst)
+
+	return nil
 }
 
 func (c *Compiler) compileGet(e parser.Get) *CompilationError {
-	return &CompilationError{err: "compiling Get expression is not implemented"}
+	if err := c.compileExpr(e.Object); err != nil {
+		return err
+	}
+	c.curChunk.AddInst(
+		bytecode.NewConstantInst(
+			bytecode.Operand(c.curChunk.AddConstant(
+				bytecode.LoxString(e.Name.Lexeme),
+			)),
+			e.Name.Line,
+		),
+	)
+	c.curChunk.AddInst(bytecode.NewInst(bytecode.OpGetProperty, e.Name.Line))
+
+	return nil
 }
 
 func (c *Compiler) compileGrouping(e parser.Grouping) *CompilationError {
@@ -506,6 +1031,112 @@ func (c *Compiler) compileSet(e parser.Set) *CompilationError {
 	return &CompilationError{err: "compiling Set expression is not implemented"}
 }
 
+func (c *Compiler) compileList(e parser.List) *CompilationError {
+	if len(e.Elements) > math.MaxUint8 {
+		return &CompilationError{err: "Can't have more than 255 elements in a list literal."}
+	}
+	for _, element := range e.Elements {
+		if err := c.compileExpr(element); err != nil {
+			return err
+		}
+	}
+	inst := bytecode.NewInst(bytecode.OpBuildList, e.Bracket.Line)
+	inst.Operands[0] = bytecode.Operand(len(e.Elements))
+	c.curChunk.AddInst(inst)
+
+	return nil
+}
+
+func (c *Compiler) compileMap(e parser.Map) *CompilationError {
+	if len(e.Keys) > math.MaxUint8 {
+		return &CompilationError{err: "Can't have more than 255 entries in a map literal."}
+	}
+	for i := range e.Keys {
+		if err := c.compileExpr(e.Keys[i]); err != nil {
+			return err
+		}
+		if err := c.compileExpr(e.Values[i]); err != nil {
+			return err
+		}
+	}
+	inst := bytecode.NewInst(bytecode.OpBuildMap, e.Brace.Line)
+	inst.Operands[0] = bytecode.Operand(len(e.Keys))
+	c.curChunk.AddInst(inst)
+
+	return nil
+}
+
+func (c *Compiler) compileRange(e parser.Range) *CompilationError {
+	if err := c.compileExpr(e.Start); err != nil {
+		return err
+	}
+	if err := c.compileExpr(e.End); err != nil {
+		return err
+	}
+	c.curChunk.AddInst(bytecode.NewInst(bytecode.OpBuildRange, e.Operator.Line))
+
+	return nil
+}
+
+// The parts of the string are pushed in order, leaving out empty ones, and
+// OpBuildString joins them.
+func (c *Compiler) compileInterpolation(e parser.Interpolation) *CompilationError {
+	n := 0
+	for i, str := range e.Strings {
+		if s := str.Literal.(string); s != "" {
+			i := c.curChunk.AddConstant(bytecode.LoxString(s))
+			c.curChunk.AddInst(bytecode.NewConstantInst(bytecode.Operand(i), str.Line))
+			n++
+		}
+		if i < len(e.Exprs) {
+			if err := c.compileExpr(e.Exprs[i]); err != nil {
+				return err
+			}
+			n++
+		}
+	}
+	if n > math.MaxUint8 {
+		return &CompilationError{err: "Can't have more than 255 parts in an interpolated string."}
+	}
+	inst := bytecode.NewInst(bytecode.OpBuildString, e.Strings[0].Line)
+	inst.Operands[0] = bytecode.Operand(n)
+	c.curChunk.AddInst(inst)
+
+	return nil
+}
+
+func (c *Compiler) compileLambda(e parser.Lambda) *CompilationError {
+	return c.compileClosure(e.Decl, e.Keyword.Line)
+}
+
+func (c *Compiler) compileSubscript(e parser.Subscript) *CompilationError {
+	if err := c.compileExpr(e.Object); err != nil {
+		return err
+	}
+	if err := c.compileExpr(e.Index); err != nil {
+		return err
+	}
+	c.curChunk.AddInst(bytecode.NewInst(bytecode.OpIndex, e.Bracket.Line))
+
+	return nil
+}
+
+func (c *Compiler) compileSubscriptSet(e parser.SubscriptSet) *CompilationError {
+	if err := c.compileExpr(e.Object); err != nil {
+		return err
+	}
+	if err := c.compileExpr(e.Index); err != nil {
+		return err
+	}
+	if err := c.compileExpr(e.Value); err != nil {
+		return err
+	}
+	// Leaves the value on the stack as the result of the assignment.
+	c.curChunk.AddInst(bytecode.NewInst(bytecode.OpStoreIndex, e.Bracket.Line))
+
+	return nil
+}
+
 func (c *Compiler) compileSuper(e parser.Super) *CompilationError {
 	return &CompilationError{err: "compiling Super expression is not implemented"}
 }
@@ -519,11 +1150,50 @@ func (c *Compiler) compileVariable(e parser.Variable) *CompilationError {
 	if l != nil {
 		return c.compileLocalLookup(i)
 	}
+	if i := c.resolveUpvalue(e.Name); i >= 0 {
+		c.curChunk.AddInst(
+			bytecode.Instruction{
+				Code:            bytecode.OpGetUpvalue,
+				Operands:        bytecode.OperandArray{bytecode.Operand(i)},
+				SourceLineNumer: e.Name.Line,
+			},
+		)
+		return nil
+	}
 	return c.compileGlobalLookup(e)
 }
 
+// The index of the upvalue through which the function being compiled reaches
+// the local name of a function around it, or -1 if there is no such local.
+func (c *Compiler) resolveUpvalue(name parser.Token) int {
+	if c.enclosing == nil {
+		return -1
+	}
+	if l, i := c.enclosing.getLocalVar(name); l != nil {
+		l.captured = true
+		return c.addCapture(bytecode.Capture{Local: true, Index: i})
+	}
+	if i := c.enclosing.resolveUpvalue(name); i >= 0 {
+		return c.addCapture(bytecode.Capture{Index: i})
+	}
+
+	return -1
+}
+
+// Capture a variable, once however many times the function uses it.
+func (c *Compiler) addCapture(capture bytecode.Capture) int {
+	for i, existing := range c.captures {
+		if existing == capture {
+			return i
+		}
+	}
+	c.captures = append(c.captures, capture)
+
+	return len(c.captures) - 1
+}
+
 func (c *Compiler) getLocalVar(name parser.Token) (*local, int) {
-	for i := c.localCount; i >= 0; i-- {
+	for i := c.localCount - 1; i >= 0; i-- {
 		if c.locals[i].name.Lexeme == name.Lexeme {
 			return &c.locals[i], i
 		}
@@ -609,6 +1279,37 @@ func (c *Compiler) addJmp() (jmpIndex int) {
 	return jmpIndex
 }
 
+// A jump whose offset is backpatched once its target has been compiled.
+type forwardJump struct {
+	offsetIndex int
+	// The length of the chunk just after the jump instruction.
+	from int
+}
+
+func (c *Compiler) addForwardJmp() forwardJump {
+	return forwardJump{offsetIndex: c.addJmp(), from: len(c.curChunk.InstructionSlice)}
+}
+
+// Start a try statement whose handler is backpatched with land. A finally
+// handler runs a finally clause for the exception and then throws it again
+// with OpRethrow, rather than catching it.
+func (c *Compiler) addTry(line int, finally bool) forwardJump {
+	offsetIndex := c.curChunk.AddConstant(bytecode.LoxInt(0))
+	inst := bytecode.NewInst(bytecode.OpTry, line)
+	inst.Operands[0] = bytecode.Operand(offsetIndex)
+	if finally {
+		inst.Operands[1] = 1
+	}
+	c.curChunk.AddInst(inst)
+
+	return forwardJump{offsetIndex: offsetIndex, from: len(c.curChunk.InstructionSlice)}
+}
+
+// Make j jump to the next instruction to be compiled.
+func (c *Compiler) land(j forwardJump) {
+	c.backpatchIndex(j.offsetIndex, len(c.curChunk.InstructionSlice)-j.from)
+}
+
 func (c *Compiler) backpatchIndex(jmpOffsetIndex, val int) {
 	c.curChunk.Constants[jmpOffsetIndex] = bytecode.LoxInt(val)
 }
@@ -2,7 +2,10 @@ package compiler_test
 
 import (
 	"fmt"
+	"io"
+	"lox-compiler/bytecode"
 	"lox-compiler/compiler"
+	"os"
 	"strings"
 	"testing"
 )
@@ -66,6 +69,9 @@ func TestLocalRedefine(t *testing.T) {
         chunk.Disassemble("main")
         t.Fatalf("expected compilation to fail")
     }
+    if span := err.Span(); span.Start.Offset != 8 || span.End.Offset != 14 {
+        t.Fatalf("expected the error to point at the second declaration, got %v", span)
+    }
 }
 
 func TestExceedMaxLocalVars(t *testing.T) {
@@ -91,3 +97,105 @@ func TestIf(t *testing.T) {
 func TestWhile(t *testing.T) {
     test_compilation(t, "while (true) {print 1;}")
 }
+
+func TestClosureCaptures(t *testing.T) {
+    c := compiler.Compiler{}
+    chunk, err := c.Compile("{ var a = 1; var b = 2; var f = fun () { return fun () { return b + a + b; }; }; }")
+    if err != nil {
+        t.Fatalf("%s", err.Error())
+    }
+
+    var f bytecode.LoxFunc
+    for _, v := range chunk.Constants {
+        if fn, ok := v.(bytecode.LoxFunc); ok {
+            f = fn
+        }
+    }
+    // The outer function captures b and a for the inner one, which reaches
+    // them through the outer function's upvalues, once each.
+    expected := []bytecode.Capture{{Local: true, Index: 1}, {Local: true, Index: 0}}
+    if fmt.Sprint(f.Captures) != fmt.Sprint(expected) {
+        t.Fatalf("expected the outer function to capture %v, got %v", expected, f.Captures)
+    }
+    var inner bytecode.LoxFunc
+    for _, v := range f.Body.Constants {
+        if fn, ok := v.(bytecode.LoxFunc); ok {
+            inner = fn
+        }
+    }
+    expected = []bytecode.Capture{{Index: 0}, {Index: 1}}
+    if fmt.Sprint(inner.Captures) != fmt.Sprint(expected) {
+        t.Fatalf("expected the inner function to capture %v, got %v", expected, inner.Captures)
+    }
+
+    // The block closes over a and b rather than just popping them.
+    insts := chunk.InstructionSlice
+    var codes []bytecode.OpCode
+    for _, inst := range insts[len(insts)-3:] {
+        codes = append(codes, inst.Code)
+    }
+    if fmt.Sprint(codes) != fmt.Sprint([]bytecode.OpCode{bytecode.OpPop, bytecode.OpCloseUpvalue, bytecode.OpCloseUpvalue}) {
+        t.Fatalf("expected the block to pop f and close a and b, got %v", codes)
+    }
+}
+
+func TestReturnAtTopLevel(t *testing.T) {
+    c := compiler.Compiler{}
+    if _, err := c.Compile("return 1;"); err == nil || err.Message() != "Can't return from top-level code." {
+        t.Fatalf("expected a top-level return to be rejected, got %v", err)
+    }
+}
+
+func TestSyntaxErrors(t *testing.T) {
+    s := os.Stderr
+    r, w, _ := os.Pipe()
+    os.Stderr = w
+    defer func() { os.Stderr = s }()
+
+    c := compiler.Compiler{}
+    chunk, err := c.Compile("print;\nvar 1;\nprint 1;")
+    w.Close()
+    if err == nil {
+        chunk.Disassemble("main")
+        t.Fatalf("expected compilation to fail")
+    }
+
+    out, _ := io.ReadAll(r)
+    expected := `[line 1] Error at ';': Expect expression.
+   1 | print;
+     |      ^
+[line 2] Error at '1': Expect variable name.
+   2 | var 1;
+     |     ^
+`
+    if string(out) != expected {
+        t.Fatalf("expected every syntax error to be printed:\n%s\ngot:\n%s", expected, out)
+    }
+}
+
+func TestParameters(t *testing.T) {
+    c := compiler.Compiler{}
+    chunk, err := c.Compile("var f = fun (a, b = a, ...rest) { return rest; };")
+    if err != nil {
+        t.Fatalf("%s", err.Error())
+    }
+
+    var f bytecode.LoxFunc
+    for _, v := range chunk.Constants {
+        if fn, ok := v.(bytecode.LoxFunc); ok {
+            f = fn
+        }
+    }
+    if f.MinArity != 1 || !f.Variadic || fmt.Sprint(f.Args) != "[a b rest]" {
+        t.Fatalf("unexpected parameters %v, at least %d, variadic %v", f.Args, f.MinArity, f.Variadic)
+    }
+    // The body starts by giving b its default value if it wasn't passed.
+    if code := f.Body.InstructionSlice[0]; code.Code != bytecode.OpArgMissing || code.Operands[0] != 1 {
+        t.Fatalf("expected the body to start by checking for b, got %v", code)
+    }
+
+    _, err = c.Compile("var xs = []; xs.push(x: 1);")
+    if err == nil || !strings.Contains(err.Error(), "Built-in methods don't take named arguments.") {
+        t.Fatalf("expected a compile error, got %v", err)
+    }
+}
@@ -0,0 +1,249 @@
+// Package conformance checks a backend against .lox test files annotated in
+// the style of the craftinginterpreters test suite:
+//
+//	print 1 + 2; // expect: 3
+//	print -"a";  // expect runtime error: Operand must be a number.
+//	var 1 = 2;   // [line 3] Error at '1': Expect variable name.
+//
+// An "Error ..." annotation without a "[line N]" prefix refers to the line
+// it appears on.
+package conformance
+
+import (
+	"fmt"
+	"lox-compiler/difftest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Exit codes a backend is expected to use, following sysexits.h.
+const (
+	ExitCompileError = 65
+	ExitRuntimeError = 70
+)
+
+var (
+	expectOutput       = regexp.MustCompile(`// expect: ?(.*)$`)
+	expectRuntimeError = regexp.MustCompile(`// expect runtime error: (.+)$`)
+	expectSyntaxError  = regexp.MustCompile(`// (?:\[line (\d+)\] )?(Error.*)$`)
+	errorMessage       = regexp.MustCompile(`^Error[^:]*: (.+)$`)
+)
+
+// An error a test expects to be reported on a particular line.
+type ExpectedError struct {
+	Line    int
+	Message string
+}
+
+func (e ExpectedError) String() string {
+	return fmt.Sprintf("[line %d] %s", e.Line, e.Message)
+}
+
+// The behaviour a test file expects from a backend.
+type Expectation struct {
+	Output        []string
+	CompileErrors []ExpectedError
+	RuntimeError  *ExpectedError
+}
+
+// The exit code the backend should finish with.
+func (e Expectation) ExitCode() int {
+	if len(e.CompileErrors) > 0 {
+		return ExitCompileError
+	}
+	if e.RuntimeError != nil {
+		return ExitRuntimeError
+	}
+
+	return 0
+}
+
+// Collect the expectations annotated in a test file's source.
+func ParseExpectations(source string) (Expectation, error) {
+	var exp Expectation
+
+	for i, line := range strings.Split(source, "\n") {
+		lineNumber := i + 1
+		if m := expectOutput.FindStringSubmatch(line); m != nil {
+			exp.Output = append(exp.Output, m[1])
+		} else if m := expectRuntimeError.FindStringSubmatch(line); m != nil {
+			if exp.RuntimeError != nil {
+				return exp, fmt.Errorf("line %d: a test can expect at most one runtime error", lineNumber)
+			}
+			exp.RuntimeError = &ExpectedError{Line: lineNumber, Message: m[1]}
+		} else if m := expectSyntaxError.FindStringSubmatch(line); m != nil {
+			errLine := lineNumber
+			if m[1] != "" {
+				errLine, _ = strconv.Atoi(m[1])
+			}
+			message := m[2]
+			if mm := errorMessage.FindStringSubmatch(message); mm != nil {
+				message = mm[1]
+			}
+			exp.CompileErrors = append(exp.CompileErrors, ExpectedError{Line: errLine, Message: message})
+		}
+	}
+	if len(exp.CompileErrors) > 0 && exp.RuntimeError != nil {
+		return exp, fmt.Errorf("a test can't expect both compile and runtime errors")
+	}
+
+	return exp, nil
+}
+
+// Compare a backend's result against the expectation and describe every
+// mismatch. An empty slice means the test passed.
+//
+// Error messages are matched leniently: some line of stderr must name the
+// expected line number and contain the expected message, so that backends
+// that decorate their diagnostics differently can share one test suite.
+func Check(exp Expectation, res difftest.Result) []string {
+	var failures []string
+
+	output := splitLines(res.Stdout)
+	for i, expected := range exp.Output {
+		if i >= len(output) {
+			failures = append(failures, fmt.Sprintf("missing expected output %q", expected))
+			continue
+		}
+		if output[i] != expected {
+			failures = append(failures, fmt.Sprintf("expected output %q but got %q", expected, output[i]))
+		}
+	}
+	for _, extra := range output[min(len(exp.Output), len(output)):] {
+		failures = append(failures, fmt.Sprintf("unexpected output %q", extra))
+	}
+
+	stderr := splitLines(res.Stderr)
+	expectedErrors := exp.CompileErrors
+	if exp.RuntimeError != nil {
+		expectedErrors = []ExpectedError{*exp.RuntimeError}
+	}
+	for _, e := range expectedErrors {
+		if !reported(stderr, e) {
+			failures = append(failures, fmt.Sprintf("missing expected error %s", e))
+		}
+	}
+	if len(expectedErrors) == 0 && len(stderr) > 0 {
+		for _, line := range stderr {
+			failures = append(failures, fmt.Sprintf("unexpected error %q", line))
+		}
+	}
+
+	if res.ExitCode != exp.ExitCode() {
+		failures = append(failures, fmt.Sprintf("expected exit code %d but got %d", exp.ExitCode(), res.ExitCode))
+	}
+
+	return failures
+}
+
+func reported(stderr []string, e ExpectedError) bool {
+	prefix := fmt.Sprintf("[line %d]", e.Line)
+	for _, line := range stderr {
+		if strings.HasPrefix(line, prefix) && strings.Contains(line, e.Message) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// The outcome of running one test file.
+type TestResult struct {
+	Path     string
+	Failures []string
+	// Why the test was skipped, or empty if it was run.
+	Skipped string
+}
+
+func (r TestResult) Passed() bool {
+	return r.Skipped == "" && len(r.Failures) == 0
+}
+
+// Run the test file at path under backend b.
+func Run(path string, b difftest.Backend) (TestResult, error) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return TestResult{}, err
+	}
+	exp, err := ParseExpectations(string(source))
+	if err != nil {
+		return TestResult{}, fmt.Errorf("%s: %w", path, err)
+	}
+	res, err := b.Run(path)
+	if err != nil {
+		return TestResult{}, err
+	}
+
+	return TestResult{Path: path, Failures: Check(exp, res)}, nil
+}
+
+// A SkipList maps test paths, or directories of tests, relative to the suite
+// root to the reason they can't pass on a backend yet.
+type SkipList map[string]string
+
+// Return why the test at rel is skipped, or an empty string.
+func (s SkipList) Reason(rel string) string {
+	rel = filepath.ToSlash(rel)
+	for prefix, reason := range s {
+		if rel == prefix || strings.HasPrefix(rel, strings.TrimSuffix(prefix, "/")+"/") {
+			return reason
+		}
+	}
+
+	return ""
+}
+
+// Totals for a run over a whole suite.
+type Summary struct {
+	Passed, Failed, Skipped int
+}
+
+func (s Summary) String() string {
+	return fmt.Sprintf("%d passed, %d failed, %d skipped of %d tests",
+		s.Passed, s.Failed, s.Skipped, s.Passed+s.Failed+s.Skipped)
+}
+
+// Run every test below dir under backend b, skipping the tests in skips.
+func RunSuite(dir string, b difftest.Backend, skips SkipList) ([]TestResult, Summary, error) {
+	var results []TestResult
+	var summary Summary
+
+	files, err := difftest.Corpus(dir)
+	if err != nil {
+		return nil, summary, err
+	}
+	for _, f := range files {
+		rel, err := filepath.Rel(dir, f)
+		if err != nil {
+			return results, summary, err
+		}
+		if reason := skips.Reason(rel); reason != "" {
+			results = append(results, TestResult{Path: f, Skipped: reason})
+			summary.Skipped++
+			continue
+		}
+		res, err := Run(f, b)
+		if err != nil {
+			return results, summary, err
+		}
+		if res.Passed() {
+			summary.Passed++
+		} else {
+			summary.Failed++
+		}
+		results = append(results, res)
+	}
+
+	return results, summary, nil
+}
@@ -0,0 +1,165 @@
+package conformance_test
+
+import (
+	"flag"
+	"lox-compiler/conformance"
+	"lox-compiler/difftest"
+	"os/exec"
+	"testing"
+)
+
+var backendName = flag.String("backend", "vm", "backend to run the conformance suite against: vm or golox")
+var backendBin = flag.String("bin", "", "path to the backend binary; built from source if empty")
+var suite = flag.String("suite", "testdata", "directory containing the conformance tests")
+
+// Where each backend's main package lives, relative to this package.
+var backendSources = map[string]string{
+	"vm":    "..",
+	"golox": "../../interpreted_lox",
+}
+
+// Tests that can't pass on a backend yet. Delete entries as features land so
+// the summary printed by TestConformance shows progress.
+var skips = map[string]conformance.SkipList{
+	"vm": {
+		"assignment/undefined.lox":              "the VM assigns to undeclared globals",
+		"class":                                 "the VM can't compile classes",
+		"exception/error_class.lox":             "the VM can't compile classes",
+		"exception/function.lox":                "the VM can't compile classes",
+		"for_in/iterator.lox":                   "the VM can't compile classes",
+		"if/truth.lox":                          "the VM treats 0 as false",
+		"logical_operator":                      "the VM's 'and' and 'or' return booleans rather than an operand",
+		"module/private.lox":                    "the VM words the undefined variable error differently",
+		"operator/add_bool_num.lox":             "the VM words runtime errors differently",
+		"operator/negate_nonnum.lox":            "the VM negates non-numbers instead of raising a runtime error",
+		"operator/subtract_num_string.lox":      "the VM words runtime errors differently",
+		"precedence/left_associative.lox":       "binary operators are parsed as right-associative",
+		"variable/redeclare_local.lox":          "the VM words the redeclaration error differently",
+		"variable/undefined_global.lox":         "the VM words the undefined variable error differently",
+		"variable/use_local_in_initializer.lox": "the VM doesn't reject a local read in its own initializer",
+	},
+	"golox": {
+		"assignment/undefined.lox":              "golox assigns to undeclared globals",
+		"bool/not.lox":                          "golox evaluates `!x` to the truthiness of x",
+		"nil/literal.lox":                       "golox prints nil as <nil>",
+		"precedence/left_associative.lox":       "binary operators are parsed as right-associative",
+		"return/at_top_level.lox":               "golox words resolver errors differently",
+		"return/return_nil_if_no_value.lox":     "golox's resolver crashes on a return without a value",
+		"variable/redeclare_local.lox":          "golox doesn't reject redeclared locals",
+		"variable/uninitialized.lox":            "golox prints nil as <nil>",
+		"variable/use_local_in_initializer.lox": "golox words resolver errors differently",
+	},
+}
+
+func TestParseExpectations(t *testing.T) {
+	exp, err := conformance.ParseExpectations(`print 1; // expect: 1
+print ""; // expect:
+var 1; // Error at '1': Expect variable name.
+// [line 5] Error: Unterminated string.
+"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exp.Output) != 2 || exp.Output[0] != "1" || exp.Output[1] != "" {
+		t.Fatalf("unexpected output %q", exp.Output)
+	}
+	if len(exp.CompileErrors) != 2 {
+		t.Fatalf("expected two compile errors, got %v", exp.CompileErrors)
+	}
+	if e := exp.CompileErrors[0]; e.Line != 3 || e.Message != "Expect variable name." {
+		t.Fatalf("unexpected error %v", e)
+	}
+	if e := exp.CompileErrors[1]; e.Line != 5 || e.Message != "Unterminated string." {
+		t.Fatalf("unexpected error %v", e)
+	}
+	if exp.ExitCode() != conformance.ExitCompileError {
+		t.Fatalf("expected exit code %d, got %d", conformance.ExitCompileError, exp.ExitCode())
+	}
+
+	_, err = conformance.ParseExpectations(`1; // expect runtime error: a
+2; // expect runtime error: b`)
+	if err == nil {
+		t.Fatal("expected an error for two runtime error expectations")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	exp := conformance.Expectation{
+		Output:       []string{"1", "2"},
+		RuntimeError: &conformance.ExpectedError{Line: 3, Message: "Operands must be numbers."},
+	}
+
+	pass := difftest.Result{
+		Stdout:   "1\n2\n",
+		Stderr:   "[line 3]: Operands must be numbers.\n",
+		ExitCode: conformance.ExitRuntimeError,
+	}
+	if failures := conformance.Check(exp, pass); len(failures) != 0 {
+		t.Fatalf("expected the result to pass, got %q", failures)
+	}
+
+	fail := difftest.Result{Stdout: "1\n3\n4\n", Stderr: "[line 2]: Operands must be numbers.\n"}
+	failures := conformance.Check(exp, fail)
+	expected := []string{
+		`expected output "2" but got "3"`,
+		`unexpected output "4"`,
+		`missing expected error [line 3] Operands must be numbers.`,
+		`expected exit code 70 but got 0`,
+	}
+	if len(failures) != len(expected) {
+		t.Fatalf("expected %q, got %q", expected, failures)
+	}
+	for i := range expected {
+		if failures[i] != expected[i] {
+			t.Fatalf("expected %q, got %q", expected[i], failures[i])
+		}
+	}
+}
+
+func TestSkipList(t *testing.T) {
+	s := conformance.SkipList{"class": "classes", "function/print.lox": "printing"}
+	if s.Reason("class/fields.lox") != "classes" {
+		t.Fatal("expected directory entries to skip the tests inside them")
+	}
+	if s.Reason("classes/fields.lox") != "" {
+		t.Fatal("expected directory entries to match whole path elements")
+	}
+	if s.Reason("function/print.lox") != "printing" || s.Reason("function/recursion.lox") != "" {
+		t.Fatal("expected file entries to skip only that file")
+	}
+}
+
+func TestConformance(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping conformance tests in short mode")
+	}
+	source, ok := backendSources[*backendName]
+	if !ok {
+		t.Fatalf("unknown backend %q", *backendName)
+	}
+
+	var b difftest.Backend
+	if *backendBin != "" {
+		b = difftest.NewCommandBackend(*backendName, *backendBin)
+	} else {
+		if _, err := exec.LookPath("go"); err != nil {
+			t.Skip("the go tool is needed to build the backend")
+		}
+		built, err := difftest.BuildBackend(*backendName, source, t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		b = built
+	}
+
+	results, summary, err := conformance.RunSuite(*suite, b, skips[*backendName])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, res := range results {
+		for _, f := range res.Failures {
+			t.Errorf("%s: %s", res.Path, f)
+		}
+	}
+	t.Logf("%s: %s", *backendName, summary)
+}
@@ -0,0 +1,180 @@
+package decimal_test
+
+import (
+	"lox-compiler/decimal"
+	"testing"
+)
+
+func parse(t *testing.T, s string) decimal.Decimal {
+	t.Helper()
+	d, err := decimal.Parse(s)
+	if err != nil {
+		t.Fatalf("%q: %s", s, err)
+	}
+	return d
+}
+
+func TestParse(t *testing.T) {
+	for s, want := range map[string]string{
+		"1.10":  "1.10",
+		"-0.05": "-0.05",
+		"+7":    "7",
+		".5":    "0.5",
+		"007.0": "7.0",
+	} {
+		if got := parse(t, s).String(); got != want {
+			t.Errorf("%q: expected %s, got %s", s, want, got)
+		}
+	}
+	for _, s := range []string{"", ".", "-", "1.2.3", "--1", "1.-2", "1e5", "x"} {
+		if _, err := decimal.Parse(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	var c decimal.Context
+	tests := []struct {
+		op   func(x, y decimal.Decimal) decimal.Decimal
+		x, y string
+		want string
+	}{
+		{c.Add, "0.1", "0.2", "0.3"},
+		{c.Add, "1.10", "2.205", "3.305"},
+		{c.Sub, "1", "0.01", "0.99"},
+		{c.Mul, "1.25", "4", "5.00"},
+		{c.Mul, "-0.5", "0.5", "-0.25"},
+		{quo(c), "1", "4", "0.25"},
+		{quo(c), "1.00", "1", "1.00"},
+		{quo(c), "100", "0.01", "10000"},
+		{quo(c), "0.00", "3", "0.00"},
+		{quo(c), "1", "3", "0.3333333333333333333333333333"},
+		{quo(c), "-2", "3", "-0.6666666666666666666666666667"},
+		{rem(c), "7.5", "2", "1.5"},
+		{rem(c), "-7.5", "2", "-1.5"},
+	}
+	for _, test := range tests {
+		got := test.op(parse(t, test.x), parse(t, test.y)).String()
+		if got != test.want {
+			t.Errorf("%s, %s: expected %s, got %s", test.x, test.y, test.want, got)
+		}
+	}
+
+	if _, ok := c.Quo(parse(t, "1"), decimal.Decimal{}); ok {
+		t.Error("expected division by zero to fail")
+	}
+	if _, ok := c.Rem(parse(t, "1"), parse(t, "0.0")); ok {
+		t.Error("expected the remainder of division by zero to fail")
+	}
+}
+
+func quo(c decimal.Context) func(x, y decimal.Decimal) decimal.Decimal {
+	return func(x, y decimal.Decimal) decimal.Decimal {
+		d, _ := c.Quo(x, y)
+		return d
+	}
+}
+
+func rem(c decimal.Context) func(x, y decimal.Decimal) decimal.Decimal {
+	return func(x, y decimal.Decimal) decimal.Decimal {
+		d, _ := c.Rem(x, y)
+		return d
+	}
+}
+
+func TestRounding(t *testing.T) {
+	// The results of rounding each number to two digits, in the order of
+	// the rounding modes.
+	tests := map[string][7]string{
+		"0.125":  {"0.12", "0.13", "0.12", "0.13", "0.12", "0.13", "0.12"},
+		"0.135":  {"0.14", "0.14", "0.13", "0.14", "0.13", "0.14", "0.13"},
+		"-0.125": {"-0.12", "-0.13", "-0.12", "-0.13", "-0.12", "-0.12", "-0.13"},
+		"0.1251": {"0.13", "0.13", "0.13", "0.13", "0.12", "0.13", "0.12"},
+		"0.120":  {"0.12", "0.12", "0.12", "0.12", "0.12", "0.12", "0.12"},
+		"999":    {"1000", "1000", "1000", "1000", "990", "1000", "990"},
+	}
+	modes := []decimal.Rounding{decimal.HalfEven, decimal.HalfUp, decimal.HalfDown, decimal.Up, decimal.Down, decimal.Ceiling, decimal.Floor}
+	for s, wants := range tests {
+		for i, mode := range modes {
+			c := decimal.Context{Precision: 2, Rounding: mode}
+			if got := c.Round(parse(t, s)).String(); got != wants[i] {
+				t.Errorf("%s, %s: expected %s, got %s", s, mode, wants[i], got)
+			}
+		}
+	}
+
+	// A quotient whose digits stop at half way, but that carries on, isn't
+	// a tie: 1 / 3.9999 is 0.25000625...
+	c := decimal.Context{Precision: 1, Rounding: decimal.HalfDown}
+	if d, _ := c.Quo(parse(t, "1"), parse(t, "3.9999")); d.String() != "0.3" {
+		t.Errorf("expected 0.3, got %s", d)
+	}
+	c.Rounding = decimal.Up
+	if d, _ := c.Quo(parse(t, "1"), parse(t, "3")); d.String() != "0.4" {
+		t.Errorf("expected 0.4, got %s", d)
+	}
+}
+
+func TestParseRounding(t *testing.T) {
+	for _, name := range []string{"half_even", "half_up", "half_down", "up", "down", "ceiling", "floor"} {
+		r, ok := decimal.ParseRounding(name)
+		if !ok || r.String() != name {
+			t.Errorf("%s: got %v, %v", name, r, ok)
+		}
+	}
+	if _, ok := decimal.ParseRounding("sideways"); ok {
+		t.Error("expected an unknown rounding mode to fail")
+	}
+}
+
+func TestCompare(t *testing.T) {
+	if parse(t, "1.50").Cmp(parse(t, "1.5")) != 0 || parse(t, "-2").Cmp(parse(t, "1.5")) != -1 {
+		t.Error("expected decimals to compare by value")
+	}
+	if d := parse(t, "1.500").Reduce(); d.String() != "1.5" {
+		t.Errorf("expected 1.5, got %s", d)
+	}
+	if i, ok := parse(t, "12.00").Int(); !ok || i.Int64() != 12 {
+		t.Errorf("expected 12, got %v, %v", i, ok)
+	}
+	if _, ok := parse(t, "12.5").Int(); ok {
+		t.Error("expected 12.5 not to be an integer")
+	}
+}
+
+func TestPrecision(t *testing.T) {
+	tests := []struct {
+		precision int
+		op        func(c decimal.Context) func(x, y decimal.Decimal) decimal.Decimal
+		x, y      string
+		want      string
+	}{
+		// The zero Context rounds to DefaultPrecision digits, as does a
+		// precision that isn't positive.
+		{0, mul, "12345678901234567890", "12345678901234567890", "152415787532388367501905199900000000000"},
+		{-1, mul, "1.0000000000000000000000000001", "1.0000000000000000000000000001", "1.000000000000000000000000000"},
+		{3, add, "1.234", "0", "1.23"},
+		{3, add, "123", "0.4", "123"},
+		// Rounding 999.5 up carries into a fourth digit, which is dropped
+		// again.
+		{3, sub, "1000", "0.5", "1000"},
+		{1, add, "9.5", "0", "10"},
+		{5, quo, "2", "3", "0.66667"},
+		// Only the digits of a quotient count towards the precision, not
+		// the zeros after the point that come before them.
+		{3, quo, "1", "1024", "0.000977"},
+		{3, quo, "1", "8", "0.125"},
+	}
+	for _, test := range tests {
+		c := decimal.Context{Precision: test.precision}
+		got := test.op(c)(parse(t, test.x), parse(t, test.y)).String()
+		if got != test.want {
+			t.Errorf("precision %d, %s, %s: expected %s, got %s", test.precision, test.x, test.y, test.want, got)
+		}
+	}
+}
+
+func add(c decimal.Context) func(x, y decimal.Decimal) decimal.Decimal { return c.Add }
+func sub(c decimal.Context) func(x, y decimal.Decimal) decimal.Decimal { return c.Sub }
+func mul(c decimal.Context) func(x, y decimal.Decimal) decimal.Decimal { return c.Mul }
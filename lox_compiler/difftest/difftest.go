@@ -0,0 +1,235 @@
+// Package difftest runs the same Lox programs under two backends and
+// reports every place where their observable behaviour differs.
+package difftest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The default amount of time a single program may run before it is killed.
+const DefaultTimeout = 10 * time.Second
+
+// The observable behaviour of running one program.
+type Result struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// A Backend executes a Lox source file.
+type Backend interface {
+	Name() string
+	Run(path string) (Result, error)
+}
+
+// Runs an external interpreter binary as `path [args...] file.lox`.
+type CommandBackend struct {
+	name    string
+	path    string
+	args    []string
+	Timeout time.Duration
+}
+
+func NewCommandBackend(name, path string, args ...string) CommandBackend {
+	return CommandBackend{name: name, path: path, args: args, Timeout: DefaultTimeout}
+}
+
+// Build the main package in dir with `go build` and return a backend that
+// runs the resulting binary, which is written to outDir.
+func BuildBackend(name, dir, outDir string) (CommandBackend, error) {
+	out, err := filepath.Abs(filepath.Join(outDir, name))
+	if err != nil {
+		return CommandBackend{}, err
+	}
+	cmd := exec.Command("go", "build", "-o", out, ".")
+	cmd.Dir = dir
+	if msg, err := cmd.CombinedOutput(); err != nil {
+		return CommandBackend{}, fmt.Errorf("building %s: %w\n%s", name, err, msg)
+	}
+
+	return NewCommandBackend(name, out), nil
+}
+
+func (b CommandBackend) Name() string {
+	return b.name
+}
+
+func (b CommandBackend) Run(path string) (Result, error) {
+	var stdout, stderr bytes.Buffer
+
+	timeout := b.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, b.path, append(b.args, path)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return Result{}, fmt.Errorf("%s: timed out after %s running %s", b.name, timeout, path)
+	}
+
+	res := Result{Stdout: stdout.String(), Stderr: stderr.String()}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		res.ExitCode = exitErr.ExitCode()
+	} else if err != nil {
+		return Result{}, fmt.Errorf("%s: %w", b.name, err)
+	}
+
+	return res, nil
+}
+
+// A single difference between the two backends for one program.
+type Divergence struct {
+	Path  string
+	Field string
+	Diff  string
+	// The names of the backends on the '-' and '+' sides of Diff.
+	A, B string
+}
+
+func (d Divergence) String() string {
+	return fmt.Sprintf("%s: %s differs\n--- %s\n+++ %s\n%s", d.Path, d.Field, d.A, d.B, d.Diff)
+}
+
+// Compare the results of running path under backends a and b. The returned
+// divergences are empty if the backends agree.
+func Compare(path string, a, b Result) []Divergence {
+	var ret []Divergence
+
+	if a.Stdout != b.Stdout {
+		ret = append(ret, Divergence{Path: path, Field: "stdout", Diff: Diff(a.Stdout, b.Stdout)})
+	}
+	if a.Stderr != b.Stderr {
+		ret = append(ret, Divergence{Path: path, Field: "runtime error", Diff: Diff(a.Stderr, b.Stderr)})
+	}
+	if a.ExitCode != b.ExitCode {
+		ret = append(ret, Divergence{
+			Path:  path,
+			Field: "exit status",
+			Diff:  fmt.Sprintf("-%d\n+%d\n", a.ExitCode, b.ExitCode),
+		})
+	}
+
+	return ret
+}
+
+// Run every .lox file below dir under both backends and collect the
+// divergences. Files are visited in lexical order.
+func RunCorpus(dir string, a, b Backend) ([]Divergence, error) {
+	files, err := Corpus(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []Divergence
+	for _, f := range files {
+		d, err := RunFile(f, a, b)
+		if err != nil {
+			return ret, err
+		}
+		ret = append(ret, d...)
+	}
+
+	return ret, nil
+}
+
+// Run a single file under both backends and compare the results.
+func RunFile(path string, a, b Backend) ([]Divergence, error) {
+	aRes, err := a.Run(path)
+	if err != nil {
+		return nil, err
+	}
+	bRes, err := b.Run(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := Compare(path, aRes, bRes)
+	for i := range ret {
+		ret[i].A, ret[i].B = a.Name(), b.Name()
+	}
+
+	return ret, nil
+}
+
+// List the .lox files below dir in lexical order.
+func Corpus(dir string) ([]string, error) {
+	var files []string
+
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(path, ".lox") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	sort.Strings(files)
+
+	return files, err
+}
+
+// Build a minimal line diff turning a into b. Lines only in a are prefixed
+// with '-', lines only in b with '+'; common lines are omitted.
+func Diff(a, b string) string {
+	aLines, bLines := splitLines(a), splitLines(b)
+
+	// lcs[i][j] holds the length of the longest common subsequence of
+	// aLines[i:] and bLines[j:].
+	lcs := make([][]int, len(aLines)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bLines)+1)
+	}
+	for i := len(aLines) - 1; i >= 0; i-- {
+		for j := len(bLines) - 1; j >= 0; j-- {
+			if aLines[i] == bLines[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	str := strings.Builder{}
+	i, j := 0, 0
+	for i < len(aLines) || j < len(bLines) {
+		switch {
+		case i < len(aLines) && j < len(bLines) && aLines[i] == bLines[j]:
+			i, j = i+1, j+1
+		case j >= len(bLines) || (i < len(aLines) && lcs[i+1][j] >= lcs[i][j+1]):
+			str.WriteString(fmt.Sprintf("-%s\n", aLines[i]))
+			i++
+		default:
+			str.WriteString(fmt.Sprintf("+%s\n", bLines[j]))
+			j++
+		}
+	}
+	if str.Len() == 0 && a != b {
+		str.WriteString("\\ outputs differ only in the trailing newline\n")
+	}
+
+	return str.String()
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
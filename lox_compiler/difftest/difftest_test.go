@@ -0,0 +1,149 @@
+package difftest_test
+
+import (
+	"flag"
+	"fmt"
+	"lox-compiler/difftest"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+var corpus = flag.String("corpus", "testdata", "directory of .lox programs to run under both backends")
+var goloxBin = flag.String("golox", "", "path to a golox binary; built from ../../interpreted_lox if empty")
+var loxBin = flag.String("lox", "", "path to a lox (VM) binary; built from .. if empty")
+
+// Programs in the default corpus on which the backends are known to disagree.
+// Remove an entry once the divergence is fixed so that it can't regress.
+var knownDivergences = map[string]string{
+	"testdata/arithmetic.lox":    "golox evaluates `!x` to the truthiness of x",
+	"testdata/runtime_error.lox": "the backends word runtime errors differently",
+}
+
+type fakeBackend struct {
+	name    string
+	results map[string]difftest.Result
+}
+
+func (b fakeBackend) Name() string {
+	return b.name
+}
+
+func (b fakeBackend) Run(path string) (difftest.Result, error) {
+	res, ok := b.results[path]
+	if !ok {
+		return difftest.Result{}, fmt.Errorf("no result for %s", path)
+	}
+	return res, nil
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		a, b, diff string
+	}{
+		{"", "", ""},
+		{"1\n2\n3\n", "1\n2\n3\n", ""},
+		{"1\n2\n3\n", "1\n3\n", "-2\n"},
+		{"1\n3\n", "1\n2\n3\n", "+2\n"},
+		{"a\nb\nc\n", "a\nx\nc\n", "-b\n+x\n"},
+		{"nil\n", "<nil>\n", "-nil\n+<nil>\n"},
+		{"1\n", "1", "\\ outputs differ only in the trailing newline\n"},
+	}
+
+	for _, test := range tests {
+		if got := difftest.Diff(test.a, test.b); got != test.diff {
+			t.Errorf("Diff(%q, %q):\nexpected: %q\ngot: %q", test.a, test.b, test.diff, got)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	a := fakeBackend{name: "a", results: map[string]difftest.Result{
+		"same.lox":  {Stdout: "1\n"},
+		"print.lox": {Stdout: "1\n2\n"},
+		"error.lox": {Stdout: "1\n", Stderr: "[line 2]: Operands must be numbers.\n", ExitCode: 70},
+	}}
+	b := fakeBackend{name: "b", results: map[string]difftest.Result{
+		"same.lox":  {Stdout: "1\n"},
+		"print.lox": {Stdout: "1\n3\n"},
+		"error.lox": {Stdout: "1\n"},
+	}}
+
+	d, err := difftest.RunFile("same.lox", a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d) != 0 {
+		t.Fatalf("expected no divergences, got %v", d)
+	}
+
+	d, err = difftest.RunFile("print.lox", a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d) != 1 || d[0].Field != "stdout" || d[0].Diff != "-2\n+3\n" {
+		t.Fatalf("unexpected divergences %v", d)
+	}
+
+	d, err = difftest.RunFile("error.lox", a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d) != 2 || d[0].Field != "runtime error" || d[1].Field != "exit status" {
+		t.Fatalf("unexpected divergences %v", d)
+	}
+	if d[0].A != "a" || d[0].B != "b" {
+		t.Fatalf("expected divergence to name its backends, got %q and %q", d[0].A, d[0].B)
+	}
+}
+
+func backend(t *testing.T, name, bin, dir string) difftest.Backend {
+	if bin != "" {
+		return difftest.NewCommandBackend(name, bin)
+	}
+	b, err := difftest.BuildBackend(name, dir, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+// Run the corpus under golox and the VM and report every divergence.
+func TestCorpus(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping differential tests in short mode")
+	}
+	if _, err := exec.LookPath("go"); err != nil && (*goloxBin == "" || *loxBin == "") {
+		t.Skip("the go tool is needed to build the backends")
+	}
+
+	a := backend(t, "golox", *goloxBin, "../../interpreted_lox")
+	b := backend(t, "vm", *loxBin, "..")
+
+	files, err := difftest.Corpus(*corpus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatalf("no .lox files in %s", *corpus)
+	}
+
+	for _, f := range files {
+		d, err := difftest.RunFile(f, a, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reason, known := knownDivergences[filepath.ToSlash(f)]
+		switch {
+		case len(d) > 0 && known:
+			t.Logf("%s: known divergence: %s", f, reason)
+		case len(d) > 0:
+			for _, v := range d {
+				t.Error(v)
+			}
+		case known:
+			t.Errorf("%s: backends now agree; remove it from knownDivergences", f)
+		}
+	}
+}
@@ -0,0 +1,415 @@
+// Package format prints Lox programs in a canonical layout: one statement
+// per line, blocks indented by two spaces, single spaces around binary
+// operators, and at most one blank line between statements. Comments are
+// kept next to the statements they were written beside.
+package format
+
+import (
+	"lox-compiler/parser"
+	"sort"
+	"strings"
+)
+
+const indentation = "  "
+
+// Format src. It is an error for src to have syntax errors, since there is
+// no way to lay out code that can't be parsed.
+func Source(src string) (string, error) {
+	s := parser.NewScanner(src)
+	tokens, scanErr := s.ScanTokens()
+	if scanErr != nil {
+		return "", scanErr
+	}
+	p := parser.NewParser(tokens)
+	stmts, err := p.Parse()
+	if err != nil {
+		return "", err
+	}
+
+	pr := printer{src: src, comments: s.Comments()}
+	pr.list(stmts, len(src), pr.statement)
+
+	return pr.out.String(), nil
+}
+
+type printer struct {
+	src      string
+	comments []parser.Comment
+	// The index of the first comment not yet printed.
+	next   int
+	out    strings.Builder
+	indent int
+	// The source line on which the last statement or comment printed ends,
+	// for keeping blank lines between them.
+	lastLine int
+}
+
+func (p *printer) writeIndent() {
+	p.out.WriteString(strings.Repeat(indentation, p.indent))
+}
+
+// Print a comment on a line of its own.
+func (p *printer) comment(c parser.Comment, first bool) {
+	p.separate(c.Start.Line, first)
+	p.writeIndent()
+	p.out.WriteString(commentText(c))
+	p.out.WriteString("\n")
+	p.lastLine = c.End.Line
+}
+
+func commentText(c parser.Comment) string {
+	return strings.TrimRight(c.Text, " \t\r")
+}
+
+// Keep one blank line before something that starts on line if the source
+// had at least one, unless it comes first in its block.
+func (p *printer) separate(line int, first bool) {
+	if !first && line > p.lastLine+1 {
+		p.out.WriteString("\n")
+	}
+}
+
+// Print the comments that start before offset on lines of their own, and
+// report whether there were any.
+func (p *printer) leadingComments(offset int, first bool) bool {
+	printed := false
+	for ; p.next < len(p.comments) && p.comments[p.next].Start.Offset < offset; p.next++ {
+		p.comment(p.comments[p.next], first && !printed)
+		printed = true
+	}
+
+	return printed
+}
+
+// Print the comments left inside node, and any comment after it on the same
+// line before limit. The first goes at the end of the node's last line and
+// the rest on lines of their own.
+func (p *printer) trailingComments(node parser.ASTNode, limit int) {
+	end := node.SourceSpan().End
+	first := true
+	for ; p.next < len(p.comments); p.next++ {
+		c := p.comments[p.next]
+		if c.Start.Offset >= end.Offset && (c.Start.Line != end.Line || c.Start.Offset >= limit) {
+			break
+		}
+		if first {
+			p.out.WriteString(" ")
+			p.out.WriteString(commentText(c))
+			p.out.WriteString("\n")
+			first = false
+		} else {
+			p.writeIndent()
+			p.out.WriteString(commentText(c))
+			p.out.WriteString("\n")
+		}
+	}
+	if first {
+		p.out.WriteString("\n")
+	}
+}
+
+// Print each node on lines of its own with print, along with the comments
+// before end, the offset at which the enclosing block ends.
+func (p *printer) list(nodes []parser.ASTNode, end int, print func(parser.ASTNode)) {
+	first := true
+	for i, node := range nodes {
+		span := node.SourceSpan()
+		if p.leadingComments(span.Start.Offset, first) {
+			first = false
+		}
+		p.separate(span.Start.Line, first)
+		p.writeIndent()
+		print(node)
+		p.lastLine = span.End.Line
+
+		limit := end
+		if i+1 < len(nodes) {
+			limit = nodes[i+1].SourceSpan().Start.Offset
+		}
+		p.trailingComments(node, limit)
+		first = false
+	}
+	p.leadingComments(end, first)
+}
+
+// Print the statements of a block that ends at end, with its braces.
+func (p *printer) block(stmts []parser.Statement, end int) {
+	p.blockOf(stmts, end, p.statement)
+}
+
+func (p *printer) blockOf(nodes []parser.ASTNode, end int, print func(parser.ASTNode)) {
+	if len(nodes) == 0 && (p.next >= len(p.comments) || p.comments[p.next].Start.Offset >= end) {
+		p.out.WriteString("{}")
+		return
+	}
+
+	p.out.WriteString("{\n")
+	p.indent++
+	p.list(nodes, end, print)
+	p.indent--
+	p.writeIndent()
+	p.out.WriteString("}")
+}
+
+func (p *printer) statement(stmt parser.ASTNode) {
+	switch s := stmt.(type) {
+	case parser.Block:
+		p.block(s.Statements, s.End.Offset)
+	case parser.Class:
+		p.out.WriteString("class ")
+		p.out.WriteString(s.Name.Lexeme)
+		if s.ParentClass != nil {
+			p.out.WriteString(" < ")
+			p.out.WriteString(s.ParentClass.Name.Lexeme)
+		}
+		p.out.WriteString(" ")
+		// Keep fields and methods in the order they were written.
+		var members []parser.ASTNode
+		for _, f := range s.Fields {
+			members = append(members, f)
+		}
+		for _, m := range s.Methods {
+			members = append(members, m)
+		}
+		sort.SliceStable(members, func(i, j int) bool {
+			return members[i].SourceSpan().Start.Offset < members[j].SourceSpan().Start.Offset
+		})
+		p.blockOf(members, s.End.Offset, func(m parser.ASTNode) {
+			if f, ok := m.(parser.Field); ok {
+				p.out.WriteString(f.Name.Lexeme + annotation(f.Type) + ";")
+				return
+			}
+			p.function(m.(parser.Function))
+		})
+	case parser.Export:
+		p.out.WriteString("export ")
+		p.statement(s.Declaration)
+	case parser.Function:
+		p.out.WriteString("fun ")
+		p.function(s)
+	case parser.If:
+		p.out.WriteString("if (")
+		p.out.WriteString(p.expr(s.Conditional))
+		p.out.WriteString(") ")
+		p.statement(s.If_stmt)
+		if s.Else_stmt != nil {
+			p.out.WriteString(" else ")
+			p.statement(s.Else_stmt)
+		}
+	case parser.Try:
+		p.out.WriteString("try ")
+		p.block(s.Body.Statements, s.Body.End.Offset)
+		if s.CatchName != nil {
+			p.out.WriteString(" catch (" + s.CatchName.Lexeme + ") ")
+			p.block(s.Catch.Statements, s.Catch.End.Offset)
+		}
+		if s.Finally != nil {
+			p.out.WriteString(" finally ")
+			p.block(s.Finally.Statements, s.Finally.End.Offset)
+		}
+	case parser.While:
+		p.out.WriteString("while (")
+		p.out.WriteString(p.expr(s.Conditional))
+		p.out.WriteString(") ")
+		p.statement(s.Stmt)
+	case parser.For:
+		p.out.WriteString("for (")
+		if s.Initializer != nil {
+			p.statement(s.Initializer)
+		} else {
+			p.out.WriteString(";")
+		}
+		if s.Conditional != nil {
+			p.out.WriteString(" ")
+			p.out.WriteString(p.expr(s.Conditional))
+		}
+		p.out.WriteString(";")
+		if s.Increment != nil {
+			p.out.WriteString(" ")
+			p.out.WriteString(p.expr(s.Increment))
+		}
+		p.out.WriteString(") ")
+		p.statement(s.Stmt)
+	case parser.ForIn:
+		p.out.WriteString("for (var " + s.Name.Lexeme + " in ")
+		p.out.WriteString(p.expr(s.Iterable))
+		p.out.WriteString(") ")
+		p.statement(s.Stmt)
+	default:
+		p.out.WriteString(p.simpleStatement(stmt))
+	}
+}
+
+// Format a statement that has no statements inside it.
+func (p *printer) simpleStatement(stmt parser.Statement) string {
+	switch s := stmt.(type) {
+	case parser.Break:
+		return "break;"
+	case parser.Continue:
+		return "continue;"
+	case parser.ExpressionStmt:
+		return p.expr(s.Val) + ";"
+	case parser.Import:
+		if s.Name != nil {
+			return "import " + s.Name.Lexeme + " from " + s.Path.Lexeme + ";"
+		}
+		return "import " + s.Path.Lexeme + ";"
+	case parser.Print:
+		return "print " + p.expr(s.Val) + ";"
+	case parser.Return:
+		if s.Return_expr == nil {
+			return "return;"
+		}
+		return "return " + p.expr(s.Return_expr) + ";"
+	case parser.Throw:
+		return "throw " + p.expr(s.Value) + ";"
+	case parser.Var:
+		// The parser stands in a nil without a position for a missing
+		// initializer.
+		if s.Initializer == nil || s.Initializer.SourceSpan().IsZero() {
+			return "var " + s.Name.Lexeme + annotation(s.Type) + ";"
+		}
+		return "var " + s.Name.Lexeme + annotation(s.Type) + " = " + p.expr(s.Initializer) + ";"
+	}
+
+	return p.source(stmt)
+}
+
+// Print a function's name, parameters and body.
+func (p *printer) function(f parser.Function) {
+	p.out.WriteString(f.Name.Lexeme)
+	p.out.WriteString("(")
+	for i := range f.Params {
+		if i > 0 {
+			p.out.WriteString(", ")
+		}
+		p.out.WriteString(p.param(f, i))
+	}
+	p.out.WriteString(")")
+	p.out.WriteString(annotation(f.ReturnType))
+	p.out.WriteString(" ")
+	p.block(f.Body, f.End.Offset)
+}
+
+// Format the i'th parameter of f, with its annotation and default value.
+func (p *printer) param(f parser.Function, i int) string {
+	param := f.Params[i].Lexeme
+	if f.IsRest(i) {
+		param = "..." + param
+	}
+	if i < len(f.ParamTypes) {
+		param += annotation(f.ParamTypes[i])
+	}
+	if i < len(f.Defaults) && f.Defaults[i] != nil {
+		param += " = " + p.expr(f.Defaults[i])
+	}
+
+	return param
+}
+
+// Format a function expression. The body of one written with "fun" is a
+// block, which is laid out on lines of its own at the current indentation.
+func (p *printer) lambda(e parser.Lambda) string {
+	if e.Arrow {
+		params := make([]string, len(e.Decl.Params))
+		for i := range e.Decl.Params {
+			params[i] = p.param(e.Decl, i)
+		}
+		body := p.expr(e.Decl.Body[0].(parser.Return).Return_expr)
+		if len(params) == 1 && params[0] == e.Decl.Params[0].Lexeme {
+			return params[0] + " => " + body
+		}
+		return "(" + strings.Join(params, ", ") + ") => " + body
+	}
+
+	sub := printer{src: p.src, comments: p.comments, next: p.next, indent: p.indent, lastLine: p.lastLine}
+	sub.out.WriteString("fun ")
+	sub.function(e.Decl)
+	p.next = sub.next
+
+	return sub.out.String()
+}
+
+func (p *printer) expr(e parser.Expr) string {
+	switch e := e.(type) {
+	case parser.Assign:
+		return e.Name.Lexeme + " = " + p.expr(e.Value)
+	case parser.Binary:
+		return p.expr(e.Left) + " " + e.Operator.Lexeme + " " + p.expr(e.Right)
+	case parser.Call:
+		args := make([]string, len(e.Args))
+		for i, arg := range e.Args {
+			args[i] = p.expr(arg)
+		}
+		for _, arg := range e.Named {
+			args = append(args, arg.Name.Lexeme+": "+p.expr(arg.Value))
+		}
+		return p.expr(e.Callee) + "(" + strings.Join(args, ", ") + ")"
+	case parser.Get:
+		return p.expr(e.Object) + "." + e.Name.Lexeme
+	case parser.Grouping:
+		return "(" + p.expr(e.Expr) + ")"
+	case parser.Logical:
+		return p.expr(e.Left) + " " + e.Operator.Lexeme + " " + p.expr(e.Right)
+	case parser.Unary:
+		return e.Operator.Lexeme + p.expr(e.Right)
+	case parser.List:
+		elements := make([]string, len(e.Elements))
+		for i, element := range e.Elements {
+			elements[i] = p.expr(element)
+		}
+		return "[" + strings.Join(elements, ", ") + "]"
+	case parser.Map:
+		entries := make([]string, len(e.Keys))
+		for i := range e.Keys {
+			entries[i] = p.expr(e.Keys[i]) + ": " + p.expr(e.Values[i])
+		}
+		return "{" + strings.Join(entries, ", ") + "}"
+	case parser.Set:
+		return p.expr(e.Object) + "." + e.Name.Lexeme + " = " + p.expr(e.Value)
+	case parser.Subscript:
+		return p.expr(e.Object) + "[" + p.expr(e.Index) + "]"
+	case parser.SubscriptSet:
+		return p.expr(e.Object) + "[" + p.expr(e.Index) + "] = " + p.expr(e.Value)
+	case parser.Range:
+		return p.expr(e.Start) + ".." + p.expr(e.End)
+	case parser.Interpolation:
+		// The lexemes of the string parts include the quotes and the "${"
+		// and "}" around the expressions.
+		var sb strings.Builder
+		for i, str := range e.Strings {
+			sb.WriteString(str.Lexeme)
+			if i < len(e.Exprs) {
+				sb.WriteString(p.expr(e.Exprs[i]))
+			}
+		}
+		return sb.String()
+	case parser.Lambda:
+		return p.lambda(e)
+	case parser.Super:
+		return "super." + e.Method.Lexeme
+	case parser.This:
+		return "this"
+	case parser.Variable:
+		return e.Name.Lexeme
+	}
+
+	// Literals are printed as they were written, so that 1.50 doesn't
+	// become 1.5.
+	return p.source(e)
+}
+
+// A type annotation as it reads after a name, or "" if there is none.
+func annotation(t *parser.TypeAnnotation) string {
+	if t == nil {
+		return ""
+	}
+
+	return ": " + t.Name.Lexeme
+}
+
+// The source text of a node.
+func (p *printer) source(node parser.ASTNode) string {
+	span := node.SourceSpan()
+	return p.src[span.Start.Offset:span.End.Offset]
+}
@@ -0,0 +1,305 @@
+package format_test
+
+import (
+	"io/fs"
+	"lox-compiler/format"
+	"lox-compiler/parser"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLayout(t *testing.T) {
+	src := `// Leading comment.
+var   a=1;   // trailing
+
+
+var b = 1 + // inside
+  2;
+fun add(x,y){
+  // body comment
+  return x+y;
+}
+class Foo < Bar {
+  init(x) { this.x = x; }   // after init
+
+  get() { return super.get(); }
+}
+for(var i=0;i<3;i=i+1) print i;
+for(;;) {}
+if (a == 1) print "one"; else { print "many"; }
+while (!false and nil or -a > 2.50) { a = (a - 1) * 2; }
+{
+  // only a comment
+}
+// Trailing file comment.
+`
+	expected := `// Leading comment.
+var a = 1; // trailing
+
+var b = 1 + 2; // inside
+fun add(x, y) {
+  // body comment
+  return x + y;
+}
+class Foo < Bar {
+  init(x) {
+    this.x = x;
+  } // after init
+
+  get() {
+    return super.get();
+  }
+}
+for (var i = 0; i < 3; i = i + 1) print i;
+for (;;) {}
+if (a == 1) print "one"; else {
+  print "many";
+}
+while (!false and nil or -a > 2.50) {
+  a = (a - 1) * 2;
+}
+{
+  // only a comment
+}
+// Trailing file comment.
+`
+	out, err := format.Source(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, out)
+	}
+}
+
+func TestSyntaxError(t *testing.T) {
+	if _, err := format.Source("var = 1;"); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+// Every example program that parses formats to the same program, and
+// formatting it again changes nothing.
+func TestExamples(t *testing.T) {
+	roots := []string{"../conformance/testdata", "../difftest/testdata", "../progs", "../../interpreted_lox"}
+	for _, root := range roots {
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || !strings.HasSuffix(path, ".lox") {
+				return err
+			}
+			src, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			before, ok := parse(string(src))
+			if !ok {
+				return nil
+			}
+
+			t.Run(path, func(t *testing.T) {
+				once, err := format.Source(string(src))
+				if err != nil {
+					t.Fatal(err)
+				}
+				after, ok := parse(once)
+				if !ok || after != before {
+					t.Fatalf("formatting changed the program to:\n%s", once)
+				}
+				twice, err := format.Source(once)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if twice != once {
+					t.Fatalf("formatting isn't idempotent:\n%s\nbecame:\n%s", once, twice)
+				}
+			})
+			return nil
+		})
+	}
+}
+
+// The program src parses to, printed without positions.
+func parse(src string) (string, bool) {
+	tokens, scanErr := parser.NewScanner(src).ScanTokens()
+	if scanErr != nil {
+		return "", false
+	}
+	p := parser.NewParser(tokens)
+	stmts, err := p.Parse()
+	if err != nil {
+		return "", false
+	}
+	var b strings.Builder
+	for _, stmt := range stmts {
+		b.WriteString(stmt.String())
+	}
+
+	return b.String(), true
+}
+
+func TestAnnotations(t *testing.T) {
+	src := "class P{x:number;\ninit(x:number,y):nil{}}\nvar p:P=P(1,2);\n"
+	expected := `class P {
+  x: number;
+  init(x: number, y): nil {}
+}
+var p: P = P(1, 2);
+`
+	out, err := format.Source(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, out)
+	}
+}
+
+func TestModules(t *testing.T) {
+	src := "import   \"a.lox\"  ;\nimport m   from \"lib/m.lox\";\nexport   var x=1;\nexport fun f(){}\n"
+	expected := `import "a.lox";
+import m from "lib/m.lox";
+export var x = 1;
+export fun f() {}
+`
+	out, err := format.Source(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, out)
+	}
+}
+
+func TestExceptions(t *testing.T) {
+	src := "try{throw   \"x\" ;}catch( e ){print e;}finally{}\ntry {} finally { print 1; }\n"
+	expected := `try {
+  throw "x";
+} catch (e) {
+  print e;
+} finally {}
+try {} finally {
+  print 1;
+}
+`
+	out, err := format.Source(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, out)
+	}
+}
+
+func TestLoopJumps(t *testing.T) {
+	src := "while(true){if (x) break ;continue;}\n"
+	expected := `while (true) {
+  if (x) break;
+  continue;
+}
+`
+	out, err := format.Source(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, out)
+	}
+}
+
+func TestLists(t *testing.T) {
+	src := "var xs=[ 1,2 ,[ ] ];\nxs[ 0 ]=xs [-1];\nprint xs.slice(0,1);\n"
+	expected := `var xs = [1, 2, []];
+xs[0] = xs[-1];
+print xs.slice(0, 1);
+`
+	out, err := format.Source(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, out)
+	}
+}
+
+func TestMaps(t *testing.T) {
+	src := "var m={ \"a\" :1,\"b\":{ } };\n{print m [\"a\"];}\n"
+	expected := `var m = {"a": 1, "b": {}};
+{
+  print m["a"];
+}
+`
+	out, err := format.Source(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, out)
+	}
+}
+
+func TestForIn(t *testing.T) {
+	src := "for(var x in xs)print x;\nfor ( var i in 0 .. n+1 ) { print i; }\n"
+	expected := `for (var x in xs) print x;
+for (var i in 0..n + 1) {
+  print i;
+}
+`
+	out, err := format.Source(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, out)
+	}
+}
+
+func TestLambda(t *testing.T) {
+	src := "var add=fun(a,b){return a+b;};\nxs.map( (x)=>x*2 );\nvar f = (a:number,b) => a;\n{ var g = fun () { print 1; }; }\n"
+	expected := `var add = fun (a, b) {
+  return a + b;
+};
+xs.map(x => x * 2);
+var f = (a: number, b) => a;
+{
+  var g = fun () {
+    print 1;
+  };
+}
+`
+	out, err := format.Source(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, out)
+	}
+}
+
+func TestParameters(t *testing.T) {
+	src := "fun f(a,b=a+1,...rest){}\nf(1,b:2);\nvar g=(x,...ys)=>ys;\n"
+	expected := `fun f(a, b = a + 1, ...rest) {}
+f(1, b: 2);
+var g = (x, ...ys) => ys;
+`
+	out, err := format.Source(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, out)
+	}
+}
+
+func TestInterpolation(t *testing.T) {
+	src := "print \"a ${b+1} \\${c} ${ \"d${e}\" }\";\n"
+	expected := "print \"a ${b + 1} \\${c} ${\"d${e}\"}\";\n"
+	out, err := format.Source(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, out)
+	}
+}
@@ -0,0 +1,580 @@
+// Package lint looks for code that compiles but is probably a mistake, such
+// as a variable that is never read or a call with the wrong number of
+// arguments. Each kind of mistake is checked by a rule that can be turned on
+// or off by name.
+package lint
+
+import (
+	"fmt"
+	"lox-compiler/parser"
+	"lox-compiler/source"
+	"sort"
+)
+
+type Rule struct {
+	Name        string
+	Description string
+}
+
+// Every rule, in the order they are listed to users.
+var Rules = []Rule{
+	{"unused-variable", "a local variable, function or class that is never read"},
+	{"unused-parameter", "a parameter that is never read"},
+	{"shadow", "a declaration that hides a variable of the same name in an enclosing scope"},
+	{"unreachable", "a statement after a return, throw, break or continue in the same block"},
+	{"undeclared-assign", "an assignment to a variable that is never declared"},
+	{"nil-comparison", "comparing an instance to nil, which is never equal"},
+	{"arity", "calling a function or class with the wrong number of arguments, or naming a parameter it doesn't have"},
+}
+
+type Warning struct {
+	Rule    string
+	Span    source.Span
+	Message string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("%d:%d: %s (%s)", w.Span.Start.Line, w.Span.Start.Column, w.Message, w.Rule)
+}
+
+// Lint src with the named rules, or with every rule if rules is nil. It is
+// an error for src to have syntax errors or for a rule not to exist.
+// Warnings are returned in source order.
+func Source(src string, rules []string) ([]Warning, error) {
+	enabled := make(map[string]bool)
+	if rules == nil {
+		for _, r := range Rules {
+			enabled[r.Name] = true
+		}
+	}
+	for _, name := range rules {
+		if !IsRule(name) {
+			return nil, fmt.Errorf("unknown rule %q", name)
+		}
+		enabled[name] = true
+	}
+
+	tokens, scanErr := parser.NewScanner(src).ScanTokens()
+	if scanErr != nil {
+		return nil, scanErr
+	}
+	p := parser.NewParser(tokens)
+	stmts, err := p.Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	l := linter{enabled: enabled, globals: make(map[string]*binding), assigned: make(map[string]bool)}
+	l.findAssignments(stmts)
+	l.declareGlobals(stmts)
+	l.statements(stmts)
+	sort.SliceStable(l.warnings, func(i, j int) bool {
+		return l.warnings[i].Span.Start.Offset < l.warnings[j].Span.Start.Offset
+	})
+
+	return l.warnings, nil
+}
+
+// Whether there is a rule called name.
+func IsRule(name string) bool {
+	for _, r := range Rules {
+		if r.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+type bindingKind int
+
+const (
+	variable bindingKind = iota
+	parameter
+	function
+	class
+)
+
+// What the linter knows about a declared name.
+type binding struct {
+	name parser.Token
+	kind bindingKind
+	read bool
+	// Whether the name holds what it was declared as for the whole program,
+	// because it is never assigned to or declared again.
+	known bool
+	// The function a call to the name runs: the function itself, or the
+	// class's initializer. nil if it isn't known.
+	callee *parser.Function
+	// The class the variable holds an instance of, if known.
+	instanceOf string
+}
+
+type linter struct {
+	enabled  map[string]bool
+	warnings []Warning
+	scopes   []map[string]*binding
+	globals  map[string]*binding
+	// The names assigned to anywhere in the program. What a name holds is
+	// only known if it is never assigned to.
+	assigned map[string]bool
+	// The classes declared so far, for looking up their initializers.
+	classes map[string]parser.Class
+	// The class whose methods are being checked, if any.
+	currentClass string
+	// Whether the program imports a module's exports without naming the
+	// module, so that any global might have been declared by it.
+	importsAll bool
+}
+
+func (l *linter) warn(rule string, span source.Span, format string, args ...any) {
+	if l.enabled[rule] {
+		l.warnings = append(l.warnings, Warning{Rule: rule, Span: span, Message: fmt.Sprintf(format, args...)})
+	}
+}
+
+func (l *linter) findAssignments(nodes []parser.ASTNode) {
+	for _, node := range nodes {
+		if a, ok := node.(parser.Assign); ok {
+			l.assigned[a.Name.Lexeme] = true
+		}
+		if node != nil {
+			l.findAssignments(parser.Children(node))
+		}
+	}
+}
+
+// Declare the top-level names up front, since functions can refer to
+// globals declared after them.
+func (l *linter) declareGlobals(stmts []parser.Statement) {
+	l.classes = make(map[string]parser.Class)
+	for _, stmt := range parser.Unexport(stmts) {
+		if c, ok := stmt.(parser.Class); ok {
+			l.classes[c.Name.Lexeme] = c
+		}
+	}
+
+	for _, stmt := range parser.Unexport(stmts) {
+		var b *binding
+		switch s := stmt.(type) {
+		case parser.Class:
+			b = l.bind(s.Name, class, l.classInit(s))
+		case parser.Function:
+			b = l.bind(s.Name, function, &s)
+		case parser.Var:
+			b = l.bind(s.Name, variable, nil)
+		case parser.Import:
+			if s.Name == nil {
+				l.importsAll = true
+				continue
+			}
+			b = l.bind(*s.Name, variable, nil)
+		default:
+			continue
+		}
+		if _, ok := l.globals[b.name.Lexeme]; ok {
+			// Declaring a global again replaces it, so what it holds
+			// depends on where it is used.
+			b.known, b.callee = false, nil
+		}
+		l.globals[b.name.Lexeme] = b
+	}
+	for _, stmt := range parser.Unexport(stmts) {
+		if v, ok := stmt.(parser.Var); ok && l.globals[v.Name.Lexeme].known {
+			l.globals[v.Name.Lexeme].instanceOf = l.instanceOf(v.Initializer)
+		}
+	}
+}
+
+func (l *linter) bind(name parser.Token, kind bindingKind, callee *parser.Function) *binding {
+	known := !l.assigned[name.Lexeme]
+	if !known {
+		callee = nil
+	}
+
+	return &binding{name: name, kind: kind, known: known, callee: callee}
+}
+
+// What constructing an instance of c runs: its initializer, or its parent's
+// if it has none. A class with no initializer at all runs an empty one. nil
+// if it isn't known.
+func (l *linter) classInit(c parser.Class) *parser.Function {
+	for depth := 0; depth < len(l.classes); depth++ {
+		for i, m := range c.Methods {
+			if m.Name.Lexeme == "init" {
+				return &c.Methods[i]
+			}
+		}
+		if c.ParentClass == nil {
+			return &parser.Function{}
+		}
+		parent, ok := l.classes[c.ParentClass.Name.Lexeme]
+		if !ok {
+			return nil
+		}
+		c = parent
+	}
+
+	// The classes inherit from each other in a loop.
+	return nil
+}
+
+func (l *linter) beginScope() {
+	l.scopes = append(l.scopes, make(map[string]*binding))
+}
+
+// Close the innermost scope, warning about the names in it that were never
+// read.
+func (l *linter) endScope() {
+	scope := l.scopes[len(l.scopes)-1]
+	l.scopes = l.scopes[:len(l.scopes)-1]
+	for _, b := range scope {
+		if b.read {
+			continue
+		}
+		switch b.kind {
+		case parameter:
+			l.warn("unused-parameter", b.name.Span, "parameter %s is never used", b.name.Lexeme)
+		case function:
+			l.warn("unused-variable", b.name.Span, "local function %s is never used", b.name.Lexeme)
+		case class:
+			l.warn("unused-variable", b.name.Span, "local class %s is never used", b.name.Lexeme)
+		default:
+			l.warn("unused-variable", b.name.Span, "local variable %s is never used", b.name.Lexeme)
+		}
+	}
+}
+
+// Declare b in the innermost scope. Globals were declared up front.
+func (l *linter) declare(b *binding) {
+	if len(l.scopes) == 0 {
+		return
+	}
+
+	name := b.name.Lexeme
+	// Declaring a name twice in one scope is a compile error rather than
+	// shadowing.
+	_, redeclared := l.scopes[len(l.scopes)-1][name]
+	if outer := l.lookup(name); outer != nil && !redeclared {
+		l.warn("shadow", b.name.Span, "%s shadows the declaration on line %d", name, outer.name.Start.Line)
+	}
+	l.scopes[len(l.scopes)-1][name] = b
+}
+
+// The binding name refers to, innermost scope first, or nil if it isn't
+// declared.
+func (l *linter) lookup(name string) *binding {
+	for i := len(l.scopes) - 1; i >= 0; i-- {
+		if b, ok := l.scopes[i][name]; ok {
+			return b
+		}
+	}
+
+	return l.globals[name]
+}
+
+func (l *linter) statements(stmts []parser.Statement) {
+	for i, stmt := range stmts {
+		l.statement(stmt)
+		var keyword string
+		switch stmt.(type) {
+		case parser.Return:
+			keyword = "return"
+		case parser.Throw:
+			keyword = "throw"
+		case parser.Break:
+			keyword = "break"
+		case parser.Continue:
+			keyword = "continue"
+		}
+		if keyword != "" && i+1 < len(stmts) {
+			span := stmts[i+1].SourceSpan().Join(stmts[len(stmts)-1].SourceSpan())
+			l.warn("unreachable", span, "unreachable code after %s", keyword)
+			// Check the rest for other mistakes, but only warn about the
+			// first statement that jumps away.
+			for _, rest := range stmts[i+1:] {
+				l.statement(rest)
+			}
+			return
+		}
+	}
+}
+
+func (l *linter) statement(stmt parser.Statement) {
+	switch s := stmt.(type) {
+	case parser.Block:
+		l.beginScope()
+		l.statements(s.Statements)
+		l.endScope()
+	case parser.Class:
+		if len(l.scopes) > 0 {
+			l.classes[s.Name.Lexeme] = s
+			l.declare(l.bind(s.Name, class, l.classInit(s)))
+		}
+		if s.ParentClass != nil {
+			l.expression(*s.ParentClass)
+		}
+		enclosing := l.currentClass
+		l.currentClass = s.Name.Lexeme
+		for _, m := range s.Methods {
+			l.function(m)
+		}
+		l.currentClass = enclosing
+	case parser.Export:
+		l.statement(s.Declaration)
+	case parser.ExpressionStmt:
+		l.expression(s.Val)
+	case parser.For:
+		l.beginScope()
+		if s.Initializer != nil {
+			l.statement(s.Initializer)
+		}
+		l.expression(s.Conditional)
+		l.expression(s.Increment)
+		l.statement(s.Stmt)
+		l.endScope()
+	case parser.ForIn:
+		l.expression(s.Iterable)
+		l.beginScope()
+		l.declare(&binding{name: s.Name, kind: variable})
+		l.statement(s.Stmt)
+		l.endScope()
+	case parser.Function:
+		l.declare(l.bind(s.Name, function, &s))
+		l.function(s)
+	case parser.If:
+		l.expression(s.Conditional)
+		l.statement(s.If_stmt)
+		if s.Else_stmt != nil {
+			l.statement(s.Else_stmt)
+		}
+	case parser.Print:
+		l.expression(s.Val)
+	case parser.Return:
+		l.expression(s.Return_expr)
+	case parser.Throw:
+		l.expression(s.Value)
+	case parser.Try:
+		l.statement(s.Body)
+		if s.CatchName != nil {
+			l.beginScope()
+			// Catching an exception only to ignore it is common enough
+			// that an unused catch variable isn't worth a warning.
+			l.declare(&binding{name: *s.CatchName, kind: variable, read: true})
+			l.statement(*s.Catch)
+			l.endScope()
+		}
+		if s.Finally != nil {
+			l.statement(*s.Finally)
+		}
+	case parser.Var:
+		l.expression(s.Initializer)
+		b := l.bind(s.Name, variable, nil)
+		if b.known {
+			b.instanceOf = l.instanceOf(s.Initializer)
+		}
+		l.declare(b)
+	case parser.While:
+		l.expression(s.Conditional)
+		l.statement(s.Stmt)
+	}
+}
+
+// Check a function's parameters and body, which share one scope.
+func (l *linter) function(f parser.Function) {
+	l.beginScope()
+	for i, param := range f.Params {
+		// A default value can use the parameters before its own.
+		if i < len(f.Defaults) && f.Defaults[i] != nil {
+			l.expression(f.Defaults[i])
+		}
+		l.declare(&binding{name: param, kind: parameter})
+	}
+	l.statements(f.Body)
+	l.endScope()
+}
+
+func (l *linter) expression(e parser.Expr) {
+	switch e := e.(type) {
+	case parser.Assign:
+		l.expression(e.Value)
+		if l.lookup(e.Name.Lexeme) == nil && !l.importsAll {
+			l.warn("undeclared-assign", e.Name.Span, "assignment to undeclared variable %s", e.Name.Lexeme)
+		}
+	case parser.Binary:
+		l.expression(e.Left)
+		l.expression(e.Right)
+		if e.Operator.Token_type == parser.EQUAL_EQUAL || e.Operator.Token_type == parser.BANG_EQUAL {
+			l.compareToNil(e)
+		}
+	case parser.Call:
+		l.expression(e.Callee)
+		for _, arg := range e.Args {
+			l.expression(arg)
+		}
+		for _, arg := range e.Named {
+			l.expression(arg.Value)
+		}
+		l.checkArity(e)
+	case parser.Get:
+		l.expression(e.Object)
+	case parser.Grouping:
+		l.expression(e.Expr)
+	case parser.Logical:
+		l.expression(e.Left)
+		l.expression(e.Right)
+	case parser.Unary:
+		l.expression(e.Right)
+	case parser.List:
+		for _, element := range e.Elements {
+			l.expression(element)
+		}
+	case parser.Map:
+		for i := range e.Keys {
+			l.expression(e.Keys[i])
+			l.expression(e.Values[i])
+		}
+	case parser.Set:
+		l.expression(e.Object)
+		l.expression(e.Value)
+	case parser.Subscript:
+		l.expression(e.Object)
+		l.expression(e.Index)
+	case parser.SubscriptSet:
+		l.expression(e.Object)
+		l.expression(e.Index)
+		l.expression(e.Value)
+	case parser.Range:
+		l.expression(e.Start)
+		l.expression(e.End)
+	case parser.Interpolation:
+		for _, expr := range e.Exprs {
+			l.expression(expr)
+		}
+	case parser.Lambda:
+		l.function(e.Decl)
+	case parser.Variable:
+		if b := l.lookup(e.Name.Lexeme); b != nil {
+			b.read = true
+		}
+	}
+}
+
+func (l *linter) compareToNil(e parser.Binary) {
+	other := e.Left
+	if !isNil(e.Right) {
+		if !isNil(e.Left) {
+			return
+		}
+		other = e.Right
+	}
+	if class := l.instanceOf(other); class != "" {
+		result := "false"
+		if e.Operator.Token_type == parser.BANG_EQUAL {
+			result = "true"
+		}
+		l.warn("nil-comparison", e.Span, "comparing an instance of %s to nil is always %s", class, result)
+	}
+}
+
+func isNil(e parser.Expr) bool {
+	lit, ok := e.(parser.Literal)
+	return ok && lit.Value == nil
+}
+
+// The class that e is known to evaluate to an instance of, or "".
+func (l *linter) instanceOf(e parser.Expr) string {
+	switch e := e.(type) {
+	case parser.Grouping:
+		return l.instanceOf(e.Expr)
+	case parser.This:
+		return l.currentClass
+	case parser.Variable:
+		if b := l.lookup(e.Name.Lexeme); b != nil {
+			return b.instanceOf
+		}
+	case parser.Call:
+		if callee, ok := e.Callee.(parser.Variable); ok {
+			if b := l.lookup(callee.Name.Lexeme); b != nil && b.kind == class && b.known {
+				return b.name.Lexeme
+			}
+		}
+	}
+
+	return ""
+}
+
+func (l *linter) checkArity(e parser.Call) {
+	callee, ok := e.Callee.(parser.Variable)
+	if !ok {
+		return
+	}
+	b := l.lookup(callee.Name.Lexeme)
+	if b == nil || (b.kind != function && b.kind != class) || b.callee == nil {
+		return
+	}
+	f, name := b.callee, callee.Name.Lexeme
+
+	passed := make(map[int]bool)
+	for i := range e.Args {
+		passed[i] = true
+	}
+	for _, arg := range e.Named {
+		i := paramIndex(*f, arg.Name.Lexeme)
+		switch {
+		case i == -1:
+			l.warn("arity", arg.Name.Span, "%s has no parameter named %s", name, arg.Name.Lexeme)
+			return
+		case f.IsRest(i):
+			l.warn("arity", arg.Name.Span, "the rest parameter %s of %s can't be passed by name", arg.Name.Lexeme, name)
+			return
+		case passed[i]:
+			l.warn("arity", arg.Name.Span, "%s is passed %s more than once", name, arg.Name.Lexeme)
+			return
+		}
+		passed[i] = true
+	}
+
+	min, max := f.Arity()
+	if n := len(e.Args) + len(e.Named); (len(e.Named) == 0 && n < min) || (max != -1 && n > max) {
+		l.warn("arity", e.Span, "%s takes %s but is called with %d", name, takes(min, max), n)
+		return
+	}
+	for i := 0; i < min; i++ {
+		if !passed[i] {
+			l.warn("arity", e.Span, "%s is called without an argument for %s", name, f.Params[i].Lexeme)
+			return
+		}
+	}
+}
+
+// The index of f's parameter called name, or -1 if it has none.
+func paramIndex(f parser.Function, name string) int {
+	for i, param := range f.Params {
+		if param.Lexeme == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// How many arguments a function takes, such as "1 to 2 arguments".
+func takes(min, max int) string {
+	switch {
+	case max == -1:
+		return fmt.Sprintf("at least %d %s", min, plural(min, "argument"))
+	case min != max:
+		return fmt.Sprintf("%d to %d arguments", min, max)
+	}
+
+	return fmt.Sprintf("%d %s", min, plural(min, "argument"))
+}
+
+func plural(n int, word string) string {
+	if n == 1 {
+		return word
+	}
+
+	return word + "s"
+}
@@ -0,0 +1,246 @@
+package lint_test
+
+import (
+	"lox-compiler/lint"
+	"strings"
+	"testing"
+)
+
+// Lint src with one rule and compare the warnings, one per line.
+func expect(t *testing.T, rule, src string, expected ...string) {
+	t.Helper()
+	warnings, err := lint.Source(src, []string{rule})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, w := range warnings {
+		got = append(got, w.String())
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("expected:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestUnusedVariable(t *testing.T) {
+	expect(t, "unused-variable", `var global = 1;
+fun f() {
+  var used = 1;
+  var unused = 2;
+  fun helper() {}
+  print used;
+}
+{
+  var assigned;
+  assigned = 3;
+}
+`,
+		"4:7: local variable unused is never used (unused-variable)",
+		"5:7: local function helper is never used (unused-variable)",
+		"9:7: local variable assigned is never used (unused-variable)",
+	)
+}
+
+func TestUnusedParameter(t *testing.T) {
+	expect(t, "unused-parameter", `fun f(a, b) { return a; }
+class C { m(x) { print this; } }
+`,
+		"1:10: parameter b is never used (unused-parameter)",
+		"2:13: parameter x is never used (unused-parameter)",
+	)
+}
+
+func TestShadow(t *testing.T) {
+	expect(t, "shadow", `var a = 1;
+fun f(a) {
+  var b = a;
+  {
+    var b = 2;
+    print b;
+  }
+  print b;
+}
+`,
+		"2:7: a shadows the declaration on line 1 (shadow)",
+		"5:9: b shadows the declaration on line 3 (shadow)",
+	)
+}
+
+func TestUnreachable(t *testing.T) {
+	expect(t, "unreachable", `fun f() {
+  return 1;
+  print 2;
+  return 3;
+  print 4;
+}
+fun g() {
+  if (true) return 1;
+  print 2;
+}
+`,
+		"3:3: unreachable code after return (unreachable)",
+	)
+}
+
+func TestUndeclaredAssign(t *testing.T) {
+	expect(t, "undeclared-assign", `var declared;
+fun f() {
+  declared = 1;
+  later = 2;
+  missing = 3;
+}
+var later;
+`,
+		"5:3: assignment to undeclared variable missing (undeclared-assign)",
+	)
+}
+
+func TestNilComparison(t *testing.T) {
+	expect(t, "nil-comparison", `class Point {
+  isNil() { return this == nil; }
+}
+var p = Point();
+var q = Point();
+q = nil;
+print p != nil;
+print q == nil;
+print nil == (Point());
+`,
+		"2:20: comparing an instance of Point to nil is always false (nil-comparison)",
+		"7:7: comparing an instance of Point to nil is always true (nil-comparison)",
+		"9:7: comparing an instance of Point to nil is always false (nil-comparison)",
+	)
+}
+
+func TestArity(t *testing.T) {
+	expect(t, "arity", `fun add(a, b) { return a + b; }
+class Base { init(x) {} }
+class Derived < Base {}
+class Empty {}
+add(1);
+add(1, 2);
+Derived();
+Empty(1);
+var reassigned = add;
+fun g() {}
+g = add;
+g(1, 2);
+`,
+		"5:1: add takes 2 arguments but is called with 1 (arity)",
+		"7:1: Derived takes 1 argument but is called with 0 (arity)",
+		"8:1: Empty takes 0 arguments but is called with 1 (arity)",
+	)
+	expect(t, "arity", `fun f(a, b = 1, ...rest) {}
+class P { init(x, y = 0) {} }
+f();
+f(1, 2, 3, 4);
+f(b: 2, a: 1);
+f(b: 2);
+f(1, a: 2);
+f(1, c: 2);
+f(1, rest: 2);
+P(1, 2, 3);
+P(y: 1);
+`,
+		"3:1: f takes at least 1 argument but is called with 0 (arity)",
+		"6:1: f is called without an argument for a (arity)",
+		"7:6: f is passed a more than once (arity)",
+		"8:6: f has no parameter named c (arity)",
+		"9:6: the rest parameter rest of f can't be passed by name (arity)",
+		"10:1: P takes 1 to 2 arguments but is called with 3 (arity)",
+		"11:1: P is called without an argument for x (arity)",
+	)
+}
+
+func TestRules(t *testing.T) {
+	src := "fun f(a) { var b; return; print 1; }\n"
+	warnings, err := lint.Source(src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 3 {
+		t.Fatalf("expected every rule to run, got %v", warnings)
+	}
+
+	warnings, err = lint.Source(src, []string{})
+	if err != nil || len(warnings) != 0 {
+		t.Fatalf("expected no rules to run, got %v, %v", warnings, err)
+	}
+
+	if _, err := lint.Source(src, []string{"no-such-rule"}); err == nil {
+		t.Fatal("expected an error for an unknown rule")
+	}
+	if _, err := lint.Source("var = 1;", nil); err == nil {
+		t.Fatal("expected an error for a syntax error")
+	}
+}
+
+func TestImports(t *testing.T) {
+	// A module imported without a name may declare any global.
+	expect(t, "undeclared-assign", `import "m.lox";
+fun f() { fromModule = 1; }
+`)
+	expect(t, "undeclared-assign", `import m from "m.lox";
+export var exported;
+fun f() { m = 1; exported = 2; missing = 3; }
+`,
+		"3:32: assignment to undeclared variable missing (undeclared-assign)",
+	)
+}
+
+func TestExceptions(t *testing.T) {
+	expect(t, "unreachable", `fun f() {
+  throw "oops";
+  print 1;
+}
+try { f(); } catch (e) {}
+`,
+		"3:3: unreachable code after throw (unreachable)",
+	)
+	// An unused catch variable isn't worth a warning, but it can shadow.
+	expect(t, "unused-variable", "{ try {} catch (e) {} }\n")
+	expect(t, "shadow", "{ var e; try {} catch (e) { print e; } print e; }\n",
+		"1:24: e shadows the declaration on line 1 (shadow)",
+	)
+}
+
+func TestLoopJumps(t *testing.T) {
+	expect(t, "unreachable", `while (true) {
+  break;
+  print 1;
+}
+for (;;) {
+  if (true) continue;
+  print 2;
+}
+`,
+		"3:3: unreachable code after break (unreachable)",
+	)
+}
+
+func TestLists(t *testing.T) {
+	// Variables read inside list literals and subscripts are used.
+	expect(t, "unused-variable", "{ var a = 1; var i = 0; var xs = [a]; xs[i] = xs[i]; }\n")
+}
+
+func TestForIn(t *testing.T) {
+	// The loop variable is scoped to the loop, and the range bounds and
+	// iterable are read.
+	expect(t, "unused-variable", "{ var n = 3; var xs = 0..n; for (var x in xs) print x; for (var y in xs) {} }\n",
+		"1:65: local variable y is never used (unused-variable)",
+	)
+	expect(t, "shadow", "{ var x; for (var x in [x]) print x; }\n",
+		"1:19: x shadows the declaration on line 1 (shadow)",
+	)
+}
+
+func TestLambda(t *testing.T) {
+	// A lambda's parameters are checked like a function's, and the variables
+	// it captures count as read.
+	expect(t, "unused-parameter", "{ var n = 1; var f = (a, b) => a + n; print f; }\n",
+		"1:26: parameter b is never used (unused-parameter)",
+	)
+	expect(t, "unused-variable", "{ var n = 1; var f = fun () { var m; return n; }; print f; }\n",
+		"1:35: local variable m is never used (unused-variable)",
+	)
+}
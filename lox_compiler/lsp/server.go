@@ -0,0 +1,391 @@
+// Package lsp implements a Language Server Protocol server for Lox. It
+// speaks JSON-RPC over a pair of streams, normally the process's stdin and
+// stdout, and answers from the analysis of each open document.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"lox-compiler/analysis"
+	"lox-compiler/lint"
+	"lox-compiler/source"
+	"net/textproto"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Returned by Serve when the client sends exit without asking the server to
+// shut down first. The specification asks for a non-zero exit status then.
+var ErrExitWithoutShutdown = errors.New("exit notification received before shutdown")
+
+type Server struct {
+	in           *bufio.Reader
+	out          io.Writer
+	docs         map[string]*document
+	initialized  bool
+	shuttingDown bool
+}
+
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{in: bufio.NewReader(in), out: out, docs: make(map[string]*document)}
+}
+
+// Handle messages until the client sends the exit notification.
+func (s *Server) Serve() error {
+	for {
+		body, err := s.read()
+		if err != nil {
+			return fmt.Errorf("reading message: %w", err)
+		}
+		var req request
+		if err := json.Unmarshal(body, &req); err != nil {
+			if err := s.respond(nil, nil, &ResponseError{Code: codeParseError, Message: err.Error()}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if req.Method == "exit" {
+			if !s.shuttingDown {
+				return ErrExitWithoutShutdown
+			}
+			return nil
+		}
+		if req.ID == nil {
+			if err := s.notified(req); err != nil {
+				return err
+			}
+			continue
+		}
+		result, rErr := s.handle(req)
+		if err := s.respond(*req.ID, result, rErr); err != nil {
+			return err
+		}
+	}
+}
+
+// Read the body of the next message, which follows a header giving its
+// length.
+func (s *Server) read() ([]byte, error) {
+	header, err := textproto.NewReader(s.in).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("bad Content-Length: %w", err)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.in, body); err != nil {
+		return nil, err
+	}
+
+	return body, nil
+}
+
+func (s *Server) write(msg any) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+
+	return err
+}
+
+func (s *Server) respond(id json.RawMessage, result any, rErr *ResponseError) error {
+	resp := response{JSONRPC: "2.0", ID: id, Error: rErr}
+	if rErr == nil {
+		body, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		resp.Result = body
+	}
+
+	return s.write(resp)
+}
+
+func (s *Server) notify(method string, params any) error {
+	return s.write(notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+func (s *Server) handle(req request) (any, *ResponseError) {
+	if !s.initialized && req.Method != "initialize" {
+		return nil, &ResponseError{Code: codeServerNotInitialized, Message: "the server has not been initialized"}
+	}
+	if s.shuttingDown {
+		return nil, &ResponseError{Code: codeInvalidRequest, Message: "the server is shutting down"}
+	}
+
+	switch req.Method {
+	case "initialize":
+		s.initialized = true
+		var result InitializeResult
+		result.Capabilities = ServerCapabilities{
+			TextDocumentSync:       textDocumentSyncFull,
+			DocumentSymbolProvider: true,
+			DefinitionProvider:     true,
+			ReferencesProvider:     true,
+			HoverProvider:          true,
+		}
+		result.ServerInfo.Name = "lox"
+		return result, nil
+	case "shutdown":
+		s.shuttingDown = true
+		return nil, nil
+	case "textDocument/documentSymbol":
+		var params DocumentSymbolParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		return s.documentSymbols(params), nil
+	case "textDocument/definition":
+		var params TextDocumentPositionParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		return s.definition(params), nil
+	case "textDocument/references":
+		var params ReferenceParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		return s.references(params), nil
+	case "textDocument/hover":
+		var params TextDocumentPositionParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		return s.hover(params), nil
+	}
+
+	return nil, &ResponseError{Code: codeMethodNotFound, Message: fmt.Sprintf("method %q is not supported", req.Method)}
+}
+
+func invalidParams(err error) *ResponseError {
+	return &ResponseError{Code: codeInvalidParams, Message: err.Error()}
+}
+
+// Handle a notification. Notifications have no response, so a malformed one
+// is ignored.
+func (s *Server) notified(req request) error {
+	if !s.initialized {
+		return nil
+	}
+
+	switch req.Method {
+	case "textDocument/didOpen":
+		var params DidOpenTextDocumentParams
+		if json.Unmarshal(req.Params, &params) != nil {
+			return nil
+		}
+		return s.open(params.TextDocument.URI, params.TextDocument.Text)
+	case "textDocument/didChange":
+		var params DidChangeTextDocumentParams
+		if json.Unmarshal(req.Params, &params) != nil || len(params.ContentChanges) == 0 {
+			return nil
+		}
+		// The server asks for full document sync, so the last change holds
+		// the whole text.
+		return s.open(params.TextDocument.URI, params.ContentChanges[len(params.ContentChanges)-1].Text)
+	case "textDocument/didClose":
+		var params DidCloseTextDocumentParams
+		if json.Unmarshal(req.Params, &params) != nil {
+			return nil
+		}
+		delete(s.docs, params.TextDocument.URI)
+		return s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: params.TextDocument.URI, Diagnostics: []Diagnostic{}})
+	}
+
+	return nil
+}
+
+// Analyse the text of a document and publish its diagnostics.
+func (s *Server) open(uri, text string) error {
+	doc := newDocument(text)
+	s.docs[uri] = doc
+
+	diagnostics := []Diagnostic{}
+	for _, d := range doc.file.Diagnostics {
+		diagnostics = append(diagnostics, Diagnostic{
+			Range:    doc.toRange(d.Span),
+			Severity: severityError,
+			Source:   "lox",
+			Message:  d.Message,
+		})
+	}
+	// Calls that don't match what they call are most likely mistakes, so
+	// the linter's check of them is shown as a warning. The other rules are
+	// matters of style and left to lox lint.
+	if warnings, err := lint.Source(text, []string{"arity"}); err == nil {
+		for _, w := range warnings {
+			diagnostics = append(diagnostics, Diagnostic{
+				Range:    doc.toRange(w.Span),
+				Severity: severityWarning,
+				Source:   "lox lint",
+				Message:  w.Message,
+			})
+		}
+	}
+
+	return s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: uri, Diagnostics: diagnostics})
+}
+
+func (s *Server) documentSymbols(params DocumentSymbolParams) []DocumentSymbol {
+	doc, ok := s.docs[params.TextDocument.URI]
+	if !ok {
+		return []DocumentSymbol{}
+	}
+
+	return doc.documentSymbols(doc.file.Symbols)
+}
+
+func (s *Server) symbolAt(params TextDocumentPositionParams) (*document, *analysis.Symbol) {
+	doc, ok := s.docs[params.TextDocument.URI]
+	if !ok {
+		return nil, nil
+	}
+
+	return doc, doc.file.SymbolAt(doc.offsetAt(params.Position))
+}
+
+func (s *Server) definition(params TextDocumentPositionParams) any {
+	doc, sym := s.symbolAt(params)
+	if sym == nil {
+		return nil
+	}
+
+	return Location{URI: params.TextDocument.URI, Range: doc.toRange(sym.NameSpan)}
+}
+
+func (s *Server) references(params ReferenceParams) []Location {
+	locations := []Location{}
+	doc, sym := s.symbolAt(params.TextDocumentPositionParams)
+	if sym == nil {
+		return locations
+	}
+
+	uri := params.TextDocument.URI
+	if params.Context.IncludeDeclaration {
+		locations = append(locations, Location{URI: uri, Range: doc.toRange(sym.NameSpan)})
+	}
+	for _, span := range sym.References {
+		locations = append(locations, Location{URI: uri, Range: doc.toRange(span)})
+	}
+
+	return locations
+}
+
+func (s *Server) hover(params TextDocumentPositionParams) any {
+	_, sym := s.symbolAt(params)
+	if sym == nil {
+		return nil
+	}
+
+	return Hover{Contents: MarkupContent{Kind: "markdown", Value: "```lox\n" + sym.Detail + "\n```"}}
+}
+
+// An open document and its analysis.
+type document struct {
+	text string
+	file *analysis.File
+	// The byte offset at which each line starts.
+	lineStarts []int
+}
+
+func newDocument(text string) *document {
+	doc := &document{text: text, file: analysis.Analyze(text), lineStarts: []int{0}}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			doc.lineStarts = append(doc.lineStarts, i+1)
+		}
+	}
+
+	return doc
+}
+
+// LSP positions count lines from 0 and characters in UTF-16 code units,
+// where source positions count lines from 1 and columns in bytes.
+func (d *document) toPosition(p source.Position) Position {
+	if p.Line < 1 {
+		return Position{}
+	}
+	lineStart := min(max(p.Offset-(p.Column-1), 0), len(d.text))
+	offset := min(max(p.Offset, lineStart), len(d.text))
+
+	return Position{Line: p.Line - 1, Character: utf16Len(d.text[lineStart:offset])}
+}
+
+func (d *document) toRange(span source.Span) Range {
+	return Range{Start: d.toPosition(span.Start), End: d.toPosition(span.End)}
+}
+
+// The byte offset of an LSP position. Positions past the end of a line are
+// clamped to it.
+func (d *document) offsetAt(p Position) int {
+	if p.Line < 0 {
+		return 0
+	}
+	if p.Line >= len(d.lineStarts) {
+		return len(d.text)
+	}
+
+	offset := d.lineStarts[p.Line]
+	for units := 0; offset < len(d.text) && d.text[offset] != '\n' && units < p.Character; {
+		r, size := utf8.DecodeRuneInString(d.text[offset:])
+		units += utf16RuneLen(r)
+		offset += size
+	}
+
+	return offset
+}
+
+func (d *document) documentSymbols(symbols []*analysis.Symbol) []DocumentSymbol {
+	result := []DocumentSymbol{}
+	for _, sym := range symbols {
+		result = append(result, DocumentSymbol{
+			Name:           sym.Name,
+			Detail:         sym.Detail,
+			Kind:           symbolKind(sym.Kind),
+			Range:          d.toRange(sym.Span),
+			SelectionRange: d.toRange(sym.NameSpan),
+			Children:       d.documentSymbols(sym.Children),
+		})
+	}
+
+	return result
+}
+
+func symbolKind(k analysis.Kind) int {
+	switch k {
+	case analysis.Function:
+		return symbolKindFunction
+	case analysis.Class:
+		return symbolKindClass
+	case analysis.Method:
+		return symbolKindMethod
+	}
+
+	return symbolKindVariable
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16RuneLen(r)
+	}
+
+	return n
+}
+
+func utf16RuneLen(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+
+	return 1
+}
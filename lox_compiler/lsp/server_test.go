@@ -0,0 +1,239 @@
+package lsp_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"lox-compiler/lsp"
+	"net/textproto"
+	"strconv"
+	"testing"
+)
+
+const uri = "file:///test.lox"
+
+// The 😀 takes two UTF-16 code units, so positions after it on line 4
+// differ from byte columns.
+const text = `fun add(a, b) {
+  return a + b;
+}
+var s = "😀"; var sum = s.x = add(1, 2);
+print sum;
+`
+
+// A client session: the messages to send and, once Serve has run, the ones
+// the server sent back.
+type session struct {
+	in     bytes.Buffer
+	nextID int
+}
+
+func (s *session) send(msg map[string]any) {
+	msg["jsonrpc"] = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Fprintf(&s.in, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func (s *session) request(method string, params any) int {
+	s.nextID++
+	s.send(map[string]any{"id": s.nextID, "method": method, "params": params})
+	return s.nextID
+}
+
+func (s *session) notify(method string, params any) {
+	s.send(map[string]any{"method": method, "params": params})
+}
+
+type reply struct {
+	ID     *int
+	Method string
+	Params json.RawMessage
+	Result json.RawMessage
+	Error  *lsp.ResponseError
+}
+
+func (s *session) run(t *testing.T) (map[int]reply, []reply, error) {
+	out := bytes.Buffer{}
+	err := lsp.NewServer(&s.in, &out).Serve()
+
+	responses := make(map[int]reply)
+	var notifications []reply
+	r := bufio.NewReader(&out)
+	for {
+		header, hErr := textproto.NewReader(r).ReadMIMEHeader()
+		if errors.Is(hErr, io.EOF) {
+			break
+		} else if hErr != nil {
+			t.Fatal(hErr)
+		}
+		length, _ := strconv.Atoi(header.Get("Content-Length"))
+		body := make([]byte, length)
+		if _, err := io.ReadFull(r, body); err != nil {
+			t.Fatal(err)
+		}
+		var msg reply
+		if err := json.Unmarshal(body, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.ID != nil {
+			responses[*msg.ID] = msg
+		} else {
+			notifications = append(notifications, msg)
+		}
+	}
+
+	return responses, notifications, err
+}
+
+func position(line, character int) map[string]any {
+	return map[string]any{
+		"textDocument": map[string]any{"uri": uri},
+		"position":     map[string]any{"line": line, "character": character},
+	}
+}
+
+func decode[T any](t *testing.T, r reply) T {
+	var v T
+	if r.Error != nil {
+		t.Fatalf("unexpected error %v", r.Error)
+	}
+	if err := json.Unmarshal(r.Result, &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestSession(t *testing.T) {
+	s := session{}
+	initialize := s.request("initialize", map[string]any{"capabilities": map[string]any{}})
+	s.notify("initialized", map[string]any{})
+	s.notify("textDocument/didOpen", map[string]any{
+		"textDocument": map[string]any{"uri": uri, "languageId": "lox", "version": 1, "text": text},
+	})
+	symbols := s.request("textDocument/documentSymbol", map[string]any{"textDocument": map[string]any{"uri": uri}})
+	// The call to add on line 4, after the emoji.
+	definition := s.request("textDocument/definition", position(3, 32))
+	references := s.request("textDocument/references", map[string]any{
+		"textDocument": map[string]any{"uri": uri},
+		"position":     map[string]any{"line": 1, "character": 9},
+		"context":      map[string]any{"includeDeclaration": true},
+	})
+	hover := s.request("textDocument/hover", position(4, 7))
+	nothing := s.request("textDocument/hover", position(4, 1))
+	unknown := s.request("textDocument/rename", position(4, 7))
+	s.notify("textDocument/didChange", map[string]any{
+		"textDocument":   map[string]any{"uri": uri, "version": 2},
+		"contentChanges": []map[string]any{{"text": "var 1;\n"}},
+	})
+	shutdown := s.request("shutdown", nil)
+	s.notify("exit", nil)
+
+	responses, notifications, err := s.run(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caps := decode[lsp.InitializeResult](t, responses[initialize]).Capabilities
+	if !caps.DefinitionProvider || !caps.ReferencesProvider || !caps.HoverProvider || !caps.DocumentSymbolProvider {
+		t.Fatalf("unexpected capabilities %+v", caps)
+	}
+
+	syms := decode[[]lsp.DocumentSymbol](t, responses[symbols])
+	if len(syms) != 3 || syms[0].Name != "add" || syms[0].Detail != "fun add(a, b)" || syms[2].Name != "sum" {
+		t.Fatalf("unexpected symbols %+v", syms)
+	}
+	if r := syms[0].Range; r.Start != (lsp.Position{Line: 0, Character: 0}) || r.End != (lsp.Position{Line: 2, Character: 1}) {
+		t.Fatalf("unexpected range for add %+v", r)
+	}
+
+	loc := decode[lsp.Location](t, responses[definition])
+	if loc.URI != uri || loc.Range.Start != (lsp.Position{Line: 0, Character: 4}) || loc.Range.End != (lsp.Position{Line: 0, Character: 7}) {
+		t.Fatalf("unexpected definition %+v", loc)
+	}
+
+	refs := decode[[]lsp.Location](t, responses[references])
+	if len(refs) != 2 || refs[0].Range.Start != (lsp.Position{Line: 0, Character: 8}) || refs[1].Range.Start != (lsp.Position{Line: 1, Character: 9}) {
+		t.Fatalf("unexpected references to a %+v", refs)
+	}
+
+	h := decode[lsp.Hover](t, responses[hover])
+	if h.Contents.Value != "```lox\nvar sum\n```" {
+		t.Fatalf("unexpected hover %q", h.Contents.Value)
+	}
+	if string(responses[nothing].Result) != "null" {
+		t.Fatalf("expected no hover over a keyword, got %s", responses[nothing].Result)
+	}
+	if e := responses[unknown].Error; e == nil || e.Code != -32601 {
+		t.Fatalf("expected method not found, got %v", e)
+	}
+	if r := responses[shutdown]; r.Error != nil || string(r.Result) != "null" {
+		t.Fatalf("unexpected shutdown response %+v", r)
+	}
+
+	if len(notifications) != 2 {
+		t.Fatalf("expected diagnostics to be published twice, got %d notifications", len(notifications))
+	}
+	var published lsp.PublishDiagnosticsParams
+	if err := json.Unmarshal(notifications[0].Params, &published); err != nil {
+		t.Fatal(err)
+	}
+	// The program parses, but the compiler can't compile setting properties.
+	if len(published.Diagnostics) != 1 || published.Diagnostics[0].Range.Start != (lsp.Position{Line: 3, Character: 24}) {
+		t.Fatalf("expected the compiler's diagnostic for the assignment, got %+v", published.Diagnostics)
+	}
+	if err := json.Unmarshal(notifications[1].Params, &published); err != nil {
+		t.Fatal(err)
+	}
+	if len(published.Diagnostics) != 1 || published.Diagnostics[0].Message != "Expect variable name." ||
+		published.Diagnostics[0].Range.Start != (lsp.Position{Line: 0, Character: 4}) {
+		t.Fatalf("unexpected diagnostics %+v", published.Diagnostics)
+	}
+}
+
+func TestExitWithoutShutdown(t *testing.T) {
+	s := session{}
+	early := s.request("textDocument/hover", position(0, 0))
+	s.notify("exit", nil)
+
+	responses, _, err := s.run(t)
+	if !errors.Is(err, lsp.ErrExitWithoutShutdown) {
+		t.Fatalf("expected ErrExitWithoutShutdown, got %v", err)
+	}
+	if e := responses[early].Error; e == nil || e.Code != -32002 {
+		t.Fatalf("expected a request before initialize to fail, got %v", e)
+	}
+}
+
+func TestArityWarnings(t *testing.T) {
+	s := session{}
+	s.request("initialize", map[string]any{"capabilities": map[string]any{}})
+	s.notify("textDocument/didOpen", map[string]any{
+		"textDocument": map[string]any{"uri": uri, "languageId": "lox", "version": 1, "text": "fun f(a, b = 1) {}\nf(c: 2);\n"},
+	})
+	s.request("shutdown", nil)
+	s.notify("exit", nil)
+
+	_, notifications, err := s.run(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var published lsp.PublishDiagnosticsParams
+	if err := json.Unmarshal(notifications[0].Params, &published); err != nil {
+		t.Fatal(err)
+	}
+	// The call compiles, and fails only when run, so the linter's warning
+	// is all there is.
+	if len(published.Diagnostics) != 1 {
+		t.Fatalf("expected one diagnostic, got %+v", published.Diagnostics)
+	}
+	warning := published.Diagnostics[0]
+	if warning.Severity != 2 || warning.Message != "f has no parameter named c" || warning.Range.Start != (lsp.Position{Line: 1, Character: 2}) {
+		t.Fatalf("expected a warning about the named argument, got %+v", published.Diagnostics)
+	}
+}
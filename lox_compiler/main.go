@@ -3,14 +3,37 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
+	"lox-compiler/decimal"
+	"lox-compiler/format"
+	"lox-compiler/lint"
+	"lox-compiler/lsp"
+	"lox-compiler/source"
+	"lox-compiler/typecheck"
 	"lox-compiler/vm"
     "os"
     "bufio"
+    "path/filepath"
+    "strings"
 )
 
+var searchPath = flag.String("path", os.Getenv("LOXPATH"), "directories to search for imported modules, separated by '"+string(filepath.ListSeparator)+"'")
+var decimalPrecision = flag.Int("decimal-precision", decimal.DefaultPrecision, "the significant digits decimal arithmetic rounds to")
+var decimalRounding = flag.String("decimal-rounding", decimal.HalfEven.String(), "how decimal arithmetic rounds: half_even, half_up, half_down, up, down, ceiling or floor")
+
+// The decimal context the flags ask for.
+func decimalContext() decimal.Context {
+    rounding, ok := decimal.ParseRounding(*decimalRounding)
+    if !ok || *decimalPrecision < 1 {
+        usage()
+        os.Exit(64)
+    }
+    return decimal.Context{Precision: *decimalPrecision, Rounding: rounding}
+}
+
 func repl() {
 	reader := bufio.NewReader(os.Stdin)
-    vm := vm.VirtualMachine{}
+    vm := vm.VirtualMachine{SearchPath: filepath.SplitList(*searchPath), Decimal: decimalContext()}
     vm.InteractiveMode = true
 
     for ;; {
@@ -27,20 +50,197 @@ func repl() {
 }
 
 func runFile(path string) {
-    vm := vm.VirtualMachine{}
+    vm := vm.VirtualMachine{Path: path, SearchPath: filepath.SplitList(*searchPath), Decimal: decimalContext()}
     code, err := os.ReadFile(path)
     if err != nil {
-        fmt.Println(err.Error())
-        os.Exit(1)
+        fmt.Fprintln(os.Stderr, err.Error())
+        os.Exit(66)
     }
 
     if err := vm.Interpret(string(code)); err != nil {
-        fmt.Println(err.Error())
+        fmt.Fprintln(os.Stderr, err.Error())
+        fmt.Fprint(os.Stderr, source.Render(string(code), err.Span()))
+        if err.IsCompileError() {
+            os.Exit(65)
+        }
+        os.Exit(70)
+    }
+}
+
+// Serve the Language Server Protocol on stdin and stdout.
+func serveLSP() {
+    if err := lsp.NewServer(os.Stdin, os.Stdout).Serve(); err != nil {
+        fmt.Fprintln(os.Stderr, err.Error())
+        os.Exit(1)
+    }
+}
+
+// Format the files at paths, or stdin if there are none. With -check, list
+// the files that aren't formatted instead and exit with status 1 if there
+// are any. With -w, rewrite the files in place.
+func formatFiles(args []string) {
+    flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+    check := flags.Bool("check", false, "list files whose formatting differs and exit with status 1 if there are any")
+    write := flags.Bool("w", false, "write the result to the files instead of stdout")
+    flags.Parse(args)
+
+    status := 0
+    formatOne := func(name string, src []byte) {
+        out, err := format.Source(string(src))
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "%s: %s\n", name, err.Error())
+            status = 65
+            return
+        }
+        switch {
+        case *check:
+            if out != string(src) {
+                fmt.Println(name)
+                if status == 0 {
+                    status = 1
+                }
+            }
+        case *write:
+            if out != string(src) {
+                if err := os.WriteFile(name, []byte(out), 0o644); err != nil {
+                    fmt.Fprintln(os.Stderr, err.Error())
+                    status = 74
+                }
+            }
+        default:
+            fmt.Print(out)
+        }
+    }
+
+    if flags.NArg() == 0 && *write {
+        fmt.Fprintln(os.Stderr, "lox fmt: -w needs a path")
+        os.Exit(64)
+    }
+    eachSource(flags.Args(), formatOne)
+    os.Exit(status)
+}
+
+// Lint the files at paths, or stdin if there are none, and exit with status
+// 1 if there are any warnings.
+func lintFiles(args []string) {
+    flags := flag.NewFlagSet("lint", flag.ExitOnError)
+    enable := flags.String("enable", "", "comma-separated rules to run instead of all of them")
+    disable := flags.String("disable", "", "comma-separated rules not to run")
+    flags.Usage = func() {
+        fmt.Fprintln(os.Stderr, "usage: lox lint [-enable rules] [-disable rules] [path ...]")
+        flags.PrintDefaults()
+        fmt.Fprintln(os.Stderr, "rules:")
+        for _, r := range lint.Rules {
+            fmt.Fprintf(os.Stderr, "  %-18s %s\n", r.Name, r.Description)
+        }
+    }
+    flags.Parse(args)
+
+    // A nil list of rules runs all of them.
+    var rules []string
+    if *enable != "" {
+        rules = strings.Split(*enable, ",")
+        for _, r := range rules {
+            if !lint.IsRule(r) {
+                fmt.Fprintf(os.Stderr, "lox lint: unknown rule %q\n", r)
+                os.Exit(64)
+            }
+        }
+    }
+    if *disable != "" {
+        disabled := make(map[string]bool)
+        for _, r := range strings.Split(*disable, ",") {
+            disabled[r] = true
+            if !lint.IsRule(r) {
+                fmt.Fprintf(os.Stderr, "lox lint: unknown rule %q\n", r)
+                os.Exit(64)
+            }
+        }
+        if rules == nil {
+            for _, r := range lint.Rules {
+                rules = append(rules, r.Name)
+            }
+        }
+        kept := []string{}
+        for _, r := range rules {
+            if !disabled[r] {
+                kept = append(kept, r)
+            }
+        }
+        rules = kept
+    }
+
+    status := 0
+    lintOne := func(name string, src []byte) {
+        warnings, err := lint.Source(string(src), rules)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "%s: %s\n", name, err.Error())
+            status = 65
+            return
+        }
+        for _, w := range warnings {
+            fmt.Printf("%s:%s\n", name, w.String())
+            if status == 0 {
+                status = 1
+            }
+        }
+    }
+
+    eachSource(flags.Args(), lintOne)
+    os.Exit(status)
+}
+
+// Type check the files at paths, or stdin if there are none, and exit with
+// status 1 if there are any errors.
+func checkFiles(args []string) {
+    flags := flag.NewFlagSet("check", flag.ExitOnError)
+    flags.Parse(args)
+
+    status := 0
+    eachSource(flags.Args(), func(name string, src []byte) {
+        errs, err := typecheck.Source(string(src))
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "%s: %s\n", name, err.Error())
+            status = 65
+            return
+        }
+        for _, e := range errs {
+            fmt.Printf("%s:%s\n", name, e.String())
+            if status == 0 {
+                status = 1
+            }
+        }
+    })
+    os.Exit(status)
+}
+
+// Call fn with the name and contents of each file at paths, or of stdin if
+// there are none. A file that can't be read ends the program.
+func eachSource(paths []string, fn func(name string, src []byte)) {
+    if len(paths) == 0 {
+        src, err := io.ReadAll(os.Stdin)
+        if err != nil {
+            fmt.Fprintln(os.Stderr, err.Error())
+            os.Exit(74)
+        }
+        fn("<stdin>", src)
+    }
+    for _, path := range paths {
+        src, err := os.ReadFile(path)
+        if err != nil {
+            fmt.Fprintln(os.Stderr, err.Error())
+            os.Exit(66)
+        }
+        fn(path, src)
     }
 }
 
 func usage() {
-    fmt.Fprintln(os.Stderr, "usage: lox [path]")
+    fmt.Fprintln(os.Stderr, "usage: lox [-path dirs] [-decimal-precision digits] [-decimal-rounding mode] [path]")
+    fmt.Fprintln(os.Stderr, "       lox lsp")
+    fmt.Fprintln(os.Stderr, "       lox fmt [-check] [-w] [path ...]")
+    fmt.Fprintln(os.Stderr, "       lox lint [-enable rules] [-disable rules] [path ...]")
+    fmt.Fprintln(os.Stderr, "       lox check [path ...]")
 }
 
 func main() {
@@ -48,6 +248,14 @@ func main() {
 	args := flag.Args()
     if len(args) == 0 {
         repl()
+    } else if len(args) == 1 && args[0] == "lsp" {
+        serveLSP()
+    } else if args[0] == "fmt" {
+        formatFiles(args[1:])
+    } else if args[0] == "lint" {
+        lintFiles(args[1:])
+    } else if args[0] == "check" {
+        checkFiles(args[1:])
     } else if len(args) == 1 {
         runFile(args[0])
     } else {
@@ -2,17 +2,22 @@ package parser
 
 import (
 	"fmt"
+	"lox-compiler/source"
+	"strconv"
 	"strings"
 )
 
+// Every node embeds the source.Span of the text it was parsed from.
 type ASTNode interface {
 	String() string
+	SourceSpan() source.Span
 }
 
 type Expr = ASTNode
 type Statement = ASTNode
 
 type Assign struct {
+	source.Span
 	Name  Token
 	Value Expr
 }
@@ -22,6 +27,7 @@ func (e Assign) String() string {
 }
 
 type Binary struct {
+	source.Span
 	Left     Expr
 	Operator Token
 	Right    Expr
@@ -32,16 +38,34 @@ func (s Binary) String() string {
 }
 
 type Call struct {
+	source.Span
 	Callee Expr
 	Paren  Token
 	Args   []Expr
+	// The arguments passed by name, such as "b: 2", which follow the
+	// positional ones.
+	Named []NamedArg
 }
 
 func (e Call) String() string {
+	if len(e.Named) > 0 {
+		return fmt.Sprintf("CALL %v (%v %v)", e.Callee, e.Args, e.Named)
+	}
 	return fmt.Sprintf("CALL %v (%v)", e.Callee, e.Args)
 }
 
+// An argument passed to a parameter by its name.
+type NamedArg struct {
+	Name  Token
+	Value Expr
+}
+
+func (a NamedArg) String() string {
+	return fmt.Sprintf("%s: %v", a.Name.Lexeme, a.Value)
+}
+
 type Get struct {
+	source.Span
 	Object Expr
 	Name   Token
 }
@@ -51,6 +75,7 @@ func (e Get) String() string {
 }
 
 type Grouping struct {
+	source.Span
 	Expr Expr
 }
 
@@ -59,6 +84,7 @@ func (e Grouping) String() string {
 }
 
 type Literal struct {
+	source.Span
 	Value any
 }
 
@@ -67,6 +93,7 @@ func (e Literal) String() string {
 }
 
 type Logical struct {
+	source.Span
 	Left     Expr
 	Operator Token
 	Right    Expr
@@ -81,6 +108,7 @@ func (e Logical) String() string {
 }
 
 type Unary struct {
+	source.Span
 	Operator Token
 	Right    Expr
 }
@@ -90,6 +118,7 @@ func (e Unary) String() string {
 }
 
 type Set struct {
+	source.Span
 	Object Expr
 	Name   Token
 	Value  Expr
@@ -103,7 +132,108 @@ func (e Set) String() string {
 	)
 }
 
+// A list literal, such as "[1, 2, 3]".
+type List struct {
+	source.Span
+	Bracket  Token
+	Elements []Expr
+}
+
+func (e List) String() string {
+	return fmt.Sprintf("LIST %v", e.Elements)
+}
+
+// A map literal, such as "{"a": 1, "b": 2}". Keys[i] maps to Values[i].
+type Map struct {
+	source.Span
+	Brace  Token
+	Keys   []Expr
+	Values []Expr
+}
+
+func (e Map) String() string {
+	entries := make([]string, len(e.Keys))
+	for i := range e.Keys {
+		entries[i] = fmt.Sprintf("%v: %v", e.Keys[i], e.Values[i])
+	}
+	return fmt.Sprintf("MAP {%s}", strings.Join(entries, ", "))
+}
+
+// An element of a list or map read by index or key, such as "xs[i]".
+type Subscript struct {
+	source.Span
+	Object  Expr
+	Bracket Token
+	Index   Expr
+}
+
+func (e Subscript) String() string {
+	return fmt.Sprintf("INDEX %v[%v]", e.Object, e.Index)
+}
+
+// An element of a list or map assigned by index or key, such as
+// "xs[i] = v".
+type SubscriptSet struct {
+	source.Span
+	Object  Expr
+	Bracket Token
+	Index   Expr
+	Value   Expr
+}
+
+func (e SubscriptSet) String() string {
+	return fmt.Sprintf("%v[%v] = %v", e.Object, e.Index, e.Value)
+}
+
+// A string literal with expressions in it, such as "Hello ${name}". Strings
+// are the tokens of the literal's text around the expressions, one more than
+// there are expressions.
+type Interpolation struct {
+	source.Span
+	Strings []Token
+	Exprs   []Expr
+}
+
+func (e Interpolation) String() string {
+	parts := []string{"INTERPOLATE"}
+	for i, str := range e.Strings {
+		parts = append(parts, strconv.Quote(str.Literal.(string)))
+		if i < len(e.Exprs) {
+			parts = append(parts, fmt.Sprint(e.Exprs[i]))
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// The numbers from Start up to but not including End, such as "0..n".
+type Range struct {
+	source.Span
+	Start    Expr
+	Operator Token
+	End      Expr
+}
+
+func (e Range) String() string {
+	return fmt.Sprintf("RANGE %v..%v", e.Start, e.End)
+}
+
+// A function expression, either "fun (a, b) { ... }" or the arrow form
+// "(a, b) => a + b". Decl has no name, and the body of an arrow function is a
+// single return statement.
+type Lambda struct {
+	source.Span
+	Keyword Token
+	Decl    Function
+	Arrow   bool
+}
+
+func (e Lambda) String() string {
+	return fmt.Sprintf("FUN%v", e.Decl)
+}
+
 type Super struct {
+	source.Span
 	Keyword Token
 	Method  Token
 }
@@ -113,6 +243,7 @@ func (e Super) String() string {
 }
 
 type This struct {
+	source.Span
 	Keyword Token
 }
 
@@ -121,6 +252,7 @@ func (e This) String() string {
 }
 
 type Variable struct {
+	source.Span
 	Name Token
 }
 
@@ -129,25 +261,63 @@ func (e Variable) String() string {
 }
 
 type Class struct {
+	source.Span
 	Name        Token
+	Fields      []Field
 	Methods     []Function
 	ParentClass *Variable
 }
 
+// A field declared with its type in a class body, such as "x: number;".
+type Field struct {
+	source.Span
+	Name Token
+	Type *TypeAnnotation
+}
+
+func (f Field) String() string {
+	return fmt.Sprintf("FIELD %s%s", f.Name.Lexeme, f.Type.suffix())
+}
+
+// An optional type annotation, such as the ": number" in "var x: number".
+// The runtimes ignore annotations; only the type checker reads them.
+type TypeAnnotation struct {
+	source.Span
+	Name Token
+}
+
+// The annotation as it reads after a name, or "" if there is none.
+func (t *TypeAnnotation) suffix() string {
+	if t == nil {
+		return ""
+	}
+
+	return ": " + t.Name.Lexeme
+}
+
 func (e Class) String() string {
 	str := strings.Builder{}
+	for _, f := range e.Fields {
+		str.WriteString("\n")
+		str.WriteString(f.String())
+	}
 	for _, v := range e.Methods {
 		str.WriteString("\n")
 		str.WriteString(v.String())
 	}
+	parent := ""
+	if e.ParentClass != nil {
+		parent = e.ParentClass.String()
+	}
 	return fmt.Sprintf("CLASS %s(%s) {\n%s",
 		e.Name.Lexeme,
-		e.ParentClass.String(),
+		parent,
 		str.String(),
 	)
 }
 
 type Block struct {
+	source.Span
 	Statements []Statement
 }
 
@@ -161,7 +331,51 @@ func (s Block) String() string {
 	return fmt.Sprintf("[%s]", str.String())
 }
 
+type Break struct {
+	source.Span
+	Keyword Token
+}
+
+func (s Break) String() string {
+	return "BREAK"
+}
+
+type Continue struct {
+	source.Span
+	Keyword Token
+}
+
+func (s Continue) String() string {
+	return "CONTINUE"
+}
+
+// A top-level declaration that the module makes available to the modules
+// that import it.
+type Export struct {
+	source.Span
+	// A Var, Function or Class.
+	Declaration Statement
+}
+
+func (s Export) String() string {
+	return fmt.Sprintf("EXPORT %s", s.Declaration.String())
+}
+
+// The statements with each export replaced by the declaration it exports.
+func Unexport(stmts []Statement) []Statement {
+	ret := make([]Statement, len(stmts))
+	for i, stmt := range stmts {
+		if e, ok := stmt.(Export); ok {
+			stmt = e.Declaration
+		}
+		ret[i] = stmt
+	}
+
+	return ret
+}
+
 type ExpressionStmt struct {
+	source.Span
 	Val Expr
 }
 
@@ -169,10 +383,83 @@ func (s ExpressionStmt) String() string {
 	return fmt.Sprintf("ExpressionStmt(%v)", s.Val)
 }
 
+// A for loop. Initializer, Conditional and Increment are nil when they are
+// left out.
+type For struct {
+	source.Span
+	Initializer Statement
+	Conditional Expr
+	Increment   Expr
+	Stmt        Statement
+}
+
+func (s For) String() string {
+	return fmt.Sprintf("FOR (%v; %v; %v) %s", s.Initializer, s.Conditional, s.Increment, s.Stmt.String())
+}
+
+// The while loop the for loop is equivalent to, inside a block if it has an
+// initializer. The nodes it is made of all share the span of the whole loop.
+func (s For) Desugar() Statement {
+	conditional := s.Conditional
+	if conditional == nil {
+		conditional = Literal{Span: s.Span, Value: true}
+	}
+	var loop Statement = While{Span: s.Span, Conditional: conditional, Stmt: s.Stmt, Increment: s.Increment}
+	if s.Initializer != nil {
+		loop = Block{Span: s.Span, Statements: []Statement{s.Initializer, loop}}
+	}
+
+	return loop
+}
+
+// A loop over the elements of a list, the keys of a map, the characters of a
+// string, the numbers of a range or the values of an iterator, such as
+// "for (var x in xs) print x;".
+type ForIn struct {
+	source.Span
+	Name     Token
+	Iterable Expr
+	Stmt     Statement
+}
+
+func (s ForIn) String() string {
+	return fmt.Sprintf("FOR (%s IN %v) %s", s.Name.Lexeme, s.Iterable, s.Stmt.String())
+}
+
 type Function struct {
+	source.Span
 	Name   Token
 	Params []Token
-	Body   []Statement
+	// The annotations on the parameters, one for each, nil where there is
+	// none.
+	ParamTypes []*TypeAnnotation
+	// The default value of each parameter, nil where there is none.
+	Defaults []Expr
+	// Whether the last parameter collects the rest of the arguments.
+	Variadic   bool
+	ReturnType *TypeAnnotation
+	Body       []Statement
+}
+
+// The fewest and most arguments a call to the function can pass. max is -1
+// if the function has a rest parameter.
+func (e Function) Arity() (min, max int) {
+	max = len(e.Params)
+	for i := range e.Params {
+		if !e.IsRest(i) && (i >= len(e.Defaults) || e.Defaults[i] == nil) {
+			min = i + 1
+		}
+	}
+	if e.Variadic {
+		max = -1
+	}
+
+	return min, max
+}
+
+// Whether the i'th parameter collects the rest of the arguments.
+func (e Function) IsRest(i int) bool {
+	return e.Variadic && i == len(e.Params)-1
 }
 
 func (e Function) String() string {
@@ -181,11 +468,25 @@ func (e Function) String() string {
 		str.WriteString("\n")
 		str.WriteString(v.String())
 	}
+	params := make([]string, len(e.Params))
+	for i, v := range e.Params {
+		params[i] = v.Lexeme
+		if e.IsRest(i) {
+			params[i] = "..." + params[i]
+		}
+		if i < len(e.ParamTypes) {
+			params[i] += e.ParamTypes[i].suffix()
+		}
+		if i < len(e.Defaults) && e.Defaults[i] != nil {
+			params[i] += fmt.Sprintf(" = %v", e.Defaults[i])
+		}
+	}
 
-	return fmt.Sprintf("%s (%v){%s}", e.Name.Lexeme, e.Params, str.String())
+	return fmt.Sprintf("%s (%s)%s{%s}", e.Name.Lexeme, strings.Join(params, ", "), e.ReturnType.suffix(), str.String())
 }
 
 type If struct {
+	source.Span
 	Conditional Expr
 	If_stmt     Statement
 	Else_stmt   Statement
@@ -205,7 +506,26 @@ func (s If) String() string {
 	)
 }
 
+// Runs the module at Path, unless it has already been run, and either
+// defines each of its exports as a global or, when Name isn't nil, defines
+// a single global holding the module.
+type Import struct {
+	source.Span
+	Name *Token
+	// The STRING token naming the module.
+	Path Token
+}
+
+func (s Import) String() string {
+	if s.Name != nil {
+		return fmt.Sprintf("IMPORT %s FROM %q", s.Name.Lexeme, s.Path.Literal)
+	}
+
+	return fmt.Sprintf("IMPORT %q", s.Path.Literal)
+}
+
 type Print struct {
+	source.Span
 	Val Expr
 }
 
@@ -214,26 +534,67 @@ func (s Print) String() string {
 }
 
 type Return struct {
+	source.Span
 	Return_expr Expr
 }
 
 func (s Return) String() string {
-	return fmt.Sprintf("RETURN %s", s.Return_expr.String())
+	return fmt.Sprintf("RETURN %v", s.Return_expr)
+}
+
+type Throw struct {
+	source.Span
+	Keyword Token
+	Value   Expr
+}
+
+func (s Throw) String() string {
+	return fmt.Sprintf("THROW %v", s.Value)
+}
+
+// A try statement has a catch clause, a finally clause, or both. CatchName
+// is nil when there is no catch clause, and Finally is nil when there is no
+// finally clause.
+type Try struct {
+	source.Span
+	Body      Block
+	CatchName *Token
+	Catch     *Block
+	Finally   *Block
+}
+
+func (s Try) String() string {
+	str := fmt.Sprintf("TRY %v", s.Body)
+	if s.CatchName != nil {
+		str += fmt.Sprintf(" CATCH (%s) %v", s.CatchName.Lexeme, *s.Catch)
+	}
+	if s.Finally != nil {
+		str += fmt.Sprintf(" FINALLY %v", *s.Finally)
+	}
+
+	return str
 }
 
 // Variable declaration statement.
 type Var struct {
+	source.Span
 	Initializer Expr
 	Name        Token
+	Type        *TypeAnnotation
 }
 
 func (s Var) String() string {
-	return fmt.Sprintf("VAR %s = %s", s.Name.Lexeme, s.Initializer.String())
+	return fmt.Sprintf("VAR %s%s = %v", s.Name.Lexeme, s.Type.suffix(), s.Initializer)
 }
 
 type While struct {
+	source.Span
 	Conditional Expr
 	Stmt        Statement
+	// The increment of the for loop the while loop was desugared from,
+	// which runs after each iteration, even one that continues. Nil for a
+	// while loop.
+	Increment Expr
 }
 
 func (s While) String() string {
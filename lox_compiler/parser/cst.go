@@ -0,0 +1,236 @@
+package parser
+
+import (
+	"sort"
+	"strings"
+)
+
+// A node of a concrete syntax tree. Where the AST keeps only what a program
+// means, the CST keeps every token along with the trivia around it, so that
+// printing the tree gives back the source it was parsed from byte for byte.
+//
+// Inner nodes mirror the AST: each one wraps the AST node that covers the
+// same tokens. Leaves hold a single token.
+type CSTNode struct {
+	// The AST node this node covers, or nil for the root and for leaves.
+	AST ASTNode
+	// Set on leaves only.
+	Token *Token
+	// The source text of a leaf's token. Unlike the lexeme, this is the
+	// text as written even for error tokens.
+	Text     string
+	Children []*CSTNode
+}
+
+// Parse src into a CST. The statements the parser recovered are available
+// from the tree even if there were syntax errors, which are returned as
+// Parse returns them; tokens that are not part of any statement become
+// leaves of the root.
+func ParseCST(src string) (*CSTNode, error) {
+	tokens, _ := NewLosslessScanner(src).ScanTokens()
+	p := NewParser(tokens)
+	stmts, err := p.Parse()
+
+	roots := make([]ASTNode, len(stmts))
+	for i, stmt := range stmts {
+		roots[i] = stmt
+	}
+	b := cstBuilder{src: src, tokens: tokens}
+	root := &CSTNode{Children: b.build(roots, len(src)+1)}
+
+	return root, err
+}
+
+// The statements at the top of the tree, which make up the AST.
+func (n *CSTNode) Statements() []Statement {
+	var stmts []Statement
+	for _, child := range n.Children {
+		if child.AST != nil {
+			stmts = append(stmts, child.AST)
+		}
+	}
+
+	return stmts
+}
+
+// The source text the node covers, with the trivia around its tokens.
+func (n *CSTNode) String() string {
+	var b strings.Builder
+	n.write(&b)
+
+	return b.String()
+}
+
+func (n *CSTNode) write(b *strings.Builder) {
+	if n.Token != nil {
+		for _, t := range n.Token.Leading {
+			b.WriteString(t.Text)
+		}
+		b.WriteString(n.Text)
+		for _, t := range n.Token.Trailing {
+			b.WriteString(t.Text)
+		}
+	}
+	for _, child := range n.Children {
+		child.write(b)
+	}
+}
+
+type cstBuilder struct {
+	src    string
+	tokens []Token
+	// The index of the next token to place in the tree, and the offset at
+	// which the last one placed ends.
+	next, end int
+}
+
+// Build the nodes for the tokens that start before end, nesting those that
+// fall within one of nodes under a node of their own.
+func (b *cstBuilder) build(nodes []ASTNode, end int) []*CSTNode {
+	nodes = sortedBySpan(nodes)
+	var children []*CSTNode
+	for b.next < len(b.tokens) && b.tokens[b.next].Start.Offset < end {
+		tok := b.tokens[b.next]
+		// Error tokens for mistakes inside a string literal, such as a bad
+		// escape sequence, come after the string's token and have no text
+		// of their own.
+		if tok.Start.Offset < b.end {
+			b.next++
+			continue
+		}
+		// Nodes that start before the next token either overlap one
+		// already built or have no tokens of their own.
+		for len(nodes) > 0 && nodes[0].SourceSpan().Start.Offset < tok.Start.Offset {
+			nodes = nodes[1:]
+		}
+		if len(nodes) > 0 && nodes[0].SourceSpan().Start.Offset == tok.Start.Offset {
+			node := nodes[0]
+			nodes = nodes[1:]
+			children = append(children, &CSTNode{AST: node, Children: b.build(Children(node), node.SourceSpan().End.Offset)})
+			continue
+		}
+
+		children = append(children, &CSTNode{Token: &b.tokens[b.next], Text: b.src[tok.Start.Offset:tok.End.Offset]})
+		b.next++
+		b.end = tok.End.Offset
+	}
+
+	return children
+}
+
+// Order nodes by where they start, leaving out those the parser made up,
+// which have no position.
+func sortedBySpan(nodes []ASTNode) []ASTNode {
+	var result []ASTNode
+	for _, node := range nodes {
+		if node != nil && !node.SourceSpan().IsZero() {
+			result = append(result, node)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].SourceSpan().Start.Offset < result[j].SourceSpan().Start.Offset
+	})
+
+	return result
+}
+
+// The AST nodes directly inside node, mostly in source order. Some may be
+// nil, such as a missing else branch.
+func Children(node ASTNode) []ASTNode {
+	switch n := node.(type) {
+	case Assign:
+		return []ASTNode{n.Value}
+	case Binary:
+		return []ASTNode{n.Left, n.Right}
+	case Call:
+		children := append([]ASTNode{n.Callee}, n.Args...)
+		for _, arg := range n.Named {
+			children = append(children, arg.Value)
+		}
+		return children
+	case Get:
+		return []ASTNode{n.Object}
+	case Grouping:
+		return []ASTNode{n.Expr}
+	case Logical:
+		return []ASTNode{n.Left, n.Right}
+	case Unary:
+		return []ASTNode{n.Right}
+	case Set:
+		return []ASTNode{n.Object, n.Value}
+	case List:
+		return n.Elements
+	case Map:
+		children := []ASTNode{}
+		for i := range n.Keys {
+			children = append(children, n.Keys[i], n.Values[i])
+		}
+		return children
+	case Subscript:
+		return []ASTNode{n.Object, n.Index}
+	case SubscriptSet:
+		return []ASTNode{n.Object, n.Index, n.Value}
+	case Range:
+		return []ASTNode{n.Start, n.End}
+	case Interpolation:
+		children := []ASTNode{}
+		for _, e := range n.Exprs {
+			children = append(children, e)
+		}
+		return children
+	case Lambda:
+		return Children(n.Decl)
+	case Class:
+		children := []ASTNode{}
+		if n.ParentClass != nil {
+			children = append(children, *n.ParentClass)
+		}
+		// Fields and methods may be interleaved in the source.
+		for _, f := range n.Fields {
+			children = append(children, f)
+		}
+		for _, m := range n.Methods {
+			children = append(children, m)
+		}
+		return children
+	case Block:
+		return n.Statements
+	case Export:
+		return []ASTNode{n.Declaration}
+	case ExpressionStmt:
+		return []ASTNode{n.Val}
+	case For:
+		return []ASTNode{n.Initializer, n.Conditional, n.Increment, n.Stmt}
+	case ForIn:
+		return []ASTNode{n.Iterable, n.Stmt}
+	case Function:
+		var children []ASTNode
+		for _, d := range n.Defaults {
+			children = append(children, d)
+		}
+		return append(children, n.Body...)
+	case If:
+		return []ASTNode{n.Conditional, n.If_stmt, n.Else_stmt}
+	case Print:
+		return []ASTNode{n.Val}
+	case Return:
+		return []ASTNode{n.Return_expr}
+	case Throw:
+		return []ASTNode{n.Value}
+	case Try:
+		children := []ASTNode{n.Body}
+		if n.Catch != nil {
+			children = append(children, *n.Catch)
+		}
+		if n.Finally != nil {
+			children = append(children, *n.Finally)
+		}
+		return children
+	case Var:
+		return []ASTNode{n.Initializer}
+	case While:
+		return []ASTNode{n.Conditional, n.Stmt}
+	}
+
+	return nil
+}
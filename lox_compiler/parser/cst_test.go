@@ -0,0 +1,82 @@
+package parser_test
+
+import (
+	"lox-compiler/parser"
+	"strings"
+	"testing"
+)
+
+func TestTrivia(t *testing.T) {
+	toks, err := parser.NewLosslessScanner("// head\nvar a = 1; // tail\n\n  print a;").ScanTokens()
+	if err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+
+	if len(toks[0].Leading) != 2 || toks[0].Leading[0].Kind != parser.CommentTrivia || toks[0].Leading[1].Kind != parser.NewlineTrivia {
+		t.Fatalf("Unexpected leading trivia on var: %v", toks[0].Leading)
+	}
+	semicolon := toks[4]
+	if len(semicolon.Trailing) != 3 || semicolon.Trailing[1].Text != "// tail" || semicolon.Trailing[2].Kind != parser.NewlineTrivia {
+		t.Fatalf("Unexpected trailing trivia on ';': %v", semicolon.Trailing)
+	}
+	print := toks[5]
+	if len(print.Leading) != 2 || print.Leading[0].Kind != parser.NewlineTrivia || print.Leading[1].Text != "  " {
+		t.Fatalf("Unexpected leading trivia on print: %v", print.Leading)
+	}
+}
+
+func TestCSTRoundTrip(t *testing.T) {
+	for _, src := range []string{
+		"",
+		"  // only a comment",
+		"var a = 1;\r\n\tprint a;   \n",
+		"fun f(a, b) {\n  return a + b; // sum\n}\nprint f(1, 2);\n",
+		"class A < B { m() { return super.m(); } }\n",
+		"for (var i = 0; i < 3; i = i + 1) { print i; }",
+		"var = 1; print \"unterminated",
+		"print 1 @ 2;\n",
+		"print \"a\\q b\" ; // bad escape\nprint \"${1} \\u{zz}\";\n",
+		"var s = \"\"\"\n    text\n  less ${1}\n    \"\"\";\n",
+	} {
+		cst, _ := parser.ParseCST(src)
+		if printed := cst.String(); printed != src {
+			t.Fatalf("Expected the CST to print as %q, got %q", src, printed)
+		}
+	}
+}
+
+func TestCSTStatements(t *testing.T) {
+	src := "var a = 1;\nif (a) print a; else { a = a + 1; }\n"
+	cst, err := parser.ParseCST(src)
+	if err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+	toks, _ := parser.Scan(src)
+	p := parser.NewParser(toks)
+	stmts, _ := p.Parse()
+
+	derived := cst.Statements()
+	if len(derived) != len(stmts) {
+		t.Fatalf("Expected %d statements, got %d", len(stmts), len(derived))
+	}
+	for i := range stmts {
+		if derived[i].String() != stmts[i].String() {
+			t.Fatalf("Expected %s, got %s", stmts[i], derived[i])
+		}
+	}
+
+	// The if statement's node nests its condition and both branches.
+	ifNode := cst.Children[1]
+	if _, ok := ifNode.AST.(parser.If); !ok || ifNode.String() != "if (a) print a; else { a = a + 1; }\n" {
+		t.Fatalf("Unexpected node %v covering %q", ifNode.AST, ifNode.String())
+	}
+	nested := 0
+	for _, child := range ifNode.Children {
+		if child.AST != nil {
+			nested++
+		}
+	}
+	if nested != 3 || !strings.HasPrefix(ifNode.Children[0].Text, "if") {
+		t.Fatalf("Expected the condition and both branches under the if, got %d", nested)
+	}
+}
@@ -0,0 +1,64 @@
+package parser_test
+
+import (
+	"lox-compiler/parser"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Seed the fuzzer with the example programs and the conformance suite.
+func addSeedCorpus(f *testing.F) {
+	for _, pattern := range []string{
+		"../../interpreted_lox/*.lox",
+		"../conformance/testdata/*/*.lox",
+		"../difftest/testdata/*.lox",
+	} {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			f.Fatal(err)
+		}
+		for _, file := range files {
+			source, err := os.ReadFile(file)
+			if err != nil {
+				f.Fatal(err)
+			}
+			f.Add(string(source))
+		}
+	}
+}
+
+func FuzzScan(f *testing.F) {
+	addSeedCorpus(f)
+	f.Fuzz(func(t *testing.T, source string) {
+		toks, err := parser.Scan(source)
+		if err != nil {
+			return
+		}
+		if len(toks) == 0 || toks[len(toks)-1].Token_type != parser.EOF {
+			t.Fatalf("expected the tokens to end with EOF, got %v", toks)
+		}
+	})
+}
+
+func FuzzParse(f *testing.F) {
+	addSeedCorpus(f)
+	f.Fuzz(func(t *testing.T, source string) {
+		toks, err := parser.Scan(source)
+		if err != nil {
+			return
+		}
+		p := parser.NewParser(toks)
+		p.Parse()
+	})
+}
+
+func FuzzCST(f *testing.F) {
+	addSeedCorpus(f)
+	f.Fuzz(func(t *testing.T, source string) {
+		cst, _ := parser.ParseCST(source)
+		if printed := cst.String(); printed != source {
+			t.Fatalf("expected the CST to print as %q, got %q", source, printed)
+		}
+	})
+}
@@ -1,40 +1,68 @@
 package parser
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"lox-compiler/source"
+)
 
 type Parser struct {
 	tokens  []Token
 	current int
 	prev    *Token
+	errors  []error
 }
 
 func NewParser(tokens []Token) Parser {
 	return Parser{tokens: tokens, current: 0}
 }
 
-func (p *Parser) Parse() []Statement {
+// Parse the whole token stream. After a syntax error the parser synchronizes
+// at the next statement and carries on, so that every error in the source is
+// found in one pass. The errors are returned joined together; each one is a
+// *ParseError.
+func (p *Parser) Parse() ([]Statement, error) {
 	var statements []Statement
+	p.skipErrorTokens()
 	at_end := p.IsAtEnd()
 	for !at_end {
-		stmt, err := p.declaration()
-		if err != nil {
-			// errorhandling.RuntimeError(err)
-			fmt.Println("ERRROR")
-			return nil
+		stmt, err := p.topLevelDeclaration()
+		if err == nil {
+			statements = append(statements, stmt)
 		}
-		statements = append(statements, stmt)
 		at_end = p.IsAtEnd()
 	}
 
-	return statements
+	return statements, errors.Join(p.errors...)
+}
+
+// A declaration that may only appear at the top level of a module.
+func (p *Parser) topLevelDeclaration() (Statement, error) {
+	var stmt Statement
+	var err error
+	if p.match(IMPORT) {
+		stmt, err = p.importDeclaration()
+	} else if p.match(EXPORT) {
+		stmt, err = p.exportDeclaration()
+	} else {
+		return p.declaration()
+	}
+	if err != nil {
+		p.syncronize()
+		return nil, err
+	}
+	return stmt, nil
 }
 
 func (p *Parser) declaration() (Statement, error) {
 	var stmt Statement
 	var err error
-	if p.match(VAR) {
+	if p.check(IMPORT) || p.check(EXPORT) {
+		err = p.error(p.peek(), fmt.Sprintf("Can only use '%s' at the top level of a module.", p.peek().Lexeme))
+	} else if p.match(VAR) {
 		stmt, err = p.varDeclaration()
-	} else if p.match(FUN) {
+	} else if !p.checkNext(LEFT_PAREN) && p.match(FUN) {
+		// "fun (" starts a function expression rather than a declaration.
 		stmt, err = p.funcDeclaration()
 	} else if p.match(CLASS) {
 		stmt, err = p.classDeclaration()
@@ -53,11 +81,7 @@ func (p *Parser) statement() (Statement, error) {
 		return p.printStatement()
 	}
 	if p.peek().Token_type == LEFT_BRACE {
-		stmts, err := p.block()
-		if err != nil {
-			return nil, err
-		}
-		return Block{Statements: stmts}, nil
+		return p.blockStatement()
 	}
 
 	if p.match(IF) {
@@ -76,16 +100,28 @@ func (p *Parser) statement() (Statement, error) {
 		return p.returnStatement()
 	}
 
+	if p.check(BREAK) || p.check(CONTINUE) {
+		return p.loopJumpStatement()
+	}
+
+	if p.check(THROW) {
+		return p.throwStatement()
+	}
+
+	if p.check(TRY) {
+		return p.tryStatement()
+	}
+
 	return p.expressionStatement()
 }
 
 func (p *Parser) returnStatement() (Statement, error) {
-	_, err := p.consume(RETURN, "expected 'return'")
+	keyword, err := p.consume(RETURN, "expected 'return'")
 	if err != nil {
 		return nil, err
 	}
 	if p.match(SEMICOLON) {
-		return Return{Return_expr: nil}, nil
+		return Return{Span: p.spanFrom(keyword), Return_expr: nil}, nil
 	}
 	expr, err := p.expression()
 	if err != nil {
@@ -96,7 +132,78 @@ func (p *Parser) returnStatement() (Statement, error) {
 		return nil, err
 	}
 
-	return Return{Return_expr: expr}, nil
+	return Return{Span: p.spanFrom(keyword), Return_expr: expr}, nil
+}
+
+func (p *Parser) loopJumpStatement() (Statement, error) {
+	// breakStmt      → "break" ";" ;
+	// continueStmt   → "continue" ";" ;
+	// Whether the statement is inside a loop is checked by the compiler.
+	keyword := p.advance()
+	if _, err := p.consume(SEMICOLON, fmt.Sprintf("Expect ';' after '%s'.", keyword.Lexeme)); err != nil {
+		return nil, err
+	}
+	if keyword.Token_type == BREAK {
+		return Break{Span: p.spanFrom(keyword), Keyword: keyword}, nil
+	}
+
+	return Continue{Span: p.spanFrom(keyword), Keyword: keyword}, nil
+}
+
+func (p *Parser) throwStatement() (Statement, error) {
+	// throwStmt      → "throw" expression ";" ;
+	keyword := p.advance()
+	expr, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.consume(SEMICOLON, "Expect ';' after thrown value."); err != nil {
+		return nil, err
+	}
+
+	return Throw{Span: p.spanFrom(keyword), Keyword: keyword, Value: expr}, nil
+}
+
+func (p *Parser) tryStatement() (Statement, error) {
+	// tryStmt        → "try" block ( "catch" "(" IDENTIFIER ")" block )? ( "finally" block )? ;
+	// with at least one of the two clauses.
+	keyword := p.advance()
+	body, err := p.blockStatement()
+	if err != nil {
+		return nil, err
+	}
+	stmt := Try{Body: body}
+
+	if p.match(CATCH) {
+		if _, err := p.consume(LEFT_PAREN, "Expect '(' after 'catch'."); err != nil {
+			return nil, err
+		}
+		name, err := p.consume(IDENTIFIER, "Expect exception variable name.")
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.consume(RIGHT_PAREN, "Expect ')' after exception variable."); err != nil {
+			return nil, err
+		}
+		catch, err := p.blockStatement()
+		if err != nil {
+			return nil, err
+		}
+		stmt.CatchName, stmt.Catch = &name, &catch
+	}
+	if p.match(FINALLY) {
+		finally, err := p.blockStatement()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Finally = &finally
+	}
+	if stmt.CatchName == nil && stmt.Finally == nil {
+		return nil, p.error(p.peek(), "Expect 'catch' or 'finally' after try block.")
+	}
+	stmt.Span = p.spanFrom(keyword)
+
+	return stmt, nil
 }
 
 func (p *Parser) forStatement() (Statement, error) {
@@ -104,12 +211,16 @@ func (p *Parser) forStatement() (Statement, error) {
 	var conditional_expr Expr
 	var increment_expression Expr
 	var loop_stmt Statement
+	keyword := p.previous()
 
 	_, err := p.consume(LEFT_PAREN, "expected '(' after 'for'.")
 	if err != nil {
 		return nil, err
 	}
 
+	if p.check(VAR) && p.checkNext(IDENTIFIER) && p.tokens[p.current+2].Token_type == IN {
+		return p.forInStatement(keyword)
+	}
 	if p.match(VAR) {
 		initializer_stmt, err = p.varDeclaration()
 		if err != nil {
@@ -153,25 +264,39 @@ func (p *Parser) forStatement() (Statement, error) {
 	if err != nil {
 		return nil, err
 	}
-	if increment_expression != nil {
-		body := []Statement{loop_stmt, ExpressionStmt{increment_expression}}
-		loop_stmt = Block{Statements: body}
+
+	return For{
+		Span:        p.spanFrom(keyword),
+		Initializer: initializer_stmt,
+		Conditional: conditional_expr,
+		Increment:   increment_expression,
+		Stmt:        loop_stmt,
+	}, nil
+}
+
+func (p *Parser) forInStatement(keyword Token) (Statement, error) {
+	// forInStmt      → "for" "(" "var" IDENTIFIER "in" expression ")" statement ;
+	p.advance()
+	name := p.advance()
+	p.advance()
+	iterable, err := p.expression()
+	if err != nil {
+		return nil, err
 	}
-	if conditional_expr == nil {
-		conditional_expr = Literal{Value: true}
+	if _, err := p.consume(RIGHT_PAREN, "Expect ')' after for-in iterable."); err != nil {
+		return nil, err
 	}
-	var body Statement = While{Conditional: conditional_expr, Stmt: loop_stmt}
-	if initializer_stmt != nil {
-		tmp := []Statement{initializer_stmt, body}
-		body = Block{Statements: tmp}
+	body, err := p.statement()
+	if err != nil {
+		return nil, err
 	}
 
-	return body, nil // for stmt
-
+	return ForIn{Span: p.spanFrom(keyword), Name: name, Iterable: iterable, Stmt: body}, nil
 }
 
 func (p *Parser) whileStatement() (Statement, error) {
 	var err error
+	keyword := p.previous()
 	_, err = p.consume(LEFT_PAREN, "expected '(' after 'while'.")
 	if err != nil {
 		return nil, err
@@ -192,11 +317,12 @@ func (p *Parser) whileStatement() (Statement, error) {
 		return nil, err
 	}
 
-	return While{Conditional: conditional_stmt, Stmt: while_body}, nil
+	return While{Span: p.spanFrom(keyword), Conditional: conditional_stmt, Stmt: while_body}, nil
 }
 
 func (p *Parser) ifStatement() (Statement, error) {
 	var else_stmt Statement
+	keyword := p.previous()
 
 	_, err := p.consume(LEFT_PAREN, "expected '(' after 'if'.")
 	if err != nil {
@@ -226,11 +352,12 @@ func (p *Parser) ifStatement() (Statement, error) {
 	}
 
 	// return NewIfStatement(expr, if_stmt, else_stmt), nil
-	return If{Conditional: expr, If_stmt: if_stmt, Else_stmt: else_stmt}, nil
+	return If{Span: p.spanFrom(keyword), Conditional: expr, If_stmt: if_stmt, Else_stmt: else_stmt}, nil
 }
 
 func (p *Parser) printStatement() (Statement, error) {
 	var stmt Statement
+	keyword := p.previous()
 	expr, err := p.expression()
 	if err != nil {
 		return stmt, err
@@ -241,7 +368,7 @@ func (p *Parser) printStatement() (Statement, error) {
 		return stmt, err
 	}
 
-	return Print{Val: expr}, nil
+	return Print{Span: p.spanFrom(keyword), Val: expr}, nil
 }
 
 func (p *Parser) expressionStatement() (Statement, error) {
@@ -255,7 +382,17 @@ func (p *Parser) expressionStatement() (Statement, error) {
 		return stmt, err
 	}
 
-	return ExpressionStmt{Val: expr}, nil
+	return ExpressionStmt{Span: expr.SourceSpan().Join(p.previous().Span), Val: expr}, nil
+}
+
+func (p *Parser) blockStatement() (Block, error) {
+	start := p.peek()
+	stmts, err := p.block()
+	if err != nil {
+		return Block{}, err
+	}
+
+	return Block{Span: p.spanFrom(start), Statements: stmts}, nil
 }
 
 func (p *Parser) block() ([]Statement, error) {
@@ -267,11 +404,12 @@ func (p *Parser) block() ([]Statement, error) {
 	}
 
 	for !p.check(RIGHT_BRACE) && !p.IsAtEnd() {
+		// declaration has already recorded any error and synchronized, so
+		// keep going to find the errors in the rest of the block.
 		stmt, err := p.declaration()
-		if err != nil {
-			return nil, err
+		if err == nil {
+			statements = append(statements, stmt)
 		}
-		statements = append(statements, stmt)
 	}
 	_, err = p.consume(RIGHT_BRACE, "Expect '}' after block.")
 	if err != nil {
@@ -286,6 +424,7 @@ func (p *Parser) classDeclaration() (Statement, error) {
 	var parentClass *Variable
 	var err error
 	var funcs []Function
+	keyword := p.previous()
 
 	classId, err = p.consume(IDENTIFIER, "expected an identifier")
 	if err != nil {
@@ -297,7 +436,7 @@ func (p *Parser) classDeclaration() (Statement, error) {
 		if err != nil {
 			return nil, err
 		}
-		parentClass = &Variable{Name: p.previous()}
+		parentClass = &Variable{Span: p.previous().Span, Name: p.previous()}
 	}
 
 	_, err = p.consume(LEFT_BRACE, "expected '{'")
@@ -306,23 +445,39 @@ func (p *Parser) classDeclaration() (Statement, error) {
 	}
 
 	if p.match(RIGHT_BRACE) {
-		return Class{Name: classId}, nil // return class here
+		return Class{Span: p.spanFrom(keyword), Name: classId, ParentClass: parentClass}, nil // return class here
 	}
 
+	var fields []Field
 	for !p.match(RIGHT_BRACE) && !p.IsAtEnd() {
+		// A name followed by a colon declares a field rather than a method.
+		if p.check(IDENTIFIER) && p.checkNext(COLON) {
+			name := p.advance()
+			annotation, err := p.typeAnnotation()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.consume(SEMICOLON, "Expect ';' after field declaration."); err != nil {
+				return nil, err
+			}
+			fields = append(fields, Field{Span: p.spanFrom(name), Name: name, Type: annotation})
+			continue
+		}
 		val, err := p.funcDeclaration()
 		if err != nil {
 			return nil, err
 		}
 		fun, ok := val.(Function)
 		if !ok {
-			return nil, NewParseError("expected a function definition")
+			return nil, p.error(p.previous(), "expected a function definition")
 		}
 		funcs = append(funcs, fun)
 	}
 
 	return Class{
+		Span:        p.spanFrom(keyword),
 		Name:        classId,
+		Fields:      fields,
 		Methods:     funcs,
 		ParentClass: parentClass,
 	}, nil // return class here
@@ -334,71 +489,169 @@ func (p *Parser) funcDeclaration() (Statement, error) {
 }
 
 func (p *Parser) function() (Statement, error) {
-	var funcId Token
-	var err ParseError
-	var identifers []Token
+	// Methods are declared without the 'fun' keyword.
+	start := p.peek()
+	if p.previous().Token_type == FUN {
+		start = p.previous()
+	}
 
 	if !p.match(IDENTIFIER) {
-		err = NewParseError("expected an identifer")
-		return nil, &err
+		return nil, p.error(p.peek(), "expected an identifer")
+	}
+	fn, pErr := p.functionBody(start, p.previous())
+	if pErr != nil {
+		return nil, pErr
 	}
-	funcId = p.previous()
+
+	return fn, nil
+}
+
+// The parameters, return type and body of a function that starts at start,
+// which is either a declaration or a function expression with no name.
+func (p *Parser) functionBody(start Token, funcId Token) (Function, error) {
+	fn := Function{Name: funcId}
 
 	_, pErr := p.consume(LEFT_PAREN, "expected '('.")
 	if pErr != nil {
-		return nil, pErr
+		return Function{}, pErr
 	}
 
 	if p.peek().Token_type != RIGHT_PAREN {
-		identifers, pErr = p.identifiers()
-		if pErr != nil {
-			return nil, pErr
+		if pErr = p.parameters(&fn); pErr != nil {
+			return Function{}, pErr
 		}
 	}
 
 	_, pErr = p.consume(RIGHT_PAREN, "expected ')'.")
 	if pErr != nil {
-		return nil, pErr
+		return Function{}, pErr
+	}
+	fn.ReturnType, pErr = p.typeAnnotation()
+	if pErr != nil {
+		return Function{}, pErr
 	}
 
-	block, pErr := p.block()
+	fn.Body, pErr = p.block()
 	if pErr != nil {
-		return nil, pErr
+		return Function{}, pErr
 	}
+	fn.Span = p.spanFrom(start)
 
-	return Function{Name: funcId, Params: identifers, Body: block}, nil
+	return fn, nil
 }
 
-func (p *Parser) identifiers() ([]Token, error) {
-	// parameters     → IDENTIFIER ( "," IDENTIFIER )* ;
-	var tokens []Token
-	var err ParseError
+// Parse the parameters of fn.
+func (p *Parser) parameters(fn *Function) error {
+	// parameters     → parameter ( "," parameter )* ;
+	// parameter      → "..."? IDENTIFIER annotation? ( "=" expression )? ;
+	for {
+		if fn.Variadic {
+			return p.error(p.previous(), "A rest parameter must be the last parameter.")
+		}
+		fn.Variadic = p.match(ELLIPSIS)
+		if !p.match(IDENTIFIER) {
+			return p.error(p.peek(), "expected an idenifier.")
+		}
+		name := p.previous()
+		annotation, pErr := p.typeAnnotation()
+		if pErr != nil {
+			return pErr
+		}
 
-	if !p.match(IDENTIFIER) {
-		err = NewParseError("expected an idenifier.")
-		return nil, &err
+		var value Expr
+		if p.match(EQUAL) {
+			if fn.Variadic {
+				return p.error(p.previous(), "A rest parameter can't have a default value.")
+			}
+			value, pErr = p.expression()
+			if pErr != nil {
+				return pErr
+			}
+		} else if !fn.Variadic && len(fn.Defaults) > 0 && fn.Defaults[len(fn.Defaults)-1] != nil {
+			return p.error(name, "A parameter without a default value can't follow one with a default.")
+		}
+		fn.Params = append(fn.Params, name)
+		fn.ParamTypes = append(fn.ParamTypes, annotation)
+		fn.Defaults = append(fn.Defaults, value)
+
+		if !p.match(COMMA) {
+			return nil
+		}
 	}
-	tokens = append(tokens, p.previous())
+}
 
-	if p.match(COMMA) {
-		val, pErr := p.identifiers()
-		if pErr != nil {
-			return nil, pErr
+// An optional ": type" after a name. The type is a class name, one of the
+// built-in type names, or nil.
+func (p *Parser) typeAnnotation() (*TypeAnnotation, error) {
+	// annotation     → ":" ( IDENTIFIER | "nil" ) ;
+	if !p.match(COLON) {
+		return nil, nil
+	}
+	if !p.match(IDENTIFIER, NIL) {
+		return nil, p.error(p.peek(), "Expect type after ':'.")
+	}
+
+	return &TypeAnnotation{Span: p.previous().Span, Name: p.previous()}, nil
+}
+
+func (p *Parser) importDeclaration() (Statement, error) {
+	// importDecl     → "import" ( IDENTIFIER "from" )? STRING ";" ;
+	keyword := p.previous()
+	var name *Token
+	if p.match(IDENTIFIER) {
+		n := p.previous()
+		name = &n
+		// "from" is only a keyword here, so that it can still name variables.
+		if !p.check(IDENTIFIER) || p.peek().Lexeme != "from" {
+			return nil, p.error(p.peek(), "Expect 'from' after module name.")
 		}
-		return append(tokens, val...), nil
+		p.advance()
+	}
+	path, err := p.consume(STRING, "Expect module path.")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.consume(SEMICOLON, "Expect ';' after import."); err != nil {
+		return nil, err
 	}
 
-	return tokens, nil
+	return Import{Span: p.spanFrom(keyword), Name: name, Path: path}, nil
+}
+
+func (p *Parser) exportDeclaration() (Statement, error) {
+	// exportDecl     → "export" ( varDecl | funDecl | classDecl ) ;
+	keyword := p.previous()
+	var decl Statement
+	var err error
+	if p.match(VAR) {
+		decl, err = p.varDeclaration()
+	} else if p.match(FUN) {
+		decl, err = p.funcDeclaration()
+	} else if p.match(CLASS) {
+		decl, err = p.classDeclaration()
+	} else {
+		return nil, p.error(p.peek(), "Expect declaration after 'export'.")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return Export{Span: p.spanFrom(keyword), Declaration: decl}, nil
 }
 
 func (p *Parser) varDeclaration() (Statement, error) {
 	var initializer Expr
 	var err error
+	keyword := p.previous()
 
 	name, err := p.consume(IDENTIFIER, "Expect variable name.")
 	if err != nil {
 		return nil, err
 	}
+	annotation, err := p.typeAnnotation()
+	if err != nil {
+		return nil, err
+	}
 	if p.match(EQUAL) {
 		initializer, err = p.expression()
 	} else {
@@ -412,7 +665,7 @@ func (p *Parser) varDeclaration() (Statement, error) {
 	if err != nil {
 		return nil, err
 	}
-	return Var{Name: name, Initializer: initializer}, nil
+	return Var{Span: p.spanFrom(keyword), Name: name, Initializer: initializer, Type: annotation}, nil
 }
 
 func (p *Parser) expression() (Expr, error) {
@@ -426,6 +679,7 @@ func (p *Parser) assignment() (Expr, error) {
 	}
 
 	if p.match(EQUAL) {
+		equals := p.previous()
 		right, err := p.assignment()
 		if err != nil {
 			return nil, err
@@ -433,20 +687,14 @@ func (p *Parser) assignment() (Expr, error) {
 
 		switch t := left.(type) {
 		case Variable:
-			return Assign{Name: t.Name, Value: right}, nil
+			return Assign{Span: left.SourceSpan().Join(right.SourceSpan()), Name: t.Name, Value: right}, nil
 		case Get:
-			return Set{Object: t.Object, Name: t.Name, Value: right}, nil // Set expression
+			return Set{Span: left.SourceSpan().Join(right.SourceSpan()), Object: t.Object, Name: t.Name, Value: right}, nil // Set expression
+		case Subscript:
+			return SubscriptSet{Span: left.SourceSpan().Join(right.SourceSpan()), Object: t.Object, Bracket: t.Bracket, Index: t.Index, Value: right}, nil
 		default:
-			err := NewParseError("Left side of assignment must be a variable.")
-			return nil, &err
+			return nil, p.error(equals, "Left side of assignment must be a variable.")
 		}
-
-		val, ok := left.(Variable)
-		if !ok {
-			err := NewParseError("Left side of assignment must be a variable.")
-			return nil, &err
-		}
-		return Assign{Name: val.Name, Value: right}, nil
 	}
 
 	return left, nil
@@ -465,7 +713,7 @@ func (p *Parser) logic_or() (Expr, error) {
 			return nil, err
 		}
 
-		return Logical{Left: left, Operator: op, Right: right}, nil
+		return Logical{Span: left.SourceSpan().Join(right.SourceSpan()), Left: left, Operator: op, Right: right}, nil
 	}
 
 	return left, nil
@@ -484,7 +732,7 @@ func (p *Parser) logic_and() (Expr, error) {
 			return nil, err
 		}
 
-		return Logical{Left: left, Operator: op, Right: right}, nil
+		return Logical{Span: left.SourceSpan().Join(right.SourceSpan()), Left: left, Operator: op, Right: right}, nil
 	}
 
 	return left, nil
@@ -504,9 +752,9 @@ func (p *Parser) equality() (Expr, error) {
 		op := p.previous()
 		right, err := p.equality()
 		if err != nil {
-			return Unary{}, nil
+			return Unary{}, err
 		}
-		return Binary{Left: prefix, Operator: op, Right: right}, nil
+		return Binary{Span: prefix.SourceSpan().Join(right.SourceSpan()), Left: prefix, Operator: op, Right: right}, nil
 	}
 
 	// Base case:
@@ -516,7 +764,7 @@ func (p *Parser) equality() (Expr, error) {
 }
 
 func (p *Parser) comparison() (Expr, error) {
-	prefix, err := p.term()
+	prefix, err := p.rangeExpr()
 	if err != nil {
 		return Unary{}, err
 	}
@@ -525,14 +773,32 @@ func (p *Parser) comparison() (Expr, error) {
 		op := p.previous()
 		right, err := p.comparison()
 		if err != nil {
-			return Unary{}, nil
+			return Unary{}, err
 		}
-		return Binary{Left: prefix, Operator: op, Right: right}, nil
+		return Binary{Span: prefix.SourceSpan().Join(right.SourceSpan()), Left: prefix, Operator: op, Right: right}, nil
 	}
 
 	return prefix, nil
 }
 
+func (p *Parser) rangeExpr() (Expr, error) {
+	// range          → term ( ".." term )? ;
+	start, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	if !p.match(DOT_DOT) {
+		return start, nil
+	}
+	op := p.previous()
+	end, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+
+	return Range{Span: start.SourceSpan().Join(end.SourceSpan()), Start: start, Operator: op, End: end}, nil
+}
+
 // term           → factor ( ( "-" | "+" ) factor )* ;
 func (p *Parser) term() (Expr, error) {
 	prefix, err := p.factor()
@@ -546,26 +812,26 @@ func (p *Parser) term() (Expr, error) {
 		if err != nil {
 			return Unary{}, err
 		}
-		return Binary{Left: prefix, Operator: op, Right: right}, nil
+		return Binary{Span: prefix.SourceSpan().Join(right.SourceSpan()), Left: prefix, Operator: op, Right: right}, nil
 	}
 
 	return prefix, nil
 }
 
-// factor         → unary ( ( "/" | "*" ) unary )* ;
+// factor         → unary ( ( "/" | "*" | "%" ) unary )* ;
 func (p *Parser) factor() (Expr, error) {
 	prefix, err := p.unary()
 	if err != nil {
 		return Unary{}, err
 	}
 
-	if p.match(STAR, SLASH) {
+	if p.match(STAR, SLASH, PERCENT) {
 		op := p.previous()
 		right, err := p.factor()
 		if err != nil {
 			return Unary{}, err
 		}
-		return Binary{Left: prefix, Operator: op, Right: right}, nil
+		return Binary{Span: prefix.SourceSpan().Join(right.SourceSpan()), Left: prefix, Operator: op, Right: right}, nil
 	}
 
 	return prefix, nil
@@ -585,7 +851,7 @@ func (p *Parser) unary() (Expr, error) {
 		if err != nil {
 			return Unary{}, err
 		}
-		return Unary{Operator: operator, Right: right}, nil
+		return Unary{Span: operator.Span.Join(right.SourceSpan()), Operator: operator, Right: right}, nil
 	}
 
 	primary, err := p.call()
@@ -617,7 +883,12 @@ func (p *Parser) call() (Expr, error) {
 			if err != nil {
 				return nil, err
 			}
-			expr = Get{Object: expr, Name: name}
+			expr = Get{Span: expr.SourceSpan().Join(name.Span), Object: expr, Name: name}
+		} else if p.match(LEFT_BRACKET) {
+			expr, err = p.subscript(expr)
+			if err != nil {
+				return nil, err
+			}
 		} else {
 			break
 		}
@@ -629,10 +900,10 @@ func (p *Parser) call() (Expr, error) {
 func (p *Parser) add_args(expr Expr) (Expr, error) {
 	paren := p.previous()
 	if p.match(RIGHT_PAREN) {
-		return Call{Callee: expr, Paren: paren, Args: nil}, nil
+		return Call{Span: expr.SourceSpan().Join(p.previous().Span), Callee: expr, Paren: paren, Args: nil}, nil
 	}
 
-	args, err := p.arguments()
+	args, named, err := p.arguments()
 	if err != nil {
 		return nil, err
 	}
@@ -642,56 +913,166 @@ func (p *Parser) add_args(expr Expr) (Expr, error) {
 		return nil, err
 	}
 
-	return Call{Callee: expr, Paren: paren, Args: args}, nil // call expr with args, nil
+	return Call{Span: expr.SourceSpan().Join(p.previous().Span), Callee: expr, Paren: paren, Args: args, Named: named}, nil // call expr with args, nil
 }
 
-func (p *Parser) arguments() ([]Expr, error) {
+func (p *Parser) subscript(object Expr) (Expr, error) {
+	// subscript      → call "[" expression "]" ;
+	bracket := p.previous()
+	index, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.consume(RIGHT_BRACKET, "Expect ']' after index."); err != nil {
+		return nil, err
+	}
+
+	return Subscript{Span: object.SourceSpan().Join(p.previous().Span), Object: object, Bracket: bracket, Index: index}, nil
+}
+
+func (p *Parser) list() (Expr, error) {
+	// list           → "[" ( expression ( "," expression )* )? "]" ;
+	bracket := p.previous()
+	var elements []Expr
+	if !p.check(RIGHT_BRACKET) {
+		for {
+			element, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			elements = append(elements, element)
+			if !p.match(COMMA) {
+				break
+			}
+		}
+	}
+	if _, err := p.consume(RIGHT_BRACKET, "Expect ']' after list elements."); err != nil {
+		return nil, err
+	}
+
+	return List{Span: p.spanFrom(bracket), Bracket: bracket, Elements: elements}, nil
+}
+
+func (p *Parser) mapLiteral() (Expr, error) {
+	// map            → "{" ( entry ( "," entry )* )? "}" ;
+	// entry          → expression ":" expression ;
+	brace := p.previous()
+	var keys, values []Expr
+	if !p.check(RIGHT_BRACE) {
+		for {
+			key, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.consume(COLON, "Expect ':' after map key."); err != nil {
+				return nil, err
+			}
+			value, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			keys, values = append(keys, key), append(values, value)
+			if !p.match(COMMA) {
+				break
+			}
+		}
+	}
+	if _, err := p.consume(RIGHT_BRACE, "Expect '}' after map entries."); err != nil {
+		return nil, err
+	}
+
+	return Map{Span: p.spanFrom(brace), Brace: brace, Keys: keys, Values: values}, nil
+}
+
+func (p *Parser) arguments() ([]Expr, []NamedArg, error) {
+	// arguments      → argument ( "," argument )* ;
+	// argument       → ( IDENTIFIER ":" )? expression ;
 	var args []Expr
-	var err error
-	for cur_arg, err := p.expression(); err == nil; cur_arg, err = p.expression() {
-		if len(args) >= 255 {
+	var named []NamedArg
+	for {
+		var name *Token
+		if p.check(IDENTIFIER) && p.checkNext(COLON) {
+			tok := p.advance()
+			p.advance()
+			name = &tok
+		} else if len(named) > 0 {
+			return nil, nil, p.error(p.peek(), "Positional arguments can't follow named arguments.")
+		}
+		cur_arg, err := p.expression()
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(args)+len(named) >= 255 {
 			p.error(p.peek(), "Can't have more than 255 argumens.")
 		}
-		args = append(args, cur_arg)
+		if name != nil {
+			named = append(named, NamedArg{Name: *name, Value: cur_arg})
+		} else {
+			args = append(args, cur_arg)
+		}
 		if !p.match(COMMA) {
-			return args, nil
+			return args, named, nil
 		}
 	}
-	return nil, err
 }
 
 // primary        → NUMBER | STRING | "true" | "false" | "nil" | IDENTIFIER | (expression)
 //
-//	| "(" expression ")"
+//	| "(" expression ")" | list | map | lambda | arrow | interpolation
 func (p *Parser) primary() (Expr, error) {
 	var err error
 	var expr Expr
 	if p.match(FALSE) {
-		return Literal{Value: false}, nil
+		return Literal{Span: p.previous().Span, Value: false}, nil
 	}
 	if p.match(TRUE) {
-		return Literal{Value: true}, nil
+		return Literal{Span: p.previous().Span, Value: true}, nil
 	}
 	if p.match(NIL) {
-		return Literal{Value: nil}, nil
+		return Literal{Span: p.previous().Span, Value: nil}, nil
+	}
+	if p.match(INTERPOLATION) {
+		return p.interpolation()
 	}
 	if p.match(STRING, NUMBER) {
-		return Literal{Value: p.previous().Literal}, nil
+		return Literal{Span: p.previous().Span, Value: p.previous().Literal}, nil
+	}
+	if p.check(FUN) && p.checkNext(LEFT_PAREN) {
+		keyword := p.advance()
+		decl, err := p.functionBody(keyword, Token{})
+		if err != nil {
+			return nil, err
+		}
+
+		return Lambda{Span: decl.Span, Keyword: keyword, Decl: decl}, nil
+	}
+	if p.arrowAhead() {
+		return p.arrowFunction()
 	}
 	if p.match(LEFT_PAREN) {
+		paren := p.previous()
 		expr, err = p.expression()
+		if err != nil {
+			return Unary{}, err
+		}
 		_, err = p.consume(RIGHT_PAREN, "expected right paren!")
 		if err != nil {
 			return Unary{}, err
 		}
 
-		return Grouping{Expr: expr}, nil
+		return Grouping{Span: p.spanFrom(paren), Expr: expr}, nil
+	}
+	if p.match(LEFT_BRACKET) {
+		return p.list()
+	}
+	if p.match(LEFT_BRACE) {
+		return p.mapLiteral()
 	}
 	if p.match(IDENTIFIER) {
-		return Variable{p.previous()}, nil
+		return Variable{Span: p.previous().Span, Name: p.previous()}, nil
 	}
 	if p.match(THIS) {
-		return This{Keyword: p.previous()}, nil
+		return This{Span: p.previous().Span, Keyword: p.previous()}, nil
 	}
 	if p.match(SUPER) {
 		keyword := p.previous()
@@ -704,15 +1085,116 @@ func (p *Parser) primary() (Expr, error) {
 			return nil, err
 		}
 
-		return Super{Keyword: keyword, Method: id}, nil
+		return Super{Span: p.spanFrom(keyword), Keyword: keyword, Method: id}, nil
 	}
-	if p.match(ERROR) {
-		e := p.error(p.peek(), p.prev.Lexeme)
-		return nil, e
+
+	return nil, p.error(p.peek(), "Expect expression.")
+}
+
+func (p *Parser) interpolation() (Expr, error) {
+	// interpolation  → ( INTERPOLATION expression )+ STRING ;
+	start := p.previous()
+	parts := []Token{start}
+	var exprs []Expr
+	for {
+		// The text after an interpolated expression starts with the "}"
+		// that ends it, which is all there is if the expression is missing.
+		if (p.check(STRING) || p.check(INTERPOLATION)) && p.peek().Lexeme[0] == '}' {
+			return nil, p.error(p.peek(), "Expect expression.")
+		}
+		expr, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+		if p.match(STRING) {
+			break
+		}
+		if !p.match(INTERPOLATION) {
+			return nil, p.error(p.peek(), "Expect '}' after interpolated expression.")
+		}
+		parts = append(parts, p.previous())
 	}
-	parse_error := p.error(p.peek(), "invalid token")
+	parts = append(parts, p.previous())
 
-	return nil, &parse_error
+	return Interpolation{Span: p.spanFrom(start), Strings: parts, Exprs: exprs}, nil
+}
+
+// Whether the tokens ahead are the parameters of an arrow function: a name,
+// or a parenthesized list of parameters, followed by "=>".
+func (p Parser) arrowAhead() bool {
+	i := p.current
+	if p.typeAt(i) == IDENTIFIER {
+		return p.typeAt(i+1) == ARROW
+	}
+	if p.typeAt(i) != LEFT_PAREN {
+		return false
+	}
+	// Once a default value starts, any expression can follow, so only the
+	// brackets are tracked to find the closing paren.
+	depth, inDefault := 1, false
+	for i++; ; i++ {
+		t := p.typeAt(i)
+		switch {
+		case t == EOF:
+			return false
+		case t == RIGHT_PAREN && depth == 1:
+			return p.typeAt(i+1) == ARROW
+		case inDefault:
+			switch t {
+			case LEFT_PAREN, LEFT_BRACKET, LEFT_BRACE:
+				depth++
+			case RIGHT_PAREN, RIGHT_BRACKET, RIGHT_BRACE:
+				depth--
+			}
+		case t == EQUAL:
+			inDefault = true
+		case t == IDENTIFIER, t == COMMA, t == COLON, t == NIL, t == ELLIPSIS:
+			continue
+		default:
+			return false
+		}
+	}
+}
+
+// The type of the i'th token, or EOF past the end.
+func (p Parser) typeAt(i int) TokenType {
+	if i >= len(p.tokens) {
+		return EOF
+	}
+	return p.tokens[i].Token_type
+}
+
+func (p *Parser) arrowFunction() (Expr, error) {
+	// lambda         → "fun" "(" parameters? ")" annotation? block ;
+	// arrow          → ( IDENTIFIER | "(" parameters? ")" ) "=>" expression ;
+	start := p.peek()
+	var decl Function
+	if p.match(IDENTIFIER) {
+		decl.Params, decl.ParamTypes, decl.Defaults = []Token{p.previous()}, []*TypeAnnotation{nil}, []Expr{nil}
+	} else {
+		p.advance()
+		if !p.check(RIGHT_PAREN) {
+			if err := p.parameters(&decl); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := p.consume(RIGHT_PAREN, "expected ')'."); err != nil {
+			return nil, err
+		}
+	}
+	arrow, err := p.consume(ARROW, "Expect '=>' after parameters.")
+	if err != nil {
+		return nil, err
+	}
+	body, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+
+	decl.Span = p.spanFrom(start)
+	decl.Body = []Statement{Return{Span: body.SourceSpan(), Return_expr: body}}
+	return Lambda{Span: decl.Span, Keyword: arrow, Decl: decl, Arrow: true}, nil
 }
 
 // func (p *Parser) identifier() (Expr, error)
@@ -726,7 +1208,7 @@ func (p *Parser) syncronize() {
 		}
 		t := p.peek().Token_type
 		switch t {
-		case CLASS, FUN, VAR, FOR, IF, WHILE, PRINT, RETURN:
+		case CLASS, FUN, VAR, FOR, IF, WHILE, PRINT, RETURN, IMPORT, EXPORT, THROW, TRY, BREAK, CONTINUE:
 			return
 		}
 
@@ -734,6 +1216,12 @@ func (p *Parser) syncronize() {
 	}
 }
 
+// The span from the start of token start to the end of the last token
+// consumed.
+func (p Parser) spanFrom(start Token) source.Span {
+	return start.Span.Join(p.previous().Span)
+}
+
 func (p *Parser) match(token_type ...TokenType) bool {
 	for _, v := range token_type {
 		if p.check(v) {
@@ -752,46 +1240,62 @@ func (p Parser) check(token_type TokenType) bool {
 	return p.peek().Token_type == token_type
 }
 
+// Whether the token after the current one has the given type.
+func (p Parser) checkNext(token_type TokenType) bool {
+	if p.current+1 >= len(p.tokens) {
+		return false
+	}
+	return p.tokens[p.current+1].Token_type == token_type
+}
+
 func (p Parser) cur() *Token {
 	return &p.tokens[p.current]
 }
 
 func (p *Parser) advance() Token {
-	for {
-		if !p.IsAtEnd() {
-			p.prev = p.cur()
-			p.current += 1
-			if p.peek().Token_type == ERROR {
-				fmt.Println(p.peek().Lexeme)
-				continue
-			}
-		}
-		break
+	if !p.IsAtEnd() {
+		p.prev = p.cur()
+		p.current += 1
+		p.skipErrorTokens()
 	}
 
-	ret := p.previous()
+	return p.previous()
+}
 
-	return ret
+// Record the errors carried by any ERROR tokens at the current position and
+// step over them, so that the grammar never has to deal with them.
+func (p *Parser) skipErrorTokens() {
+	for p.peek().Token_type == ERROR {
+		tok := p.peek()
+		err := &ParseError{line: tok.Line, span: tok.Span, message: tok.Lexeme}
+		if e, ok := tok.Literal.(ScannerError); ok {
+			err.message = e.err
+		}
+		p.errors = append(p.errors, err)
+		p.current += 1
+	}
 }
 
 func (p *Parser) consume(tokenType TokenType, message string) (Token, error) {
 	if p.check(tokenType) {
 		return p.advance(), nil
 	}
-	error := p.error(p.peek(), message)
 
-	return Token{}, &error
+	return Token{}, p.error(p.peek(), message)
 }
 
-func (p Parser) error(token Token, message string) ParseError {
+// Record a syntax error at token. The caller decides whether to unwind to
+// the enclosing declaration by returning the error.
+func (p *Parser) error(token Token, message string) *ParseError {
+	err := &ParseError{line: token.Line, span: token.Span, message: message}
 	if token.Token_type == EOF {
-		// errorhandling.Report(token.Line, " at end ", message)
-		fmt.Printf("[line %d] Error %s: '%s'\n", token.Line, "at end", message)
+		err.where = " at end"
 	} else {
-		// errorhandling.Report(token.Line, " at "+token.Lexeme, message)
-		fmt.Printf("[line %d] Error at %s: %s\n", token.Line, token.Lexeme, message)
+		err.where = fmt.Sprintf(" at '%s'", token.Lexeme)
 	}
-	return NewParseError(message)
+	p.errors = append(p.errors, err)
+
+	return err
 }
 
 func (p Parser) peek() Token {
@@ -811,13 +1315,29 @@ func (p Parser) errorAtCurrent() {
 }
 
 type ParseError struct {
-	error string
+	line    int
+	span    source.Span
+	where   string
+	message string
 }
 
 func (e ParseError) Error() string {
-	return e.error
+	return fmt.Sprintf("[line %d] Error%s: %s", e.line, e.where, e.message)
+}
+
+func (e ParseError) Line() int {
+	return e.line
+}
+
+func (e ParseError) Column() int {
+	return e.span.Start.Column
+}
+
+// The span of the token at which the error was found.
+func (e ParseError) Span() source.Span {
+	return e.span
 }
 
-func NewParseError(message string) ParseError {
-	return ParseError{error: "Parser Error: " + message}
+func (e ParseError) Message() string {
+	return e.message
 }
@@ -1,7 +1,9 @@
 package parser_test
 
 import (
+	"errors"
 	"lox-compiler/parser"
+	"strings"
 	"testing"
 )
 
@@ -11,7 +13,10 @@ func TestBinaryStmt(t *testing.T) {
 		t.Fatalf("%s", err.Error())
 	}
 	p := parser.NewParser(toks)
-	stmts := p.Parse()
+	stmts, parseErr := p.Parse()
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
 	if len(stmts) > 1 {
 		t.Fatal(stmts)
 	}
@@ -28,7 +33,7 @@ func TestBinaryStmt(t *testing.T) {
 func TestBinaryPrecedence(t *testing.T) {
 	toks, _ := parser.Scan("2+2*3-8;")
 	p := parser.NewParser(toks)
-	stmts := p.Parse()
+	stmts, _ := p.Parse()
 	if stmts[0].(parser.ExpressionStmt).Val.(parser.Binary).String() != "PLUS 2 MINUS STAR 2 3 8" {
 		t.Fatalf("%v", stmts)
 	}
@@ -37,7 +42,7 @@ func TestBinaryPrecedence(t *testing.T) {
 func TestBinaryPrecedence2(t *testing.T) {
 	toks, _ := parser.Scan("8*2-2/4+10;")
 	p := parser.NewParser(toks)
-	stmts := p.Parse()
+	stmts, _ := p.Parse()
 	if stmts[0].(parser.ExpressionStmt).Val.(parser.Binary).String() != "MINUS STAR 8 2 PLUS SLASH 2 4 10" {
 		t.Fatalf("%v", stmts)
 	}
@@ -46,8 +51,440 @@ func TestBinaryPrecedence2(t *testing.T) {
 func TestCall(t *testing.T) {
 	toks, _ := parser.Scan("foo.bar();")
 	p := parser.NewParser(toks)
-	stmts := p.Parse()
+	stmts, _ := p.Parse()
 	if stmts[0].(parser.ExpressionStmt).Val.(parser.Call).String() != "CALL GET foo.bar ([])" {
 		t.Fatalf("%v", stmts)
 	}
 }
+
+func TestMultipleErrors(t *testing.T) {
+	toks, _ := parser.Scan(`print;
+var 1 = 2;
+{
+  print (1;
+  print 2;
+}
+print 3 @;`)
+	p := parser.NewParser(toks)
+	stmts, err := p.Parse()
+	if err == nil {
+		t.Fatal("expected syntax errors")
+	}
+	// The block survives the error inside it, and scanner errors don't
+	// unwind the parser at all.
+	if len(stmts) != 2 {
+		t.Fatalf("expected two statements, got %v", stmts)
+	}
+
+	expected := []struct {
+		line, column int
+		message      string
+	}{
+		{1, 6, "[line 1] Error at ';': Expect expression."},
+		{2, 5, "[line 2] Error at '1': Expect variable name."},
+		{4, 11, "[line 4] Error at ';': expected right paren!"},
+		{7, 9, "[line 7] Error: Unexpected character."},
+	}
+	errs := err.(interface{ Unwrap() []error }).Unwrap()
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, got %q", len(expected), errs)
+	}
+	for i, e := range expected {
+		var pErr *parser.ParseError
+		if !errors.As(errs[i], &pErr) {
+			t.Fatalf("expected a *parser.ParseError, got %T", errs[i])
+		}
+		if pErr.Line() != e.line || pErr.Column() != e.column || pErr.Error() != e.message {
+			t.Errorf("expected %q at %d:%d, got %q at %d:%d",
+				e.message, e.line, e.column, pErr.Error(), pErr.Line(), pErr.Column())
+		}
+	}
+}
+
+func TestSpans(t *testing.T) {
+	src := "var a = 1;\nprint a +\n  foo.bar(2);"
+	toks, _ := parser.Scan(src)
+	p := parser.NewParser(toks)
+	stmts, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text := func(n parser.ASTNode) string {
+		s := n.SourceSpan()
+		return src[s.Start.Offset:s.End.Offset]
+	}
+	print := stmts[1].(parser.Print)
+	binary := print.Val.(parser.Binary)
+	call := binary.Right.(parser.Call)
+	for _, test := range []struct {
+		node     parser.ASTNode
+		expected string
+	}{
+		{stmts[0], "var a = 1;"},
+		{print, "print a +\n  foo.bar(2);"},
+		{binary, "a +\n  foo.bar(2)"},
+		{call, "foo.bar(2)"},
+		{call.Callee, "foo.bar"},
+	} {
+		if got := text(test.node); got != test.expected {
+			t.Errorf("expected %q, got %q", test.expected, got)
+		}
+	}
+
+	if s := call.Paren.Span; s.Start.Line != 3 || s.Start.Column != 10 || s.End.Column != 11 {
+		t.Errorf("unexpected span for '(': %v", s)
+	}
+}
+
+func TestTypeAnnotations(t *testing.T) {
+	toks, _ := parser.Scan(`var n: number = 1;
+fun f(a: string, b): bool { return true; }
+class P { x: number; m(): nil {} }`)
+	p := parser.NewParser(toks)
+	stmts, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := stmts[0].(parser.Var); v.Type == nil || v.Type.Name.Lexeme != "number" {
+		t.Fatalf("%v", v)
+	}
+	f := stmts[1].(parser.Function)
+	if len(f.ParamTypes) != 2 || f.ParamTypes[0].Name.Lexeme != "string" || f.ParamTypes[1] != nil || f.ReturnType.Name.Lexeme != "bool" {
+		t.Fatalf("%v", f)
+	}
+	c := stmts[2].(parser.Class)
+	if len(c.Fields) != 1 || c.Fields[0].Type.Name.Lexeme != "number" || len(c.Methods) != 1 || c.Methods[0].ReturnType.Name.Lexeme != "nil" {
+		t.Fatalf("%v", c)
+	}
+
+	toks, _ = parser.Scan("var n: = 1;")
+	p = parser.NewParser(toks)
+	if _, err := p.Parse(); err == nil || !strings.Contains(err.Error(), "Expect type after ':'.") {
+		t.Fatalf("Expected a missing type error, got %v", err)
+	}
+}
+
+func TestModules(t *testing.T) {
+	toks, _ := parser.Scan(`import "a.lox";
+import m from "lib/m.lox";
+export var x = 1;
+export fun f() {}
+var from = 2;`)
+	p := parser.NewParser(toks)
+	stmts, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		`IMPORT "a.lox"`,
+		`IMPORT m FROM "lib/m.lox"`,
+		`EXPORT VAR x = 1`,
+		`EXPORT f (){}`,
+		`VAR from = 2`,
+	}
+	for i, e := range expected {
+		if stmts[i].String() != e {
+			t.Errorf("expected %q, got %q", e, stmts[i].String())
+		}
+	}
+
+	for src, message := range map[string]string{
+		`{ import "a.lox"; }`:       "Can only use 'import' at the top level of a module.",
+		`fun f() { export var x; }`: "Can only use 'export' at the top level of a module.",
+		`export print 1;`:           "Expect declaration after 'export'.",
+		`import m "a.lox";`:         "Expect 'from' after module name.",
+		`import 1;`:                 "Expect module path.",
+	} {
+		toks, _ := parser.Scan(src)
+		p := parser.NewParser(toks)
+		if _, err := p.Parse(); err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("%s: expected %q, got %v", src, message, err)
+		}
+	}
+}
+
+func TestExceptions(t *testing.T) {
+	toks, _ := parser.Scan(`throw "oops";
+try { print 1; } catch (e) { print e; }
+try {} finally {}
+try {} catch (e) {} finally {}`)
+	p := parser.NewParser(toks)
+	stmts, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		`THROW oops`,
+		"TRY [\nPRINT 1] CATCH (e) [\nPRINT e]",
+		`TRY [] FINALLY []`,
+		`TRY [] CATCH (e) [] FINALLY []`,
+	}
+	for i, e := range expected {
+		if stmts[i].String() != e {
+			t.Errorf("expected %q, got %q", e, stmts[i].String())
+		}
+	}
+
+	for src, message := range map[string]string{
+		`try {} print 1;`:         "Expect 'catch' or 'finally' after try block.",
+		`try {} catch e {}`:       "Expect '(' after 'catch'.",
+		`try {} catch (1) {}`:     "Expect exception variable name.",
+		`try {} catch (e {}`:      "Expect ')' after exception variable.",
+		`throw 1 print 2;`:        "Expect ';' after thrown value.",
+		`try print 1; finally {}`: "expected '{'",
+	} {
+		toks, _ := parser.Scan(src)
+		p := parser.NewParser(toks)
+		if _, err := p.Parse(); err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("%s: expected %q, got %v", src, message, err)
+		}
+	}
+}
+
+func TestLoopJumps(t *testing.T) {
+	toks, _ := parser.Scan(`while (true) { break; continue; }`)
+	p := parser.NewParser(toks)
+	stmts, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "WHILE (true) [\nBREAK\nCONTINUE]"
+	if stmts[0].String() != expected {
+		t.Errorf("expected %q, got %q", expected, stmts[0].String())
+	}
+
+	for src, message := range map[string]string{
+		`while (true) break`:       "Expect ';' after 'break'.",
+		`while (true) continue 1;`: "Expect ';' after 'continue'.",
+	} {
+		toks, _ := parser.Scan(src)
+		p := parser.NewParser(toks)
+		if _, err := p.Parse(); err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("%s: expected %q, got %v", src, message, err)
+		}
+	}
+}
+
+func TestLists(t *testing.T) {
+	toks, _ := parser.Scan(`[1, "a", []];
+xs[0];
+xs[-1][i] = 2;`)
+	p := parser.NewParser(toks)
+	stmts, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		`LIST [1 a LIST []]`,
+		`INDEX xs[0]`,
+		`INDEX xs[MINUS 1][i] = 2`,
+	}
+	for i, e := range expected {
+		if stmts[i].(parser.ExpressionStmt).Val.String() != e {
+			t.Errorf("expected %q, got %q", e, stmts[i].(parser.ExpressionStmt).Val.String())
+		}
+	}
+
+	for src, message := range map[string]string{
+		`[1, 2;`:      "Expect ']' after list elements.",
+		`[1,];`:       "Expect expression.",
+		`xs[0;`:       "Expect ']' after index.",
+		`[1, 2] = 3;`: "Left side of assignment must be a variable.",
+		`xs[] = 3;`:   "Expect expression.",
+	} {
+		toks, _ := parser.Scan(src)
+		p := parser.NewParser(toks)
+		if _, err := p.Parse(); err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("%s: expected %q, got %v", src, message, err)
+		}
+	}
+}
+
+func TestMaps(t *testing.T) {
+	toks, _ := parser.Scan(`print {};
+print {"a": 1, "b": [2]};
+m["a"] = m["b"];
+{ print 1; }`)
+	p := parser.NewParser(toks)
+	stmts, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		`PRINT MAP {}`,
+		`PRINT MAP {a: 1, b: LIST [2]}`,
+		`m[a] = INDEX m[b]`,
+		"[\nPRINT 1]",
+	}
+	for i, e := range expected {
+		got := stmts[i].String()
+		if s, ok := stmts[i].(parser.ExpressionStmt); ok {
+			got = s.Val.String()
+		}
+		if got != e {
+			t.Errorf("expected %q, got %q", e, got)
+		}
+	}
+
+	for src, message := range map[string]string{
+		`print {"a" 1};`:   "Expect ':' after map key.",
+		`print {"a": 1;`:   "Expect '}' after map entries.",
+		`print {"a": 1,};`: "Expect expression.",
+	} {
+		toks, _ := parser.Scan(src)
+		p := parser.NewParser(toks)
+		if _, err := p.Parse(); err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("%s: expected %q, got %v", src, message, err)
+		}
+	}
+}
+
+func TestForIn(t *testing.T) {
+	toks, _ := parser.Scan(`for (var x in xs) print x;
+for (var i in 0..n + 1) {}
+for (var i = 0; i < 3; i = i + 1) {}
+print a < 0..1;`)
+	p := parser.NewParser(toks)
+	stmts, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		`FOR (x IN xs) PRINT x`,
+		`FOR (i IN RANGE 0..PLUS n 1) []`,
+	}
+	for i, e := range expected {
+		if stmts[i].String() != e {
+			t.Errorf("expected %q, got %q", e, stmts[i].String())
+		}
+	}
+	if _, ok := stmts[2].(parser.For); !ok {
+		t.Errorf("expected a for loop, got %T", stmts[2])
+	}
+	if s := stmts[3].(parser.Print).Val.String(); s != `LESS a RANGE 0..1` {
+		t.Errorf("expected the range to bind tighter than <, got %q", s)
+	}
+
+	for src, message := range map[string]string{
+		`for (var x in xs print x;`: "Expect ')' after for-in iterable.",
+		`for (var x in) print x;`:   "Expect expression.",
+		`for (var in xs) print x;`:  "Expect variable name.",
+	} {
+		toks, _ := parser.Scan(src)
+		p := parser.NewParser(toks)
+		if _, err := p.Parse(); err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("%s: expected %q, got %v", src, message, err)
+		}
+	}
+}
+
+func TestLambda(t *testing.T) {
+	toks, _ := parser.Scan(`var add = fun (a, b) { return a + b; };
+xs.map(x => x * 2);
+var f = (a: number) => (b) => a + b;
+fun () {};
+print (x);`)
+	p := parser.NewParser(toks)
+	stmts, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"VAR add = FUN (a, b){\nRETURN PLUS a b}",
+		"ExpressionStmt(CALL GET xs.map ([FUN (x){\nRETURN STAR x 2}]))",
+		"VAR f = FUN (a: number){\nRETURN FUN (b){\nRETURN PLUS a b}}",
+		"ExpressionStmt(FUN (){})",
+		"PRINT (x)",
+	}
+	for i, e := range expected {
+		if stmts[i].String() != e {
+			t.Errorf("expected %q, got %q", e, stmts[i].String())
+		}
+	}
+	if l := stmts[1].(parser.ExpressionStmt).Val.(parser.Call).Args[0].(parser.Lambda); !l.Arrow || l.Keyword.Lexeme != "=>" {
+		t.Errorf("expected an arrow function, got %+v", l)
+	}
+
+	for src, message := range map[string]string{
+		`var f = fun (a { return a; };`: "expected ')'.",
+		`var f = fun () return 1;`:      "expected '{'",
+		`var f = (a, b) =>;`:            "Expect expression.",
+		`fun () {}`:                     "expected ';' at end of line",
+	} {
+		toks, _ := parser.Scan(src)
+		p := parser.NewParser(toks)
+		if _, err := p.Parse(); err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("%s: expected %q, got %v", src, message, err)
+		}
+	}
+}
+
+func TestParameters(t *testing.T) {
+	toks, _ := parser.Scan(`fun f(a, b = a + 1, ...rest) {}
+f(1, b: 2);
+var g = (x = (1), ...ys) => ys;
+var h = (a = 1);`)
+	p := parser.NewParser(toks)
+	stmts, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"f (a, b = PLUS a 1, ...rest){}",
+		"ExpressionStmt(CALL f ([1] [b: 2]))",
+		"VAR g = FUN (x = (1), ...ys){\nRETURN ys}",
+		"VAR h = (a = 1)",
+	}
+	for i, e := range expected {
+		if stmts[i].String() != e {
+			t.Errorf("expected %q, got %q", e, stmts[i].String())
+		}
+	}
+	if min, max := stmts[0].(parser.Function).Arity(); min != 1 || max != -1 {
+		t.Errorf("expected f to take at least 1 argument, got %d to %d", min, max)
+	}
+
+	for src, message := range map[string]string{
+		`fun f(...a, b) {}`:  "A rest parameter must be the last parameter.",
+		`fun f(...a = 1) {}`: "A rest parameter can't have a default value.",
+		`fun f(a = 1, b) {}`: "A parameter without a default value can't follow one with a default.",
+		`f(a: 1, 2);`:        "Positional arguments can't follow named arguments.",
+	} {
+		toks, _ := parser.Scan(src)
+		p := parser.NewParser(toks)
+		if _, err := p.Parse(); err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("%s: expected %q, got %v", src, message, err)
+		}
+	}
+}
+
+func TestInterpolation(t *testing.T) {
+	toks, _ := parser.Scan(`print "Hello ${name}, you are ${age + 1}";
+print "${"in ${x}"}";`)
+	p := parser.NewParser(toks)
+	stmts, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		`PRINT INTERPOLATE "Hello " name ", you are " PLUS age 1 ""`,
+		`PRINT INTERPOLATE "" INTERPOLATE "in " x "" ""`,
+	}
+	for i, e := range expected {
+		if stmts[i].String() != e {
+			t.Errorf("expected %q, got %q", e, stmts[i].String())
+		}
+	}
+
+	for src, message := range map[string]string{
+		`print "${}";`:    "Expect expression.",
+		`print "${a b}";`: "Expect '}' after interpolated expression.",
+	} {
+		toks, _ := parser.Scan(src)
+		p := parser.NewParser(toks)
+		if _, err := p.Parse(); err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("%s: expected %q, got %v", src, message, err)
+		}
+	}
+}
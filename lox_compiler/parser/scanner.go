@@ -3,24 +3,66 @@ package parser
 import (
 	"fmt"
 	"lox-compiler/debug"
+	"lox-compiler/decimal"
+	"lox-compiler/source"
+	"math/big"
 	"strconv"
+	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 type Scanner struct {
 	source               string
 	tokens               []Token
 	start, current, line int
+	// The offset at which the current line begins, and the line and column
+	// at which the token being scanned starts.
+	lineStart, startLine, column int
+	comments                     []Comment
+	// Whether to attach trivia to the tokens, and the trivia found so far.
+	lossless bool
+	trivia   []Trivia
+	// The interpolated expressions being scanned, innermost last.
+	interpolations []interpolation
+	// Errors in the text of the string literal being scanned, which are added
+	// after its token so the parser still sees the string.
+	stringErrors []ScannerError
+}
+
+// How the text of a string literal is scanned.
+type stringLiteral struct {
+	// Whether escape sequences and interpolations are left as they are.
+	raw bool
+	// Whether the string is triple-quoted, and the indentation stripped from
+	// each of its lines.
+	multiline bool
+	indent    string
+}
+
+// An interpolated expression being scanned.
+type interpolation struct {
+	// The string the expression is in.
+	str stringLiteral
+	// The number of braces opened in the expression and not yet closed.
+	braces int
+}
+
+// A line comment, which the parser never sees. Text includes the leading
+// "//".
+type Comment struct {
+	source.Span
+	Text string
 }
 
 type ScannerError struct {
-	line int
+	span source.Span
 	seq  string
 	err  string
 }
 
 func (e ScannerError) Error() string {
-	return fmt.Sprintf("parsing error on line %d at \"%s\": %s", e.line, e.seq, e.err)
+	return fmt.Sprintf("parsing error on line %d at \"%s\": %s", e.span.Start.Line, e.seq, e.err)
 }
 
 func Scan(source string) ([]Token, *ScannerError) {
@@ -33,9 +75,20 @@ func NewScanner(source string) *Scanner {
 	return &ret
 }
 
+// A scanner that keeps whitespace and comments as trivia on the tokens, so
+// that the source can be rebuilt from them byte for byte.
+func NewLosslessScanner(source string) *Scanner {
+	s := NewScanner(source)
+	s.lossless = true
+
+	return s
+}
+
 func (s *Scanner) ScanTokens() ([]Token, *ScannerError) {
 	for !s.isAtEnd() {
 		s.start = s.current
+		s.startLine = s.line
+		s.column = s.current - s.lineStart + 1
 		err := s.scanToken()
 		if err != nil {
 			return nil, err
@@ -48,8 +101,12 @@ func (s *Scanner) ScanTokens() ([]Token, *ScannerError) {
 			Lexeme:     "",
 			Literal:    nil,
 			Line:       s.line,
+			Span:       source.Span{Start: s.position(), End: s.position()},
 		},
 	)
+	if s.lossless {
+		s.attachTrivia()
+	}
 
 	return s.tokens, nil
 }
@@ -65,16 +122,50 @@ func (s *Scanner) scanToken() *ScannerError {
 		s.addToken(RIGHT_PAREN)
 
 	case '{':
+		if n := len(s.interpolations); n > 0 {
+			s.interpolations[n-1].braces++
+		}
 		s.addToken(LEFT_BRACE)
 
 	case '}':
+		n := len(s.interpolations)
+		if n > 0 && s.interpolations[n-1].braces == 0 {
+			// The end of an interpolated expression, and the rest of the
+			// string it is in.
+			str := s.interpolations[n-1].str
+			s.interpolations = s.interpolations[:n-1]
+			if err := s.tokenize_string(str); err != nil {
+				s.addErrorToken(*err)
+			}
+			break
+		}
+		if n > 0 {
+			s.interpolations[n-1].braces--
+		}
 		s.addToken(RIGHT_BRACE)
 
+	case '[':
+		s.addToken(LEFT_BRACKET)
+
+	case ']':
+		s.addToken(RIGHT_BRACKET)
+
 	case ',':
 		s.addToken(COMMA)
 
+	case ':':
+		s.addToken(COLON)
+
 	case '.':
-		s.addToken(DOT)
+		if s.match('.') {
+			t = DOT_DOT
+			if s.match('.') {
+				t = ELLIPSIS
+			}
+		} else {
+			t = DOT
+		}
+		s.addToken(t)
 
 	case '-':
 		s.addToken(MINUS)
@@ -88,6 +179,9 @@ func (s *Scanner) scanToken() *ScannerError {
 	case '*':
 		s.addToken(STAR)
 
+	case '%':
+		s.addToken(PERCENT)
+
 	case '!':
 		if s.match('=') {
 			t = BANG_EQUAL
@@ -99,6 +193,8 @@ func (s *Scanner) scanToken() *ScannerError {
 	case '=':
 		if s.match('=') {
 			t = EQUAL_EQUAL
+		} else if s.match('>') {
+			t = ARROW
 		} else {
 			t = EQUAL
 		}
@@ -127,30 +223,42 @@ func (s *Scanner) scanToken() *ScannerError {
 			for s.peek() != '\n' && !s.isAtEnd() {
 				s.advance()
 			}
+			s.comments = append(s.comments, Comment{Span: s.span(), Text: s.source[s.start:s.current]})
+			s.addTrivia(CommentTrivia)
 		}
 
-	case ' ':
-	case '\r':
-	case '\t':
+	case ' ', '\r', '\t':
+		for s.peek() == ' ' || s.peek() == '\r' || s.peek() == '\t' {
+			s.advance()
+		}
+		s.addTrivia(WhitespaceTrivia)
 	case '\n':
-		s.line += 1
+		s.newLine()
+		s.addTrivia(NewlineTrivia)
 	case '"':
-		err := s.tokenize_string()
+		err := s.tokenize_string(s.openString(false))
 		if err != nil {
 			s.addErrorToken(*err)
 		}
 
 	default:
-		if unicode.IsDigit(c) {
-			s.tokenize_number()
+		if c == 'r' && s.peek() == '"' {
+			s.advance()
+			if err := s.tokenize_string(s.openString(true)); err != nil {
+				s.addErrorToken(*err)
+			}
+		} else if unicode.IsDigit(c) {
+			if err := s.tokenize_number(); err != nil {
+				s.addErrorToken(*err)
+			}
 		} else if unicode.IsLetter(c) || c == '_' {
 			s.tokenize_identifier()
 		} else {
 			s.addErrorToken(
 				ScannerError{
-					line: s.line,
+					span: s.span(),
 					seq:  s.source[s.start:s.current],
-					err:  "unexpected character",
+					err:  "Unexpected character.",
 				},
 			)
 		}
@@ -159,13 +267,57 @@ func (s *Scanner) scanToken() *ScannerError {
 	return nil
 }
 
+// The comments found by ScanTokens, in source order.
+func (s *Scanner) Comments() []Comment {
+	return s.comments
+}
+
+func (s *Scanner) addTrivia(kind TriviaKind) {
+	if s.lossless {
+		s.trivia = append(s.trivia, Trivia{Span: s.span(), Kind: kind, Text: s.source[s.start:s.current]})
+	}
+}
+
+// Hand out the trivia to the tokens. Trivia on the same line as the token
+// before it, up to and including the newline, trails that token; everything
+// else leads the token after it. Trivia at the end of the source leads the
+// EOF token. Error tokens for mistakes inside the token before them, such as
+// a bad escape sequence in a string, get none.
+func (s *Scanner) attachTrivia() {
+	next := 0
+	var prev *Token
+	for i := range s.tokens {
+		if prev != nil && s.tokens[i].Start.Offset < prev.End.Offset {
+			continue
+		}
+		if prev != nil {
+			for next < len(s.trivia) && s.trivia[next].Start.Offset < s.tokens[i].Start.Offset {
+				t := s.trivia[next]
+				if t.Start.Line != prev.End.Line {
+					break
+				}
+				prev.Trailing = append(prev.Trailing, t)
+				next++
+				if t.Kind == NewlineTrivia {
+					break
+				}
+			}
+		}
+		for next < len(s.trivia) && s.trivia[next].Start.Offset < s.tokens[i].Start.Offset {
+			s.tokens[i].Leading = append(s.tokens[i].Leading, s.trivia[next])
+			next++
+		}
+		prev = &s.tokens[i]
+	}
+}
+
 func (s *Scanner) addToken(t TokenType) {
 	s.addTokenLiteral(t, nil)
 }
 
 func (s *Scanner) addTokenLiteral(t TokenType, literal any) {
 	text := s.source[s.start:s.current]
-	s.tokens = append(s.tokens, Token{Token_type: t, Lexeme: text, Literal: literal, Line: s.line})
+	s.tokens = append(s.tokens, Token{Token_type: t, Lexeme: text, Literal: literal, Line: s.line, Span: s.span()})
 }
 
 func (s *Scanner) tokenize_identifier() {
@@ -185,53 +337,272 @@ func (s *Scanner) tokenize_identifier() {
 
 }
 
-func (s *Scanner) tokenize_number() {
+func (s *Scanner) tokenize_number() *ScannerError {
 	var new_string string
 	for unicode.IsDigit(s.peek()) {
 		s.advance()
 	}
-
-	if s.peek() == '.' && unicode.IsDigit(s.peekNext()) {
+	fractional := s.peek() == '.' && unicode.IsDigit(s.peekNext())
+	if fractional {
 		s.advance()
 		for unicode.IsDigit(s.peek()) {
 			s.advance()
 		}
 	}
-
 	new_string = s.source[s.start:s.current]
+
+	// An n after the digits makes a bigint and a d a decimal, both of which
+	// are exact however many digits there are.
+	switch s.numberSuffix() {
+	case 'n':
+		if fractional {
+			return &ScannerError{
+				span: s.span(),
+				seq:  s.source[s.start:s.current],
+				err:  "A bigint literal can't have a fractional part.",
+			}
+		}
+		num, _ := new(big.Int).SetString(new_string, 10)
+		s.addTokenLiteral(NUMBER, num)
+		return nil
+	case 'd':
+		num, _ := decimal.Parse(new_string)
+		s.addTokenLiteral(NUMBER, num)
+		return nil
+	}
+
+	// Otherwise a number with a fractional part is a float, and one without
+	// is an integer.
+	if !fractional {
+		num, err := strconv.ParseInt(new_string, 10, 64)
+		if err != nil {
+			return &ScannerError{
+				span: s.span(),
+				seq:  new_string,
+				err:  "Number literal is out of range.",
+			}
+		}
+		s.addTokenLiteral(NUMBER, num)
+		return nil
+	}
 	num, err := strconv.ParseFloat(new_string, 64)
 	if err != nil {
-		panic("Not a number!")
+		return &ScannerError{
+			span: s.span(),
+			seq:  new_string,
+			err:  "Number literal is out of range.",
+		}
 	}
 
 	s.addTokenLiteral(NUMBER, num)
+
+	return nil
 }
 
-func (s *Scanner) tokenize_string() *ScannerError {
-	var new_string string
+// Scan the suffix of a number literal, n or d, and report which it was, or
+// 0 if there isn't one. A letter that starts a longer identifier isn't one.
+func (s *Scanner) numberSuffix() rune {
+	c := s.peek()
+	if c != 'n' && c != 'd' {
+		return 0
+	}
+	if next := s.peekNext(); unicode.IsDigit(next) || unicode.IsLetter(next) || next == '_' {
+		return 0
+	}
+	s.advance()
 
-	for s.peek() != '"' && !s.isAtEnd() {
-		if s.peek() == '\n' {
-			s.line += 1
+	return c
+}
+
+// Scan the rest of the quotes that open a string literal, and work out how
+// its text is scanned. A raw string's "r" and first quote have been scanned.
+func (s *Scanner) openString(raw bool) stringLiteral {
+	str := stringLiteral{raw: raw}
+	if s.peek() != '"' || s.peekNext() != '"' {
+		return str
+	}
+	s.advance()
+	s.advance()
+	str.multiline = true
+	str.indent = s.closingIndent(raw)
+	// A line break straight after the opening quotes isn't part of the
+	// string.
+	if s.match('\n') {
+		s.newLine()
+		s.skipIndent(str)
+	}
+
+	return str
+}
+
+// The indentation of the closing quotes of the multi-line string being
+// scanned, or "" unless they are on a line of their own. They are taken to
+// be the first unescaped """, so a multi-line string nested in an
+// interpolated expression ends the search early.
+func (s Scanner) closingIndent(raw bool) string {
+	end := -1
+	for i := s.current; i < len(s.source); i++ {
+		if s.source[i] == '\\' && !raw {
+			i++
+		} else if strings.HasPrefix(s.source[i:], `"""`) {
+			end = i
+			break
 		}
+	}
+	if end < 0 {
+		return ""
+	}
+	lineBreak := strings.LastIndexByte(s.source[s.current:end], '\n')
+	if lineBreak < 0 {
+		return ""
+	}
+	indent := s.source[s.current+lineBreak+1 : end]
+	if strings.Trim(indent, " \t") != "" {
+		return ""
+	}
+
+	return indent
+}
+
+// Skip the indentation at the start of a line of a multi-line string. Lines
+// with nothing but whitespace on them needn't be indented.
+func (s *Scanner) skipIndent(str stringLiteral) {
+	rest := s.source[s.current:]
+	if strings.HasPrefix(rest, str.indent) {
+		s.current += len(str.indent)
+		return
+	}
+	line := rest
+	if i := strings.IndexByte(rest, '\n'); i >= 0 {
+		line = rest[:i]
+	}
+	if strings.TrimLeft(line, " \t\r") == "" {
+		s.current += len(line)
+		return
+	}
+
+	start := s.current
+	for s.peek() == ' ' || s.peek() == '\t' {
 		s.advance()
 	}
+	s.stringError(start, "Line is indented less than the closing quotes.")
+}
+
+// Scan the text of a string literal, or the rest of it after an interpolated
+// expression. The part before a "${" is an INTERPOLATION token, which the
+// tokens of the expression follow.
+func (s *Scanner) tokenize_string(str stringLiteral) *ScannerError {
+	var value strings.Builder
+	closing := `"`
+	if str.multiline {
+		closing = `"""`
+	}
+
+	for !strings.HasPrefix(s.source[s.current:], closing) && !s.isAtEnd() {
+		c := s.advance()
+		switch {
+		case c == '\n':
+			s.newLine()
+			if !str.multiline {
+				break
+			}
+			// Neither is the line break before closing quotes on a line of
+			// their own.
+			if strings.HasPrefix(s.source[s.current:], str.indent+closing) {
+				s.current += len(str.indent)
+				continue
+			}
+			value.WriteByte('\n')
+			s.skipIndent(str)
+			continue
+		case c == '\\' && !str.raw:
+			s.escape(&value)
+			continue
+		case c == '$' && !str.raw && s.match('{'):
+			s.interpolations = append(s.interpolations, interpolation{str: str})
+			s.addTokenLiteral(INTERPOLATION, value.String())
+			s.addStringErrors()
+			return nil
+		}
+		value.WriteByte(byte(c))
+	}
 
 	if s.isAtEnd() {
+		// Errors in the text of a string with no end would only add noise.
+		s.stringErrors = nil
 		// errorhandling.Report(s.line, s.source[s.start:s.current], "Unterminated string.")
+		// Report the error on the line of the opening quote rather than at
+		// the end of the file.
 		return &ScannerError{
-			line: s.line,
+			span: s.span(),
 			seq:  s.source[s.start:s.current],
-			err:  "unterminated string",
+			err:  "Unterminated string.",
 		}
 	}
 
+	s.current += len(closing)
+	s.addTokenLiteral(STRING, value.String())
+	s.addStringErrors()
+
+	return nil
+}
+
+// Scan the escape sequence after a backslash into value.
+func (s *Scanner) escape(value *strings.Builder) {
+	start := s.current - 1
+	switch c := s.peek(); c {
+	case 'n':
+		value.WriteByte('\n')
+	case 't':
+		value.WriteByte('\t')
+	case 'r':
+		value.WriteByte('\r')
+	case '\\', '"', '$':
+		value.WriteByte(byte(c))
+	case 'u':
+		s.advance()
+		s.unicodeEscape(value, start)
+		return
+	default:
+		// A line break is left for the string to scan.
+		if c != '\n' && !s.isAtEnd() {
+			_, size := utf8.DecodeRuneInString(s.source[s.current:])
+			s.current += size
+		}
+		s.stringError(start, "Invalid escape sequence.")
+		return
+	}
 	s.advance()
-	new_string = s.source[s.start+1 : s.current-1]
+}
 
-	s.addTokenLiteral(STRING, new_string)
+// Scan the rest of a "\u{...}" escape sequence, which names a Unicode code
+// point with one to six hex digits.
+func (s *Scanner) unicodeEscape(value *strings.Builder, start int) {
+	if !s.match('{') {
+		s.stringError(start, "Invalid Unicode escape sequence.")
+		return
+	}
+	digits := s.current
+	for unicode.Is(unicode.ASCII_Hex_Digit, s.peek()) {
+		s.advance()
+	}
+	hex := s.source[digits:s.current]
+	if !s.match('}') || len(hex) == 0 || len(hex) > 6 {
+		s.stringError(start, "Invalid Unicode escape sequence.")
+		return
+	}
+	r, _ := strconv.ParseUint(hex, 16, 32)
+	if !utf8.ValidRune(rune(r)) {
+		s.stringError(start, "Invalid Unicode escape sequence.")
+		return
+	}
+	value.WriteRune(rune(r))
+}
 
-	return nil
+// Record an error in the text of a string literal, from offset start on the
+// current line to the next character.
+func (s *Scanner) stringError(start int, message string) {
+	s.stringErrors = append(s.stringErrors, ScannerError{span: s.spanFrom(start), seq: s.source[start:s.current], err: message})
 }
 
 func (s *Scanner) match(expected rune) bool {
@@ -254,6 +625,37 @@ func (s *Scanner) advance() rune {
 	return rune(r)
 }
 
+// The position of the next character to be scanned.
+func (s Scanner) position() source.Position {
+	return source.Position{Line: s.line, Column: s.current - s.lineStart + 1, Offset: s.current}
+}
+
+// The span of the token being scanned.
+func (s Scanner) span() source.Span {
+	return source.Span{
+		Start: source.Position{Line: s.startLine, Column: s.column, Offset: s.start},
+		End:   s.position(),
+	}
+}
+
+func (s Scanner) previous() rune {
+	return rune(s.source[s.current-1])
+}
+
+// The span from offset start, on the current line, to the next character.
+func (s Scanner) spanFrom(start int) source.Span {
+	return source.Span{
+		Start: source.Position{Line: s.line, Column: start - s.lineStart + 1, Offset: start},
+		End:   s.position(),
+	}
+}
+
+// Called after consuming a newline.
+func (s *Scanner) newLine() {
+	s.line += 1
+	s.lineStart = s.current
+}
+
 func (s Scanner) isAtEnd() bool {
 	return s.current >= len(s.source)
 }
@@ -274,11 +676,20 @@ func (s Scanner) peekNext() rune {
 	return rune(s.source[s.current+1])
 }
 
+// Error tokens carry their ScannerError as the literal so the parser can
+// report it alongside its own errors.
 func (s *Scanner) addErrorToken(e ScannerError) {
 	debug.Printf("%s", e.Error())
-	s.tokens = append(s.tokens, Token{Token_type: ERROR, Lexeme: e.Error(), Literal: nil, Line: e.line})
+	s.tokens = append(s.tokens, newErrorToken(e))
+}
+
+func (s *Scanner) addStringErrors() {
+	for _, e := range s.stringErrors {
+		s.addErrorToken(e)
+	}
+	s.stringErrors = nil
 }
 
 func newErrorToken(e ScannerError) Token {
-	return Token{Token_type: ERROR, Lexeme: e.Error(), Literal: nil, Line: e.line}
+	return Token{Token_type: ERROR, Lexeme: e.Error(), Literal: e, Line: e.span.Start.Line, Span: e.span}
 }
@@ -1,7 +1,9 @@
 package parser_test
 
 import (
+	"lox-compiler/decimal"
 	"lox-compiler/parser"
+	"math/big"
 	"testing"
 )
 
@@ -36,3 +38,116 @@ func TestInvalidStr(t *testing.T) {
 
     assertTokenTypesMatch(t, expectedTokens, toks)
 }
+
+func TestComments(t *testing.T) {
+	s := parser.NewScanner("var a; // one\n// two\nprint a;")
+	if _, err := s.ScanTokens(); err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+
+	comments := s.Comments()
+	if len(comments) != 2 || comments[0].Text != "// one" || comments[1].Text != "// two" {
+		t.Fatalf("Unexpected comments %v", comments)
+	}
+	if comments[1].Start.Line != 2 || comments[1].Start.Offset != 14 {
+		t.Fatalf("Unexpected position %v", comments[1].Start)
+	}
+}
+
+func TestScanInterpolation(t *testing.T) {
+	expectedTokens := []parser.TokenType{
+		parser.INTERPOLATION, parser.IDENTIFIER, parser.INTERPOLATION, parser.LEFT_BRACE, parser.RIGHT_BRACE,
+		parser.STRING, parser.EOF,
+	}
+	toks, err := parser.Scan(`"a ${b} \${c} ${ {} }"`)
+	if err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+
+    assertTokenTypesMatch(t, expectedTokens, toks)
+	if toks[0].Literal != "a " || toks[2].Literal != " ${c} " || toks[5].Lexeme != `}"` {
+		t.Fatalf("Unexpected tokens %v", toks)
+	}
+}
+
+func TestEscapes(t *testing.T) {
+	toks, err := parser.Scan(`"a\tb\n\\ \"q\" \$ \u{41}\u{1F600}" r"raw\n${x}"`)
+	if err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+
+	if toks[0].Literal != "a\tb\n\\ \"q\" $ A😀" || toks[1].Literal != `raw\n${x}` {
+		t.Fatalf("Unexpected literals %q and %q", toks[0].Literal, toks[1].Literal)
+	}
+
+	// Bad escapes are reported after the string, at their own position.
+	expectedTokens := []parser.TokenType{parser.STRING, parser.ERROR, parser.ERROR, parser.EOF}
+	toks, err = parser.Scan(`"a \q \u{D800}"`)
+	if err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+	assertTokenTypesMatch(t, expectedTokens, toks)
+	if toks[1].Start.Column != 4 || toks[2].Start.Column != 7 {
+		t.Fatalf("Unexpected positions %v and %v", toks[1].Start, toks[2].Start)
+	}
+}
+
+func TestMultilineStrings(t *testing.T) {
+	toks, err := parser.Scan("\"\"\"\n    Hello,\n      ${name}\n\n    bye\n    \"\"\" \"\"\"say \"hi\" ok\"\"\"")
+	if err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+
+	if toks[0].Literal != "Hello,\n  " || toks[2].Literal != "\n\nbye" || toks[3].Literal != `say "hi" ok` {
+		t.Fatalf("Unexpected tokens %v", toks)
+	}
+
+	expectedTokens := []parser.TokenType{parser.STRING, parser.ERROR, parser.EOF}
+	toks, err = parser.Scan("\"\"\"\n    a\n  b\n    \"\"\"")
+	if err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+	assertTokenTypesMatch(t, expectedTokens, toks)
+	if toks[1].Start.Line != 3 || toks[0].Literal != "a\nb" {
+		t.Fatalf("Unexpected tokens %v", toks)
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	toks, err := parser.Scan("1 1.0 2.5 9223372036854775807 %")
+	if err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+
+	if toks[0].Literal != int64(1) || toks[1].Literal != 1.0 || toks[2].Literal != 2.5 || toks[3].Literal != int64(9223372036854775807) {
+		t.Fatalf("Unexpected literals %v", toks)
+	}
+	if toks[4].Token_type != parser.PERCENT {
+		t.Fatalf("Expected '%%' but got %v", toks[4])
+	}
+
+	toks, _ = parser.Scan("9223372036854775808")
+	if toks[0].Token_type != parser.ERROR {
+		t.Fatalf("Expected an out of range literal to be an error, got %v", toks[0])
+	}
+
+	toks, _ = parser.Scan("9223372036854775808n 1.10d 2d 3nd")
+	if n, ok := toks[0].Literal.(*big.Int); !ok || n.String() != "9223372036854775808" {
+		t.Fatalf("Expected a bigint, got %v", toks[0])
+	}
+	if d, ok := toks[1].Literal.(decimal.Decimal); !ok || d.String() != "1.10" || toks[1].Lexeme != "1.10d" {
+		t.Fatalf("Expected a decimal, got %v", toks[1])
+	}
+	if d, ok := toks[2].Literal.(decimal.Decimal); !ok || d.String() != "2" {
+		t.Fatalf("Expected a decimal, got %v", toks[2])
+	}
+	// A suffix that starts a longer name isn't one.
+	if toks[3].Literal != int64(3) || toks[4].Lexeme != "nd" {
+		t.Fatalf("Expected 3 and then a name, got %v", toks[3:])
+	}
+
+	toks, _ = parser.Scan("1.5n")
+	if toks[0].Token_type != parser.ERROR {
+		t.Fatalf("Expected a fractional bigint to be an error, got %v", toks[0])
+	}
+}
@@ -1,23 +1,29 @@
 package parser
 
+import "lox-compiler/source"
+
 type TokenType int
 
 //go:generate stringer -type=TokenType
 const (
-    ERROR TokenType = iota
+	ERROR TokenType = iota
 
 	// Single-character tokens
 	LEFT_PAREN
 	RIGHT_PAREN
 	LEFT_BRACE
 	RIGHT_BRACE
+	LEFT_BRACKET
+	RIGHT_BRACKET
 	COMMA
+	COLON
 	DOT
 	MINUS
 	PLUS
 	SEMICOLON
 	SLASH
 	STAR
+	PERCENT
 
 	// One or two character tokens
 	BANG
@@ -28,27 +34,41 @@ const (
 	GREATER_EQUAL
 	LESS
 	LESS_EQUAL
+	DOT_DOT
+	ARROW
+	ELLIPSIS
 
 	// Literals
 	IDENTIFIER
 	STRING
+	// The part of a string literal before an interpolated expression.
+	INTERPOLATION
 	NUMBER
 
 	// Keywords
 	AND
+	BREAK
+	CATCH
 	CLASS
+	CONTINUE
 	ELSE
+	EXPORT
 	FALSE
+	FINALLY
 	FOR
 	FUN
 	IF
+	IMPORT
+	IN
 	NIL
 	OR
 	PRINT
 	RETURN
 	SUPER
 	THIS
+	THROW
 	TRUE
+	TRY
 	VAR
 	WHILE
 
@@ -60,23 +80,54 @@ type Token struct {
 	Lexeme     string
 	Literal    any
 	Line       int
+	source.Span
+	// Only a lossless scanner fills these in. Leading holds the trivia
+	// between the end of the previous token's line and this token; Trailing
+	// holds the trivia after this token up to and including the end of its
+	// line.
+	Leading, Trailing []Trivia
+}
+
+type TriviaKind int
+
+const (
+	// A run of spaces, tabs and carriage returns.
+	WhitespaceTrivia TriviaKind = iota
+	NewlineTrivia
+	CommentTrivia
+)
+
+// Source text that the parser never sees.
+type Trivia struct {
+	source.Span
+	Kind TriviaKind
+	Text string
 }
 
 var KeywordMap = map[string]TokenType{
-	"and":    AND,
-	"class":  CLASS,
-	"else":   ELSE,
-	"false":  FALSE,
-	"fun":    FUN,
-	"for":    FOR,
-	"if":     IF,
-	"nil":    NIL,
-	"or":     OR,
-	"print":  PRINT,
-	"return": RETURN,
-	"super":  SUPER,
-	"this":   THIS,
-	"true":   TRUE,
-	"var":    VAR,
-	"while":  WHILE,
+	"and":      AND,
+	"break":    BREAK,
+	"catch":    CATCH,
+	"class":    CLASS,
+	"continue": CONTINUE,
+	"else":     ELSE,
+	"export":   EXPORT,
+	"false":    FALSE,
+	"finally":  FINALLY,
+	"fun":      FUN,
+	"for":      FOR,
+	"if":       IF,
+	"import":   IMPORT,
+	"in":       IN,
+	"nil":      NIL,
+	"or":       OR,
+	"print":    PRINT,
+	"return":   RETURN,
+	"super":    SUPER,
+	"this":     THIS,
+	"throw":    THROW,
+	"true":     TRUE,
+	"try":      TRY,
+	"var":      VAR,
+	"while":    WHILE,
 }
@@ -13,46 +13,63 @@ func _() {
 	_ = x[RIGHT_PAREN-2]
 	_ = x[LEFT_BRACE-3]
 	_ = x[RIGHT_BRACE-4]
-	_ = x[COMMA-5]
-	_ = x[DOT-6]
-	_ = x[MINUS-7]
-	_ = x[PLUS-8]
-	_ = x[SEMICOLON-9]
-	_ = x[SLASH-10]
-	_ = x[STAR-11]
-	_ = x[BANG-12]
-	_ = x[BANG_EQUAL-13]
-	_ = x[EQUAL-14]
-	_ = x[EQUAL_EQUAL-15]
-	_ = x[GREATER-16]
-	_ = x[GREATER_EQUAL-17]
-	_ = x[LESS-18]
-	_ = x[LESS_EQUAL-19]
-	_ = x[IDENTIFIER-20]
-	_ = x[STRING-21]
-	_ = x[NUMBER-22]
-	_ = x[AND-23]
-	_ = x[CLASS-24]
-	_ = x[ELSE-25]
-	_ = x[FALSE-26]
-	_ = x[FOR-27]
-	_ = x[FUN-28]
-	_ = x[IF-29]
-	_ = x[NIL-30]
-	_ = x[OR-31]
-	_ = x[PRINT-32]
-	_ = x[RETURN-33]
-	_ = x[SUPER-34]
-	_ = x[THIS-35]
-	_ = x[TRUE-36]
-	_ = x[VAR-37]
-	_ = x[WHILE-38]
-	_ = x[EOF-39]
+	_ = x[LEFT_BRACKET-5]
+	_ = x[RIGHT_BRACKET-6]
+	_ = x[COMMA-7]
+	_ = x[COLON-8]
+	_ = x[DOT-9]
+	_ = x[MINUS-10]
+	_ = x[PLUS-11]
+	_ = x[SEMICOLON-12]
+	_ = x[SLASH-13]
+	_ = x[STAR-14]
+	_ = x[PERCENT-15]
+	_ = x[BANG-16]
+	_ = x[BANG_EQUAL-17]
+	_ = x[EQUAL-18]
+	_ = x[EQUAL_EQUAL-19]
+	_ = x[GREATER-20]
+	_ = x[GREATER_EQUAL-21]
+	_ = x[LESS-22]
+	_ = x[LESS_EQUAL-23]
+	_ = x[DOT_DOT-24]
+	_ = x[ARROW-25]
+	_ = x[ELLIPSIS-26]
+	_ = x[IDENTIFIER-27]
+	_ = x[STRING-28]
+	_ = x[INTERPOLATION-29]
+	_ = x[NUMBER-30]
+	_ = x[AND-31]
+	_ = x[BREAK-32]
+	_ = x[CATCH-33]
+	_ = x[CLASS-34]
+	_ = x[CONTINUE-35]
+	_ = x[ELSE-36]
+	_ = x[EXPORT-37]
+	_ = x[FALSE-38]
+	_ = x[FINALLY-39]
+	_ = x[FOR-40]
+	_ = x[FUN-41]
+	_ = x[IF-42]
+	_ = x[IMPORT-43]
+	_ = x[IN-44]
+	_ = x[NIL-45]
+	_ = x[OR-46]
+	_ = x[PRINT-47]
+	_ = x[RETURN-48]
+	_ = x[SUPER-49]
+	_ = x[THIS-50]
+	_ = x[THROW-51]
+	_ = x[TRUE-52]
+	_ = x[TRY-53]
+	_ = x[VAR-54]
+	_ = x[WHILE-55]
+	_ = x[EOF-56]
 }
 
-const _TokenType_name = "ERRORLEFT_PARENRIGHT_PARENLEFT_BRACERIGHT_BRACECOMMADOTMINUSPLUSSEMICOLONSLASHSTARBANGBANG_EQUALEQUALEQUAL_EQUALGREATERGREATER_EQUALLESSLESS_EQUALIDENTIFIERSTRINGNUMBERANDCLASSELSEFALSEFORFUNIFNILORPRINTRETURNSUPERTHISTRUEVARWHILEEOF"
+const _TokenType_name = "ERRORLEFT_PARENRIGHT_PARENLEFT_BRACERIGHT_BRACELEFT_BRACKETRIGHT_BRACKETCOMMACOLONDOTMINUSPLUSSEMICOLONSLASHSTARPERCENTBANGBANG_EQUALEQUALEQUAL_EQUALGREATERGREATER_EQUALLESSLESS_EQUALDOT_DOTARROWELLIPSISIDENTIFIERSTRINGINTERPOLATIONNUMBERANDBREAKCATCHCLASSCONTINUEELSEEXPORTFALSEFINALLYFORFUNIFIMPORTINNILORPRINTRETURNSUPERTHISTHROWTRUETRYVARWHILEEOF"
 
-var _TokenType_index = [...]uint8{0, 5, 15, 26, 36, 47, 52, 55, 60, 64, 73, 78, 82, 86, 96, 101, 112, 119, 132, 136, 146, 156, 162, 168, 171, 176, 180, 185, 188, 191, 193, 196, 198, 203, 209, 214, 218, 222, 225, 230, 233}
+var _TokenType_index = [...]uint16{0, 5, 15, 26, 36, 47, 59, 72, 77, 82, 85, 90, 94, 103, 108, 112, 119, 123, 133, 138, 149, 156, 169, 173, 183, 190, 195, 203, 213, 219, 232, 238, 241, 246, 251, 256, 264, 268, 274, 279, 286, 289, 292, 294, 300, 302, 305, 307, 312, 318, 323, 327, 332, 336, 339, 342, 347, 350}
 
 func (i TokenType) String() string {
 	if i < 0 || i >= TokenType(len(_TokenType_index)-1) {
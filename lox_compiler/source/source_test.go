@@ -0,0 +1,79 @@
+package source_test
+
+import (
+	"lox-compiler/source"
+	"testing"
+)
+
+func span(line, col, offset, length int) source.Span {
+	return source.Span{
+		Start: source.Position{Line: line, Column: col, Offset: offset},
+		End:   source.Position{Line: line, Column: col + length, Offset: offset + length},
+	}
+}
+
+func TestJoin(t *testing.T) {
+	a, b := span(1, 1, 0, 3), span(2, 5, 10, 2)
+	joined := a.Join(b)
+	if joined.Start != a.Start || joined.End != b.End {
+		t.Fatalf("expected %v to %v, got %v", a.Start, b.End, joined)
+	}
+	if b.Join(a) != joined {
+		t.Fatal("expected Join to be symmetric")
+	}
+	if a.Join(source.Span{}) != a || (source.Span{}).Join(a) != a {
+		t.Fatal("expected zero spans to be ignored")
+	}
+}
+
+func TestRender(t *testing.T) {
+	src := "var a = 1;\n\tprint a - \"b\";\nprint \"unterminated\n"
+	tests := []struct {
+		span     source.Span
+		expected string
+	}{
+		{span(1, 5, 4, 1), "   1 | var a = 1;\n     |     ^\n"},
+		{span(2, 8, 18, 7), "   2 | \tprint a - \"b\";\n     | \t      ^^^^^^^\n"},
+		// Spans running past the end of their line are cut off there.
+		{span(3, 7, 33, 20), "   3 | print \"unterminated\n     |       ^^^^^^^^^^^^^\n"},
+		// Empty spans, such as the end of the file, get a single caret.
+		{span(4, 1, len(src), 0), "   4 | \n     | ^\n"},
+		{source.Span{}, ""},
+	}
+
+	for _, test := range tests {
+		if got := source.Render(src, test.span); got != test.expected {
+			t.Errorf("Render(%v):\nexpected:\n%s\ngot:\n%s", test.span, test.expected, got)
+		}
+	}
+}
+
+// Edge cases of the caret underline. The same cases are tested against the
+// other module's copy of this package.
+func TestRenderEdgeCases(t *testing.T) {
+	src := "\t\tx = 1;\nif (a) {\n  b;\n}\nc;\r\nd;"
+	tests := []struct {
+		name     string
+		span     source.Span
+		expected string
+	}{
+		{"tabs before the span", span(1, 3, 2, 1), "   1 | \t\tx = 1;\n     | \t\t^\n"},
+		{"tabs and spaces before the span", span(1, 7, 6, 1), "   1 | \t\tx = 1;\n     | \t\t    ^\n"},
+		{"past the end of the line", span(1, 7, 6, 50), "   1 | \t\tx = 1;\n     | \t\t    ^^\n"},
+		{"past the end of the source", span(6, 1, 29, 10), "   6 | d;\n     | ^^\n"},
+		{
+			"multi-line span",
+			source.Span{Start: source.Position{Line: 2, Column: 1, Offset: 9}, End: source.Position{Line: 4, Column: 2, Offset: 24}},
+			"   2 | if (a) {\n     | ^^^^^^^^\n",
+		},
+		{"carriage return", span(5, 1, 25, 4), "   5 | c;\n     | ^^\n"},
+		{"starts past the end of the source", span(7, 1, 100, 1), ""},
+		{"column doesn't match the offset", span(1, 20, 2, 1), ""},
+	}
+
+	for _, test := range tests {
+		if got := source.Render(src, test.span); got != test.expected {
+			t.Errorf("%s: Render(%v):\nexpected:\n%q\ngot:\n%q", test.name, test.span, test.expected, got)
+		}
+	}
+}
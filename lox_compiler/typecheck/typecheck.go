@@ -0,0 +1,891 @@
+// Package typecheck finds type errors, such as subtracting a string from a
+// number, before a program runs. Annotations are optional: names without
+// one take the type of their initializer if they are never assigned to, and
+// are otherwise left unchecked, so that fully dynamic code checks cleanly.
+package typecheck
+
+import (
+	"fmt"
+	"lox-compiler/decimal"
+	"lox-compiler/parser"
+	"lox-compiler/source"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+type Error struct {
+	Span    source.Span
+	Message string
+}
+
+func (e Error) String() string {
+	return fmt.Sprintf("%d:%d: %s", e.Span.Start.Line, e.Span.Start.Column, e.Message)
+}
+
+// Check src and return the type errors in it in source order. It is an
+// error for src to have syntax errors.
+func Source(src string) ([]Error, error) {
+	tokens, scanErr := parser.NewScanner(src).ScanTokens()
+	if scanErr != nil {
+		return nil, scanErr
+	}
+	p := parser.NewParser(tokens)
+	stmts, err := p.Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	c := checker{
+		globals:    make(map[string]*variable),
+		assigned:   make(map[string]bool),
+		signatures: make(map[int]*function),
+	}
+	c.findAssignments(stmts)
+	c.declareGlobals(stmts)
+	c.statements(stmts)
+	sort.SliceStable(c.errors, func(i, j int) bool {
+		return c.errors[i].Span.Start.Offset < c.errors[j].Span.Start.Offset
+	})
+
+	return c.errors, nil
+}
+
+type typ interface {
+	String() string
+}
+
+// A built-in type.
+type basic string
+
+func (b basic) String() string { return string(b) }
+
+const (
+	// The type of values the checker knows nothing about, which can be used
+	// as any other type.
+	anyType    basic = "any"
+	numberType basic = "number"
+	stringType basic = "string"
+	boolType   basic = "bool"
+	nilType    basic = "nil"
+	listType   basic = "list"
+	mapType    basic = "map"
+	rangeType  basic = "range"
+)
+
+// The types of the built-in methods of lists and maps.
+var builtinMethods = map[basic]map[string]*function{
+	listType: {
+		"push":   {name: "push", params: []typ{anyType}, result: nilType},
+		"pop":    {name: "pop", result: anyType},
+		"len":    {name: "len", result: numberType},
+		"slice":  {name: "slice", params: []typ{numberType, numberType}, result: listType},
+		"map":    {name: "map", params: []typ{anyType}, result: listType},
+		"filter": {name: "filter", params: []typ{anyType}, result: listType},
+	},
+	mapType: {
+		"keys":   {name: "keys", result: listType},
+		"values": {name: "values", result: listType},
+		"has":    {name: "has", params: []typ{anyType}, result: boolType},
+		"remove": {name: "remove", params: []typ{anyType}, result: anyType},
+		"len":    {name: "len", result: numberType},
+	},
+}
+
+type function struct {
+	name   string
+	params []typ
+	// The names of the parameters, which arguments can be passed by. nil
+	// for the built-in methods, which don't take named arguments.
+	names []string
+	// How many of the last parameters, not counting a rest parameter, have
+	// default values.
+	optional int
+	// Whether the last parameter collects the rest of the arguments. Its
+	// type is that of each of them.
+	variadic bool
+	result   typ
+}
+
+func (f *function) String() string {
+	params := make([]string, len(f.params))
+	for i, p := range f.params {
+		params[i] = p.String()
+		if f.variadic && i == len(f.params)-1 {
+			params[i] = "..." + params[i]
+		} else if i >= f.fixed()-f.optional {
+			params[i] = "[" + params[i] + "]"
+		}
+	}
+
+	return fmt.Sprintf("fun(%s): %s", strings.Join(params, ", "), f.result)
+}
+
+// The number of parameters before the rest parameter, or all of them if
+// there is none.
+func (f *function) fixed() int {
+	if f.variadic {
+		return len(f.params) - 1
+	}
+	return len(f.params)
+}
+
+// The fewest and most arguments a call can pass. max is -1 if there is a
+// rest parameter.
+func (f *function) arity() (min, max int) {
+	min, max = f.fixed()-f.optional, len(f.params)
+	if f.variadic {
+		max = -1
+	}
+	return min, max
+}
+
+// The type of a class itself, as opposed to its instances.
+type class struct {
+	name    string
+	parent  *class
+	fields  map[string]typ
+	methods map[string]*function
+}
+
+func (c *class) String() string { return "class " + c.name }
+
+// The method called name, looked up through the superclasses.
+func (c *class) method(name string) *function {
+	for ; c != nil; c = c.parent {
+		if m, ok := c.methods[name]; ok {
+			return m
+		}
+	}
+
+	return nil
+}
+
+// The declared type of the field called name, looked up through the
+// superclasses.
+func (c *class) field(name string) (typ, bool) {
+	for ; c != nil; c = c.parent {
+		if t, ok := c.fields[name]; ok {
+			return t, true
+		}
+	}
+
+	return nil, false
+}
+
+func (c *class) inherits(other *class) bool {
+	for ; c != nil; c = c.parent {
+		if c == other {
+			return true
+		}
+	}
+
+	return false
+}
+
+type instance struct {
+	class *class
+}
+
+func (i instance) String() string { return i.class.name }
+
+// Whether a value of type from can be used where to is expected. Since nil
+// is how Lox spells "no object", it can be used as any instance.
+func assignable(from, to typ) bool {
+	if from == anyType || to == anyType {
+		return true
+	}
+	switch to := to.(type) {
+	case instance:
+		if from == nilType {
+			return true
+		}
+		i, ok := from.(instance)
+		return ok && i.class.inherits(to.class)
+	case *function:
+		f, ok := from.(*function)
+		return ok && len(f.params) == len(to.params)
+	}
+
+	return from == to
+}
+
+type variable struct {
+	typ typ
+	// Whether typ comes from an annotation, so that assignments must match
+	// it.
+	annotated bool
+}
+
+type checker struct {
+	errors  []Error
+	scopes  []map[string]*variable
+	globals map[string]*variable
+	// The names assigned to anywhere in the program, whose types can't be
+	// inferred from their initializers.
+	assigned map[string]bool
+	// The signatures of the functions and methods worked out so far, by the
+	// offset at which they are declared, so each annotation is only checked
+	// once.
+	signatures map[int]*function
+	// The function and class whose bodies are being checked, if any.
+	currentFunction *function
+	currentClass    *class
+	// The types of the values returned by the return statements of the
+	// function being checked so far.
+	returns []typ
+	// Whether the program imports a module's exports without naming the
+	// module, so that a name the checker can't find may still be declared.
+	importsAll bool
+}
+
+func (c *checker) error(span source.Span, format string, args ...any) {
+	c.errors = append(c.errors, Error{Span: span, Message: fmt.Sprintf(format, args...)})
+}
+
+func (c *checker) findAssignments(nodes []parser.ASTNode) {
+	for _, node := range nodes {
+		if a, ok := node.(parser.Assign); ok {
+			c.assigned[a.Name.Lexeme] = true
+		}
+		if node != nil {
+			c.findAssignments(parser.Children(node))
+		}
+	}
+}
+
+// Declare the top-level names up front, since functions can refer to
+// globals declared after them, and annotations to classes declared after
+// them.
+func (c *checker) declareGlobals(stmts []parser.Statement) {
+	stmts = parser.Unexport(stmts)
+	var classes []parser.Class
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case parser.Class:
+			c.globals[s.Name.Lexeme] = &variable{typ: &class{name: s.Name.Lexeme}}
+			classes = append(classes, s)
+		case parser.Import:
+			// What a module exports isn't known until it runs.
+			c.importsAll = c.importsAll || s.Name == nil
+		}
+	}
+	for _, s := range classes {
+		if cls, ok := c.globals[s.Name.Lexeme].typ.(*class); ok {
+			c.defineClass(cls, s)
+		}
+	}
+
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case parser.Function:
+			c.globals[s.Name.Lexeme] = &variable{typ: c.signature(s)}
+		case parser.Import:
+			if s.Name != nil {
+				c.globals[s.Name.Lexeme] = &variable{typ: anyType}
+			}
+		case parser.Var:
+			if _, ok := c.globals[s.Name.Lexeme]; ok {
+				// Declaring a global again replaces it, so its type depends
+				// on where it is used.
+				c.globals[s.Name.Lexeme] = &variable{typ: anyType}
+				c.assigned[s.Name.Lexeme] = true
+				continue
+			}
+			v := &variable{typ: anyType}
+			if s.Type != nil {
+				v.typ, v.annotated = c.resolve(s.Type), true
+			}
+			c.globals[s.Name.Lexeme] = v
+		}
+	}
+}
+
+// Fill in a class's superclass, fields and methods.
+func (c *checker) defineClass(cls *class, s parser.Class) {
+	cls.fields = make(map[string]typ)
+	cls.methods = make(map[string]*function)
+	if s.ParentClass != nil {
+		if v := c.lookup(s.ParentClass.Name.Lexeme); v != nil {
+			if parent, ok := v.typ.(*class); ok && !parent.inherits(cls) {
+				cls.parent = parent
+			}
+		}
+	}
+	for _, f := range s.Fields {
+		cls.fields[f.Name.Lexeme] = c.resolve(f.Type)
+	}
+	for _, m := range s.Methods {
+		cls.methods[m.Name.Lexeme] = c.signature(m)
+	}
+}
+
+// The type an annotation names.
+func (c *checker) resolve(t *parser.TypeAnnotation) typ {
+	if t == nil {
+		return anyType
+	}
+	switch name := t.Name.Lexeme; name {
+	case "any", "number", "string", "bool", "nil", "list", "map", "range":
+		return basic(name)
+	default:
+		v := c.lookup(name)
+		if v == nil {
+			if !c.importsAll {
+				c.error(t.Span, "unknown type %s", name)
+			}
+			return anyType
+		}
+		cls, ok := v.typ.(*class)
+		if !ok {
+			c.error(t.Span, "%s is not a class", name)
+			return anyType
+		}
+		return instance{cls}
+	}
+}
+
+// The type of the function f, from its annotations.
+func (c *checker) signature(f parser.Function) *function {
+	if sig, ok := c.signatures[f.Start.Offset]; ok {
+		return sig
+	}
+	sig := &function{name: f.Name.Lexeme, result: anyType}
+	if sig.name == "" {
+		sig.name = "lambda"
+	}
+	for i, param := range f.Params {
+		var annotation *parser.TypeAnnotation
+		if i < len(f.ParamTypes) {
+			annotation = f.ParamTypes[i]
+		}
+		sig.params = append(sig.params, c.resolve(annotation))
+		sig.names = append(sig.names, param.Lexeme)
+		if i < len(f.Defaults) && f.Defaults[i] != nil {
+			sig.optional++
+		}
+	}
+	sig.variadic = f.Variadic
+	if f.ReturnType != nil {
+		sig.result = c.resolve(f.ReturnType)
+	}
+	c.signatures[f.Start.Offset] = sig
+
+	return sig
+}
+
+func (c *checker) beginScope() {
+	c.scopes = append(c.scopes, make(map[string]*variable))
+}
+
+func (c *checker) endScope() {
+	c.scopes = c.scopes[:len(c.scopes)-1]
+}
+
+// Declare a name in the innermost scope. Globals were declared up front.
+func (c *checker) declare(name string, v *variable) {
+	if len(c.scopes) > 0 {
+		c.scopes[len(c.scopes)-1][name] = v
+	}
+}
+
+func (c *checker) lookup(name string) *variable {
+	for i := len(c.scopes) - 1; i >= 0; i-- {
+		if v, ok := c.scopes[i][name]; ok {
+			return v
+		}
+	}
+
+	return c.globals[name]
+}
+
+func (c *checker) statements(stmts []parser.Statement) {
+	for _, stmt := range stmts {
+		c.statement(stmt)
+	}
+}
+
+func (c *checker) statement(stmt parser.Statement) {
+	switch s := stmt.(type) {
+	case parser.Block:
+		c.beginScope()
+		c.statements(s.Statements)
+		c.endScope()
+	case parser.Class:
+		var cls *class
+		if global, ok := c.globals[s.Name.Lexeme]; ok && len(c.scopes) == 0 {
+			cls, _ = global.typ.(*class)
+		}
+		if cls == nil {
+			cls = &class{name: s.Name.Lexeme}
+			// Declare the class before defining it, so that its methods
+			// can refer to it.
+			c.declare(s.Name.Lexeme, &variable{typ: cls})
+			c.defineClass(cls, s)
+		}
+		enclosing := c.currentClass
+		c.currentClass = cls
+		for _, m := range s.Methods {
+			c.function(m)
+		}
+		c.currentClass = enclosing
+	case parser.Export:
+		c.statement(s.Declaration)
+	case parser.ExpressionStmt:
+		c.expression(s.Val)
+	case parser.For:
+		c.beginScope()
+		if s.Initializer != nil {
+			c.statement(s.Initializer)
+		}
+		if s.Conditional != nil {
+			c.expression(s.Conditional)
+		}
+		if s.Increment != nil {
+			c.expression(s.Increment)
+		}
+		c.statement(s.Stmt)
+		c.endScope()
+	case parser.ForIn:
+		element := c.iterate(s.Iterable)
+		c.beginScope()
+		c.declare(s.Name.Lexeme, &variable{typ: element})
+		c.statement(s.Stmt)
+		c.endScope()
+	case parser.Function:
+		c.declare(s.Name.Lexeme, &variable{typ: c.signature(s)})
+		c.function(s)
+	case parser.If:
+		c.expression(s.Conditional)
+		c.statement(s.If_stmt)
+		if s.Else_stmt != nil {
+			c.statement(s.Else_stmt)
+		}
+	case parser.Print:
+		c.expression(s.Val)
+	case parser.Return:
+		result := typ(nilType)
+		if s.Return_expr != nil {
+			result = c.expression(s.Return_expr)
+		}
+		if f := c.currentFunction; f != nil && !assignable(result, f.result) {
+			c.error(s.Span, "cannot return %s from %s, which returns %s", result, f.name, f.result)
+		}
+		c.returns = append(c.returns, result)
+	case parser.Throw:
+		c.expression(s.Value)
+	case parser.Try:
+		c.statement(s.Body)
+		if s.CatchName != nil {
+			// Anything can be thrown.
+			c.beginScope()
+			c.declare(s.CatchName.Lexeme, &variable{typ: anyType})
+			c.statement(*s.Catch)
+			c.endScope()
+		}
+		if s.Finally != nil {
+			c.statement(*s.Finally)
+		}
+	case parser.Var:
+		c.varDeclaration(s)
+	case parser.While:
+		c.expression(s.Conditional)
+		c.statement(s.Stmt)
+	}
+}
+
+func (c *checker) varDeclaration(s parser.Var) {
+	// The parser stands in a nil without a position for a missing
+	// initializer. Leaving a variable uninitialized is allowed whatever its
+	// type, so that it can be assigned later.
+	initialized := s.Initializer != nil && !s.Initializer.SourceSpan().IsZero()
+	value := typ(nilType)
+	if initialized {
+		value = c.expression(s.Initializer)
+	}
+
+	var v *variable
+	if len(c.scopes) == 0 {
+		v = c.globals[s.Name.Lexeme]
+	} else {
+		v = &variable{typ: anyType}
+		if s.Type != nil {
+			v.typ, v.annotated = c.resolve(s.Type), true
+		}
+		c.declare(s.Name.Lexeme, v)
+	}
+
+	if v.annotated {
+		if initialized && !assignable(value, v.typ) {
+			c.error(s.Initializer.SourceSpan(), "cannot initialize %s, which is declared as %s, with %s", s.Name.Lexeme, v.typ, value)
+		}
+	} else if initialized && !c.assigned[s.Name.Lexeme] {
+		v.typ = value
+	}
+}
+
+// Check a function's body against its signature. A function without a
+// return type annotation returns whatever its return statements do, if they
+// all agree, which calls checked after its body can rely on.
+func (c *checker) function(f parser.Function) {
+	sig := c.signature(f)
+	enclosing, enclosingReturns := c.currentFunction, c.returns
+	c.currentFunction, c.returns = sig, nil
+	c.beginScope()
+	for i, param := range f.Params {
+		// A default value can use the parameters before its own.
+		if i < len(f.Defaults) && f.Defaults[i] != nil {
+			if t := c.expression(f.Defaults[i]); !assignable(t, sig.params[i]) {
+				c.error(f.Defaults[i].SourceSpan(), "the default value of %s must be %s, not %s", param.Lexeme, sig.params[i], t)
+			}
+		}
+		if f.IsRest(i) {
+			c.declare(param.Lexeme, &variable{typ: listType, annotated: true})
+			continue
+		}
+		c.declare(param.Lexeme, &variable{typ: sig.params[i], annotated: true})
+	}
+	c.statements(f.Body)
+	c.endScope()
+	// An initializer returns the instance, whatever its return statements
+	// say.
+	if f.ReturnType == nil && !(c.currentClass != nil && f.Name.Lexeme == "init") {
+		sig.result = inferResult(f.Body, c.returns)
+	}
+	c.currentFunction, c.returns = enclosing, enclosingReturns
+}
+
+// The result of a function with the body and the types of its return
+// statements: their common type, or any if they differ. A body that may
+// finish without returning also returns nil.
+func inferResult(body []parser.Statement, returns []typ) typ {
+	if len(body) == 0 {
+		return nilType
+	}
+	if _, ok := body[len(body)-1].(parser.Return); !ok {
+		returns = append(returns, nilType)
+	}
+	for _, t := range returns[1:] {
+		if t != returns[0] {
+			return anyType
+		}
+	}
+
+	return returns[0]
+}
+
+// Check e and return its type.
+func (c *checker) expression(e parser.Expr) typ {
+	switch e := e.(type) {
+	case parser.Assign:
+		value := c.expression(e.Value)
+		if v := c.lookup(e.Name.Lexeme); v != nil && v.annotated && !assignable(value, v.typ) {
+			c.error(e.Span, "cannot assign %s to %s, which is declared as %s", value, e.Name.Lexeme, v.typ)
+		}
+		return value
+	case parser.Binary:
+		return c.binary(e)
+	case parser.Call:
+		return c.call(e)
+	case parser.Get:
+		return c.property(c.expression(e.Object), e.Name, e.Span)
+	case parser.Grouping:
+		return c.expression(e.Expr)
+	case parser.Literal:
+		switch e.Value.(type) {
+		case int64, float64, *big.Int, decimal.Decimal:
+			return numberType
+		case string:
+			return stringType
+		case bool:
+			return boolType
+		case nil:
+			return nilType
+		}
+	case parser.Logical:
+		left, right := c.expression(e.Left), c.expression(e.Right)
+		// Either operand can be the result.
+		if left == right {
+			return left
+		}
+	case parser.List:
+		for _, element := range e.Elements {
+			c.expression(element)
+		}
+		return listType
+	case parser.Map:
+		for i := range e.Keys {
+			c.mapKey(e.Keys[i], c.expression(e.Keys[i]))
+			c.expression(e.Values[i])
+		}
+		return mapType
+	case parser.Lambda:
+		c.function(e.Decl)
+		return c.signature(e.Decl)
+	case parser.Range:
+		c.expectNumber(e.Operator, c.expression(e.Start), e.Start)
+		c.expectNumber(e.Operator, c.expression(e.End), e.End)
+		return rangeType
+	case parser.Interpolation:
+		// Any value can be interpolated, the same as it can be printed.
+		for _, expr := range e.Exprs {
+			c.expression(expr)
+		}
+		return stringType
+	case parser.Subscript:
+		c.subscript(e.Object, e.Index)
+	case parser.SubscriptSet:
+		c.subscript(e.Object, e.Index)
+		return c.expression(e.Value)
+	case parser.Set:
+		object := c.expression(e.Object)
+		value := c.expression(e.Value)
+		switch o := object.(type) {
+		case instance:
+			if t, ok := o.class.field(e.Name.Lexeme); ok && !assignable(value, t) {
+				c.error(e.Span, "cannot assign %s to field %s of %s, which is declared as %s", value, e.Name.Lexeme, o, t)
+			}
+		case basic:
+			if o != anyType {
+				c.error(e.Span, "only instances have fields, not %s", o)
+			}
+		default:
+			c.error(e.Span, "only instances have fields, not %s", o)
+		}
+		return value
+	case parser.Super:
+		if c.currentClass != nil && c.currentClass.parent != nil {
+			if m := c.currentClass.parent.method(e.Method.Lexeme); m != nil {
+				return m
+			}
+		}
+	case parser.This:
+		if c.currentClass != nil {
+			return instance{c.currentClass}
+		}
+	case parser.Unary:
+		right := c.expression(e.Right)
+		if e.Operator.Token_type == parser.BANG {
+			return boolType
+		}
+		c.expectNumber(e.Operator, right, e.Right)
+		return numberType
+	case parser.Variable:
+		if v := c.lookup(e.Name.Lexeme); v != nil {
+			return v.typ
+		}
+	}
+
+	return anyType
+}
+
+func (c *checker) binary(e parser.Binary) typ {
+	left, right := c.expression(e.Left), c.expression(e.Right)
+	switch e.Operator.Token_type {
+	case parser.EQUAL_EQUAL, parser.BANG_EQUAL:
+		return boolType
+	case parser.PLUS:
+		if left == numberType && right == numberType {
+			return numberType
+		}
+		if left == stringType && right == stringType {
+			return stringType
+		}
+		addable := func(t typ) bool { return t == anyType || t == numberType || t == stringType }
+		if !addable(left) || !addable(right) || (left != anyType && right != anyType) {
+			c.error(e.Span, "operands of + must be two numbers or two strings, not %s and %s", left, right)
+		}
+		return anyType
+	case parser.GREATER, parser.GREATER_EQUAL, parser.LESS, parser.LESS_EQUAL:
+		c.expectNumber(e.Operator, left, e.Left)
+		c.expectNumber(e.Operator, right, e.Right)
+		return boolType
+	}
+
+	c.expectNumber(e.Operator, left, e.Left)
+	c.expectNumber(e.Operator, right, e.Right)
+	return numberType
+}
+
+func (c *checker) expectNumber(operator parser.Token, t typ, operand parser.Expr) {
+	if !assignable(t, numberType) {
+		c.error(operand.SourceSpan(), "operand of %s must be a number, not %s", operator.Lexeme, t)
+	}
+}
+
+func (c *checker) call(e parser.Call) typ {
+	callee := c.expression(e.Callee)
+	args := make([]typ, len(e.Args))
+	for i, arg := range e.Args {
+		args[i] = c.expression(arg)
+	}
+	named := make([]typ, len(e.Named))
+	for i, arg := range e.Named {
+		named[i] = c.expression(arg.Value)
+	}
+
+	switch f := callee.(type) {
+	case *function:
+		c.arguments(e, f, args, named)
+		return f.result
+	case *class:
+		init := f.method("init")
+		if init == nil {
+			init = &function{names: []string{}}
+		}
+		c.arguments(e, &function{name: f.name, params: init.params, names: init.names, optional: init.optional, variadic: init.variadic}, args, named)
+		return instance{f}
+	case basic:
+		if f == anyType {
+			return anyType
+		}
+	}
+
+	c.error(e.Span, "can only call functions and classes, not %s", callee)
+	return anyType
+}
+
+// Check the arguments of a call to f, whose positional arguments are of the
+// types args and named ones of the types named.
+func (c *checker) arguments(e parser.Call, f *function, args []typ, named []typ) {
+	min, max := f.arity()
+	if n := len(args) + len(named); (len(named) == 0 && n < min) || (max != -1 && n > max) {
+		noun := "arguments"
+		if min == 1 && (max == 1 || max == -1) {
+			noun = "argument"
+		}
+		expects := fmt.Sprint(min)
+		if max == -1 {
+			expects = "at least " + expects
+		} else if min != max {
+			expects = fmt.Sprintf("%d to %d", min, max)
+		}
+		c.error(e.Span, "%s expects %s %s but got %d", f.name, expects, noun, n)
+		return
+	}
+	if len(named) > 0 && f.names == nil {
+		c.error(e.Named[0].Name.Span, "%s doesn't take named arguments", f.name)
+		return
+	}
+
+	passed := make([]bool, len(f.params))
+	for i, arg := range args {
+		param := i
+		if param >= f.fixed() {
+			param = len(f.params) - 1
+		}
+		passed[param] = true
+		if !assignable(arg, f.params[param]) {
+			c.error(e.Args[i].SourceSpan(), "argument %d of %s must be %s, not %s", i+1, f.name, f.params[param], arg)
+		}
+	}
+	for i, arg := range e.Named {
+		param := -1
+		for j, name := range f.names {
+			if name == arg.Name.Lexeme {
+				param = j
+			}
+		}
+		switch {
+		case param == -1:
+			c.error(arg.Name.Span, "%s has no parameter %s", f.name, arg.Name.Lexeme)
+			continue
+		case param >= f.fixed():
+			c.error(arg.Name.Span, "the rest parameter %s of %s can't be passed by name", arg.Name.Lexeme, f.name)
+			continue
+		case passed[param]:
+			c.error(arg.Name.Span, "%s got more than one argument for %s", f.name, arg.Name.Lexeme)
+			continue
+		}
+		passed[param] = true
+		if !assignable(named[i], f.params[param]) {
+			c.error(arg.Value.SourceSpan(), "argument %s of %s must be %s, not %s", arg.Name.Lexeme, f.name, f.params[param], named[i])
+		}
+	}
+	for i := 0; i < min; i++ {
+		if !passed[i] {
+			c.error(e.Span, "%s is missing an argument for %s", f.name, f.names[i])
+		}
+	}
+}
+
+// The type of object.name.
+func (c *checker) property(object typ, name parser.Token, span source.Span) typ {
+	switch o := object.(type) {
+	case instance:
+		if t, ok := o.class.field(name.Lexeme); ok {
+			return t
+		}
+		if m := o.class.method(name.Lexeme); m != nil {
+			return m
+		}
+		// Fields can be added to any instance without being declared.
+		return anyType
+	case basic:
+		if o == anyType {
+			return anyType
+		}
+		if methods, ok := builtinMethods[o]; ok {
+			if m, ok := methods[name.Lexeme]; ok {
+				return m
+			}
+			c.error(span, "%ss have no method %s", o, name.Lexeme)
+			return anyType
+		}
+	}
+
+	c.error(span, "only instances have properties, not %s", object)
+	return anyType
+}
+
+// Check the object and index of a subscript. The elements of lists and maps
+// can be of any type.
+func (c *checker) subscript(object, index parser.Expr) {
+	o, i := c.expression(object), c.expression(index)
+	switch o {
+	case anyType:
+	case listType:
+		if !assignable(i, numberType) {
+			c.error(index.SourceSpan(), "a list index must be a number, not %s", i)
+		}
+	case mapType:
+		c.mapKey(index, i)
+	default:
+		c.error(object.SourceSpan(), "only lists and maps can be indexed, not %s", o)
+	}
+}
+
+// Check that a for-in loop can iterate over iterable, and return the type of
+// the loop variable.
+func (c *checker) iterate(iterable parser.Expr) typ {
+	it := c.expression(iterable)
+	switch t := it.(type) {
+	case basic:
+		switch t {
+		case anyType, listType, mapType:
+			return anyType
+		case stringType:
+			return stringType
+		case rangeType:
+			return numberType
+		}
+	case instance:
+		if t.class.method("iterator") != nil {
+			return anyType
+		}
+		c.error(iterable.SourceSpan(), "%s has no iterator method, so it can't be iterated", t)
+		return anyType
+	}
+
+	c.error(iterable.SourceSpan(), "only lists, maps, strings, ranges and iterators can be iterated, not %s", it)
+	return anyType
+}
+
+// Check that key, of type t, can be a map key. Any value can be, apart from
+// functions and classes.
+func (c *checker) mapKey(key parser.Expr, t typ) {
+	switch t.(type) {
+	case *function, *class:
+		c.error(key.SourceSpan(), "a map key can't be %s", t)
+	}
+}
@@ -0,0 +1,327 @@
+package typecheck_test
+
+import (
+	"io/fs"
+	"lox-compiler/typecheck"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Check src and compare the errors, one per line.
+func expect(t *testing.T, src string, expected ...string) {
+	t.Helper()
+	errs, err := typecheck.Source(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range errs {
+		got = append(got, e.String())
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("expected:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestOperators(t *testing.T) {
+	expect(t, `print "a" - 1;
+print -"a";
+print 1 + "a";
+print true + x;
+print 1 + x;
+print "a" < 2;
+print !"a";
+print "a" == 1;
+var n: number = 7 % 2.5;
+print n % "a";
+var money: number = 1.10d * 3n;
+`,
+		"1:7: operand of - must be a number, not string",
+		"2:8: operand of - must be a number, not string",
+		"3:7: operands of + must be two numbers or two strings, not number and string",
+		"4:7: operands of + must be two numbers or two strings, not bool and any",
+		"6:7: operand of < must be a number, not string",
+		"10:11: operand of % must be a number, not string",
+	)
+}
+
+func TestInference(t *testing.T) {
+	expect(t, `var inferred = "a";
+print inferred - 1;
+var reassigned = "a";
+reassigned = 1;
+print reassigned - 1;
+var sum = 1 + 2;
+print sum - 1;
+var either = nil or "a";
+print either - 1;
+`,
+		"2:7: operand of - must be a number, not string",
+	)
+}
+
+func TestAnnotations(t *testing.T) {
+	expect(t, `var n: number = "a";
+var later: string;
+later = 1;
+var ok: string = "a";
+ok = "b";
+var unknown: Shape;
+fun f() {}
+var notClass: f;
+`,
+		"1:17: cannot initialize n, which is declared as number, with string",
+		"3:1: cannot assign number to later, which is declared as string",
+		"6:14: unknown type Shape",
+		"8:15: f is not a class",
+	)
+}
+
+func TestFunctions(t *testing.T) {
+	expect(t, `fun greet(name: string): string {
+  return "hi " + name;
+}
+fun count(): number {
+  return "one";
+}
+fun nothing(): nil { return; }
+greet(1);
+greet("a", "b");
+print greet("a") - 1;
+var n = 1;
+n();
+fun untyped(a) { return a - 1; }
+print untyped("a");
+`,
+		"5:3: cannot return string from count, which returns number",
+		"8:7: argument 1 of greet must be string, not number",
+		"9:1: greet expects 1 argument but got 2",
+		"10:7: operand of - must be a number, not string",
+		"12:1: can only call functions and classes, not number",
+	)
+}
+
+func TestInferredResults(t *testing.T) {
+	expect(t, `fun g() { return 1; }
+var r: string = g();
+fun either(x) {
+  if (x) return 1;
+  return "one";
+}
+var e: string = either(true);
+fun maybe(x) {
+  if (x) return "a";
+}
+var m: string = maybe(true);
+fun nothing() {}
+var n: number = nothing();
+var double = (x) => x * 2;
+var d: string = double(1);
+fun fact(n) {
+  if (n < 2) return 1;
+  return n * fact(n - 1);
+}
+var f: string = fact(5);
+class Box {
+  init() { return; }
+  label() { return "box"; }
+}
+var b: Box = Box();
+var l: number = b.label();
+`,
+		"2:17: cannot initialize r, which is declared as string, with number",
+		"13:17: cannot initialize n, which is declared as number, with nil",
+		"15:17: cannot initialize d, which is declared as string, with number",
+		"20:17: cannot initialize f, which is declared as string, with number",
+		"26:17: cannot initialize l, which is declared as number, with string",
+	)
+}
+
+func TestClasses(t *testing.T) {
+	expect(t, `class Shape {
+  name: string;
+  area(): number { return 0; }
+}
+class Circle < Shape {
+  radius: number;
+  init(radius: number) {
+    this.radius = radius;
+    this.name = 1;
+  }
+  describe(): string { return this.name + " " + this.area(); }
+}
+var c: Circle = Circle("big");
+var s: Shape = c;
+var wrong: Circle = Shape();
+var none: Shape = nil;
+print c.radius - 1;
+print c.name - 1;
+print c.anything - 1;
+print (1).x;
+c.radius = "a";
+`,
+		"9:5: cannot assign number to field name of Circle, which is declared as string",
+		"11:43: operands of + must be two numbers or two strings, not string and number",
+		"13:24: argument 1 of Circle must be number, not string",
+		"15:21: cannot initialize wrong, which is declared as Circle, with Shape",
+		"18:7: operand of - must be a number, not string",
+		"20:7: only instances have properties, not number",
+		"21:1: cannot assign string to field radius of Circle, which is declared as number",
+	)
+}
+
+func TestImports(t *testing.T) {
+	expect(t, `import m from "m.lox";
+export var n: number = "a";
+var x: Unknown;
+print m - 1;
+`,
+		"2:24: cannot initialize n, which is declared as number, with string",
+		"3:8: unknown type Unknown",
+	)
+	// A module imported without a name may declare any class.
+	expect(t, `import "shapes.lox";
+var s: Shape;
+`)
+}
+
+// The examples only fail the checker where they expect a runtime error.
+func TestExamples(t *testing.T) {
+	roots := []string{"../conformance/testdata", "../difftest/testdata", "../progs", "../../interpreted_lox"}
+	for _, root := range roots {
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || !strings.HasSuffix(path, ".lox") {
+				return err
+			}
+			src, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			errs, err := typecheck.Source(string(src))
+			if err != nil || len(errs) == 0 {
+				return nil
+			}
+			if !strings.Contains(string(src), "expect runtime error") && filepath.Base(path) != "runtime_error.lox" {
+				t.Errorf("%s: unexpected error %s", path, errs[0])
+			}
+			return nil
+		})
+	}
+}
+
+func TestLists(t *testing.T) {
+	expect(t, `var xs: list = [1, "a"];
+print xs[0] - 1;
+print xs["a"];
+var n = 1;
+print n[0];
+xs[0] = "b";
+var length: number = xs.len();
+var popped: string = xs.pop();
+var wrong: string = xs.len();
+xs.push();
+xs.size();
+var s: list = "a";
+`,
+		"3:10: a list index must be a number, not string",
+		"5:7: only lists and maps can be indexed, not number",
+		"9:21: cannot initialize wrong, which is declared as string, with number",
+		"10:1: push expects 1 argument but got 0",
+		"11:1: lists have no method size",
+		"12:15: cannot initialize s, which is declared as list, with string",
+	)
+}
+
+func TestMaps(t *testing.T) {
+	expect(t, `var m: map = {"a": 1, 2: "b", nil: true};
+print m["a"] - 1;
+print m[1];
+var keys: list = m.keys();
+var has: bool = m.has("a");
+var wrong: number = m.values();
+m.clear();
+fun f() {}
+print {f: 1};
+print m[f];
+`,
+		"6:21: cannot initialize wrong, which is declared as number, with list",
+		"7:1: maps have no method clear",
+		"9:8: a map key can't be fun(): nil",
+		"10:9: a map key can't be fun(): nil",
+	)
+}
+
+func TestForIn(t *testing.T) {
+	expect(t, `for (var i in 0..3) print i - 1;
+for (var c in "abc") print c - 1;
+var r: range = 0.."3";
+for (var x in 3) print x;
+class Bag { iterator() { return nil; } }
+class Box {}
+for (var x in Bag()) print x;
+for (var x in Box()) print x;
+for (var k in {"a": 1}) print k;
+`,
+		"2:28: operand of - must be a number, not string",
+		"3:19: operand of .. must be a number, not string",
+		"4:15: only lists, maps, strings, ranges and iterators can be iterated, not number",
+		"8:15: Box has no iterator method, so it can't be iterated",
+	)
+}
+
+func TestLambda(t *testing.T) {
+	expect(t, `var add = fun (a: number, b: number): number { return a + b; };
+var n: number = add;
+var twice = (x: number) => x * 2;
+print twice - 1;
+var bad = fun (): number { return "a"; };
+var s = (x: string) => x - 1;
+`,
+		"2:17: cannot initialize n, which is declared as number, with fun(number, number): number",
+		"4:7: operand of - must be a number, not fun(number): number",
+		"5:28: cannot return string from lambda, which returns number",
+		"6:24: operand of - must be a number, not string",
+	)
+}
+
+func TestParameters(t *testing.T) {
+	expect(t, `fun f(a: number, b: string = "x", ...rest: number): number { return rest.len(); }
+f(1, "a", 2, 3);
+f(1, 2);
+f(1, "a", "b");
+f(b: "y", a: 1);
+f(b: 1);
+f(1, a: 2);
+f(1, c: 2);
+f(1, rest: 2);
+f();
+fun g(x: number = "s") {}
+[].push(x: 1);
+var n: number = f;
+`,
+		"3:6: argument 2 of f must be string, not number",
+		"4:11: argument 3 of f must be number, not string",
+		"6:1: f is missing an argument for a",
+		"6:6: argument b of f must be string, not number",
+		"7:6: f got more than one argument for a",
+		"8:6: f has no parameter c",
+		"9:6: the rest parameter rest of f can't be passed by name",
+		"10:1: f expects at least 1 argument but got 0",
+		"11:19: the default value of x must be number, not string",
+		"12:9: push doesn't take named arguments",
+		"13:17: cannot initialize n, which is declared as number, with fun(number, [string], ...number): number",
+	)
+}
+
+func TestInterpolation(t *testing.T) {
+	expect(t, `var age: number = 1;
+var s: string = "age ${age + 1} ${[age]}";
+var n: number = "${age}";
+print "${age - "a"}";
+`,
+		"3:17: cannot initialize n, which is declared as number, with string",
+		"4:16: operand of - must be a number, not string",
+	)
+}
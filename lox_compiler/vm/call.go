@@ -0,0 +1,258 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"lox-compiler/bytecode"
+)
+
+// The most calls that can be running at once before a call fails with a
+// stack overflow.
+const maxFrames = 1024
+
+// What a call saves of the function that made it, to carry on with when the
+// call returns.
+type frame struct {
+	insts     bytecode.InstructionSlice
+	constants bytecode.ValueSlice
+	pc        int
+	base      int
+	callee    *bytecode.LoxClosure
+}
+
+// Call the value below the top argc values of the stack, which are its
+// arguments.
+func (vm *VirtualMachine) call(i bytecode.Instruction) *InterpreterError {
+	argc := int(i.Operands[0])
+	if argc >= len(vm.chunk.Values) {
+		return &InterpreterError{interpreterErr: popEmptyStack, line: i.SourceLineNumer, span: i.Span}
+	}
+	switch callee := vm.chunk.Values[len(vm.chunk.Values)-argc-1].(type) {
+	case *bytecode.LoxClosure:
+		return vm.callClosure(i, callee, argc)
+	case *bytecode.LoxBuiltinMethod:
+		args, err := vm.popValues(i, argc)
+		if err != nil {
+			return err
+		}
+		vm.chunk.Values.Pop()
+		result, callErr := vm.invokeMethod(callee.Receiver, callee.Name, args)
+		if callErr != nil {
+			return methodError(i, callErr)
+		}
+		vm.chunk.Values.Push(result)
+		return nil
+	case *bytecode.LoxNative:
+		if argc != callee.Arity {
+			return &InterpreterError{interpreterErr: fmt.Sprintf("Expected %d arguments but got %d", callee.Arity, argc), line: i.SourceLineNumer, span: i.Span}
+		}
+		args, err := vm.popValues(i, argc)
+		if err != nil {
+			return err
+		}
+		vm.chunk.Values.Pop()
+		result, callErr := callee.Fn(args)
+		if callErr != nil {
+			return &InterpreterError{interpreterErr: callErr.Error(), line: i.SourceLineNumer, span: i.Span}
+		}
+		vm.chunk.Values.Push(result)
+		return nil
+	}
+
+	return &InterpreterError{interpreterErr: "Can only call functions and classes.", line: i.SourceLineNumer, span: i.Span}
+}
+
+// Call the value below the positional and named arguments on the stack,
+// putting each named argument in the slot of the parameter it names. The
+// names are on top of the stack, above the values of the named arguments.
+func (vm *VirtualMachine) callNamed(i bytecode.Instruction) *InterpreterError {
+	positional, named := int(i.Operands[0]), int(i.Operands[1])
+	names, err := vm.popValues(i, named)
+	if err != nil {
+		return err
+	}
+	values, err := vm.popValues(i, named)
+	if err != nil {
+		return err
+	}
+	args, err := vm.popValues(i, positional)
+	if err != nil {
+		return err
+	}
+	callee, err := vm.peek(i)
+	if err != nil {
+		return err
+	}
+
+	var bindErr error
+	switch callee := callee.(type) {
+	case *bytecode.LoxClosure:
+		args, bindErr = bindNamed(callee.Func, args, names, values)
+	case *bytecode.LoxBuiltinMethod, *bytecode.LoxNative:
+		bindErr = errors.New("Native functions don't take named arguments.")
+	default:
+		bindErr = errors.New("Can only call functions and classes.")
+	}
+	if bindErr != nil {
+		return &InterpreterError{interpreterErr: bindErr.Error(), line: i.SourceLineNumer, span: i.Span}
+	}
+	for _, arg := range args {
+		vm.chunk.Values.Push(arg)
+	}
+
+	return vm.callClosure(i, callee.(*bytecode.LoxClosure), len(args))
+}
+
+// Add values to args in the slots of the parameters of fn named by names,
+// the way golox does. Slots given no argument are left unset.
+func bindNamed(fn bytecode.LoxFunc, args, names, values []bytecode.Value) ([]bytecode.Value, error) {
+	for j, name := range names {
+		slot := -1
+		for k, param := range fn.Args {
+			if param == name {
+				slot = k
+			}
+		}
+		if slot == -1 {
+			return nil, fmt.Errorf("No parameter named '%s'.", name)
+		}
+		if fn.Variadic && slot == len(fn.Args)-1 {
+			return nil, fmt.Errorf("Can't pass the rest parameter '%s' by name.", name)
+		}
+		if slot < len(args) && args[slot] != nil {
+			return nil, fmt.Errorf("Parameter '%s' was passed more than one argument.", name)
+		}
+		for len(args) <= slot {
+			args = append(args, nil)
+		}
+		args[slot] = values[j]
+	}
+	for j := 0; j < fn.MinArity; j++ {
+		if j >= len(args) || args[j] == nil {
+			return nil, fmt.Errorf("Missing argument for parameter '%s'.", fn.Args[j])
+		}
+	}
+
+	return args, nil
+}
+
+// Start running the body of callee. The callee stays on the stack below its
+// arguments, which become the first locals of the body: a parameter that
+// wasn't passed an argument is left unset for OpArgMissing to find, and a
+// rest parameter gets a list of the arguments left over.
+func (vm *VirtualMachine) callClosure(i bytecode.Instruction, callee *bytecode.LoxClosure, argc int) *InterpreterError {
+	fn := callee.Func
+	if argc < fn.MinArity || !fn.Variadic && argc > len(fn.Args) {
+		return &InterpreterError{interpreterErr: fmt.Sprintf("Expected %s arguments but got %d", arity(fn), argc), line: i.SourceLineNumer, span: i.Span}
+	}
+	if len(vm.frames) == maxFrames {
+		return &InterpreterError{interpreterErr: "Stack overflow.", line: i.SourceLineNumer, span: i.Span}
+	}
+
+	params := len(fn.Args)
+	if fn.Variadic {
+		params--
+	}
+	for ; argc < params; argc++ {
+		vm.chunk.Values.Push(nil)
+	}
+	if fn.Variadic {
+		rest, err := vm.popValues(i, argc-params)
+		if err != nil {
+			return err
+		}
+		vm.chunk.Values.Push(&bytecode.LoxList{Elements: rest})
+	}
+
+	vm.frames = append(vm.frames, frame{
+		insts:     vm.chunk.InstructionSlice,
+		constants: vm.chunk.Constants,
+		pc:        vm.pc,
+		base:      vm.base,
+		callee:    vm.callee,
+	})
+	vm.chunk.InstructionSlice, vm.chunk.Constants = fn.Body.InstructionSlice, fn.Body.Constants
+	vm.pc, vm.base, vm.callee = 0, len(vm.chunk.Values)-len(fn.Args), callee
+
+	return nil
+}
+
+// Return val from the function being run to the one that called it, taking
+// the callee and its locals off the stack, along with the handlers of any try
+// statements in it that weren't ended.
+func (vm *VirtualMachine) ret(val bytecode.Value) {
+	vm.closeUpvalues(vm.base)
+	vm.chunk.Values = vm.chunk.Values[:vm.base-1]
+	vm.unwindFrames(len(vm.frames) - 1)
+	for len(vm.handlers) > 0 && vm.handlers[len(vm.handlers)-1].frames > len(vm.frames) {
+		vm.handlers = vm.handlers[:len(vm.handlers)-1]
+	}
+	vm.chunk.Values.Push(val)
+}
+
+// Call f with args from a built-in method, running the VM until f returns.
+// An error f raises that no try statement inside it catches is returned as
+// it was raised, to be handled as though the method had raised it.
+func (vm *VirtualMachine) callBack(f bytecode.Value, args []bytecode.Value) (bytecode.Value, error) {
+	depth := len(vm.frames)
+	vm.chunk.Values.Push(f)
+	for _, arg := range args {
+		vm.chunk.Values.Push(arg)
+	}
+	if err := vm.call(bytecode.Instruction{Operands: bytecode.OperandArray{bytecode.Operand(len(args))}}); err != nil {
+		return nil, errors.New(err.interpreterErr)
+	}
+	if len(vm.frames) == depth {
+		// f was a built-in method, which has already returned.
+		return vm.chunk.Values.Pop(), nil
+	}
+
+	vm.callbacks = append(vm.callbacks, depth)
+	defer func() { vm.callbacks = vm.callbacks[:len(vm.callbacks)-1] }()
+	for {
+		err := vm.execute()
+		if err == nil {
+			return vm.chunk.Values.Pop(), nil
+		}
+		if len(vm.handlers) == 0 || vm.handlers[len(vm.handlers)-1].frames <= depth {
+			return nil, err
+		}
+		if err = vm.handle(err); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Go back to running the function that was running when depth calls were.
+func (vm *VirtualMachine) unwindFrames(depth int) {
+	if depth >= len(vm.frames) {
+		return
+	}
+	f := vm.frames[depth]
+	vm.chunk.InstructionSlice, vm.chunk.Constants = f.insts, f.constants
+	vm.pc, vm.base, vm.callee = f.pc, f.base, f.callee
+	vm.frames = vm.frames[:depth]
+}
+
+// Push whether the parameter in slot wasn't passed an argument.
+func (vm *VirtualMachine) argMissing(i bytecode.Instruction) *InterpreterError {
+	slot := vm.base + int(i.Operands[0])
+	if slot >= len(vm.chunk.Values) {
+		return &InterpreterError{interpreterErr: invalidLocal, line: i.SourceLineNumer, span: i.Span}
+	}
+	vm.chunk.Values.Push(bytecode.LoxBool(vm.chunk.Values[slot] == nil))
+
+	return nil
+}
+
+// The numbers of arguments fn takes, worded the way golox words them.
+func arity(fn bytecode.LoxFunc) string {
+	switch {
+	case fn.Variadic:
+		return fmt.Sprint("at least ", fn.MinArity)
+	case fn.MinArity != len(fn.Args):
+		return fmt.Sprint(fn.MinArity, " to ", len(fn.Args))
+	}
+
+	return fmt.Sprint(fn.MinArity)
+}
@@ -0,0 +1,118 @@
+package vm
+
+import (
+	"lox-compiler/bytecode"
+)
+
+// Push a closure of the function constant, capturing the variables it uses.
+func (vm *VirtualMachine) closure(i bytecode.Instruction) *InterpreterError {
+	val, err := vm.read_const(i, 0)
+	if err != nil {
+		return err
+	}
+	fn, ok := val.(bytecode.LoxFunc)
+	if !ok {
+		return &InterpreterError{interpreterErr: wrongType, line: i.SourceLineNumer, span: i.Span}
+	}
+
+	closure := &bytecode.LoxClosure{Func: fn, Upvalues: make([]*bytecode.Upvalue, len(fn.Captures))}
+	for j, capture := range fn.Captures {
+		if capture.Local {
+			closure.Upvalues[j] = vm.capture(vm.base + capture.Index)
+			continue
+		}
+		// Passed on from what the function making the closure captured.
+		if vm.callee == nil || capture.Index >= len(vm.callee.Upvalues) {
+			return &InterpreterError{interpreterErr: internalError, line: i.SourceLineNumer, span: i.Span}
+		}
+		closure.Upvalues[j] = vm.callee.Upvalues[capture.Index]
+	}
+	vm.chunk.Values.Push(closure)
+
+	return nil
+}
+
+// The upvalue for the variable in slot, which is shared by every closure that
+// captures it while it's on the stack.
+func (vm *VirtualMachine) capture(slot int) *bytecode.Upvalue {
+	for _, u := range vm.openUpvalues {
+		if u.Slot == slot {
+			return u
+		}
+	}
+	u := &bytecode.Upvalue{Slot: slot}
+	vm.openUpvalues = append(vm.openUpvalues, u)
+
+	return u
+}
+
+// Move the variables in slots from height up off the stack and into the
+// upvalues that captured them.
+func (vm *VirtualMachine) closeUpvalues(height int) {
+	open := vm.openUpvalues[:0]
+	for _, u := range vm.openUpvalues {
+		if u.Slot < height {
+			open = append(open, u)
+			continue
+		}
+		u.Value, u.Closed = vm.chunk.Values[u.Slot], true
+	}
+	vm.openUpvalues = open
+}
+
+// The upvalue the closure being run reaches its capture at operand 0 through.
+func (vm *VirtualMachine) upvalue(i bytecode.Instruction) (*bytecode.Upvalue, *InterpreterError) {
+	index := int(i.Operands[0])
+	if vm.callee == nil || index >= len(vm.callee.Upvalues) {
+		return nil, &InterpreterError{interpreterErr: internalError, line: i.SourceLineNumer, span: i.Span}
+	}
+
+	return vm.callee.Upvalues[index], nil
+}
+
+// Push the value of a captured variable, from the stack if its scope hasn't
+// ended yet.
+func (vm *VirtualMachine) getUpvalue(i bytecode.Instruction) *InterpreterError {
+	u, err := vm.upvalue(i)
+	if err != nil {
+		return err
+	}
+	if u.Closed {
+		vm.chunk.Values.Push(u.Value)
+	} else {
+		vm.chunk.Values.Push(vm.chunk.Values[u.Slot])
+	}
+
+	return nil
+}
+
+// Assign the value on top of the stack to a captured variable, leaving it
+// there as the result of the assignment.
+func (vm *VirtualMachine) setUpvalue(i bytecode.Instruction) *InterpreterError {
+	u, err := vm.upvalue(i)
+	if err != nil {
+		return err
+	}
+	val, err := vm.peek(i)
+	if err != nil {
+		return err
+	}
+	if u.Closed {
+		u.Value = val
+	} else {
+		vm.chunk.Values[u.Slot] = val
+	}
+
+	return nil
+}
+
+// Pop the local on top of the stack, closing it over first.
+func (vm *VirtualMachine) closeUpvalue(i bytecode.Instruction) *InterpreterError {
+	if len(vm.chunk.Values) == 0 {
+		return &InterpreterError{interpreterErr: popEmptyStack, line: i.SourceLineNumer, span: i.Span}
+	}
+	vm.closeUpvalues(len(vm.chunk.Values) - 1)
+	_, err := vm.pop(i)
+
+	return err
+}
@@ -0,0 +1,57 @@
+package vm_test
+
+import (
+	"lox-compiler/vm"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func FuzzInterpret(f *testing.F) {
+	for _, pattern := range []string{
+		"../../interpreted_lox/*.lox",
+		"../conformance/testdata/*/*.lox",
+		"../difftest/testdata/*.lox",
+	} {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			f.Fatal(err)
+		}
+		for _, file := range files {
+			source, err := os.ReadFile(file)
+			if err != nil {
+				f.Fatal(err)
+			}
+			f.Add(string(source))
+		}
+	}
+
+	// Programs print to stdout; fuzz workers stall once that fills up.
+	// Syntax errors go to stderr, which is only swapped while an input runs so
+	// that the fuzzer's own progress reports still show.
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		f.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = devNull
+	f.Cleanup(func() {
+		os.Stdout = stdout
+		devNull.Close()
+	})
+
+	f.Fuzz(func(t *testing.T, source string) {
+		// Loops may never terminate; bound them so every input finishes.
+		v := vm.VirtualMachine{StepLimit: 100000}
+		stderr := os.Stderr
+		os.Stderr = devNull
+		err := v.Interpret(source)
+		os.Stderr = stderr
+		// Interpret recovers from panics, but each one is a bug that should
+		// have been reported through a proper error path.
+		if err != nil && strings.Contains(err.Error(), "internal error") {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,114 @@
+package vm
+
+import (
+	"errors"
+	"lox-compiler/bytecode"
+)
+
+const notIterable = "Only lists, maps, strings, ranges and iterators can be iterated."
+
+// An iterator over the values a for-in loop visits in val: the elements of a
+// list, the keys of a map, the characters of a string or the numbers of a
+// range.
+func iterator(val bytecode.Value) (*bytecode.LoxIterator, error) {
+	var next func() (bytecode.Value, bool)
+	switch v := val.(type) {
+	case *bytecode.LoxList:
+		// Elements pushed during the loop are visited too.
+		i := 0
+		next = func() (bytecode.Value, bool) {
+			if i >= len(v.Elements) {
+				return nil, false
+			}
+			i++
+			return v.Elements[i-1], true
+		}
+	case *bytecode.LoxMap:
+		next = values(v.Keys())
+	case bytecode.LoxString:
+		chars := []bytecode.Value{}
+		for _, c := range string(v) {
+			chars = append(chars, bytecode.LoxString(c))
+		}
+		next = values(chars)
+	case bytecode.LoxRange:
+		n := v.Start
+		next = func() (bytecode.Value, bool) {
+			if n >= v.End {
+				return nil, false
+			}
+			n++
+			return n - 1, true
+		}
+	default:
+		return nil, errors.New(notIterable)
+	}
+
+	return &bytecode.LoxIterator{Next: next}, nil
+}
+
+// A function that returns each of vals in turn.
+func values(vals []bytecode.Value) func() (bytecode.Value, bool) {
+	i := 0
+	return func() (bytecode.Value, bool) {
+		if i >= len(vals) {
+			return nil, false
+		}
+		i++
+		return vals[i-1], true
+	}
+}
+
+func (vm *VirtualMachine) buildRange(i bytecode.Instruction) *InterpreterError {
+	start, end, err := vm.popOperands(i)
+	if err != nil {
+		return err
+	}
+	s, ok := start.(bytecode.LoxInt)
+	e, ok2 := end.(bytecode.LoxInt)
+	if !ok || !ok2 {
+		return &InterpreterError{interpreterErr: "Range bounds must be integers.", line: i.SourceLineNumer, span: i.Span}
+	}
+	vm.chunk.Values.Push(bytecode.LoxRange{Start: s, End: e})
+
+	return nil
+}
+
+// Replace the value on top of the stack with an iterator over it.
+func (vm *VirtualMachine) iterate(i bytecode.Instruction) *InterpreterError {
+	val, err := vm.pop(i)
+	if err != nil {
+		return err
+	}
+	it, iterErr := iterator(val)
+	if iterErr != nil {
+		return &InterpreterError{interpreterErr: iterErr.Error(), line: i.SourceLineNumer, span: i.Span}
+	}
+	vm.chunk.Values.Push(it)
+
+	return nil
+}
+
+// Push the next value of the iterator on top of the stack, or jump out of
+// the loop if there are none left.
+func (vm *VirtualMachine) iterNext(i bytecode.Instruction) *InterpreterError {
+	top, err := vm.peek(i)
+	if err != nil {
+		return err
+	}
+	it, ok := top.(*bytecode.LoxIterator)
+	if !ok {
+		return &InterpreterError{interpreterErr: wrongType, line: i.SourceLineNumer, span: i.Span}
+	}
+	if val, ok := it.Next(); ok {
+		vm.chunk.Values.Push(val)
+		return nil
+	}
+	offset, err := vm.read_jump_offset(i, 0)
+	if err != nil {
+		return err
+	}
+	vm.pc += offset
+
+	return nil
+}
@@ -0,0 +1,285 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"lox-compiler/bytecode"
+)
+
+const notIndexable = "Only lists and maps can be indexed."
+
+// A built-in method of lists, and how many arguments it takes.
+type listMethod struct {
+	arity int
+	call  func(vm *VirtualMachine, l *bytecode.LoxList, args []bytecode.Value) (bytecode.Value, error)
+}
+
+// The built-in methods of lists, by name.
+var listMethods = map[bytecode.LoxString]listMethod{
+	"push": {1, func(vm *VirtualMachine, l *bytecode.LoxList, args []bytecode.Value) (bytecode.Value, error) {
+		l.Elements = append(l.Elements, args[0])
+		return bytecode.LoxNil(0), nil
+	}},
+	"pop": {0, func(vm *VirtualMachine, l *bytecode.LoxList, args []bytecode.Value) (bytecode.Value, error) {
+		if len(l.Elements) == 0 {
+			return nil, errors.New("Can't pop from an empty list.")
+		}
+		last := l.Elements[len(l.Elements)-1]
+		l.Elements = l.Elements[:len(l.Elements)-1]
+		return last, nil
+	}},
+	"len": {0, func(vm *VirtualMachine, l *bytecode.LoxList, args []bytecode.Value) (bytecode.Value, error) {
+		return bytecode.LoxInt(len(l.Elements)), nil
+	}},
+	"slice": {2, func(vm *VirtualMachine, l *bytecode.LoxList, args []bytecode.Value) (bytecode.Value, error) {
+		start, err := sliceBound(args[0], len(l.Elements))
+		if err != nil {
+			return nil, err
+		}
+		end, err := sliceBound(args[1], len(l.Elements))
+		if err != nil {
+			return nil, err
+		}
+		if start > end {
+			return nil, errors.New("Slice bounds out of range.")
+		}
+		return &bytecode.LoxList{Elements: append([]bytecode.Value{}, l.Elements[start:end]...)}, nil
+	}},
+}
+
+// map and filter call back into the VM, which looks them up in listMethods,
+// so they can't be in its initializer.
+func init() {
+	listMethods["map"] = listMethod{1, mapList}
+	listMethods["filter"] = listMethod{1, filterList}
+}
+
+func mapList(vm *VirtualMachine, l *bytecode.LoxList, args []bytecode.Value) (bytecode.Value, error) {
+	result := &bytecode.LoxList{}
+	err := vm.each(l, args[0], func(element, mapped bytecode.Value) {
+		result.Elements = append(result.Elements, mapped)
+	})
+	return result, err
+}
+
+func filterList(vm *VirtualMachine, l *bytecode.LoxList, args []bytecode.Value) (bytecode.Value, error) {
+	result := &bytecode.LoxList{}
+	err := vm.each(l, args[0], func(element, keep bytecode.Value) {
+		if keep.Truthy() {
+			result.Elements = append(result.Elements, element)
+		}
+	})
+	return result, err
+}
+
+// Call f with each element of l in turn, and pass the element and what f
+// returned for it to yield.
+func (vm *VirtualMachine) each(l *bytecode.LoxList, f bytecode.Value, yield func(element, result bytecode.Value)) error {
+	// Go over a copy, in case f changes the list.
+	for _, element := range append([]bytecode.Value{}, l.Elements...) {
+		result, err := vm.callBack(f, []bytecode.Value{element})
+		if err != nil {
+			return err
+		}
+		yield(element, result)
+	}
+
+	return nil
+}
+
+// Call the built-in method name of l.
+func (vm *VirtualMachine) invokeList(l *bytecode.LoxList, name bytecode.LoxString, args []bytecode.Value) (bytecode.Value, error) {
+	method, ok := listMethods[name]
+	if !ok {
+		return nil, fmt.Errorf("Lists have no method '%s'.", name)
+	}
+	if len(args) != method.arity {
+		return nil, fmt.Errorf("Expected %d arguments but got %d", method.arity, len(args))
+	}
+
+	return method.call(vm, l, args)
+}
+
+// The built-in method name of object, a list or map, looked up without
+// calling it.
+func builtinMethod(object bytecode.Value, name bytecode.LoxString) (bytecode.Value, error) {
+	_, isList := object.(*bytecode.LoxList)
+	if _, ok := listMethods[name]; isList && !ok {
+		return nil, fmt.Errorf("Lists have no method '%s'.", name)
+	}
+	if _, ok := mapMethods[name]; !isList && !ok {
+		return nil, fmt.Errorf("Maps have no method '%s'.", name)
+	}
+
+	return &bytecode.LoxBuiltinMethod{Receiver: object, Name: name}, nil
+}
+
+// The position of the element of a list of length n that index refers to.
+// Negative indexes count back from the end of the list.
+func listIndex(index bytecode.Value, n int) (int, error) {
+	i, ok := integer(index)
+	if !ok {
+		return 0, errors.New("List index must be an integer.")
+	}
+	if i < 0 {
+		i += n
+	}
+	if i < 0 || i >= n {
+		return 0, errors.New("List index out of range.")
+	}
+
+	return i, nil
+}
+
+// Like listIndex, but for the bounds of a slice, which can be n itself.
+func sliceBound(index bytecode.Value, n int) (int, error) {
+	i, ok := integer(index)
+	if !ok {
+		return 0, errors.New("Slice bounds must be integers.")
+	}
+	if i < 0 {
+		i += n
+	}
+	if i < 0 || i > n {
+		return 0, errors.New("Slice bounds out of range.")
+	}
+
+	return i, nil
+}
+
+func integer(v bytecode.Value) (int, bool) {
+	i, ok := v.(bytecode.LoxInt)
+	if !ok || bytecode.LoxInt(int(i)) != i {
+		return 0, false
+	}
+
+	return int(i), true
+}
+
+func (vm *VirtualMachine) buildList(i bytecode.Instruction) *InterpreterError {
+	elements, err := vm.popValues(i, int(i.Operands[0]))
+	if err != nil {
+		return err
+	}
+	vm.chunk.Values.Push(&bytecode.LoxList{Elements: elements})
+
+	return nil
+}
+
+func (vm *VirtualMachine) index(i bytecode.Instruction) *InterpreterError {
+	object, index, err := vm.popOperands(i)
+	if err != nil {
+		return err
+	}
+
+	var val bytecode.Value
+	var indexErr error
+	switch o := object.(type) {
+	case *bytecode.LoxList:
+		var n int
+		if n, indexErr = listIndex(index, len(o.Elements)); indexErr == nil {
+			val = o.Elements[n]
+		}
+	case *bytecode.LoxMap:
+		val, indexErr = mapGet(o, index)
+	default:
+		indexErr = errors.New(notIndexable)
+	}
+	if indexErr != nil {
+		return &InterpreterError{interpreterErr: indexErr.Error(), line: i.SourceLineNumer, span: i.Span}
+	}
+	vm.chunk.Values.Push(val)
+
+	return nil
+}
+
+func (vm *VirtualMachine) storeIndex(i bytecode.Instruction) *InterpreterError {
+	val, err := vm.pop(i)
+	if err != nil {
+		return err
+	}
+	object, index, err := vm.popOperands(i)
+	if err != nil {
+		return err
+	}
+
+	var indexErr error
+	switch o := object.(type) {
+	case *bytecode.LoxList:
+		var n int
+		if n, indexErr = listIndex(index, len(o.Elements)); indexErr == nil {
+			o.Elements[n] = val
+		}
+	case *bytecode.LoxMap:
+		indexErr = mapSet(o, index, val)
+	default:
+		indexErr = errors.New(notIndexable)
+	}
+	if indexErr != nil {
+		return &InterpreterError{interpreterErr: indexErr.Error(), line: i.SourceLineNumer, span: i.Span}
+	}
+	vm.chunk.Values.Push(val)
+
+	return nil
+}
+
+// Call a method with the arguments on the stack, above the object it's
+// called on and below its name.
+func (vm *VirtualMachine) invoke(i bytecode.Instruction) *InterpreterError {
+	name, err := vm.popName(i)
+	if err != nil {
+		return err
+	}
+	args, err := vm.popValues(i, int(i.Operands[0]))
+	if err != nil {
+		return err
+	}
+	object, err := vm.pop(i)
+	if err != nil {
+		return err
+	}
+
+	result, callErr := vm.invokeMethod(object, name, args)
+	if callErr != nil {
+		return methodError(i, callErr)
+	}
+	vm.chunk.Values.Push(result)
+
+	return nil
+}
+
+// Call the built-in method name of object.
+func (vm *VirtualMachine) invokeMethod(object bytecode.Value, name bytecode.LoxString, args []bytecode.Value) (bytecode.Value, error) {
+	switch o := object.(type) {
+	case *bytecode.LoxList:
+		return vm.invokeList(o, name, args)
+	case *bytecode.LoxMap:
+		return invokeMap(o, name, args)
+	case *bytecode.LoxModule:
+		return nil, errors.New("the VM can't call the functions of modules")
+	}
+
+	return nil, errors.New("Only lists and maps have methods.")
+}
+
+// The error for the built-in method called by i failing with err. An error
+// raised inside a function the method called keeps the line it was raised on.
+func methodError(i bytecode.Instruction, err error) *InterpreterError {
+	var raised *InterpreterError
+	if errors.As(err, &raised) {
+		return raised
+	}
+
+	return &InterpreterError{interpreterErr: err.Error(), line: i.SourceLineNumer, span: i.Span}
+}
+
+// Pop the top n values of the stack, in the order they were pushed.
+func (vm *VirtualMachine) popValues(i bytecode.Instruction, n int) ([]bytecode.Value, *InterpreterError) {
+	if n > len(vm.chunk.Values) {
+		return nil, &InterpreterError{interpreterErr: popEmptyStack, line: i.SourceLineNumer, span: i.Span}
+	}
+	values := append([]bytecode.Value{}, vm.chunk.Values[len(vm.chunk.Values)-n:]...)
+	vm.chunk.Values = vm.chunk.Values[:len(vm.chunk.Values)-n]
+
+	return values, nil
+}
@@ -0,0 +1,113 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"lox-compiler/bytecode"
+)
+
+// The built-in methods of maps, by name.
+var mapMethods = map[bytecode.LoxString]struct {
+	arity int
+	call  func(m *bytecode.LoxMap, args []bytecode.Value) (bytecode.Value, error)
+}{
+	"keys": {0, func(m *bytecode.LoxMap, args []bytecode.Value) (bytecode.Value, error) {
+		keys := &bytecode.LoxList{}
+		for _, key := range m.Keys() {
+			keys.Elements = append(keys.Elements, key)
+		}
+		return keys, nil
+	}},
+	"values": {0, func(m *bytecode.LoxMap, args []bytecode.Value) (bytecode.Value, error) {
+		values := &bytecode.LoxList{}
+		for _, key := range m.Keys() {
+			val, _ := m.Get(key)
+			values.Elements = append(values.Elements, val)
+		}
+		return values, nil
+	}},
+	"has": {1, func(m *bytecode.LoxMap, args []bytecode.Value) (bytecode.Value, error) {
+		key, err := mapKey(args[0])
+		if err != nil {
+			return nil, err
+		}
+		_, err = m.Get(key)
+		return bytecode.LoxBool(err == nil), nil
+	}},
+	"remove": {1, func(m *bytecode.LoxMap, args []bytecode.Value) (bytecode.Value, error) {
+		key, err := mapKey(args[0])
+		if err != nil {
+			return nil, err
+		}
+		val, err := m.Get(key)
+		if err != nil {
+			return bytecode.LoxNil(0), nil
+		}
+		m.Delete(key)
+		return val, nil
+	}},
+	"len": {0, func(m *bytecode.LoxMap, args []bytecode.Value) (bytecode.Value, error) {
+		return bytecode.LoxInt(m.Len()), nil
+	}},
+}
+
+// Call the built-in method name of m.
+func invokeMap(m *bytecode.LoxMap, name bytecode.LoxString, args []bytecode.Value) (bytecode.Value, error) {
+	method, ok := mapMethods[name]
+	if !ok {
+		return nil, fmt.Errorf("Maps have no method '%s'.", name)
+	}
+	if len(args) != method.arity {
+		return nil, fmt.Errorf("Expected %d arguments but got %d", method.arity, len(args))
+	}
+
+	return method.call(m, args)
+}
+
+func mapKey(key bytecode.Value) (bytecode.Value, error) {
+	if !bytecode.Hashable(key) {
+		return nil, errors.New("Functions and classes can't be map keys.")
+	}
+
+	return key, nil
+}
+
+// The value key maps to in m.
+func mapGet(m *bytecode.LoxMap, key bytecode.Value) (bytecode.Value, error) {
+	key, err := mapKey(key)
+	if err != nil {
+		return nil, err
+	}
+	val, err := m.Get(key)
+	if err != nil {
+		return nil, fmt.Errorf("Map has no key '%v'.", key)
+	}
+
+	return val, nil
+}
+
+func mapSet(m *bytecode.LoxMap, key, val bytecode.Value) error {
+	key, err := mapKey(key)
+	if err != nil {
+		return err
+	}
+	m.Insert(key, val)
+
+	return nil
+}
+
+func (vm *VirtualMachine) buildMap(i bytecode.Instruction) *InterpreterError {
+	entries, err := vm.popValues(i, 2*int(i.Operands[0]))
+	if err != nil {
+		return err
+	}
+	m := bytecode.NewLoxMap()
+	for j := 0; j < len(entries); j += 2 {
+		if err := mapSet(m, entries[j], entries[j+1]); err != nil {
+			return &InterpreterError{interpreterErr: err.Error(), line: i.SourceLineNumer, span: i.Span}
+		}
+	}
+	vm.chunk.Values.Push(m)
+
+	return nil
+}
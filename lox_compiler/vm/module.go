@@ -0,0 +1,114 @@
+package vm
+
+import (
+	"fmt"
+	"lox-compiler/bytecode"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The modules imported while running a script, shared by the virtual
+// machines that run the script and each of its modules.
+type modules struct {
+	// The directory that paths in error messages are relative to.
+	root   string
+	loaded map[string]*bytecode.LoxModule
+	// The files being run, outermost first, for detecting import cycles.
+	running []string
+}
+
+func (vm *VirtualMachine) initModules() {
+	if vm.modules != nil {
+		return
+	}
+	vm.modules = &modules{loaded: make(map[string]*bytecode.LoxModule)}
+	if vm.Path != "" {
+		path, err := filepath.Abs(vm.Path)
+		if err != nil {
+			path = vm.Path
+		}
+		vm.modules.root = filepath.Dir(path)
+		vm.modules.running = []string{path}
+	}
+}
+
+// Run the module that an import of name refers to, unless it has already
+// been run, and return its exports.
+func (vm *VirtualMachine) importModule(name string) (*bytecode.LoxModule, error) {
+	vm.initModules()
+	path, err := findModule(name, vm.Path, vm.SearchPath)
+	if err != nil {
+		return nil, err
+	}
+	if m, ok := vm.modules.loaded[path]; ok {
+		return m, nil
+	}
+	for i, running := range vm.modules.running {
+		if running == path {
+			cycle := append(append([]string{}, vm.modules.running[i:]...), path)
+			return nil, fmt.Errorf("import cycle: %s", vm.modules.describe(cycle))
+		}
+	}
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't read module %q", name)
+	}
+
+	vm.modules.running = append(vm.modules.running, path)
+	defer func() { vm.modules.running = vm.modules.running[:len(vm.modules.running)-1] }()
+
+	child := VirtualMachine{Path: path, SearchPath: vm.SearchPath, StepLimit: vm.StepLimit, Decimal: vm.Decimal, modules: vm.modules}
+	if err := child.Interpret(string(src)); err != nil {
+		return nil, fmt.Errorf("in module %q: %s", name, err.Error())
+	}
+
+	m := &bytecode.LoxModule{Name: bytecode.LoxString(name), Exports: make(map[bytecode.LoxString]bytecode.Value)}
+	for _, exported := range child.chunk.Exports {
+		m.Exports[exported] = child.vars[exported]
+	}
+	vm.modules.loaded[path] = m
+
+	return m, nil
+}
+
+// The paths of files, relative to the directory of the script being run
+// where possible, joined with arrows.
+func (m *modules) describe(paths []string) string {
+	names := make([]string, len(paths))
+	for i, p := range paths {
+		names[i] = p
+		if rel, err := filepath.Rel(m.root, p); err == nil && m.root != "" {
+			names[i] = rel
+		}
+	}
+
+	return strings.Join(names, " -> ")
+}
+
+// The absolute path of the file that an import of name from the file at
+// importer refers to. A relative name is looked up in the importer's
+// directory first, and then in each directory of the search path in turn.
+// An empty importer stands for a script read from somewhere other than a
+// file, whose imports are relative to the working directory.
+func findModule(name, importer string, searchPath []string) (string, error) {
+	candidates := []string{name}
+	if !filepath.IsAbs(name) {
+		dir := "."
+		if importer != "" {
+			dir = filepath.Dir(importer)
+		}
+		candidates = []string{filepath.Join(dir, name)}
+		for _, d := range searchPath {
+			candidates = append(candidates, filepath.Join(d, name))
+		}
+	}
+
+	for _, c := range candidates {
+		if info, err := os.Stat(c); err == nil && !info.IsDir() {
+			return filepath.Abs(c)
+		}
+	}
+
+	return "", fmt.Errorf("can't find module %q", name)
+}
@@ -0,0 +1,99 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"lox-compiler/bytecode"
+	"lox-compiler/decimal"
+	"math"
+	"math/big"
+	"strconv"
+	"time"
+)
+
+// Define the functions built into the VM among the globals: clock, and the
+// number functions golox has. bigint and decimal convert numbers and
+// strings to bigints and decimals, and decimalContext sets the precision and
+// rounding mode of arithmetic on decimals.
+func (vm *VirtualMachine) defineNatives() {
+	for _, native := range []*bytecode.LoxNative{
+		{Name: "clock", Arity: 0, Fn: func(args []bytecode.Value) (bytecode.Value, error) {
+			return bytecode.LoxFloat(float64(time.Now().UnixMilli()) / 1000), nil
+		}},
+		{Name: "bigint", Arity: 1, Fn: nativeBigInt},
+		{Name: "decimal", Arity: 1, Fn: nativeDecimal},
+		{Name: "decimalContext", Arity: 2, Fn: vm.decimalContext},
+	} {
+		vm.vars[native.Name] = native
+	}
+}
+
+func nativeBigInt(args []bytecode.Value) (bytecode.Value, error) {
+	switch x := args[0].(type) {
+	case bytecode.LoxInt, bytecode.LoxBigInt:
+		return bytecode.LoxBigInt{Int: toBigInt(x)}, nil
+	case bytecode.LoxFloat:
+		f := float64(x)
+		if math.IsInf(f, 0) || f != math.Trunc(f) {
+			return nil, errors.New("Only whole numbers can be converted to bigints.")
+		}
+		i, _ := big.NewFloat(f).Int(nil)
+		return bytecode.LoxBigInt{Int: i}, nil
+	case bytecode.LoxDecimal:
+		i, ok := x.Int()
+		if !ok {
+			return nil, errors.New("Only whole numbers can be converted to bigints.")
+		}
+		return bytecode.LoxBigInt{Int: i}, nil
+	case bytecode.LoxString:
+		i, ok := new(big.Int).SetString(string(x), 10)
+		if !ok {
+			return nil, fmt.Errorf("Can't convert %q to a bigint.", string(x))
+		}
+		return bytecode.LoxBigInt{Int: i}, nil
+	}
+
+	return nil, errors.New("Only numbers and strings can be converted to bigints.")
+}
+
+// A float becomes the shortest decimal that reads back as the same float,
+// so decimal(0.1) is 0.1 rather than the float's exact value.
+func nativeDecimal(args []bytecode.Value) (bytecode.Value, error) {
+	switch x := args[0].(type) {
+	case bytecode.LoxInt, bytecode.LoxBigInt, bytecode.LoxDecimal:
+		return bytecode.LoxDecimal{Decimal: toDecimal(x)}, nil
+	case bytecode.LoxFloat:
+		f := float64(x)
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return nil, errors.New("Infinities and NaN can't be converted to decimals.")
+		}
+		d, _ := decimal.Parse(strconv.FormatFloat(f, 'f', -1, 64))
+		return bytecode.LoxDecimal{Decimal: d}, nil
+	case bytecode.LoxString:
+		d, err := decimal.Parse(string(x))
+		if err != nil {
+			return nil, fmt.Errorf("Can't convert %q to a decimal.", string(x))
+		}
+		return bytecode.LoxDecimal{Decimal: d}, nil
+	}
+
+	return nil, errors.New("Only numbers and strings can be converted to decimals.")
+}
+
+func (vm *VirtualMachine) decimalContext(args []bytecode.Value) (bytecode.Value, error) {
+	precision, ok := integer(args[0])
+	if !ok || precision < 1 {
+		return nil, errors.New("Decimal precision must be a positive integer.")
+	}
+	name, ok := args[1].(bytecode.LoxString)
+	if !ok {
+		return nil, errors.New("Rounding mode must be a string.")
+	}
+	rounding, ok := decimal.ParseRounding(string(name))
+	if !ok {
+		return nil, fmt.Errorf("Unknown rounding mode %q.", string(name))
+	}
+	vm.Decimal = decimal.Context{Precision: precision, Rounding: rounding}
+
+	return bytecode.LoxNil(0), nil
+}
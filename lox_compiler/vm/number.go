@@ -0,0 +1,218 @@
+package vm
+
+import (
+	"lox-compiler/bytecode"
+	"lox-compiler/decimal"
+	"math"
+	"math/big"
+)
+
+const (
+	divisionByZero    = "Division by zero."
+	mixedDecimalFloat = "Can't mix decimals and floats."
+)
+
+// The kinds of number, in the order that arithmetic on two kinds converts
+// to: an integer and a bigint give a bigint, a bigint and a decimal a
+// decimal, and anything but a decimal and a float a float. Decimals and
+// floats don't mix, since the point of a decimal is to avoid floats'
+// rounding.
+type numberKind int
+
+const (
+	notNumber numberKind = iota
+	intKind
+	bigIntKind
+	decimalKind
+	floatKind
+)
+
+func kindOf(v bytecode.Value) numberKind {
+	switch v.(type) {
+	case bytecode.LoxInt:
+		return intKind
+	case bytecode.LoxBigInt:
+		return bigIntKind
+	case bytecode.LoxDecimal:
+		return decimalKind
+	case bytecode.LoxFloat:
+		return floatKind
+	}
+	return notNumber
+}
+
+// Whether l and r are both numbers.
+func numbers(l, r bytecode.Value) bool {
+	return kindOf(l) != notNumber && kindOf(r) != notNumber
+}
+
+func toFloat(v bytecode.Value) float64 {
+	switch n := v.(type) {
+	case bytecode.LoxInt:
+		return float64(n)
+	case bytecode.LoxBigInt:
+		f, _ := new(big.Float).SetInt(n.Int).Float64()
+		return f
+	case bytecode.LoxFloat:
+		return float64(n)
+	}
+	return 0
+}
+
+func toBigInt(v bytecode.Value) *big.Int {
+	if n, ok := v.(bytecode.LoxInt); ok {
+		return big.NewInt(int64(n))
+	}
+	return v.(bytecode.LoxBigInt).Int
+}
+
+func toDecimal(v bytecode.Value) decimal.Decimal {
+	if n, ok := v.(bytecode.LoxDecimal); ok {
+		return n.Decimal
+	}
+	return decimal.FromInt(toBigInt(v))
+}
+
+// Apply an arithmetic opcode to two numbers. Two integers give an integer,
+// wrapping around on overflow, with division and remainder truncating
+// towards zero, as do bigints; decimals are rounded to the precision of the
+// VM's decimal context. Otherwise the operands are converted to the kind of
+// the later of the two.
+func (vm *VirtualMachine) arithmetic(i bytecode.Instruction, l, r bytecode.Value) (bytecode.Value, *InterpreterError) {
+	lk, rk := kindOf(l), kindOf(r)
+	if lk == notNumber || rk == notNumber {
+		return nil, &InterpreterError{interpreterErr: wrongType, line: i.SourceLineNumer, span: i.Span}
+	}
+	switch max(lk, rk) {
+	case intKind:
+		return vm.intArithmetic(i, l.(bytecode.LoxInt), r.(bytecode.LoxInt))
+	case bigIntKind:
+		return vm.bigIntArithmetic(i, toBigInt(l), toBigInt(r))
+	case decimalKind:
+		return vm.decimalArithmetic(i, toDecimal(l), toDecimal(r))
+	}
+	if lk == decimalKind || rk == decimalKind {
+		return nil, &InterpreterError{interpreterErr: mixedDecimalFloat, line: i.SourceLineNumer, span: i.Span}
+	}
+
+	lf, rf := toFloat(l), toFloat(r)
+	switch i.Code {
+	case bytecode.OpAdd:
+		return bytecode.LoxFloat(lf + rf), nil
+	case bytecode.OpSubtract:
+		return bytecode.LoxFloat(lf - rf), nil
+	case bytecode.OpMultiply:
+		return bytecode.LoxFloat(lf * rf), nil
+	case bytecode.OpDivide:
+		return bytecode.LoxFloat(lf / rf), nil
+	}
+	return bytecode.LoxFloat(math.Mod(lf, rf)), nil
+}
+
+func (vm *VirtualMachine) intArithmetic(i bytecode.Instruction, l, r bytecode.LoxInt) (bytecode.Value, *InterpreterError) {
+	switch i.Code {
+	case bytecode.OpAdd:
+		return l + r, nil
+	case bytecode.OpSubtract:
+		return l - r, nil
+	case bytecode.OpMultiply:
+		return l * r, nil
+	}
+	if r == 0 {
+		return nil, &InterpreterError{interpreterErr: divisionByZero, line: i.SourceLineNumer, span: i.Span}
+	}
+	if i.Code == bytecode.OpDivide {
+		return l / r, nil
+	}
+	return l % r, nil
+}
+
+func (vm *VirtualMachine) bigIntArithmetic(i bytecode.Instruction, l, r *big.Int) (bytecode.Value, *InterpreterError) {
+	z := new(big.Int)
+	switch i.Code {
+	case bytecode.OpAdd:
+		return bytecode.LoxBigInt{Int: z.Add(l, r)}, nil
+	case bytecode.OpSubtract:
+		return bytecode.LoxBigInt{Int: z.Sub(l, r)}, nil
+	case bytecode.OpMultiply:
+		return bytecode.LoxBigInt{Int: z.Mul(l, r)}, nil
+	}
+	if r.Sign() == 0 {
+		return nil, &InterpreterError{interpreterErr: divisionByZero, line: i.SourceLineNumer, span: i.Span}
+	}
+	if i.Code == bytecode.OpDivide {
+		return bytecode.LoxBigInt{Int: z.Quo(l, r)}, nil
+	}
+	return bytecode.LoxBigInt{Int: z.Rem(l, r)}, nil
+}
+
+func (vm *VirtualMachine) decimalArithmetic(i bytecode.Instruction, l, r decimal.Decimal) (bytecode.Value, *InterpreterError) {
+	switch i.Code {
+	case bytecode.OpAdd:
+		return bytecode.LoxDecimal{Decimal: vm.Decimal.Add(l, r)}, nil
+	case bytecode.OpSubtract:
+		return bytecode.LoxDecimal{Decimal: vm.Decimal.Sub(l, r)}, nil
+	case bytecode.OpMultiply:
+		return bytecode.LoxDecimal{Decimal: vm.Decimal.Mul(l, r)}, nil
+	}
+	var d decimal.Decimal
+	var ok bool
+	if i.Code == bytecode.OpDivide {
+		d, ok = vm.Decimal.Quo(l, r)
+	} else {
+		d, ok = vm.Decimal.Rem(l, r)
+	}
+	if !ok {
+		return nil, &InterpreterError{interpreterErr: divisionByZero, line: i.SourceLineNumer, span: i.Span}
+	}
+	return bytecode.LoxDecimal{Decimal: d}, nil
+}
+
+// Compare two numbers: -1, 0 or 1 as l is less than, equal to or greater
+// than r. ordered is false if they can't be compared, because one isn't a
+// number or is NaN. Bigints and decimals are compared exactly with other
+// numbers.
+func compare(l, r bytecode.Value) (cmp int, ordered bool) {
+	lk, rk := kindOf(l), kindOf(r)
+	if lk == notNumber || rk == notNumber {
+		return 0, false
+	}
+	if lk == intKind && rk == intKind {
+		return compareOrdered(l.(bytecode.LoxInt), r.(bytecode.LoxInt)), true
+	}
+	if lk == bigIntKind || lk == decimalKind || rk == bigIntKind || rk == decimalKind {
+		return compareExact(l, r)
+	}
+	lf, rf := toFloat(l), toFloat(r)
+	if math.IsNaN(lf) || math.IsNaN(rf) {
+		return 0, false
+	}
+
+	return compareOrdered(lf, rf), true
+}
+
+// Compare two numbers as fractions. Only a float can't be one, if it's
+// infinite or NaN.
+func compareExact(l, r bytecode.Value) (cmp int, ordered bool) {
+	lr, lOK := bytecode.Rat(l)
+	rr, rOK := bytecode.Rat(r)
+	switch {
+	case lOK && rOK:
+		return lr.Cmp(rr), true
+	case !lOK && !math.IsNaN(toFloat(l)):
+		return int(math.Copysign(1, toFloat(l))), true
+	case !rOK && !math.IsNaN(toFloat(r)):
+		return -int(math.Copysign(1, toFloat(r))), true
+	}
+	return 0, false
+}
+
+func compareOrdered[T bytecode.LoxInt | float64](l, r T) int {
+	switch {
+	case l < r:
+		return -1
+	case l > r:
+		return 1
+	}
+	return 0
+}
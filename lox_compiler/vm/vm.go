@@ -5,6 +5,9 @@ import (
 	"lox-compiler/bytecode"
 	"lox-compiler/compiler"
 	"lox-compiler/debug"
+	"lox-compiler/decimal"
+	"lox-compiler/source"
+	"math/big"
 	"strings"
 )
 
@@ -13,20 +16,78 @@ type VirtualMachine struct {
 	pc              int
 	InteractiveMode bool
 	vars            map[bytecode.LoxString]bytecode.Value
+	// If non-zero, the most instructions a single call to Interpret may
+	// execute before it gives up.
+	StepLimit int
+	// The file being run, which imports are resolved relative to. Empty
+	// means the working directory.
+	Path string
+	// Directories to look for imported modules in when they aren't found
+	// relative to the file that imports them.
+	SearchPath []string
+	modules    *modules
+	// The handlers of the try statements being run, innermost last.
+	handlers []handler
+	// The errors that finally clauses are being run for, innermost last,
+	// which are thrown again when the clauses finish.
+	pending []*InterpreterError
+	// The upvalues of the variables captured by closures that are still on
+	// the stack.
+	openUpvalues []*bytecode.Upvalue
+	// The calls being run, innermost last, each saving what its caller
+	// was doing.
+	frames []frame
+	// Where the locals of the function being run start on the stack.
+	base int
+	// The closure being run, whose upvalues its body reads, or nil at the
+	// top level.
+	callee *bytecode.LoxClosure
+	// The numbers of calls being run when built-in methods called back into
+	// the VM, innermost last. Returning to that many calls hands the result
+	// back to the method.
+	callbacks []int
+	// The instructions executed so far by this call to Interpret.
+	steps int
+	// The precision and rounding mode of arithmetic on decimals.
+	Decimal decimal.Context
+}
+
+// Where to carry on when something is thrown inside a try statement.
+type handler struct {
+	// The first instruction of the code that handles the exception.
+	pc int
+	// The height of the value stack when the try statement started.
+	stackHeight int
+	// The number of pending errors when the try statement started.
+	pending int
+	// The number of calls being run when the try statement started.
+	frames int
+	// Whether the handler runs a finally clause rather than catching the
+	// exception.
+	finally bool
 }
 
 const (
-	outOfBoundsPC string = "out of bounds program counter"
-	popEmptyStack        = "pop on an empty stack"
-	wrongType            = "incorrect type"
-	invalidOpCode        = "invalid OpCode"
-	expectedInts         = "expected two ints"
-	expectedStr          = "expected a string"
+	outOfBoundsPC     string = "out of bounds program counter"
+	popEmptyStack            = "pop on an empty stack"
+	wrongType                = "incorrect type"
+	invalidOpCode            = "invalid OpCode"
+	expectedNumbers          = "expected two numbers"
+	expectedStr              = "expected a string"
+	invalidConstant          = "invalid constant index"
+	invalidLocal             = "invalid local variable slot"
+	internalError            = "internal error"
+	stepLimitExceeded        = "step limit exceeded"
 )
 
 type InterpreterError struct {
 	interpreterErr string
 	line           int
+	span           source.Span
+	compileErr     bool
+	// The value of a throw statement, or nil for an error raised by the
+	// virtual machine itself.
+	thrown bytecode.Value
 }
 
 func (e InterpreterError) Error() string {
@@ -34,22 +95,57 @@ func (e InterpreterError) Error() string {
 	if e.line >= 0 {
 		str.WriteString(fmt.Sprintf("[line %d]: ", e.line))
 	}
-    str.WriteString(fmt.Sprintf("encountered an error: %s", e.interpreterErr))
+	str.WriteString(fmt.Sprintf("encountered an error: %s", e.interpreterErr))
 
 	return str.String()
 }
 
-func (vm *VirtualMachine) Interpret(s string) *InterpreterError {
+// The source text of the instruction that failed, or a zero span if the
+// error can't be attributed to one.
+func (e InterpreterError) Span() source.Span {
+	return e.span
+}
+
+// Reports whether the error was raised while compiling the source rather
+// than while running it.
+func (e InterpreterError) IsCompileError() bool {
+	return e.compileErr
+}
+
+// The value a catch clause receives for the error.
+func (e InterpreterError) exception() bytecode.Value {
+	if e.thrown != nil {
+		return e.thrown
+	}
+
+	return &bytecode.LoxError{Message: bytecode.LoxString(e.interpreterErr), Line: bytecode.LoxInt(e.line)}
+}
+
+// Compile and run s. Any failure, including a bug in the compiler or VM
+// that would otherwise panic, is returned as an *InterpreterError.
+func (vm *VirtualMachine) Interpret(s string) (ret *InterpreterError) {
+	defer func() {
+		if r := recover(); r != nil {
+			ret = &InterpreterError{interpreterErr: fmt.Sprintf("%s: %v", internalError, r), line: -1}
+		}
+	}()
+
 	if vm.vars == nil {
 		vm.vars = make(map[bytecode.LoxString]bytecode.Value)
-
+		vm.defineNatives()
 	}
 	vm.pc = 0
+	vm.handlers, vm.pending, vm.openUpvalues = nil, nil, nil
+	vm.frames, vm.base, vm.callee, vm.callbacks = nil, 0, nil, nil
 	c := compiler.Compiler{}
 	c.InteractiveMode = vm.InteractiveMode
 	chunk, err := c.Compile(s)
 	if err != nil {
-		return &InterpreterError{interpreterErr: err.Error(), line: -1}
+		ret := &InterpreterError{interpreterErr: err.Error(), line: -1, span: err.Span(), compileErr: true}
+		if !ret.span.IsZero() {
+			ret.line = ret.span.Start.Line
+		}
+		return ret
 	}
 
 	return vm.run_bytecode(chunk)
@@ -61,153 +157,403 @@ func (vm *VirtualMachine) run_bytecode(c *bytecode.Chunk) *InterpreterError {
 	return vm.run()
 }
 
+// Run the chunk, handing the errors raised inside a try statement to its
+// handler.
+func (vm *VirtualMachine) run() *InterpreterError {
+	vm.steps = 0
+	for {
+		err := vm.execute()
+		if err == nil {
+			return nil
+		}
+		if err = vm.handle(err); err != nil {
+			return err
+		}
+	}
+}
+
+// Unwind to the innermost try statement's handler. A catch clause starts
+// with the exception for err on the stack, and a finally clause with err
+// pending. Returns the error to report instead: err if there is no handler
+// or it can't be caught, or an internal error if the handler belongs to a
+// call that already returned.
+func (vm *VirtualMachine) handle(err *InterpreterError) *InterpreterError {
+	if len(vm.handlers) == 0 || err.compileErr || err.interpreterErr == stepLimitExceeded {
+		return err
+	}
+	h := vm.handlers[len(vm.handlers)-1]
+	vm.handlers = vm.handlers[:len(vm.handlers)-1]
+	if h.frames > len(vm.frames) {
+		return &InterpreterError{interpreterErr: fmt.Sprintf("%s: handler of a returned call", internalError), line: err.line, span: err.span}
+	}
+	vm.unwindFrames(h.frames)
+	vm.closeUpvalues(h.stackHeight)
+	vm.chunk.Values = vm.chunk.Values[:h.stackHeight]
+	// Any finally clauses that started since are abandoned.
+	vm.pending = vm.pending[:h.pending]
+	if h.finally {
+		vm.pending = append(vm.pending, err)
+	} else {
+		vm.chunk.Values.Push(err.exception())
+	}
+	vm.pc = h.pc
+
+	return nil
+}
+
 // This is a performance critical path. There are techniques to speed it up.
 // If you want to learn some of these techniques, look up “direct threaded code”, “jump table”, and “computed goto”.
-func (vm *VirtualMachine) run() *InterpreterError {
+func (vm *VirtualMachine) execute() *InterpreterError {
 	var err *InterpreterError
 	var inst bytecode.Instruction
 
 	for inst, err = vm.read_inst(); err == nil; inst, err = vm.read_inst() {
 		debug.Printf("%s", inst.String())
+		vm.steps++
+		if vm.StepLimit > 0 && vm.steps > vm.StepLimit {
+			return &InterpreterError{interpreterErr: stepLimitExceeded, line: inst.SourceLineNumer, span: inst.Span}
+		}
 		switch inst.Code {
 		case bytecode.OpReturn:
-			fmt.Println(vm.chunk.Values.Pop())
-			return nil
+			val, err := vm.pop(inst)
+			if err != nil {
+				return err
+			}
+			if len(vm.frames) == 0 {
+				fmt.Println(val)
+				return nil
+			}
+			vm.ret(val)
+			if n := len(vm.callbacks); n > 0 && vm.callbacks[n-1] == len(vm.frames) {
+				return nil
+			}
 
 		case bytecode.OpConstant:
 			// We could define some type aliases and methods on those aliases for each
 			// Instruction type?? Would this be slow as balls? Any good?
 			// fmt.Println(vm.chunk.Constants[inst.Operands[0]])
-			vm.chunk.Values.Push(vm.read_const(inst))
-
-		case bytecode.OpNegate:
-			loxInt, ok := vm.chunk.Values[len(vm.chunk.Values)-1].(bytecode.LoxInt)
-			if ok {
-				vm.chunk.Values[len(vm.chunk.Values)-1] = -loxInt
-			} else {
-				vm.chunk.Values[len(vm.chunk.Values)-1] = bytecode.LoxBool(!vm.chunk.Values[len(vm.chunk.Values)-1].Truthy())
-			}
-
-		case bytecode.OpLess:
-			rInt, ok := vm.chunk.Values.Pop().(bytecode.LoxInt)
-			if !ok {
-				return &InterpreterError{interpreterErr: expectedInts, line: inst.SourceLineNumer}
-			}
-			lInt, ok := vm.chunk.Values.Pop().(bytecode.LoxInt)
-			if !ok {
-				return &InterpreterError{interpreterErr: expectedInts, line: inst.SourceLineNumer}
-			}
-			vm.chunk.Values.Push(bytecode.LoxBool(lInt < rInt))
-
-		case bytecode.OpLessEqual:
-			rInt, ok := vm.chunk.Values.Pop().(bytecode.LoxInt)
-			if !ok {
-				return &InterpreterError{interpreterErr: expectedInts, line: inst.SourceLineNumer}
-			}
-			lInt, ok := vm.chunk.Values.Pop().(bytecode.LoxInt)
-			if !ok {
-				return &InterpreterError{interpreterErr: expectedInts, line: inst.SourceLineNumer}
+			val, err := vm.read_const(inst, 0)
+			if err != nil {
+				return err
 			}
-			vm.chunk.Values.Push(bytecode.LoxBool(lInt <= rInt))
+			vm.chunk.Values.Push(val)
 
-		case bytecode.OpGreater:
-			rInt, ok := vm.chunk.Values.Pop().(bytecode.LoxInt)
-			if !ok {
-				return &InterpreterError{interpreterErr: expectedInts, line: inst.SourceLineNumer}
+		case bytecode.OpNegate:
+			val, err := vm.pop(inst)
+			if err != nil {
+				return err
 			}
-			lInt, ok := vm.chunk.Values.Pop().(bytecode.LoxInt)
-			if !ok {
-				return &InterpreterError{interpreterErr: expectedInts, line: inst.SourceLineNumer}
+			switch n := val.(type) {
+			case bytecode.LoxInt:
+				vm.chunk.Values.Push(-n)
+			case bytecode.LoxFloat:
+				vm.chunk.Values.Push(-n)
+			case bytecode.LoxBigInt:
+				vm.chunk.Values.Push(bytecode.LoxBigInt{Int: new(big.Int).Neg(n.Int)})
+			case bytecode.LoxDecimal:
+				vm.chunk.Values.Push(bytecode.LoxDecimal{Decimal: n.Neg()})
+			default:
+				vm.chunk.Values.Push(bytecode.LoxBool(!val.Truthy()))
 			}
-			vm.chunk.Values.Push(bytecode.LoxBool(lInt > rInt))
 
-		case bytecode.OpGreaterEqual:
-			rInt, ok := vm.chunk.Values.Pop().(bytecode.LoxInt)
-			if !ok {
-				return &InterpreterError{interpreterErr: expectedInts, line: inst.SourceLineNumer}
-			}
-			lInt, ok := vm.chunk.Values.Pop().(bytecode.LoxInt)
-			if !ok {
-				return &InterpreterError{interpreterErr: expectedInts, line: inst.SourceLineNumer}
+		case bytecode.OpLess, bytecode.OpLessEqual, bytecode.OpGreater, bytecode.OpGreaterEqual:
+			err = vm.run_comparison_op(inst)
+			if err != nil {
+				return err
 			}
-			vm.chunk.Values.Push(bytecode.LoxBool(lInt >= rInt))
 
 		case bytecode.OpEqualEqual:
-			r := vm.chunk.Values.Pop()
-			l := vm.chunk.Values.Pop()
-			vm.chunk.Values.Push(bytecode.LoxBool(l == r))
+			l, r, err := vm.popOperands(inst)
+			if err != nil {
+				return err
+			}
+			vm.chunk.Values.Push(bytecode.LoxBool(bytecode.Equal(l, r)))
 
 		case bytecode.OpNotEqual:
-			r := vm.chunk.Values.Pop()
-			l := vm.chunk.Values.Pop()
-			vm.chunk.Values.Push(bytecode.LoxBool(l != r))
+			l, r, err := vm.popOperands(inst)
+			if err != nil {
+				return err
+			}
+			vm.chunk.Values.Push(bytecode.LoxBool(!bytecode.Equal(l, r)))
 
-		case bytecode.OpAdd, bytecode.OpSubtract, bytecode.OpMultiply, bytecode.OpDivide:
+		case bytecode.OpAdd, bytecode.OpSubtract, bytecode.OpMultiply, bytecode.OpDivide, bytecode.OpModulo:
 			err = vm.run_binary_op(inst)
 			if err != nil {
 				return err
 			}
 
 		case bytecode.OpPrint:
-			fmt.Println(vm.chunk.Values.Pop())
+			val, err := vm.pop(inst)
+			if err != nil {
+				return err
+			}
+			fmt.Println(val)
 
 		case bytecode.OpDeclareGlobal:
-			//
-			val := vm.chunk.Values.Pop()
-			name, ok := val.(bytecode.LoxString)
-			if !ok {
-				return &InterpreterError{interpreterErr: expectedStr, line: inst.SourceLineNumer}
+			name, err := vm.popName(inst)
+			if err != nil {
+				return err
 			}
 			vm.vars[name] = nil
 
 		case bytecode.OpAssign:
-			// pop name
-			name, ok := vm.chunk.Values.Pop().(bytecode.LoxString)
-			if !ok {
-				return &InterpreterError{interpreterErr: expectedStr, line: inst.SourceLineNumer}
+			name, err := vm.popName(inst)
+			if err != nil {
+				return err
+			}
+			// Don't pop the value, because an expression needs a result
+			val, err := vm.peek(inst)
+			if err != nil {
+				return err
 			}
-            // Don't pop the value, because an expression needs a result
-			vm.vars[name] = vm.chunk.Values[len(vm.chunk.Values)-1]
+			vm.vars[name] = val
 
 		case bytecode.OpGlobalLookup:
-			name, ok := vm.chunk.Values.Pop().(bytecode.LoxString)
-			if !ok {
-				return &InterpreterError{interpreterErr: expectedStr, line: inst.SourceLineNumer}
+			name, err := vm.popName(inst)
+			if err != nil {
+				return err
 			}
 			val, ok := vm.vars[name]
 			if !ok {
-				return &InterpreterError{interpreterErr: fmt.Sprintf("variable %s is not defined in this scope", name), line: inst.SourceLineNumer}
+				return &InterpreterError{interpreterErr: fmt.Sprintf("variable %s is not defined in this scope", name), line: inst.SourceLineNumer, span: inst.Span}
 			}
 			vm.chunk.Values.Push(val)
 
+		case bytecode.OpImport:
+			name, err := vm.popName(inst)
+			if err != nil {
+				return err
+			}
+			module, importErr := vm.importModule(string(name))
+			if importErr != nil {
+				return &InterpreterError{interpreterErr: importErr.Error(), line: inst.SourceLineNumer, span: inst.Span}
+			}
+			vm.chunk.Values.Push(module)
+
+		case bytecode.OpImportAll:
+			val, err := vm.pop(inst)
+			if err != nil {
+				return err
+			}
+			module, ok := val.(*bytecode.LoxModule)
+			if !ok {
+				return &InterpreterError{interpreterErr: wrongType, line: inst.SourceLineNumer, span: inst.Span}
+			}
+			for name, export := range module.Exports {
+				vm.vars[name] = export
+			}
+
+		case bytecode.OpGetProperty:
+			name, err := vm.popName(inst)
+			if err != nil {
+				return err
+			}
+			val, err := vm.pop(inst)
+			if err != nil {
+				return err
+			}
+			switch obj := val.(type) {
+			case *bytecode.LoxModule:
+				export, ok := obj.Exports[name]
+				if !ok {
+					return &InterpreterError{interpreterErr: fmt.Sprintf("\"%s\" is not exported by \"%s\"", name, obj.Name), line: inst.SourceLineNumer, span: inst.Span}
+				}
+				vm.chunk.Values.Push(export)
+			case *bytecode.LoxError:
+				switch name {
+				case "message":
+					vm.chunk.Values.Push(obj.Message)
+				case "line":
+					vm.chunk.Values.Push(obj.Line)
+				default:
+					return &InterpreterError{interpreterErr: fmt.Sprintf("errors have no property \"%s\"", name), line: inst.SourceLineNumer, span: inst.Span}
+				}
+			case *bytecode.LoxList, *bytecode.LoxMap:
+				method, err := builtinMethod(obj, name)
+				if err != nil {
+					return &InterpreterError{interpreterErr: err.Error(), line: inst.SourceLineNumer, span: inst.Span}
+				}
+				vm.chunk.Values.Push(method)
+			default:
+				return &InterpreterError{interpreterErr: "only modules and errors have properties", line: inst.SourceLineNumer, span: inst.Span}
+			}
+
+		case bytecode.OpBuildList:
+			if err := vm.buildList(inst); err != nil {
+				return err
+			}
+
+		case bytecode.OpBuildMap:
+			if err := vm.buildMap(inst); err != nil {
+				return err
+			}
+
+		case bytecode.OpIndex:
+			if err := vm.index(inst); err != nil {
+				return err
+			}
+
+		case bytecode.OpBuildRange:
+			if err := vm.buildRange(inst); err != nil {
+				return err
+			}
+
+		case bytecode.OpBuildString:
+			parts, err := vm.popValues(inst, int(inst.Operands[0]))
+			if err != nil {
+				return err
+			}
+			// Values are converted the same way print converts them.
+			var sb strings.Builder
+			for _, part := range parts {
+				fmt.Fprint(&sb, part)
+			}
+			vm.chunk.Values.Push(bytecode.LoxString(sb.String()))
+
+		case bytecode.OpCall:
+			if err := vm.call(inst); err != nil {
+				return err
+			}
+
+		case bytecode.OpCallNamed:
+			if err := vm.callNamed(inst); err != nil {
+				return err
+			}
+
+		case bytecode.OpArgMissing:
+			if err := vm.argMissing(inst); err != nil {
+				return err
+			}
+
+		case bytecode.OpClosure:
+			if err := vm.closure(inst); err != nil {
+				return err
+			}
+
+		case bytecode.OpCloseUpvalue:
+			if err := vm.closeUpvalue(inst); err != nil {
+				return err
+			}
+
+		case bytecode.OpGetUpvalue:
+			if err := vm.getUpvalue(inst); err != nil {
+				return err
+			}
+
+		case bytecode.OpSetUpvalue:
+			if err := vm.setUpvalue(inst); err != nil {
+				return err
+			}
+
+		case bytecode.OpIterate:
+			if err := vm.iterate(inst); err != nil {
+				return err
+			}
+
+		case bytecode.OpIterNext:
+			if err := vm.iterNext(inst); err != nil {
+				return err
+			}
+
+		case bytecode.OpStoreIndex:
+			if err := vm.storeIndex(inst); err != nil {
+				return err
+			}
+
+		case bytecode.OpInvoke:
+			if err := vm.invoke(inst); err != nil {
+				return err
+			}
+
 		case bytecode.OpLocalLookup:
-			vm.chunk.Values.Push(vm.chunk.Values[inst.Operands[0]])
+			slot := vm.base + int(inst.Operands[0])
+			if slot >= len(vm.chunk.Values) {
+				return &InterpreterError{interpreterErr: invalidLocal, line: inst.SourceLineNumer, span: inst.Span}
+			}
+			vm.chunk.Values.Push(vm.chunk.Values[slot])
 
 		case bytecode.OpLocalAssign:
-            // Don't pop the value, that's the result of the assignment expression
-			vm.chunk.Values[inst.Operands[0]] = vm.chunk.Values[len(vm.chunk.Values)-1]
+			slot := vm.base + int(inst.Operands[0])
+			if slot >= len(vm.chunk.Values) {
+				return &InterpreterError{interpreterErr: invalidLocal, line: inst.SourceLineNumer, span: inst.Span}
+			}
+			// Don't pop the value, that's the result of the assignment expression
+			val, err := vm.peek(inst)
+			if err != nil {
+				return err
+			}
+			vm.chunk.Values[slot] = val
 
 		case bytecode.OpPop:
-			vm.chunk.Values.Pop()
+			if _, err := vm.pop(inst); err != nil {
+				return err
+			}
+
+		case bytecode.OpConditionalJump:
+			cond, err := vm.pop(inst)
+			if err != nil {
+				return err
+			}
+			if !cond.Truthy() {
+				offset, err := vm.read_jump_offset(inst, 1)
+				if err != nil {
+					return err
+				}
+				vm.pc += offset
+			}
+
+		case bytecode.OpJump:
+			offset, err := vm.read_jump_offset(inst, 0)
+			if err != nil {
+				return err
+			}
+			vm.pc += offset
 
-        case bytecode.OpConditionalJump:
-            cond := vm.chunk.Values.Pop().Truthy()
-            if !cond {
-                vm.pc += int(vm.chunk.Constants[inst.Operands[1]].(bytecode.LoxInt))
-            } 
+		case bytecode.OpTry:
+			offset, err := vm.read_jump_offset(inst, 0)
+			if err != nil {
+				return err
+			}
+			vm.handlers = append(vm.handlers, handler{
+				pc:          vm.pc + offset,
+				stackHeight: len(vm.chunk.Values),
+				pending:     len(vm.pending),
+				frames:      len(vm.frames),
+				finally:     inst.Operands[1] != 0,
+			})
+
+		case bytecode.OpEndTry:
+			if len(vm.handlers) == 0 {
+				return &InterpreterError{interpreterErr: fmt.Sprintf("%s: no try statement to end", internalError), line: inst.SourceLineNumer, span: inst.Span}
+			}
+			if vm.handlers[len(vm.handlers)-1].frames != len(vm.frames) {
+				return &InterpreterError{interpreterErr: fmt.Sprintf("%s: try statement ended in another call", internalError), line: inst.SourceLineNumer, span: inst.Span}
+			}
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+		case bytecode.OpThrow:
+			val, err := vm.pop(inst)
+			if err != nil {
+				return err
+			}
+			return thrown(val, inst)
 
-        case bytecode.OpJump:
-            vm.pc += int(vm.chunk.Constants[inst.Operands[0]].(bytecode.LoxInt))
+		case bytecode.OpRethrow:
+			if len(vm.pending) == 0 {
+				return &InterpreterError{interpreterErr: fmt.Sprintf("%s: no error to rethrow", internalError), line: inst.SourceLineNumer, span: inst.Span}
+			}
+			err := vm.pending[len(vm.pending)-1]
+			vm.pending = vm.pending[:len(vm.pending)-1]
+			return err
 
-        case bytecode.OpAnd, bytecode.OpOr:
-            err := vm.run_logical_op(inst)
-            if err != nil {
-                return err
-            }
+		case bytecode.OpAnd, bytecode.OpOr:
+			err := vm.run_logical_op(inst)
+			if err != nil {
+				return err
+			}
 
 		default:
-			fmt.Println("unknown instruction ", inst.String())
-			return &InterpreterError{interpreterErr: "unkown instruction", line: inst.SourceLineNumer}
+			return &InterpreterError{interpreterErr: fmt.Sprintf("%s %s", invalidOpCode, inst.String()), line: inst.SourceLineNumer, span: inst.Span}
 		}
 		debug.Printf("%v", vm.chunk.Values)
 
@@ -217,14 +563,24 @@ func (vm *VirtualMachine) run() *InterpreterError {
 		if err.interpreterErr == outOfBoundsPC {
 			return nil
 		}
-		return &InterpreterError{interpreterErr: outOfBoundsPC, line: inst.SourceLineNumer}
+		return &InterpreterError{interpreterErr: outOfBoundsPC, line: inst.SourceLineNumer, span: inst.Span}
 	}
 
 	return nil
 }
 
+// The error for throwing val. Throwing an error the virtual machine raised,
+// after catching it, fails the way the original error did.
+func thrown(val bytecode.Value, i bytecode.Instruction) *InterpreterError {
+	if e, ok := val.(*bytecode.LoxError); ok {
+		return &InterpreterError{interpreterErr: string(e.Message), line: int(e.Line), span: i.Span, thrown: val}
+	}
+
+	return &InterpreterError{interpreterErr: fmt.Sprintf("uncaught exception: %v", val), line: i.SourceLineNumer, span: i.Span, thrown: val}
+}
+
 func (vm *VirtualMachine) read_inst() (bytecode.Instruction, *InterpreterError) {
-	if vm.pc >= len(vm.chunk.InstructionSlice) {
+	if vm.pc < 0 || vm.pc >= len(vm.chunk.InstructionSlice) {
 		return bytecode.Instruction{}, &InterpreterError{interpreterErr: outOfBoundsPC}
 	}
 	i := vm.chunk.InstructionSlice[vm.pc]
@@ -232,21 +588,110 @@ func (vm *VirtualMachine) read_inst() (bytecode.Instruction, *InterpreterError)
 	return i, nil
 }
 
-func (vm VirtualMachine) read_const(i bytecode.Instruction) bytecode.Value {
-	return vm.chunk.Constants[i.Operands[0]]
+func (vm VirtualMachine) read_const(i bytecode.Instruction, operand int) (bytecode.Value, *InterpreterError) {
+	index := int(i.Operands[operand])
+	if index >= len(vm.chunk.Constants) {
+		return nil, &InterpreterError{interpreterErr: invalidConstant, line: i.SourceLineNumer, span: i.Span}
+	}
+	return vm.chunk.Constants[index], nil
+}
+
+func (vm VirtualMachine) read_jump_offset(i bytecode.Instruction, operand int) (int, *InterpreterError) {
+	val, err := vm.read_const(i, operand)
+	if err != nil {
+		return 0, err
+	}
+	offset, ok := val.(bytecode.LoxInt)
+	if !ok {
+		return 0, &InterpreterError{interpreterErr: invalidConstant, line: i.SourceLineNumer, span: i.Span}
+	}
+	return int(offset), nil
+}
+
+// Pop the top of the value stack, failing rather than panicking if it's empty.
+func (vm *VirtualMachine) pop(i bytecode.Instruction) (bytecode.Value, *InterpreterError) {
+	if len(vm.chunk.Values) == 0 {
+		return nil, &InterpreterError{interpreterErr: popEmptyStack, line: i.SourceLineNumer, span: i.Span}
+	}
+	return vm.chunk.Values.Pop(), nil
+}
+
+func (vm *VirtualMachine) peek(i bytecode.Instruction) (bytecode.Value, *InterpreterError) {
+	if len(vm.chunk.Values) == 0 {
+		return nil, &InterpreterError{interpreterErr: popEmptyStack, line: i.SourceLineNumer, span: i.Span}
+	}
+	return vm.chunk.Values[len(vm.chunk.Values)-1], nil
+}
+
+// Pop the name of a global variable.
+func (vm *VirtualMachine) popName(i bytecode.Instruction) (bytecode.LoxString, *InterpreterError) {
+	val, err := vm.pop(i)
+	if err != nil {
+		return "", err
+	}
+	name, ok := val.(bytecode.LoxString)
+	if !ok {
+		return "", &InterpreterError{interpreterErr: expectedStr, line: i.SourceLineNumer, span: i.Span}
+	}
+	return name, nil
+}
+
+// Pop the right and then the left operand of a binary instruction.
+func (vm *VirtualMachine) popOperands(i bytecode.Instruction) (l, r bytecode.Value, err *InterpreterError) {
+	r, err = vm.pop(i)
+	if err != nil {
+		return nil, nil, err
+	}
+	l, err = vm.pop(i)
+	if err != nil {
+		return nil, nil, err
+	}
+	return l, r, nil
+}
+
+func (vm *VirtualMachine) run_comparison_op(i bytecode.Instruction) *InterpreterError {
+	var ret bool
+	lVal, rVal, err := vm.popOperands(i)
+	if err != nil {
+		return err
+	}
+	if !numbers(lVal, rVal) {
+		return &InterpreterError{interpreterErr: expectedNumbers, line: i.SourceLineNumer, span: i.Span}
+	}
+
+	// Comparisons with NaN are all false.
+	cmp, ordered := compare(lVal, rVal)
+	switch i.Code {
+	case bytecode.OpLess:
+		ret = ordered && cmp < 0
+	case bytecode.OpLessEqual:
+		ret = ordered && cmp <= 0
+	case bytecode.OpGreater:
+		ret = ordered && cmp > 0
+	case bytecode.OpGreaterEqual:
+		ret = ordered && cmp >= 0
+	default:
+		return &InterpreterError{interpreterErr: invalidOpCode, line: i.SourceLineNumer, span: i.Span}
+	}
+
+	vm.chunk.Values.Push(bytecode.LoxBool(ret))
+	return nil
 }
 
 func (vm *VirtualMachine) run_logical_op(i bytecode.Instruction) *InterpreterError {
 	var ret bytecode.Value
-	rVal, lVal := vm.chunk.Values.Pop(), vm.chunk.Values.Pop()
+	lVal, rVal, err := vm.popOperands(i)
+	if err != nil {
+		return err
+	}
 
 	switch i.Code {
 	case bytecode.OpOr:
 		ret = bytecode.LoxBool(rVal.Truthy() || lVal.Truthy())
 	case bytecode.OpAnd:
 		ret = bytecode.LoxBool(rVal.Truthy() && lVal.Truthy())
-    default:
-        return &InterpreterError{interpreterErr: "invalid opcode"}
+	default:
+		return &InterpreterError{interpreterErr: invalidOpCode, line: i.SourceLineNumer, span: i.Span}
 	}
 
 	vm.chunk.Values.Push(ret)
@@ -255,33 +700,30 @@ func (vm *VirtualMachine) run_logical_op(i bytecode.Instruction) *InterpreterErr
 
 func (vm *VirtualMachine) run_binary_op(i bytecode.Instruction) *InterpreterError {
 	var ret bytecode.Value
-	rVal, lVal := vm.chunk.Values.Pop(), vm.chunk.Values.Pop()
-	lInt, lOK := lVal.(bytecode.LoxInt)
-	rInt, rOK := rVal.(bytecode.LoxInt)
-	if !lOK || !rOK {
+	lVal, rVal, err := vm.popOperands(i)
+	if err != nil {
+		return err
+	}
+	if !numbers(lVal, rVal) {
 		lStr, lOK := lVal.(bytecode.LoxString)
 		rStr, rOK := rVal.(bytecode.LoxString)
 		if (!lOK || !rOK) || i.Code != bytecode.OpAdd {
 			// error!!
 			// Only + supports str and int other sneed int
-			debug.Printf("line[]: expected integers but got (%T, %T)", rVal, lVal)
+			debug.Printf("line[]: expected numbers but got (%T, %T)", rVal, lVal)
 			// return fmt.Errorf()
-			return &InterpreterError{interpreterErr: wrongType}
+			return &InterpreterError{interpreterErr: wrongType, line: i.SourceLineNumer, span: i.Span}
 		} else {
 			ret = lStr + rStr
 		}
 	} else {
 		switch i.Code {
-		case bytecode.OpAdd:
-			ret = lInt + rInt
-		case bytecode.OpSubtract:
-			ret = lInt - rInt
-		case bytecode.OpMultiply:
-			ret = lInt * rInt
-		case bytecode.OpDivide:
-			ret = lInt / rInt
+		case bytecode.OpAdd, bytecode.OpSubtract, bytecode.OpMultiply, bytecode.OpDivide, bytecode.OpModulo:
+			if ret, err = vm.arithmetic(i, lVal, rVal); err != nil {
+				return err
+			}
 		default:
-			return &InterpreterError{interpreterErr: invalidOpCode}
+			return &InterpreterError{interpreterErr: invalidOpCode, line: i.SourceLineNumer, span: i.Span}
 		}
 	}
 
@@ -3,8 +3,11 @@ package vm_test
 import (
 	"bufio"
 	"io"
+	"lox-compiler/decimal"
 	"lox-compiler/vm"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -77,3 +80,288 @@ func TestIfOutput(t *testing.T) {
     test_interp_output(t, "if (true) {print \"yes\";} else { print \"no\";}", "yes\n")
     test_interp_output(t, "if (false) {print \"yes\";} else { print \"no\";}", "no\n")
 }
+
+// Write files, keyed by their path relative to a new temporary directory,
+// and return the directory.
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, src := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestImport(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.lox":        `import "lib/values.lox"; import v from "lib/values.lox"; import "extra.lox";`,
+		"lib/values.lox":  `var secret = 1; export var answer = 42;`,
+		"other/extra.lox": `export var extra = "found";`,
+		"private.lox":     `import "lib/values.lox"; print secret;`,
+		"missing.lox":     `import "nowhere.lox";`,
+		"cycle/a.lox":     `import "b.lox";`,
+		"cycle/b.lox":     `import "a.lox";`,
+		"cycle/main.lox":  `import "a.lox";`,
+		"broken.lox":      `import "lib/broken.lox";`,
+		"lib/broken.lox":  `print 1 - "a";`,
+	})
+
+	src, _ := os.ReadFile(filepath.Join(dir, "main.lox"))
+	main := vm.VirtualMachine{Path: filepath.Join(dir, "main.lox"), SearchPath: []string{filepath.Join(dir, "other")}}
+	if err := main.Interpret(string(src)); err != nil {
+		t.Fatal(err)
+	}
+	// The module's globals stay in the module; only its exports come out.
+	if err := main.Interpret(`answer; v.answer; extra;`); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, message := range map[string]string{
+		"private.lox":    "variable secret is not defined",
+		"missing.lox":    `can't find module "nowhere.lox"`,
+		"cycle/main.lox": "import cycle: a.lox -> b.lox -> a.lox",
+		"broken.lox":     `in module "lib/broken.lox": [line 1]`,
+	} {
+		src, _ := os.ReadFile(filepath.Join(dir, path))
+		v := vm.VirtualMachine{Path: filepath.Join(dir, path)}
+		err := v.Interpret(string(src))
+		if err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("%s: expected an error containing %q, got %v", path, message, err)
+		}
+	}
+}
+
+func TestExceptions(t *testing.T) {
+	v := vm.VirtualMachine{}
+	// A throw in the middle of an expression leaves nothing behind on the
+	// stack for the statements that follow.
+	if err := v.Interpret(`var x = "x"; try { print 1 + (2 + x); } catch (e) { x = e.message; } var y = x;`); err != nil {
+		t.Fatal(err)
+	}
+
+	err := v.Interpret(`try { throw "inner"; } finally { print "cleanup"; }`)
+	if err == nil || !strings.Contains(err.Error(), "[line 1]: encountered an error: uncaught exception: inner") {
+		t.Fatalf("expected the exception to escape the finally clause, got %v", err)
+	}
+
+	// A return ends the try statements it leaves, so a later throw isn't
+	// caught by them, and keeps its value on the stack past the locals of
+	// the finally clauses.
+	err = v.Interpret(`fun f(n) { for (var i = 0; i < n; i = i + 1) { try { return i; } finally { var a = "a"; } } } var z = f(2) + 1; fun g() { try { return 1; } catch (e) {} } g(); throw "outer";`)
+	if err == nil || !strings.Contains(err.Error(), "uncaught exception: outer") {
+		t.Fatalf("expected the exception to be uncaught, got %v", err)
+	}
+
+	err = v.Interpret(`fun f() { try {} finally { return 1; } }`)
+	if err == nil || !strings.Contains(err.Error(), "Can't use 'return' to leave a finally clause.") {
+		t.Fatalf("expected a compile error, got %v", err)
+	}
+
+	// Running out of steps isn't an exception the script can catch.
+	limited := vm.VirtualMachine{StepLimit: 100}
+	err = limited.Interpret(`while (true) { try { var a = 1; } catch (e) {} }`)
+	if err == nil || !strings.Contains(err.Error(), "step limit exceeded") {
+		t.Fatalf("expected the step limit to be exceeded, got %v", err)
+	}
+}
+
+func TestLoopJumps(t *testing.T) {
+	v := vm.VirtualMachine{}
+	// Jumping out of a block pops its locals, so the global read afterwards
+	// still finds the right value.
+	if err := v.Interpret(`var x = "x"; for (var i = 0; i < 3; i = i + 1) { var a = i; { var b = a; if (b == 1) continue; if (b == 2) break; } } var y = x + "y";`); err != nil {
+		t.Fatal(err)
+	}
+
+	err := v.Interpret(`break;`)
+	if err == nil || !strings.Contains(err.Error(), "Can't use 'break' outside of a loop.") {
+		t.Fatalf("expected a compile error, got %v", err)
+	}
+}
+
+func TestLists(t *testing.T) {
+	v := vm.VirtualMachine{}
+	// A failed subscript or method call leaves nothing behind on the stack
+	// for the statements that follow.
+	if err := v.Interpret(`var xs = [1, 2]; try { print xs[5]; } catch (e) {} try { xs.nope(1, 2); } catch (e) {} var y = xs[-1];`); err != nil {
+		t.Fatal(err)
+	}
+
+	err := v.Interpret(`var f; f.x(1);`)
+	if err == nil || !strings.Contains(err.Error(), "Only lists and maps have methods.") {
+		t.Fatalf("expected a runtime error, got %v", err)
+	}
+
+	// An exception thrown by a function map calls unwinds out of the
+	// method to the try statement around it, and one caught inside the
+	// function doesn't leave it.
+	if err := v.Interpret(`var caught; try { xs.map(fun (x) { throw "in map"; }); } catch (e) { caught = e; } var zs = xs.map(fun (x) { try { throw x; } catch (e) { return e + 1; } }); var z = zs[0] + 1;`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Errors calling the function are reported where map was called, and
+	// errors inside it where they happened.
+	for src, message := range map[string]string{
+		"xs.map(1);":             "[line 1]: encountered an error: Can only call functions and classes.",
+		"xs.map(fun (a, b) {});": "[line 1]: encountered an error: Expected 2 arguments but got 1",
+		"xs.map(fun (x) {\n return 1 - \"a\"; });": "[line 2]: encountered an error: incorrect type",
+	} {
+		err := v.Interpret(src)
+		if err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("%s: expected an error containing %q, got %v", src, message, err)
+		}
+	}
+}
+
+func TestForIn(t *testing.T) {
+	v := vm.VirtualMachine{}
+	// The loop pops its iterator and variable however it ends, so the
+	// local declared after it gets the right slot.
+	if err := v.Interpret(`{ var a = "a"; for (var x in [1, 2, 3]) { var b = x; if (b == 1) continue; if (b == 2) break; } for (var c in "") {} var d = a + "d"; if (d != "ad") throw d; }`); err != nil {
+		t.Fatal(err)
+	}
+
+	err := v.Interpret(`for (var x in 3) print x;`)
+	if err == nil || !strings.Contains(err.Error(), "Only lists, maps, strings, ranges and iterators can be iterated.") {
+		t.Fatalf("expected a runtime error, got %v", err)
+	}
+}
+
+func TestClosures(t *testing.T) {
+	v := vm.VirtualMachine{}
+	// Closing over a variable takes it off the stack however its scope is
+	// left, so the locals declared afterwards get the right slots.
+	if err := v.Interpret(`var fs = [];
+for (var i in 0..3) { var j = i; fs.push(() => j + i); if (i == 1) continue; if (i == 2) break; }
+{ var a = "a"; try { var b = 1; fs.push(fun () { return b; }); throw "x"; } catch (e) {} var c = a + "c"; if (c != "ac") throw c; }
+if (fs.len() != 4) throw fs.len();`); err != nil {
+		t.Fatal(err)
+	}
+	test_interp_output(t, `{ var n = 1; print x => x + n; }`, "<fn lambda>\n")
+	test_interp_output(t, `fun add(a, b) { return a + b; } print add;`, "<fn add>\n")
+
+	err := v.Interpret(`var m = {}; m[() => 1] = 1;`)
+	if err == nil || !strings.Contains(err.Error(), "Functions and classes can't be map keys.") {
+		t.Fatalf("expected a runtime error, got %v", err)
+	}
+
+	// A captured variable outlives the call that declared it, and each call
+	// gets its own.
+	test_interp_output(t, `fun counter() { var n = 0; return () => n = n + 1; }
+var a = counter(); var b = counter(); a(); a(); print [a(), b()];`, "[3, 1]\n")
+	// Closures made in the same scope share what they capture, before and
+	// after the scope ends.
+	test_interp_output(t, `var get; var set;
+{ var shared = 1; get = () => shared; set = fun (v) { shared = v; }; set(2); if (get() != 2) throw get(); }
+set(3); print get();`, "3\n")
+	// A lambda inside a lambda reaches the variables of the function around
+	// both through the upvalues of the one in the middle.
+	test_interp_output(t, `fun outer(x) { return () => () => x + 1; } print outer(41)()();`, "42\n")
+	test_interp_output(t, `var fs = []; for (var i in 0..3) { var j = i * 10; fs.push(() => j); }
+print [fs[0](), fs[1](), fs[2]()];`, "[0, 10, 20]\n")
+}
+
+func TestCalls(t *testing.T) {
+	test_interp_output(t, `fun fib(n) { if (n < 2) return n; return fib(n - 1) + fib(n - 2); } print fib(10);`, "55\n")
+	test_interp_output(t, `fun f(a, b = a + 1, ...rest) { return [a, b, rest]; } print [f(1), f(1, 5, 6, 7)];`, "[[1, 2, []], [1, 5, [6, 7]]]\n")
+	test_interp_output(t, `var xs = [1]; var push = xs.push; push(2); print xs;`, "[1, 2]\n")
+	// Named arguments fill the slots of their parameters, in any order.
+	test_interp_output(t, `fun f(a, b = 2, ...rest) { return [a, b, rest]; } print [f(b: 1, a: 0), f(1, b: 4)];`, "[[0, 1, []], [1, 4, []]]\n")
+	// Locals declared after a call has returned get the right slots.
+	test_interp_output(t, `fun id(x) { var y = x; return y; } { var a = id(1); var b = id(2); print a + b; }`, "3\n")
+	// An exception thrown by a function unwinds its frame.
+	test_interp_output(t, `fun boom(x) { var y = x; throw y; } var r; try { boom(1); } catch (e) { r = e; } { var z = 2; print r + z; }`, "3\n")
+
+	v := vm.VirtualMachine{}
+	for src, want := range map[string]string{
+		`fun f(a, b) {} f(1);`:            "Expected 2 arguments but got 1",
+		`fun f(a, b = 1) {} f();`:         "Expected 1 to 2 arguments but got 0",
+		`var x = 1; x();`:                 "Can only call functions and classes.",
+		`fun f() { f(); } f();`:           "Stack overflow.",
+		`var push = [].push; push(x: 1);`: "Native functions don't take named arguments.",
+		`var x = 1; x(a: 1);`:             "Can only call functions and classes.",
+	} {
+		err := v.Interpret(src)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: expected a runtime error %q, got %v", src, want, err)
+		}
+	}
+}
+
+func TestInterpolation(t *testing.T) {
+	test_interp_output(t, `var name = "Ann"; var age = 41; print "Hello ${name}, you are ${age + 1}";`, "Hello Ann, you are 42\n")
+	test_interp_output(t, `var xs = [1, "a"]; print "${xs} ${"in ${xs[1]}"} \${x}";`, "[1, a] in a ${x}\n")
+	// The pieces are popped off the stack, so a local declared after the
+	// string still gets the right slot.
+	test_interp(t, `{ var a = "a"; var s = "${a}${1}${a}"; var b = a + "b"; if (b != "ab" or s != "a1a") throw s; }`)
+}
+
+func TestNumbers(t *testing.T) {
+	test_interp_output(t, `print [7 / 2, 7.0 / 2, -7 % 3, 1 + 1.0, 9223372036854775807 + 1];`, "[3, 3.5, -1, 2.0, -9223372036854775808]\n")
+	test_interp_output(t, `print [1 == 1.0, 1 < 1.5, 0.0 / 0.0 < 1];`, "[true, true, false]\n")
+
+	v := vm.VirtualMachine{}
+	err := v.Interpret(`var x = 1 % 0;`)
+	if err == nil || !strings.Contains(err.Error(), "Division by zero.") {
+		t.Fatalf("expected a runtime error, got %v", err)
+	}
+}
+
+func TestBigNumbers(t *testing.T) {
+	test_interp_output(t, `print [9223372036854775807n + 1, 7n / 2, 1n + 0.5, -(2n)];`, "[9223372036854775808, 3, 1.5, -2]\n")
+	test_interp_output(t, `print [0.1d + 0.2d, 1.25d * 4, 1d / 3, 19.99d * 3n];`, "[0.3, 5.00, 0.3333333333333333333333333333, 59.97]\n")
+	test_interp_output(t, `print [1n == 1.0, 1.50d == 1.5, 0.1d == 0.1, 2n < 2.5d, 1n < 0.0 / 0.0];`, "[true, true, false, true, false]\n")
+
+	v := vm.VirtualMachine{}
+	err := v.Interpret(`var x = 1.5d + 1.5;`)
+	if err == nil || !strings.Contains(err.Error(), "Can't mix decimals and floats.") {
+		t.Fatalf("expected a runtime error, got %v", err)
+	}
+	err = v.Interpret(`var x = 1d / 0n;`)
+	if err == nil || !strings.Contains(err.Error(), "Division by zero.") {
+		t.Fatalf("expected a runtime error, got %v", err)
+	}
+}
+
+func TestDecimalContext(t *testing.T) {
+	r, w, _ := os.Pipe()
+	stdout := os.Stdout
+	os.Stdout = w
+	v := vm.VirtualMachine{Decimal: decimal.Context{Precision: 2, Rounding: decimal.Floor}}
+	err := v.Interpret(`print [2d / 3, -2d / 3, 0.125d * 1];`)
+	os.Stdout = stdout
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ := io.ReadAll(r)
+	if string(out) != "[0.66, -0.67, 0.12]\n" {
+		t.Fatalf("expected [0.66, -0.67, 0.12], got %q", out)
+	}
+
+	// decimalContext changes the context of the VM it's called in.
+	if err := v.Interpret(`decimalContext(5, "up");`); err != nil {
+		t.Fatal(err)
+	}
+	if v.Decimal != (decimal.Context{Precision: 5, Rounding: decimal.Up}) {
+		t.Fatalf("expected precision 5 rounding up, got %+v", v.Decimal)
+	}
+	for src, want := range map[string]string{
+		`decimalContext(0, "up");`:   "Decimal precision must be a positive integer.",
+		`decimalContext(2);`:         "Expected 2 arguments but got 1",
+		`bigint(x: 1);`:              "Native functions don't take named arguments.",
+		`var d = decimal(nil);`:      "Only numbers and strings can be converted to decimals.",
+		`var n = [1.5].map(bigint);`: "Only whole numbers can be converted to bigints.",
+	} {
+		err := v.Interpret(src)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: expected a runtime error %q, got %v", src, want, err)
+		}
+	}
+}